@@ -113,6 +113,11 @@ func setUpBucket(
 		}
 	}
 
+	// Guard against names that collide under case folding, if requested.
+	if flags.RejectCaseCollisions {
+		b = gcsx.NewCaseInsensitiveBucket(tmpObjectPrefix, b)
+	}
+
 	// Enable rate limiting, if requested.
 	b, err = setUpRateLimiting(
 		b,
@@ -124,6 +129,11 @@ func setUpBucket(
 		return
 	}
 
+	// Bound the number of simultaneous requests, if requested.
+	if flags.MaxConcurrentRequests > 0 {
+		b = gcsx.NewBoundedConcurrencyBucket(flags.MaxConcurrentRequests, b)
+	}
+
 	// Enable cached StatObject results, if appropriate.
 	if flags.StatCacheTTL != 0 {
 		const cacheCapacity = 4096
@@ -110,6 +110,26 @@ func newApp() (app *cli.App) {
 				Usage: "Mount only the given directory, relative to the bucket root.",
 			},
 
+			cli.BoolFlag{
+				Name: "reject-case-collisions",
+				Usage: "Refuse to create names that differ from existing ones only " +
+					"by case.",
+			},
+
+			cli.BoolFlag{
+				Name: "staged-writes",
+				Usage: "Upload rewritten files to a temporary object before " +
+					"swapping them into place, so that readers never see a " +
+					"partial write.",
+			},
+
+			cli.BoolFlag{
+				Name: "idempotent-writes",
+				Usage: "Tag each rewritten file with a token, so that an upload " +
+					"whose response is lost isn't repeated, e.g. to avoid " +
+					"duplicate Pub/Sub notifications.",
+			},
+
 			/////////////////////////
 			// GCS
 			/////////////////////////
@@ -135,6 +155,14 @@ func newApp() (app *cli.App) {
 					"(use -1 for no limit)",
 			},
 
+			cli.IntFlag{
+				Name:  "max-concurrent-requests",
+				Value: -1,
+				Usage: "Maximum number of requests to GCS that may be awaiting a " +
+					"response at once, and of reads from GCS that may be open at " +
+					"once. (use -1 for no limit)",
+			},
+
 			/////////////////////////
 			// Tuning
 			/////////////////////////
@@ -192,18 +220,22 @@ type flagStorage struct {
 	Foreground bool
 
 	// File system
-	MountOptions map[string]string
-	DirMode      os.FileMode
-	FileMode     os.FileMode
-	Uid          int64
-	Gid          int64
-	ImplicitDirs bool
-	OnlyDir      string
+	MountOptions         map[string]string
+	DirMode              os.FileMode
+	FileMode             os.FileMode
+	Uid                  int64
+	Gid                  int64
+	ImplicitDirs         bool
+	OnlyDir              string
+	RejectCaseCollisions bool
+	StagedWrites         bool
+	IdempotentWrites     bool
 
 	// GCS
 	KeyFile                            string
 	EgressBandwidthLimitBytesPerSecond float64
 	OpRateLimitHz                      float64
+	MaxConcurrentRequests              int
 
 	// Tuning
 	StatCacheTTL time.Duration
@@ -224,18 +256,22 @@ func populateFlags(c *cli.Context) (flags *flagStorage) {
 		Foreground: c.Bool("foreground"),
 
 		// File system
-		MountOptions: make(map[string]string),
-		DirMode:      os.FileMode(*c.Generic("dir-mode").(*OctalInt)),
-		FileMode:     os.FileMode(*c.Generic("file-mode").(*OctalInt)),
-		Uid:          int64(c.Int("uid")),
-		Gid:          int64(c.Int("gid")),
-		ImplicitDirs: c.Bool("implicit-dirs"),
-		OnlyDir:      c.String("only-dir"),
+		MountOptions:         make(map[string]string),
+		DirMode:              os.FileMode(*c.Generic("dir-mode").(*OctalInt)),
+		FileMode:             os.FileMode(*c.Generic("file-mode").(*OctalInt)),
+		Uid:                  int64(c.Int("uid")),
+		Gid:                  int64(c.Int("gid")),
+		ImplicitDirs:         c.Bool("implicit-dirs"),
+		OnlyDir:              c.String("only-dir"),
+		RejectCaseCollisions: c.Bool("reject-case-collisions"),
+		StagedWrites:         c.Bool("staged-writes"),
+		IdempotentWrites:     c.Bool("idempotent-writes"),
 
 		// GCS,
 		KeyFile: c.String("key-file"),
 		EgressBandwidthLimitBytesPerSecond: c.Float64("limit-bytes-per-sec"),
 		OpRateLimitHz:                      c.Float64("limit-ops-per-sec"),
+		MaxConcurrentRequests:              c.Int("max-concurrent-requests"),
 
 		// Tuning,
 		StatCacheTTL: c.Duration("stat-cache-ttl"),
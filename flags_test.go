@@ -69,11 +69,15 @@ func (t *FlagsTest) Defaults() {
 	ExpectEq(-1, f.Uid)
 	ExpectEq(-1, f.Gid)
 	ExpectFalse(f.ImplicitDirs)
+	ExpectFalse(f.RejectCaseCollisions)
+	ExpectFalse(f.StagedWrites)
+	ExpectFalse(f.IdempotentWrites)
 
 	// GCS
 	ExpectEq("", f.KeyFile)
 	ExpectEq(-1, f.EgressBandwidthLimitBytesPerSecond)
 	ExpectEq(5, f.OpRateLimitHz)
+	ExpectEq(-1, f.MaxConcurrentRequests)
 
 	// Tuning
 	ExpectEq(time.Minute, f.StatCacheTTL)
@@ -90,6 +94,9 @@ func (t *FlagsTest) Defaults() {
 func (t *FlagsTest) Bools() {
 	names := []string{
 		"implicit-dirs",
+		"reject-case-collisions",
+		"staged-writes",
+		"idempotent-writes",
 		"debug_fuse",
 		"debug_gcs",
 		"debug_http",
@@ -107,6 +114,9 @@ func (t *FlagsTest) Bools() {
 
 	f = parseArgs(args)
 	ExpectTrue(f.ImplicitDirs)
+	ExpectTrue(f.RejectCaseCollisions)
+	ExpectTrue(f.StagedWrites)
+	ExpectTrue(f.IdempotentWrites)
 	ExpectTrue(f.DebugFuse)
 	ExpectTrue(f.DebugGCS)
 	ExpectTrue(f.DebugHTTP)
@@ -120,6 +130,9 @@ func (t *FlagsTest) Bools() {
 
 	f = parseArgs(args)
 	ExpectFalse(f.ImplicitDirs)
+	ExpectFalse(f.RejectCaseCollisions)
+	ExpectFalse(f.StagedWrites)
+	ExpectFalse(f.IdempotentWrites)
 	ExpectFalse(f.DebugFuse)
 	ExpectFalse(f.DebugGCS)
 	ExpectFalse(f.DebugHTTP)
@@ -133,6 +146,9 @@ func (t *FlagsTest) Bools() {
 
 	f = parseArgs(args)
 	ExpectTrue(f.ImplicitDirs)
+	ExpectTrue(f.RejectCaseCollisions)
+	ExpectTrue(f.StagedWrites)
+	ExpectTrue(f.IdempotentWrites)
 	ExpectTrue(f.DebugFuse)
 	ExpectTrue(f.DebugGCS)
 	ExpectTrue(f.DebugHTTP)
@@ -145,6 +161,7 @@ func (t *FlagsTest) DecimalNumbers() {
 		"--gid=19",
 		"--limit-bytes-per-sec=123.4",
 		"--limit-ops-per-sec=56.78",
+		"--max-concurrent-requests=23",
 	}
 
 	f := parseArgs(args)
@@ -152,6 +169,7 @@ func (t *FlagsTest) DecimalNumbers() {
 	ExpectEq(19, f.Gid)
 	ExpectEq(123.4, f.EgressBandwidthLimitBytesPerSecond)
 	ExpectEq(56.78, f.OpRateLimitHz)
+	ExpectEq(23, f.MaxConcurrentRequests)
 }
 
 func (t *FlagsTest) OctalNumbers() {
@@ -21,6 +21,7 @@ import (
 	"log"
 	"os"
 	"reflect"
+	"syscall"
 	"time"
 
 	"github.com/googlecloudplatform/gcsfuse/internal/fs/handle"
@@ -106,6 +107,24 @@ type ServerConfig struct {
 	// periodically garbage collected.
 	AppendThreshold int64
 	TmpObjectPrefix string
+
+	// If set, files that must be rewritten in full are first uploaded to a
+	// temporary object whose name begins with TmpObjectPrefix, which then
+	// replaces the original object in a single request. This ensures that
+	// readers never see a partially written object, at the cost of extra
+	// requests. The same garbage collection caveat as above applies.
+	StagedWrites bool
+
+	// If set, files that must be rewritten in full are tagged with a token in
+	// their metadata, so that when an upload appears to fail but actually
+	// landed, it is adopted rather than repeated. This avoids duplicate object
+	// change notifications. See gcsx.NewIdempotentSyncer. Incompatible with
+	// StagedWrites.
+	IdempotentWrites bool
+
+	// Options applied to each file inode. The zero value gives the default
+	// behavior.
+	FileConfig inode.FileConfig
 }
 
 // Create a fuse file system server according to the supplied configuration.
@@ -121,8 +140,9 @@ func NewServer(cfg *ServerConfig) (server fuse.Server, err error) {
 		return
 	}
 
-	// Set up a bucket that infers content types when creating files.
-	bucket := gcsx.NewContentTypeBucket(cfg.Bucket)
+	// Set up a bucket that infers content types when creating files, and that
+	// returns typed errors that we can make decisions based on.
+	bucket := gcsx.NewContentTypeBucket(gcsx.NewClassifyingBucket(cfg.Bucket))
 
 	// Create the object syncer.
 	if cfg.TmpObjectPrefix == "" {
@@ -130,7 +150,21 @@ func NewServer(cfg *ServerConfig) (server fuse.Server, err error) {
 		return
 	}
 
-	syncer := gcsx.NewSyncer(
+	if cfg.StagedWrites && cfg.IdempotentWrites {
+		err = errors.New("StagedWrites and IdempotentWrites are incompatible.")
+		return
+	}
+
+	newSyncer := gcsx.NewSyncer
+	switch {
+	case cfg.StagedWrites:
+		newSyncer = gcsx.NewStagedSyncer
+
+	case cfg.IdempotentWrites:
+		newSyncer = gcsx.NewIdempotentSyncer
+	}
+
+	syncer := newSyncer(
 		cfg.AppendThreshold,
 		cfg.TmpObjectPrefix,
 		bucket)
@@ -142,6 +176,7 @@ func NewServer(cfg *ServerConfig) (server fuse.Server, err error) {
 		bucket:                 bucket,
 		syncer:                 syncer,
 		tempDir:                cfg.TempDir,
+		fileConfig:             cfg.FileConfig,
 		implicitDirs:           cfg.ImplicitDirectories,
 		inodeAttributeCacheTTL: cfg.InodeAttributeCacheTTL,
 		dirTypeCacheTTL:        cfg.DirTypeCacheTTL,
@@ -240,6 +275,7 @@ type fileSystem struct {
 	implicitDirs           bool
 	inodeAttributeCacheTTL time.Duration
 	dirTypeCacheTTL        time.Duration
+	fileConfig             inode.FileConfig
 
 	// The user and group owning everything in the file system.
 	uid uint32
@@ -564,7 +600,8 @@ func (fs *fileSystem) mintInode(name string, o *gcs.Object) (in inode.Inode) {
 			fs.bucket,
 			fs.syncer,
 			fs.tempDir,
-			fs.mtimeClock)
+			fs.mtimeClock,
+			fs.fileConfig)
 	}
 
 	// Place it in our map of IDs to inodes.
@@ -752,10 +789,11 @@ func (fs *fileSystem) lookUpOrCreateChildInode(
 func (fs *fileSystem) syncFile(
 	ctx context.Context,
 	f *inode.FileInode) (err error) {
-	// Sync the inode.
-	err = f.Sync(ctx)
+	// Sync the inode. The kernel takes success to mean the contents have been
+	// persisted, so don't let the upload be deferred.
+	err = f.ForceSync(ctx)
 	if err != nil {
-		err = fmt.Errorf("FileInode.Sync: %v", err)
+		err = fmt.Errorf("FileInode.ForceSync: %v", err)
 		return
 	}
 
@@ -1038,6 +1076,11 @@ func (fs *fileSystem) SetInodeAttributes(
 	// Truncate files.
 	if isFile && op.Size != nil {
 		err = file.Truncate(ctx, int64(*op.Size))
+		if _, ok := err.(*gcsx.NoSpaceError); ok {
+			err = syscall.ENOSPC
+			return
+		}
+
 		if err != nil {
 			err = fmt.Errorf("Truncate: %v", err)
 			return
@@ -1090,8 +1133,10 @@ func (fs *fileSystem) MkDir(
 	o, err := parent.CreateChildDir(ctx, op.Name)
 	parent.Unlock()
 
-	// Special case: *gcs.PreconditionError means the name already exists.
-	if _, ok := err.(*gcs.PreconditionError); ok {
+	// Special case: these errors mean the name (or one differing only in case)
+	// already exists.
+	switch err.(type) {
+	case *gcsx.AlreadyExistsError, *gcsx.CollisionError:
 		err = fuse.EEXIST
 		return
 	}
@@ -1170,11 +1215,13 @@ func (fs *fileSystem) createFile(
 	// Create an empty backing object for the child, failing if it already
 	// exists.
 	parent.Lock()
-	o, err := parent.CreateChildFile(ctx, name)
+	o, err := parent.CreateChildFile(ctx, name, fs.fileConfig.Creator)
 	parent.Unlock()
 
-	// Special case: *gcs.PreconditionError means the name already exists.
-	if _, ok := err.(*gcs.PreconditionError); ok {
+	// Special case: these errors mean the name (or one differing only in case)
+	// already exists.
+	switch err.(type) {
+	case *gcsx.AlreadyExistsError, *gcsx.CollisionError:
 		err = fuse.EEXIST
 		return
 	}
@@ -1250,8 +1297,10 @@ func (fs *fileSystem) CreateSymlink(
 	o, err := parent.CreateChildSymlink(ctx, op.Name, op.Target)
 	parent.Unlock()
 
-	// Special case: *gcs.PreconditionError means the name already exists.
-	if _, ok := err.(*gcs.PreconditionError); ok {
+	// Special case: these errors mean the name (or one differing only in case)
+	// already exists.
+	switch err.(type) {
+	case *gcsx.AlreadyExistsError, *gcsx.CollisionError:
 		err = fuse.EEXIST
 		return
 	}
@@ -1559,6 +1608,17 @@ func (fs *fileSystem) ReadFile(
 		err = nil
 	}
 
+	// Tell the user clearly if we couldn't fault in the contents because the
+	// temp directory is full, or because the generation being read has been
+	// replaced or deleted remotely.
+	switch err.(type) {
+	case *gcsx.NoSpaceError:
+		err = syscall.ENOSPC
+
+	case *gcsx.ClobberedError:
+		err = syscall.ESTALE
+	}
+
 	return
 }
 
@@ -1594,6 +1654,9 @@ func (fs *fileSystem) WriteFile(
 
 	// Serve the request.
 	err = in.Write(ctx, op.Data, op.Offset)
+	if _, ok := err.(*gcsx.NoSpaceError); ok {
+		err = syscall.ENOSPC
+	}
 
 	return
 }
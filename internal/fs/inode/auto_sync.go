@@ -0,0 +1,259 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// A policy for syncing dirty file inodes in the background, so that write-heavy
+// workloads needn't pay for an upload on every fsync. The zero value disables
+// background syncing.
+//
+// Background syncs take the inode lock like any other operation, so they never
+// race with explicit calls to Sync: whichever comes second finds the inode
+// clean and does nothing.
+type AutoSyncConfig struct {
+	// If positive, sync the inode once its contents have been dirty and
+	// unmodified for at least this long, as measured by FileConfig.Clock.
+	IdleInterval time.Duration
+
+	// If positive, sync the inode as soon as at least this many bytes have been
+	// written to it since it was last synced.
+	DirtyBytes int64
+
+	// How often, according to FileConfig.Clock, to check whether IdleInterval
+	// has elapsed. If zero, a quarter of IdleInterval is used.
+	PollInterval time.Duration
+}
+
+func (c *AutoSyncConfig) enabled() bool {
+	return c.IdleInterval > 0 || c.DirtyBytes > 0
+}
+
+// State for a file inode's background syncing.
+type autoSyncState struct {
+	// Is there a goroutine running autoSyncLoop?
+	running bool
+
+	// A channel used to wake up the background goroutine early, e.g. because
+	// DirtyBytes has been reached. Buffered with capacity one.
+	kick chan struct{}
+
+	// The time at which the contents were last modified.
+	lastModified time.Time
+
+	// The number of bytes written since the last sync.
+	dirtyBytes int64
+
+	// The result of the most recent background sync.
+	lastErr error
+}
+
+// Return the error returned by the most recent background sync, or nil if it
+// succeeded or there has been none. A successful explicit Sync clears this.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) LastSyncError() error {
+	return f.autoSync.lastErr
+}
+
+// Record a modification of n bytes to f.content for the purposes of background
+// syncing, starting the background goroutine if necessary.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) noteModified(n int64) {
+	cfg := &f.cfg.AutoSync
+	if !cfg.enabled() {
+		return
+	}
+
+	as := &f.autoSync
+	as.lastModified = f.cfg.Clock.Now()
+	as.dirtyBytes += n
+
+	if !as.running {
+		as.running = true
+		go f.autoSyncLoop()
+	}
+
+	if cfg.DirtyBytes > 0 && as.dirtyBytes >= cfg.DirtyBytes {
+		f.kickAutoSync()
+	}
+}
+
+// Wake the background goroutine, if any, so that it reconsiders its state.
+// Without an IdleInterval it doesn't poll, so this is the only way it notices
+// e.g. that the inode has been destroyed.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) kickAutoSync() {
+	select {
+	case f.autoSync.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Record that f.content has been synced, or discarded.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) noteSynced() {
+	f.autoSync.dirtyBytes = 0
+	f.autoSync.lastErr = nil
+	f.wakeCleanWaiters()
+}
+
+// Block until f holds no unsynced modifications or has been destroyed, or
+// until ctx is cancelled, in which case the context's error is returned. This
+// doesn't itself cause a sync; it waits for an explicit or background one.
+//
+// A clobbered inode stays dirty until destroyed.
+//
+// LOCKS_EXCLUDED(f.mu)
+func (f *FileInode) WaitClean(ctx context.Context) (err error) {
+	for {
+		f.mu.Lock()
+
+		var dirty bool
+		if !f.destroyed {
+			dirty, err = f.dirty()
+		}
+
+		var cleaned chan struct{}
+		if err == nil && dirty {
+			if f.cleaned == nil {
+				f.cleaned = make(chan struct{})
+			}
+
+			cleaned = f.cleaned
+		}
+
+		f.mu.Unlock()
+
+		if err != nil {
+			err = fmt.Errorf("dirty: %v", err)
+			return
+		}
+
+		if !dirty {
+			return
+		}
+
+		select {
+		case <-cleaned:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+}
+
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) wakeCleanWaiters() {
+	if f.cleaned != nil {
+		close(f.cleaned)
+		f.cleaned = nil
+	}
+}
+
+// Sync f in the background according to f.cfg.AutoSync, until it is clean or
+// destroyed.
+//
+// LOCKS_EXCLUDED(f.mu)
+func (f *FileInode) autoSyncLoop() {
+	cfg := &f.cfg.AutoSync
+
+	// Figure out how often to poll for the idle interval, if any.
+	pollInterval := cfg.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = cfg.IdleInterval / 4
+	}
+
+	if pollInterval <= 0 {
+		pollInterval = cfg.IdleInterval
+	}
+
+	for {
+		var poll <-chan time.Time
+		if cfg.IdleInterval > 0 {
+			poll = f.cfg.Clock.After(pollInterval)
+		}
+
+		select {
+		case <-poll:
+		case <-f.autoSync.kick:
+		}
+
+		f.mu.Lock()
+		done := f.autoSyncStep()
+		f.mu.Unlock()
+
+		if done {
+			return
+		}
+	}
+}
+
+// Sync f if it is due, returning true if the background goroutine should exit.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) autoSyncStep() (done bool) {
+	cfg := &f.cfg.AutoSync
+	as := &f.autoSync
+
+	// Is there anything left to do? The contents of a destroyed inode are gone,
+	// so don't look at them.
+	if f.destroyed {
+		as.running = false
+		done = true
+		return
+	}
+
+	dirty, err := f.dirty()
+	if err == nil && !dirty {
+		as.running = false
+		done = true
+		return
+	}
+
+	// Is it time yet?
+	due := (cfg.DirtyBytes > 0 && as.dirtyBytes >= cfg.DirtyBytes) ||
+		(cfg.IdleInterval > 0 &&
+			f.cfg.Clock.Now().Sub(as.lastModified) >= cfg.IdleInterval)
+
+	if !due {
+		return
+	}
+
+	// Sync, recording the result. On failure, wait for the triggering condition
+	// to arise anew before trying again.
+	err = f.Sync(context.Background())
+	if err != nil {
+		as.lastErr = err
+		as.lastModified = f.cfg.Clock.Now()
+		as.dirtyBytes = 0
+		return
+	}
+
+	// Either we're now clean, or we've been clobbered and there is nothing more
+	// we can do.
+	as.running = false
+	done = true
+
+	return
+}
@@ -21,6 +21,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/fuse/fuseutil"
 	"github.com/jacobsa/gcloud/gcs"
@@ -103,10 +104,13 @@ type DirInode interface {
 		tok string) (entries []fuseutil.Dirent, newTok string, err error)
 
 	// Create an empty child file with the supplied (relative) name, failing with
-	// *gcs.PreconditionError if a backing object already exists in GCS.
+	// *gcsx.AlreadyExistsError if a backing object already exists in GCS. If
+	// creator is non-empty, it is recorded under gcsx.CreatorMetadataKey; see
+	// FileConfig.Creator.
 	CreateChildFile(
 		ctx context.Context,
-		name string) (o *gcs.Object, err error)
+		name string,
+		creator string) (o *gcs.Object, err error)
 
 	// Like CreateChildFile, except clone the supplied source object instead of
 	// creating an empty object.
@@ -116,7 +120,7 @@ type DirInode interface {
 		src *gcs.Object) (o *gcs.Object, err error)
 
 	// Create a symlink object with the supplied (relative) name and the supplied
-	// target, failing with *gcs.PreconditionError if a backing object already
+	// target, failing with *gcsx.AlreadyExistsError if a backing object already
 	// exists in GCS.
 	CreateChildSymlink(
 		ctx context.Context,
@@ -124,7 +128,7 @@ type DirInode interface {
 		target string) (o *gcs.Object, err error)
 
 	// Create a backing object for a child directory with the supplied (relative)
-	// name, failing with *gcs.PreconditionError if a backing object already
+	// name, failing with *gcsx.AlreadyExistsError if a backing object already
 	// exists in GCS.
 	CreateChildDir(
 		ctx context.Context,
@@ -368,7 +372,8 @@ func objectNamePrefixNonEmpty(
 }
 
 // Stat the object with the given name, returning (nil, nil) if the object
-// doesn't exist rather than failing.
+// doesn't exist rather than failing. Soft-deleted objects don't exist for
+// this purpose.
 func statObjectMayNotExist(
 	ctx context.Context,
 	bucket gcs.Bucket,
@@ -378,16 +383,18 @@ func statObjectMayNotExist(
 		Name: name,
 	}
 
-	o, err = bucket.StatObject(ctx, req)
+	o, err = gcsx.StatObject(ctx, bucket, req)
 
 	// Suppress "not found" errors.
-	if _, ok := err.(*gcs.NotFoundError); ok {
+	switch err.(type) {
+	case *gcs.NotFoundError, *gcsx.SoftDeletedError:
+		o = nil
 		err = nil
 	}
 
 	// Annotate others.
 	if err != nil {
-		err = fmt.Errorf("StatObject: %v", err)
+		err = fmt.Errorf("gcsx.StatObject: %v", err)
 		return
 	}
 
@@ -401,15 +408,13 @@ func (d *dirInode) createNewObject(
 	metadata map[string]string) (o *gcs.Object, err error) {
 	// Create an empty backing object for the child, failing if it already
 	// exists.
-	var precond int64
 	createReq := &gcs.CreateObjectRequest{
-		Name:                   name,
-		Contents:               strings.NewReader(""),
-		GenerationPrecondition: &precond,
-		Metadata:               metadata,
+		Name:     name,
+		Contents: strings.NewReader(""),
+		Metadata: metadata,
 	}
 
-	o, err = d.bucket.CreateObject(ctx, createReq)
+	o, err = gcsx.CreateObjectIfAbsent(ctx, d.bucket, createReq)
 	if err != nil {
 		return
 	}
@@ -751,11 +756,16 @@ func (d *dirInode) ReadEntries(
 // LOCKS_REQUIRED(d)
 func (d *dirInode) CreateChildFile(
 	ctx context.Context,
-	name string) (o *gcs.Object, err error) {
+	name string,
+	creator string) (o *gcs.Object, err error) {
 	metadata := map[string]string{
 		FileMtimeMetadataKey: d.mtimeClock.Now().UTC().Format(time.RFC3339Nano),
 	}
 
+	if creator != "" {
+		metadata[gcsx.CreatorMetadataKey] = creator
+	}
+
 	o, err = d.createNewObject(ctx, path.Join(d.Name(), name), metadata)
 	if err != nil {
 		return
@@ -799,11 +809,7 @@ func (d *dirInode) CreateChildSymlink(
 	ctx context.Context,
 	name string,
 	target string) (o *gcs.Object, err error) {
-	metadata := map[string]string{
-		SymlinkMetadataKey: target,
-	}
-
-	o, err = d.createNewObject(ctx, path.Join(d.Name(), name), metadata)
+	o, err = CreateSymlinkObject(ctx, d.bucket, path.Join(d.Name(), name), target)
 	if err != nil {
 		return
 	}
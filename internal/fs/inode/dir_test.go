@@ -24,6 +24,7 @@ import (
 	"golang.org/x/net/context"
 
 	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/fuse/fuseutil"
 	"github.com/jacobsa/gcloud/gcs"
@@ -103,6 +104,22 @@ func (t *DirTest) resetInode(implicitDirs bool) {
 	t.in.Lock()
 }
 
+// A bucket that reports every object it stats as soft-deleted.
+type softDeletedBucket struct {
+	gcs.Bucket
+}
+
+func (b softDeletedBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.Bucket.StatObject(ctx, req)
+	if err == nil {
+		o.Deleted = o.Updated
+	}
+
+	return
+}
+
 // Read all of the entries and sort them by name.
 func (t *DirTest) readAllEntries() (entries []fuseutil.Dirent, err error) {
 	tok := ""
@@ -234,6 +251,24 @@ func (t *DirTest) LookUpChild_DirOnly() {
 	ExpectFalse(result.Exists())
 }
 
+func (t *DirTest) LookUpChild_SoftDeleted() {
+	const name = "qux"
+	objName := path.Join(dirInodeName, name)
+
+	// Create a backing object, then arrange for it to appear soft-deleted.
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, objName, []byte("taco"))
+	AssertEq(nil, err)
+
+	t.bucket = softDeletedBucket{t.bucket}
+	t.resetInode(false)
+
+	// It should be treated as absent.
+	result, err := t.in.LookUpChild(t.ctx, name)
+
+	AssertEq(nil, err)
+	ExpectFalse(result.Exists())
+}
+
 func (t *DirTest) LookUpChild_ImplicitDirOnly_Disabled() {
 	const name = "qux"
 	var err error
@@ -658,7 +693,7 @@ func (t *DirTest) CreateChildFile_DoesntExist() {
 	var err error
 
 	// Call the inode.
-	o, err = t.in.CreateChildFile(t.ctx, name)
+	o, err = t.in.CreateChildFile(t.ctx, name, "")
 	AssertEq(nil, err)
 	AssertNe(nil, o)
 
@@ -671,6 +706,14 @@ func (t *DirTest) CreateChildFile_DoesntExist() {
 		o.Metadata["gcsfuse_mtime"])
 }
 
+func (t *DirTest) CreateChildFile_Creator() {
+	o, err := t.in.CreateChildFile(t.ctx, "qux", "some-host")
+	AssertEq(nil, err)
+
+	ExpectEq(2, len(o.Metadata))
+	ExpectEq("some-host", o.Metadata[gcsx.CreatorMetadataKey])
+}
+
 func (t *DirTest) CreateChildFile_Exists() {
 	const name = "qux"
 	objName := path.Join(dirInodeName, name)
@@ -682,7 +725,8 @@ func (t *DirTest) CreateChildFile_Exists() {
 	AssertEq(nil, err)
 
 	// Call the inode.
-	_, err = t.in.CreateChildFile(t.ctx, name)
+	_, err = t.in.CreateChildFile(t.ctx, name, "")
+	ExpectThat(err, HasSameTypeAs(&gcsx.AlreadyExistsError{}))
 	ExpectThat(err, Error(HasSubstr("Precondition")))
 	ExpectThat(err, Error(HasSubstr("exists")))
 }
@@ -696,7 +740,7 @@ func (t *DirTest) CreateChildFile_TypeCaching() {
 	var err error
 
 	// Create the name.
-	_, err = t.in.CreateChildFile(t.ctx, name)
+	_, err = t.in.CreateChildFile(t.ctx, name, "")
 	AssertEq(nil, err)
 
 	// Create a backing object for a directory.
@@ -1046,7 +1090,7 @@ func (t *DirTest) DeleteChildFile_TypeCaching() {
 	var err error
 
 	// Create the name, priming the type cache.
-	_, err = t.in.CreateChildFile(t.ctx, name)
+	_, err = t.in.CreateChildFile(t.ctx, name, "")
 	AssertEq(nil, err)
 
 	// Create a backing object for a directory. It should be shadowed by the
@@ -15,13 +15,21 @@
 package inode
 
 import (
+	"bytes"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/ratelimit"
 	"github.com/jacobsa/syncutil"
 	"github.com/jacobsa/timeutil"
 	"golang.org/x/net/context"
@@ -31,6 +39,166 @@ import (
 // the format defined by time.RFC3339Nano.
 const FileMtimeMetadataKey = gcsx.MtimeMetadataKey
 
+// Options controlling the behavior of a file inode. The zero value gives the
+// default behavior.
+type FileConfig struct {
+	// If non-nil, a debugging aid: called with the inode's name when a file
+	// inode holding local modifications that have not been synced is garbage
+	// collected, which means those modifications were silently lost. Calling
+	// Destroy counts as deliberately discarding them.
+	//
+	// This is implemented with runtime.SetFinalizer, so it is not guaranteed to
+	// catch every such inode, and is called on an arbitrary goroutine.
+	DroppedWhileDirty func(name string)
+
+	// If positive, reads fault in the source object's contents in aligned blocks
+	// of this many bytes, covering the range being read. This amortizes request
+	// overhead across many small nearby reads. If zero, each read faults in
+	// everything from the read's offset to the end of the source object.
+	FaultInBlockSize int64
+
+	// If set along with FaultInBlockSize, a read also starts faulting in the
+	// block following those it covers in the background, if missing, so that a
+	// sequential reader finds it already present. At most one such prefetch is
+	// in flight at a time. A prefetch downloads without holding the inode lock,
+	// and is cancelled by Destroy. Its errors are reported to OnFaultInEnd; a
+	// read that needs the block fetches it as usual.
+	PrefetchNextBlock bool
+
+	// If positive, the maximum number of bytes to fetch with a single request
+	// to GCS when faulting in contents. Larger ranges are fetched with several
+	// requests in sequence. If zero, each missing range is fetched at once.
+	MaxFaultInChunk int64
+
+	// A policy for syncing dirty contents in the background. By default, contents
+	// are synced only when Sync is called.
+	AutoSync AutoSyncConfig
+
+	// If positive, a Sync within this long of the previous upload, as measured
+	// by Clock, uploads nothing and leaves the contents dirty. Instead a
+	// background sync is scheduled for when the interval is up, so that rapid
+	// syncs are coalesced into one new generation holding the latest contents.
+	// ForceSync ignores this, and Destroy carries out a scheduled sync early.
+	MinSyncInterval time.Duration
+
+	// If set, each Write syncs the inode before returning, so that a write that
+	// succeeds has been persisted to GCS. This trades write latency and request
+	// count for durability; see Sync for when only the dirty tail is uploaded.
+	WriteThrough bool
+
+	// The clock used to wait for intervals to elapse, e.g. when polling for
+	// AutoSync.IdleInterval. If nil, gcsx.RealClock() is used.
+	Clock gcsx.Clock
+
+	// If non-nil, a transformation applied to the contents when writing them to
+	// GCS, and undone when reading them back. Sizes reported by the inode are
+	// those of the logical (decoded) contents.
+	Transform ContentTransform
+
+	// Decompressors for objects stored in a compressed format, keyed by the
+	// Content-Encoding recorded for the object. An object whose encoding has an
+	// entry here and that records its uncompressed size under
+	// UncompressedSizeMetadataKey is read as if through a Transform that
+	// decompresses it. Writes are synced uncompressed, after which the
+	// decompressor no longer applies. Ignored when Transform is set.
+	Decompressors map[string]Decompressor
+
+	// If non-empty, the Cache-Control header set on each generation written by
+	// Sync. Otherwise the source object's value, if any, is preserved.
+	CacheControl string
+
+	// If non-empty, an identity for this mount, e.g. its hostname, recorded
+	// under gcsx.CreatorMetadataKey in the objects created for new files. The
+	// file system passes it to DirInode.CreateChildFile. Sync itself never sets
+	// it, but preserves any value the source object carries.
+	Creator string
+
+	// Labels recorded in each generation written by Sync, each under its key
+	// prefixed with gcsx.LabelMetadataPrefix. Labels the source object already
+	// carries are preserved too, with these taking precedence.
+	Labels map[string]string
+
+	// If non-nil, called just before and just after each fetch of the source
+	// object's contents from GCS when faulting them in, with the range being
+	// fetched, e.g. to show progress. OnFaultInEnd receives the fetch's
+	// result, and is called even if it failed. Both are called with the inode
+	// locked, so must not call back into it.
+	OnFaultInStart func(offset int64, length int64)
+	OnFaultInEnd   func(offset int64, length int64, err error)
+
+	// If non-nil, called once for each new generation that Sync uploads, with
+	// the object's name and the generation, after the inode has adopted it as
+	// its source, e.g. to invalidate external caches. It isn't called when
+	// there was nothing to sync, when the sync failed, or when
+	// SkipIdenticalUploads found the contents already present. Called with the
+	// inode locked, so must not call back into it.
+	OnSynced func(name string, generation int64)
+
+	// If non-nil, a throttle from which one token is acquired for each byte of
+	// the source object's contents faulted in, e.g. to stop one large read
+	// from saturating a shared link. Waiting for the throttle respects
+	// cancellation of the context of the read that caused the fault-in.
+	FaultInThrottle ratelimit.Throttle
+
+	// If set, once the inode has seen that its source generation no longer
+	// exists, whether in Attributes or while faulting in, further reads that
+	// need contents from that generation fail immediately with
+	// *gcsx.ClobberedError rather than asking GCS again. This is appropriate
+	// only for buckets without object versioning, where a clobbered
+	// generation never comes back. Syncing to a new generation clears the
+	// condition.
+	FailFastOnClobber bool
+
+	// If set, when GCS serves a read of the source object from a newer
+	// generation than the inode's source generation (see
+	// gcsx.StaleReadError), an inode without local modifications adopts the
+	// newer generation as its source, discarding what it faulted in from the
+	// old one, and restarts the read. Otherwise, or if the inode has local
+	// modifications, the read fails with *gcsx.ClobberedError.
+	FollowNewGenerations bool
+
+	// If set, Sync checks before uploading whether the object's live generation
+	// already has exactly the local contents, by comparing sizes and CRC32C
+	// checksums. If so nothing is written, and the inode adopts that generation
+	// as its source, along with the metadata it already carries (so a new
+	// mtime is not recorded). Ignored when Transform is set.
+	SkipIdenticalUploads bool
+
+	// If set, the inode refuses to modify the file: Write, Truncate, SetMtime,
+	// Sync and copying into it with CopyFileContents fail with
+	// *gcsx.ReadOnlyError, while reads work as usual.
+	ReadOnly bool
+
+	// If set, when the temp directory's file system fills up while writing to,
+	// extending or faulting in the inode's contents, the contents are moved into
+	// memory and the operation is retried, rather than failing with
+	// *gcsx.NoSpaceError. See gcsx.MoveTempFileToMemory.
+	MemoryFallback bool
+
+	// If set, a read starting strictly beyond the end of the file's current
+	// contents fails with *gcsx.OutOfRangeError. By default, as with
+	// io.ReaderAt, it returns io.EOF just like a read starting at the end.
+	StrictReadRange bool
+
+	// The number of times Sync may retry an upload that fails with an error
+	// that gcsx.IsRetryable considers worth retrying, before giving up. Each
+	// attempt reads the contents afresh from the inode's temp file, so a
+	// failure part way through an upload never causes a retry to send less.
+	// Zero means don't retry.
+	SyncRetries int
+
+	// If set, called to create an empty store for each temp file holding the
+	// inode's contents, e.g. to keep them encrypted, in place of an anonymous
+	// file in the temp directory. Contents kept elsewhere can't be mapped with
+	// MMap.
+	NewBackingStore func() (gcsx.BackingStore, error)
+
+	// If non-nil, the inode joins the registry when created and leaves it when
+	// destroyed, allowing contents held locally to be observed and evicted
+	// across inodes. See Registry.
+	Registry *Registry
+}
+
 type FileInode struct {
 	/////////////////////////
 	// Dependencies
@@ -48,6 +216,7 @@ type FileInode struct {
 	name    string
 	attrs   fuseops.InodeAttributes
 	tempDir string
+	cfg     FileConfig
 
 	/////////////////////////
 	// Mutable state
@@ -68,13 +237,137 @@ type FileInode struct {
 	src gcs.Object
 
 	// The current content of this inode, or nil if the source object is still
-	// authoritative.
+	// authoritative. Ranges of the source object's contents are faulted in
+	// lazily, as they are needed.
 	content gcsx.TempFile
 
+	// Set when every range of the source object's contents has been faulted
+	// into f.content, in which case reads can be served from f.content without
+	// consulting it about missing ranges. Once set this stays true until
+	// f.content is replaced, since writes and truncations never make initial
+	// content go missing again.
+	//
+	// INVARIANT: If cached, then content != nil
+	//
+	// GUARDED_BY(mu)
+	cached bool
+
+	// Set when we have seen that src's generation no longer exists in GCS, and
+	// cleared when src is replaced with another generation. See
+	// FileConfig.FailFastOnClobber.
+	//
+	// GUARDED_BY(mu)
+	srcGone bool
+
+	// Set when the bucket has refused a ranged read with
+	// *gcsx.RangeUnsupportedError, after which we read the backing object in
+	// full rather than by range.
+	//
+	// GUARDED_BY(mu)
+	rangesUnsupported bool
+
+	// The number of fetches from GCS made while faulting in contents. See
+	// ReadAtCacheStatus.
+	//
+	// GUARDED_BY(mu)
+	fetches uint64
+
+	// If a goroutine is running prefetch, a function that cancels its context;
+	// otherwise nil. See FileConfig.PrefetchNextBlock.
+	//
+	// GUARDED_BY(mu)
+	cancelPrefetch context.CancelFunc
+
+	// The time, according to cfg.Clock, at which Sync last uploaded a new
+	// generation, or nil if never. See FileConfig.MinSyncInterval.
+	//
+	// GUARDED_BY(mu)
+	lastUpload *time.Time
+
+	// Is there a goroutine waiting to run a sync deferred because of
+	// FileConfig.MinSyncInterval?
+	//
+	// GUARDED_BY(mu)
+	syncDeferred bool
+
 	// Has Destroy been called?
 	//
 	// GUARDED_BY(mu)
 	destroyed bool
+
+	// Shared with a finalizer when cfg.DroppedWhileDirty is set, otherwise nil.
+	//
+	// GUARDED_BY(mu)
+	tracker *dirtyTracker
+
+	// GUARDED_BY(mu)
+	autoSync autoSyncState
+
+	// A stamp identifying the most recent local modification that has not yet
+	// been synced, or zero if there is none. See Version.
+	//
+	// GUARDED_BY(mu)
+	editStamp uint64
+
+	// The CRC32C checksum of the longest prefix of the source object's contents
+	// that has been faulted in and not since modified.
+	//
+	// GUARDED_BY(mu)
+	verified prefixCRC
+
+	// Closed and cleared after each sync and on destruction, to wake callers of
+	// WaitClean. Nil if nobody is waiting.
+	//
+	// GUARDED_BY(mu)
+	cleaned chan struct{}
+
+	// State allowing AbortSync to cancel a Sync in progress. This has its own
+	// lock, since the Sync holds mu.
+	abort syncAbortState
+}
+
+// See FileInode.abort.
+type syncAbortState struct {
+	mu sync.Mutex
+
+	// The cancel function for the context of the Sync in progress, or nil if
+	// there is none.
+	//
+	// GUARDED_BY(mu)
+	cancel context.CancelFunc
+
+	// Has AbortSync been called for the Sync in progress?
+	//
+	// GUARDED_BY(mu)
+	aborted bool
+}
+
+// A source of unique values for FileInode.editStamp.
+var nextEditStamp uint64
+
+// Update f.editStamp to reflect a new local modification.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) noteEdit() {
+	f.editStamp = atomic.AddUint64(&nextEditStamp, 1)
+}
+
+// State used to detect file inodes that are garbage collected while dirty.
+//
+// This is separate from FileInode because FileInode is part of a reference
+// cycle (through its invariant mutex), and the runtime doesn't promise to run
+// finalizers for objects in cycles. Nothing here refers back to the inode, so
+// it becomes unreachable exactly when the inode does.
+type dirtyTracker struct {
+	name  string
+	dirty bool
+	cb    func(name string)
+}
+
+func (t *dirtyTracker) finalize() {
+	if t.dirty {
+		t.cb(t.name)
+	}
 }
 
 var _ Inode = &FileInode{}
@@ -94,7 +387,8 @@ func NewFileInode(
 	bucket gcs.Bucket,
 	syncer gcsx.Syncer,
 	tempDir string,
-	mtimeClock timeutil.Clock) (f *FileInode) {
+	mtimeClock timeutil.Clock,
+	cfg FileConfig) (f *FileInode) {
 	// Set up the basic struct.
 	f = &FileInode{
 		bucket:     bucket,
@@ -104,17 +398,74 @@ func NewFileInode(
 		name:       o.Name,
 		attrs:      attrs,
 		tempDir:    tempDir,
+		cfg:        cfg,
 		src:        *o,
 	}
 
 	f.lc.Init(id)
 
+	if f.cfg.Clock == nil {
+		f.cfg.Clock = gcsx.RealClock()
+	}
+
+	f.autoSync.kick = make(chan struct{}, 1)
+
+	if f.cfg.Registry != nil {
+		f.cfg.Registry.touch(f)
+	}
+
+	// Set up detection of dirty inodes being dropped, if requested.
+	if cfg.DroppedWhileDirty != nil {
+		f.tracker = &dirtyTracker{
+			name: o.Name,
+			cb:   cfg.DroppedWhileDirty,
+		}
+
+		runtime.SetFinalizer(f.tracker, (*dirtyTracker).finalize)
+	}
+
 	// Set up invariant checking.
 	f.mu = syncutil.NewInvariantMutex(f.checkInvariants)
 
 	return
 }
 
+// Stat the named object once and create a file inode for its live
+// generation, as with NewFileInode. If the object doesn't exist, or is only
+// soft-deleted, returns *gcs.NotFoundError.
+//
+// REQUIRES: len(name) > 0
+// REQUIRES: name[len(name)-1] != '/'
+func OpenFileInode(
+	ctx context.Context,
+	id fuseops.InodeID,
+	name string,
+	attrs fuseops.InodeAttributes,
+	bucket gcs.Bucket,
+	syncer gcsx.Syncer,
+	tempDir string,
+	mtimeClock timeutil.Clock,
+	cfg FileConfig) (f *FileInode, err error) {
+	o, err := gcsx.StatObject(ctx, bucket, &gcs.StatObjectRequest{Name: name})
+	switch typed := err.(type) {
+	case nil:
+
+	case *gcs.NotFoundError:
+		return
+
+	case *gcsx.SoftDeletedError:
+		err = &gcs.NotFoundError{Err: typed}
+		return
+
+	default:
+		err = fmt.Errorf("gcsx.StatObject: %v", err)
+		return
+	}
+
+	f = NewFileInode(id, o, attrs, bucket, syncer, tempDir, mtimeClock, cfg)
+	return
+}
+
 ////////////////////////////////////////////////////////////////////////
 // Helpers
 ////////////////////////////////////////////////////////////////////////
@@ -140,356 +491,2244 @@ func (f *FileInode) checkInvariants() {
 	if f.content != nil {
 		f.content.CheckInvariants()
 	}
+
+	// INVARIANT: If cached, then content != nil
+	if f.cached && f.content == nil {
+		panic("Cached without content")
+	}
 }
 
 // LOCKS_REQUIRED(f.mu)
 func (f *FileInode) clobbered(ctx context.Context) (b bool, err error) {
 	// Stat the object in GCS.
 	req := &gcs.StatObjectRequest{Name: f.name}
-	o, err := f.bucket.StatObject(ctx, req)
+	o, err := gcsx.StatObject(ctx, f.bucket, req)
 
-	// Special case: "not found" means we have been clobbered.
-	if _, ok := err.(*gcs.NotFoundError); ok {
+	// Special case: "not found" means we have been clobbered, even if the
+	// object is still recoverable.
+	switch err.(type) {
+	case *gcs.NotFoundError, *gcsx.SoftDeletedError:
 		err = nil
 		b = true
+		f.srcGone = true
 		return
 	}
 
 	// Propagate other errors.
 	if err != nil {
-		err = fmt.Errorf("StatObject: %v", err)
+		err = fmt.Errorf("gcsx.StatObject: %v", err)
 		return
 	}
 
 	// We are clobbered iff the generation doesn't match our source generation.
+	// A change in meta-generation alone leaves our contents readable.
 	oGen := Generation{o.Generation, o.MetaGeneration}
 	b = f.SourceGeneration().Compare(oGen) != 0
+	if o.Generation != f.src.Generation {
+		f.srcGone = true
+	}
 
 	return
 }
 
-// Ensure that f.content != nil
+// Does f.content hold modifications that have not yet been synced?
 //
 // LOCKS_REQUIRED(f.mu)
-func (f *FileInode) ensureContent(ctx context.Context) (err error) {
-	// Is there anything to do?
-	if f.content != nil {
+func (f *FileInode) dirty() (d bool, err error) {
+	if f.content == nil {
 		return
 	}
 
-	// Open a reader for the generation we care about.
-	rc, err := f.bucket.NewReader(
-		ctx,
-		&gcs.ReadObjectRequest{
-			Name:       f.src.Name,
-			Generation: f.src.Generation,
-		})
+	sr, err := f.content.Stat()
+	if err != nil {
+		err = fmt.Errorf("Stat: %v", err)
+		return
+	}
+
+	srcSize := f.srcSize()
+	d = !(sr.Size == srcSize && sr.DirtyThreshold == srcSize)
+
+	return
+}
+
+// Bring f.tracker up to date after a change to f.content, if it is in use.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) updateTracker() (err error) {
+	if f.tracker == nil {
+		return
+	}
 
+	f.tracker.dirty, err = f.dirty()
 	if err != nil {
-		err = fmt.Errorf("NewReader: %v", err)
+		err = fmt.Errorf("dirty: %v", err)
 		return
 	}
 
-	defer rc.Close()
+	return
+}
+
+// Ensure that f.content != nil. This doesn't fault in any of the source
+// object's contents; see faultIn.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) ensureContent(ctx context.Context) (err error) {
+	// Is there anything to do?
+	if f.content != nil {
+		return
+	}
 
-	// Create a temporary file with its contents.
-	tf, err := gcsx.NewTempFile(rc, f.tempDir, f.mtimeClock)
+	// Create an empty temporary file of the appropriate size.
+	tf, err := f.newSparseTempFile(f.srcSize())
 	if err != nil {
-		err = fmt.Errorf("NewTempFile: %v", err)
+		err = fmt.Errorf("newSparseTempFile: %v", err)
 		return
 	}
 
 	// Update state.
 	f.content = tf
+	f.cached = false
 
 	return
 }
 
-////////////////////////////////////////////////////////////////////////
-// Public interface
-////////////////////////////////////////////////////////////////////////
+// Create a temp file with the supplied initial contents, kept in a backing
+// store from FileConfig.NewBackingStore if set, or in f.tempDir otherwise.
+func (f *FileInode) newTempFile(r io.Reader) (tf gcsx.TempFile, err error) {
+	if f.cfg.NewBackingStore == nil {
+		tf, err = gcsx.NewTempFile(r, f.tempDir, f.mtimeClock)
+		return
+	}
 
-func (f *FileInode) Lock() {
-	f.mu.Lock()
-}
+	store, err := f.cfg.NewBackingStore()
+	if err != nil {
+		err = fmt.Errorf("NewBackingStore: %v", err)
+		return
+	}
 
-func (f *FileInode) Unlock() {
-	f.mu.Unlock()
+	tf, err = gcsx.NewTempFileWithStore(r, store, f.mtimeClock)
+	return
 }
 
-func (f *FileInode) ID() fuseops.InodeID {
-	return f.id
-}
+// Like newTempFile, but for a sparse temp file of the given size.
+func (f *FileInode) newSparseTempFile(size int64) (tf gcsx.TempFile, err error) {
+	if f.cfg.NewBackingStore == nil {
+		tf, err = gcsx.NewSparseTempFile(size, f.tempDir, f.mtimeClock)
+		return
+	}
 
-func (f *FileInode) Name() string {
-	return f.name
-}
+	store, err := f.cfg.NewBackingStore()
+	if err != nil {
+		err = fmt.Errorf("NewBackingStore: %v", err)
+		return
+	}
 
-// Return a record for the GCS object from which this inode is branched. The
-// record is guaranteed not to be modified, and users must not modify it.
-//
-// LOCKS_REQUIRED(f.mu)
-func (f *FileInode) Source() *gcs.Object {
-	// Make a copy, since we modify f.src.
-	o := f.src
-	return &o
+	tf, err = gcsx.NewSparseTempFileWithStore(size, store, f.mtimeClock)
+	return
 }
 
-// If true, it is safe to serve reads directly from the object given by
-// f.Source(), rather than calling f.ReadAt. Doing so may be more efficient,
-// because f.ReadAt may cause the entire object to be faulted in and requires
-// the inode to be locked during the read.
+// Ensure that any parts of the range [start, limit) of f.content that are
+// derived from the source object have been read from GCS.
 //
-// LOCKS_REQUIRED(f.mu)
-func (f *FileInode) SourceGenerationIsAuthoritative() bool {
-	return f.content == nil
-}
-
-// Equivalent to the generation returned by f.Source().
+// Returns *gcs.NotFoundError unmodified if the source generation no longer
+// exists, *gcsx.StaleReadError if GCS serves another generation, and
+// *gcsx.SizeMismatchError if GCS returns the wrong amount of data.
 //
-// LOCKS_REQUIRED(f)
-func (f *FileInode) SourceGeneration() (g Generation) {
-	g.Object = f.src.Generation
-	g.Metadata = f.src.MetaGeneration
-	return
-}
-
 // LOCKS_REQUIRED(f.mu)
-func (f *FileInode) IncrementLookupCount() {
-	f.lc.Inc()
-}
+// REQUIRES: f.content != nil
+func (f *FileInode) faultIn(
+	ctx context.Context,
+	start int64,
+	limit int64) (err error) {
+	// Transformed contents must be fetched in full, as must anything from a
+	// bucket that can't serve ranges, so get everything at once.
+	whole := f.transform() != nil || f.rangesUnsupported
+	if whole {
+		start, limit = 0, f.srcSize()
+	}
 
-// LOCKS_REQUIRED(f.mu)
-func (f *FileInode) DecrementLookupCount(n uint64) (destroy bool) {
-	destroy = f.lc.Dec(n)
-	return
-}
+	missing, err := f.content.Missing(start, limit)
+	if err != nil {
+		err = fmt.Errorf("Missing: %v", err)
+		return
+	}
 
-// LOCKS_REQUIRED(f.mu)
-func (f *FileInode) Destroy() (err error) {
-	f.destroyed = true
+	// Don't bother asking for a generation we know is gone.
+	if len(missing) > 0 && f.srcGone && f.cfg.FailFastOnClobber {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf(
+				"Generation %d of %q is known to have been clobbered",
+				f.src.Generation,
+				f.src.Name),
+		}
 
-	if f.content != nil {
-		f.content.Destroy()
+		return
 	}
 
-	return
-}
-
-// LOCKS_REQUIRED(f.mu)
-func (f *FileInode) Attributes(
-	ctx context.Context) (attrs fuseops.InodeAttributes, err error) {
-	attrs = f.attrs
+	if whole {
+		if len(missing) > 0 {
+			err = f.fetchNotifying(start, limit, func() error {
+				return f.faultInDecoded(ctx, missing)
+			})
+		}
 
-	// Obtain default information from the source object.
-	attrs.Mtime = f.src.Updated
-	attrs.Size = uint64(f.src.Size)
+		switch err.(type) {
+		case *gcs.NotFoundError, *gcsx.StaleReadError:
+			f.srcGone = true
 
-	// We require only that atime and ctime be "reasonable".
-	attrs.Atime = attrs.Mtime
-	attrs.Ctime = attrs.Mtime
+		case *gcsx.NoSpaceError:
+			if f.fallBackToMemory(err) {
+				err = f.faultIn(ctx, start, limit)
+				return
+			}
+		}
 
-	// If the source object has an mtime metadata key, use that instead of its
-	// update time.
-	if formatted, ok := f.src.Metadata["gcsfuse_mtime"]; ok {
-		attrs.Mtime, err = time.Parse(time.RFC3339Nano, formatted)
-		if err != nil {
-			err = fmt.Errorf("time.Parse(%q): %v", formatted, err)
+		// Checksums cover the encoded contents, so there's nothing more to do
+		// for transformed contents.
+		if err != nil || f.transform() != nil {
 			return
 		}
+	} else {
+		for _, r := range f.splitForFaultIn(missing) {
+			err = f.fetchNotifying(int64(r.Start), int64(r.Limit), func() error {
+				return f.faultInRange(ctx, r)
+			})
+
+			// Remember if the generation has gone away. Don't mangle typed
+			// errors.
+			switch err.(type) {
+			case *gcs.NotFoundError, *gcsx.StaleReadError:
+				f.srcGone = true
+				return
+
+			case *gcsx.SizeMismatchError:
+				return
+
+			case *gcsx.RangeUnsupportedError:
+				// Fall back to reading the whole object, now and from now on.
+				f.rangesUnsupported = true
+				err = f.faultIn(ctx, start, limit)
+				return
+
+			case *gcsx.NoSpaceError:
+				if f.fallBackToMemory(err) {
+					err = f.faultIn(ctx, start, limit)
+				}
+
+				return
+			}
+
+			if err != nil {
+				err = fmt.Errorf("faultInRange(%v): %v", r, err)
+				return
+			}
+		}
 	}
 
-	// If we've got local content, its size and (maybe) mtime take precedence.
-	if f.content != nil {
-		var sr gcsx.StatResult
-		sr, err = f.content.Stat()
-		if err != nil {
-			err = fmt.Errorf("Stat: %v", err)
+	// Incorporate anything new into our checksum.
+	if len(missing) > 0 {
+		err = f.extendVerifiedPrefix()
+
+		// Don't mangle checksum mismatch errors.
+		if _, ok := err.(*gcsx.ChecksumMismatchError); ok {
 			return
 		}
 
-		attrs.Size = uint64(sr.Size)
-		if sr.Mtime != nil {
-			attrs.Mtime = *sr.Mtime
+		if err != nil {
+			err = fmt.Errorf("extendVerifiedPrefix: %v", err)
+			return
 		}
 	}
 
-	// If the object has been clobbered, we reflect that as the inode being
-	// unlinked.
-	clobbered, err := f.clobbered(ctx)
-	if err != nil {
-		err = fmt.Errorf("clobbered: %v", err)
-		return
+	// If we covered the whole object, there's nothing left to fault in.
+	if start <= 0 && limit >= f.srcSize() {
+		f.cached = true
 	}
 
-	if !clobbered {
-		attrs.Nlink = 1
+	return
+}
+
+// Apply the configured fault-in throttle, if any, to reads from r.
+func (f *FileInode) throttled(ctx context.Context, r io.Reader) io.Reader {
+	if f.cfg.FaultInThrottle == nil {
+		return r
 	}
 
+	return ratelimit.ThrottledReader(ctx, r, f.cfg.FaultInThrottle)
+}
+
+// Call fetch, which fetches [start, limit) of the source object's contents,
+// surrounded by calls to the configured fault-in hooks.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) fetchNotifying(
+	start int64,
+	limit int64,
+	fetch func() error) (err error) {
+	f.startFetch(start, limit)
+	err = fetch()
+	f.endFetch(start, limit, err)
+
 	return
 }
 
-// Serve a read for this file with semantics matching io.ReaderAt.
+// Record the start of a fetch of [start, limit) of the source object's
+// contents, calling the configured hook.
 //
-// The caller may be better off reading directly from GCS when
-// f.SourceGenerationIsAuthoritative() is true.
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) startFetch(start int64, limit int64) {
+	f.fetches++
+	if f.cfg.OnFaultInStart != nil {
+		f.cfg.OnFaultInStart(start, limit-start)
+	}
+}
+
+// Record the end of a fetch started with startFetch, calling the configured
+// hook.
 //
 // LOCKS_REQUIRED(f.mu)
-func (f *FileInode) Read(
-	ctx context.Context,
-	dst []byte,
-	offset int64) (n int, err error) {
-	// Make sure f.content != nil.
+func (f *FileInode) endFetch(start int64, limit int64, err error) {
+	if f.cfg.OnFaultInEnd != nil {
+		f.cfg.OnFaultInEnd(start, limit-start, err)
+	}
+}
+
+// Extend f.verified as far as possible over the unmodified prefix of f.content
+// that has been faulted in. If this completes the source object's contents,
+// compare against the checksum that GCS recorded for it, returning
+// *gcsx.ChecksumMismatchError if they differ.
+//
+// LOCKS_REQUIRED(f.mu)
+// REQUIRES: f.content != nil
+func (f *FileInode) extendVerifiedPrefix() (err error) {
+	srcSize := int64(f.src.Size)
+
+	// GCS's checksum covers the encoded form, which we don't keep.
+	if f.transform() != nil {
+		return
+	}
+
+	if f.verified.length >= srcSize {
+		return
+	}
+
+	// Don't go beyond the first modification or the first missing byte.
+	sr, err := f.content.Stat()
+	if err != nil {
+		err = fmt.Errorf("Stat: %v", err)
+		return
+	}
+
+	limit := srcSize
+	if sr.DirtyThreshold < limit {
+		limit = sr.DirtyThreshold
+	}
+
+	missing, err := f.content.Missing(f.verified.length, limit)
+	if err != nil {
+		err = fmt.Errorf("Missing: %v", err)
+		return
+	}
+
+	if len(missing) > 0 {
+		limit = int64(missing[0].Start)
+	}
+
+	// Extend.
+	err = f.verified.extend(f.content, limit)
+	if err != nil {
+		err = fmt.Errorf("extend: %v", err)
+		return
+	}
+
+	// Check the checksum if we've got everything.
+	if f.verified.length == srcSize && f.verified.crc != f.src.CRC32C {
+		err = &gcsx.ChecksumMismatchError{
+			Name:     f.src.Name,
+			Expected: f.src.CRC32C,
+			Actual:   f.verified.crc,
+		}
+
+		return
+	}
+
+	return
+}
+
+// Return the length of the longest prefix of the source object's contents
+// that has been faulted in and not since modified, and the CRC32C checksum of
+// those bytes. If the local contents can't be examined, nothing is reported as
+// verified.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) VerifiedPrefix() (length int64, crc uint32) {
+	// If the contents have been modified within the prefix since we computed
+	// it, clip it to the first modification. A CRC can't be shortened, so
+	// recompute it from the local contents.
+	if f.content != nil {
+		sr, err := f.content.Stat()
+		if err != nil {
+			return
+		}
+
+		if sr.DirtyThreshold < f.verified.length {
+			var clipped prefixCRC
+			err = clipped.extend(f.content, sr.DirtyThreshold)
+			if err != nil {
+				return
+			}
+
+			f.verified = clipped
+		}
+	}
+
+	length = f.verified.length
+	crc = f.verified.crc
+	return
+}
+
+// Return the ranges of the file's contents that have been modified locally
+// since the last sync, in increasing order. See gcsx.TempFile.DirtyRanges.
+// Empty if the inode is clean.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) DirtyRanges() (ranges []gcs.ByteRange) {
+	if f.content == nil {
+		return
+	}
+
+	ranges = f.content.DirtyRanges()
+	return
+}
+
+// Return the number of bytes of the file's contents held locally, whether
+// faulted in from the source object or written.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) MaterializedBytes() (n int64, err error) {
+	if f.content == nil || f.destroyed {
+		return
+	}
+
+	sr, err := f.content.Stat()
+	if err != nil {
+		err = fmt.Errorf("Stat: %v", err)
+		return
+	}
+
+	n = sr.MaterializedBytes
+	return
+}
+
+// Discard the contents held locally if they have no modifications, returning
+// the number of bytes released. They are faulted in again as needed. Contents
+// with modifications are left alone, returning zero.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) EvictClean() (evicted int64, err error) {
+	dirty, err := f.dirty()
+	if err != nil {
+		err = fmt.Errorf("dirty: %v", err)
+		return
+	}
+
+	if dirty {
+		return
+	}
+
+	n, err := f.MaterializedBytes()
+	if err != nil {
+		err = fmt.Errorf("MaterializedBytes: %v", err)
+		return
+	}
+
+	if f.content != nil && !f.destroyed {
+		f.content.Destroy()
+		f.content = nil
+		f.cached = false
+		f.verified = prefixCRC{}
+	}
+
+	evicted = n
+	return
+}
+
+// Note that the inode has been used, for the sake of FileConfig.Registry.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) noteUse() {
+	if f.cfg.Registry != nil && !f.destroyed {
+		f.cfg.Registry.touch(f)
+	}
+}
+
+// Return the range of the source object that should be faulted in to serve a
+// read of size bytes at the given offset. By default we fetch through to the
+// end of the object, on the assumption that the caller will continue to read
+// sequentially; see FileConfig.FaultInBlockSize.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) faultInRangeForRead(
+	offset int64,
+	size int64) (start int64, limit int64) {
+	start = offset
+	limit = f.srcSize()
+
+	bs := f.cfg.FaultInBlockSize
+	if bs <= 0 {
+		return
+	}
+
+	start = offset / bs * bs
+	if end := (offset + size + bs - 1) / bs * bs; end < limit {
+		limit = end
+	}
+
+	return
+}
+
+// If configured, start faulting in the block beginning at start in the
+// background, unless it is already present or another prefetch is in flight.
+//
+// LOCKS_REQUIRED(f.mu)
+// REQUIRES: f.content != nil
+func (f *FileInode) maybePrefetch(start int64) {
+	bs := f.cfg.FaultInBlockSize
+	if !f.cfg.PrefetchNextBlock || bs <= 0 || f.cancelPrefetch != nil {
+		return
+	}
+
+	// Transformed contents, and those of a bucket that can't serve ranges, are
+	// faulted in all at once, so there is never a next block. Nor is there any
+	// point in asking for a generation we know is gone.
+	if f.transform() != nil || f.rangesUnsupported || f.srcGone {
+		return
+	}
+
+	limit := start + bs
+	if srcSize := f.srcSize(); limit > srcSize {
+		limit = srcSize
+	}
+
+	if start >= limit {
+		return
+	}
+
+	missing, err := f.content.Missing(start, limit)
+	if err != nil || len(missing) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancelPrefetch = cancel
+	go f.prefetch(ctx, start, limit)
+}
+
+// Fault in [start, limit) of the source object, on behalf of maybePrefetch.
+// The inode is locked only to decide what to fetch and to incorporate the
+// result, not while downloading, so that the prefetch doesn't hold up other
+// operations.
+//
+// LOCKS_EXCLUDED(f.mu)
+func (f *FileInode) prefetch(ctx context.Context, start int64, limit int64) {
+	// Decide what to fetch.
+	f.mu.Lock()
+	src, r, ok := f.planPrefetch(start, limit)
+	if !ok {
+		f.finishPrefetch()
+		f.mu.Unlock()
+		return
+	}
+
+	f.startFetch(int64(r.Start), int64(r.Limit))
+	f.mu.Unlock()
+
+	// Download, in chunks if so configured.
+	buf := make([]byte, r.Limit-r.Start)
+	var err error
+	for _, c := range f.splitForFaultIn([]gcs.ByteRange{r}) {
+		err = f.readRange(ctx, &src, c, buf[c.Start-r.Start:c.Limit-r.Start])
+		if err != nil {
+			break
+		}
+	}
+
+	// Incorporate what we fetched, if it's still wanted.
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	defer f.finishPrefetch()
+
+	if err == nil {
+		err = f.materializePrefetched(&src, r, buf)
+	}
+
+	// Remember what we've learned about the source, as faultIn does.
+	switch err.(type) {
+	case *gcs.NotFoundError, *gcsx.StaleReadError:
+		if f.src.Generation == src.Generation {
+			f.srcGone = true
+		}
+
+	case *gcsx.RangeUnsupportedError:
+		f.rangesUnsupported = true
+	}
+
+	f.endFetch(int64(r.Start), int64(r.Limit), err)
+}
+
+// Return the source object and the span of [start, limit) of its contents
+// that a prefetch should fetch, or false if there is nothing to fetch.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) planPrefetch(
+	start int64,
+	limit int64) (src gcs.Object, r gcs.ByteRange, ok bool) {
+	// The inode may have changed since maybePrefetch. It's harmless to fetch
+	// from a new source, provided we stay within it, but there's no point in
+	// fetching for a destroyed inode.
+	if f.destroyed || f.content == nil {
+		return
+	}
+
+	if srcSize := f.srcSize(); limit > srcSize {
+		limit = srcSize
+	}
+
+	if start >= limit {
+		return
+	}
+
+	missing, err := f.content.Missing(start, limit)
+	if err != nil || len(missing) == 0 {
+		return
+	}
+
+	src = f.src
+	r = gcs.ByteRange{
+		Start: missing[0].Start,
+		Limit: missing[len(missing)-1].Limit,
+	}
+
+	ok = true
+	return
+}
+
+// Materialize the parts of r still missing from f.content, given buf holding
+// r of the source object src, provided src is still our source. Anything
+// faulted in or written in the meantime takes precedence.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) materializePrefetched(
+	src *gcs.Object,
+	r gcs.ByteRange,
+	buf []byte) (err error) {
+	if f.destroyed || f.content == nil || f.src.Generation != src.Generation {
+		return
+	}
+
+	missing, err := f.content.Missing(int64(r.Start), int64(r.Limit))
+	if err != nil {
+		err = fmt.Errorf("Missing: %v", err)
+		return
+	}
+
+	for _, m := range missing {
+		_, err = f.content.Materialize(
+			bytes.NewReader(buf[m.Start-r.Start:m.Limit-r.Start]),
+			int64(m.Start))
+
+		if err != nil {
+			err = fmt.Errorf("Materialize: %v", err)
+			return
+		}
+	}
+
+	// Incorporate anything new into our checksum. Don't mangle checksum
+	// mismatch errors.
+	if len(missing) > 0 {
+		err = f.extendVerifiedPrefix()
+		if _, ok := err.(*gcsx.ChecksumMismatchError); ok {
+			return
+		}
+
+		if err != nil {
+			err = fmt.Errorf("extendVerifiedPrefix: %v", err)
+			return
+		}
+	}
+
+	return
+}
+
+// Record that the goroutine running prefetch is done, allowing another.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) finishPrefetch() {
+	f.cancelPrefetch()
+	f.cancelPrefetch = nil
+}
+
+// Return *gcsx.ReadOnlyError for the named operation if f.cfg.ReadOnly is set.
+func (f *FileInode) checkWritable(op string) (err error) {
+	if f.cfg.ReadOnly {
+		err = &gcsx.ReadOnlyError{Name: f.name, Op: op}
+	}
+
+	return
+}
+
+// If err is *gcsx.NoSpaceError and f.cfg.MemoryFallback is set, move
+// f.content into memory so that the operation that failed can be retried,
+// returning true if that succeeded.
+//
+// LOCKS_REQUIRED(f.mu)
+// REQUIRES: f.content != nil
+func (f *FileInode) fallBackToMemory(err error) bool {
+	if _, ok := err.(*gcsx.NoSpaceError); !ok || !f.cfg.MemoryFallback {
+		return false
+	}
+
+	return gcsx.MoveTempFileToMemory(f.content) == nil
+}
+
+// Split the supplied ranges into pieces no longer than f.cfg.MaxFaultInChunk,
+// if set.
+func (f *FileInode) splitForFaultIn(
+	ranges []gcs.ByteRange) (out []gcs.ByteRange) {
+	if f.cfg.MaxFaultInChunk <= 0 {
+		out = ranges
+		return
+	}
+
+	max := uint64(f.cfg.MaxFaultInChunk)
+
+	for _, r := range ranges {
+		for start := r.Start; start < r.Limit; start += max {
+			limit := start + max
+			if limit > r.Limit {
+				limit = r.Limit
+			}
+
+			out = append(out, gcs.ByteRange{Start: start, Limit: limit})
+		}
+	}
+
+	return
+}
+
+// LOCKS_REQUIRED(f.mu)
+// REQUIRES: f.content != nil
+// REQUIRES: r is a sub-range of one returned by f.content.Missing
+func (f *FileInode) faultInRange(
+	ctx context.Context,
+	r gcs.ByteRange) (err error) {
+	// Open a reader for the range and generation we care about.
+	req := &gcs.ReadObjectRequest{
+		Name:       f.src.Name,
+		Generation: f.src.Generation,
+		Range:      &r,
+	}
+
+	rc, err := f.bucket.NewReader(ctx, req)
+
+	// Don't mangle not found or range errors.
+	switch err.(type) {
+	case *gcs.NotFoundError, *gcsx.RangeUnsupportedError:
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("NewReader: %v", err)
+		return
+	}
+
+	defer rc.Close()
+
+	// Make sure we're not being served some other generation.
+	err = gcsx.CheckReadGeneration(req, rc)
+	if err != nil {
+		return
+	}
+
+	// Copy the contents into the temp file, making sure we don't get more or
+	// less than we asked for.
+	expected := int64(r.Limit - r.Start)
+	n, err := f.content.Materialize(
+		io.LimitReader(f.throttled(ctx, rc), expected),
+		int64(r.Start))
+
+	// The generation may disappear while we're reading it, in which case the
+	// reader's not found error must reach faultIn intact.
+	switch err.(type) {
+	case *gcs.NotFoundError, *gcsx.NoSpaceError:
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("Materialize: %v", err)
+		return
+	}
+
+	// Make sure that the reader ends exactly where we expected. If it doesn't,
+	// something is seriously inconsistent in GCS.
+	if n == expected {
+		var extra [1]byte
+		m, _ := io.ReadFull(rc, extra[:])
+		n += int64(m)
+	}
+
+	if n != expected {
+		err = &gcsx.SizeMismatchError{
+			Name:     f.src.Name,
+			Expected: expected,
+			Actual:   n,
+		}
+
+		return
+	}
+
+	return
+}
+
+// Read the range r of the source object src into buf, which must be exactly
+// as long as r, returning errors as faultInRange does. This touches none of
+// f's mutable state, so can be called without holding f.mu.
+func (f *FileInode) readRange(
+	ctx context.Context,
+	src *gcs.Object,
+	r gcs.ByteRange,
+	buf []byte) (err error) {
+	req := &gcs.ReadObjectRequest{
+		Name:       src.Name,
+		Generation: src.Generation,
+		Range:      &r,
+	}
+
+	rc, err := f.bucket.NewReader(ctx, req)
+
+	// Don't mangle not found or range errors.
+	switch err.(type) {
+	case *gcs.NotFoundError, *gcsx.RangeUnsupportedError:
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("NewReader: %v", err)
+		return
+	}
+
+	defer rc.Close()
+
+	// Make sure we're not being served some other generation.
+	err = gcsx.CheckReadGeneration(req, rc)
+	if err != nil {
+		return
+	}
+
+	// Read exactly what we asked for. The generation may disappear while we're
+	// reading it, in which case the reader's not found error must reach the
+	// caller intact.
+	n, err := io.ReadFull(f.throttled(ctx, rc), buf)
+	switch err {
+	case nil:
+		var extra [1]byte
+		m, _ := io.ReadFull(rc, extra[:])
+		n += m
+
+	case io.EOF, io.ErrUnexpectedEOF:
+		err = nil
+
+	default:
+		if _, ok := err.(*gcs.NotFoundError); !ok {
+			err = fmt.Errorf("ReadFull: %v", err)
+		}
+
+		return
+	}
+
+	if n != len(buf) {
+		err = &gcsx.SizeMismatchError{
+			Name:     src.Name,
+			Expected: int64(len(buf)),
+			Actual:   int64(n),
+		}
+
+		return
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Public interface
+////////////////////////////////////////////////////////////////////////
+
+func (f *FileInode) Lock() {
+	f.mu.Lock()
+}
+
+func (f *FileInode) Unlock() {
+	f.mu.Unlock()
+}
+
+func (f *FileInode) ID() fuseops.InodeID {
+	return f.id
+}
+
+func (f *FileInode) Name() string {
+	return f.name
+}
+
+// Return the name of the bucket in which the inode's backing object lives.
+func (f *FileInode) BucketName() string {
+	return f.bucket.Name()
+}
+
+// Return a record for the GCS object from which this inode is branched. The
+// record is guaranteed not to be modified, and users must not modify it.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) Source() *gcs.Object {
+	// Make a copy, since we modify f.src.
+	o := f.src
+	return &o
+}
+
+// Return the entity that owns the object backing this inode, as reported by
+// GCS (e.g. "user-foo@example.com"), so that it can be mapped to a local user.
+// Returns the empty string if GCS didn't report an owner, or if the inode
+// holds modifications that have not been synced, since the generation that
+// will hold them doesn't exist yet. GCS doesn't report an object's ACL, or
+// the predefined ACL it was created with, so these aren't available.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) Owner() (owner string, err error) {
+	dirty, err := f.dirty()
+	if err != nil {
+		err = fmt.Errorf("dirty: %v", err)
+		return
+	}
+
+	if !dirty {
+		owner = f.src.Owner
+	}
+
+	return
+}
+
+// If true, it is safe to serve reads directly from the object given by
+// f.Source(), rather than calling f.ReadAt. Doing so may be more efficient,
+// because f.ReadAt may cause the entire object to be faulted in and requires
+// the inode to be locked during the read.
+//
+// Always false if f's config has a transform, since the object then holds
+// encoded contents.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) SourceGenerationIsAuthoritative() bool {
+	return f.content == nil && f.transform() == nil
+}
+
+// Equivalent to the generation returned by f.Source().
+//
+// LOCKS_REQUIRED(f)
+func (f *FileInode) SourceGeneration() (g Generation) {
+	g.Object = f.src.Generation
+	g.Metadata = f.src.MetaGeneration
+	return
+}
+
+// Return an opaque token identifying the current contents and metadata of the
+// inode, suitable for use as an HTTP ETag. When there are no unsynced local
+// modifications this is derived from f.Name() and f.SourceGeneration(), and so
+// is stable across inodes for the same generation. Otherwise it is unique to
+// the latest modification within this process.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) Version() string {
+	v := fmt.Sprintf("%q@%d.%d", f.name, f.src.Generation, f.src.MetaGeneration)
+	if f.editStamp != 0 {
+		v += fmt.Sprintf("+%d", f.editStamp)
+	}
+
+	return v
+}
+
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) IncrementLookupCount() {
+	f.lc.Inc()
+}
+
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) DecrementLookupCount(n uint64) (destroy bool) {
+	destroy = f.lc.Dec(n)
+	return
+}
+
+// If a sync has been deferred because of FileConfig.MinSyncInterval, it is
+// carried out first, since whoever asked for it was told it succeeded. The
+// inode is destroyed even if that fails.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) Destroy() (err error) {
+	if f.syncDeferred {
+		err = f.ForceSync(context.Background())
+		if err != nil {
+			err = fmt.Errorf("ForceSync: %v", err)
+		}
+	}
+
+	f.destroyed = true
+
+	if f.cancelPrefetch != nil {
+		f.cancelPrefetch()
+	}
+
+	if f.content != nil {
+		f.content.Destroy()
+	}
+
+	// The contents have been deliberately discarded.
+	if f.tracker != nil {
+		f.tracker.dirty = false
+	}
+
+	if f.cfg.Registry != nil {
+		f.cfg.Registry.remove(f)
+	}
+
+	f.wakeCleanWaiters()
+	f.kickAutoSync()
+
+	return
+}
+
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) Attributes(
+	ctx context.Context) (attrs fuseops.InodeAttributes, err error) {
+	attrs = f.attrs
+
+	// Obtain default information from the source object.
+	attrs.Mtime = f.src.Updated
+	attrs.Size = uint64(f.srcSize())
+
+	// We require only that atime and ctime be "reasonable".
+	attrs.Atime = attrs.Mtime
+	attrs.Ctime = attrs.Mtime
+
+	// If the source object has an mtime metadata key, use that instead of its
+	// update time.
+	if formatted, ok := f.src.Metadata["gcsfuse_mtime"]; ok {
+		attrs.Mtime, err = time.Parse(time.RFC3339Nano, formatted)
+		if err != nil {
+			err = fmt.Errorf("time.Parse(%q): %v", formatted, err)
+			return
+		}
+	}
+
+	// If we've got local content, its size and (maybe) mtime take precedence.
+	if f.content != nil {
+		var sr gcsx.StatResult
+		sr, err = f.content.Stat()
+		if err != nil {
+			err = fmt.Errorf("Stat: %v", err)
+			return
+		}
+
+		attrs.Size = uint64(sr.Size)
+		if sr.Mtime != nil {
+			attrs.Mtime = *sr.Mtime
+		}
+	}
+
+	// If the object has been clobbered, we reflect that as the inode being
+	// unlinked.
+	clobbered, err := f.clobbered(ctx)
+	if err != nil {
+		err = fmt.Errorf("clobbered: %v", err)
+		return
+	}
+
+	if !clobbered {
+		attrs.Nlink = 1
+	}
+
+	return
+}
+
+// Return the current size of the file's contents, including local
+// modifications. Unlike Attributes, this never contacts GCS.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) Size() (size int64, err error) {
+	if f.content == nil {
+		size = f.srcSize()
+		return
+	}
+
+	sr, err := f.content.Stat()
+	if err != nil {
+		err = fmt.Errorf("Stat: %v", err)
+		return
+	}
+
+	size = sr.Size
+	return
+}
+
+// Return the number of bytes of the file's contents, including local
+// modifications, from the given offset to the end. This is zero at or beyond
+// the end. Like Size, this never contacts GCS.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) RemainingAt(offset int64) (n int64, err error) {
+	size, err := f.Size()
+	if err != nil {
+		return
+	}
+
+	if offset < size {
+		n = size - offset
+	}
+
+	return
+}
+
+// Serve a read for this file with semantics matching io.ReaderAt.
+//
+// Data written locally is served as-is and never re-fetched; only the parts of
+// the read range still missing from the local content are faulted in from the
+// source object. An empty source object is never fetched at all.
+//
+// If any of the source object's contents must be faulted in and the source
+// generation no longer exists in GCS, returns *gcsx.ClobberedError; see also
+// FileConfig.FollowNewGenerations. If GCS returns the wrong amount of data,
+// returns *gcsx.SizeMismatchError. If the data doesn't match the object's
+// checksum, returns *gcsx.ChecksumMismatchError. See also
+// FileConfig.StrictReadRange.
+//
+// Faulting in happens with f.mu held, so an inode never has more than one
+// reader open on its source object at once on behalf of its callers, however
+// many are reading disjoint ranges, plus at most one for a prefetch.
+//
+// The caller may be better off reading directly from GCS when
+// f.SourceGenerationIsAuthoritative() is true.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) Read(
+	ctx context.Context,
+	dst []byte,
+	offset int64) (n int, err error) {
+	f.noteUse()
+
+	// Reject reads beyond the end, if requested.
+	if f.cfg.StrictReadRange {
+		var size int64
+		size, err = f.Size()
+		if err != nil {
+			err = fmt.Errorf("Size: %v", err)
+			return
+		}
+
+		if offset > size {
+			err = &gcsx.OutOfRangeError{
+				Name:   f.name,
+				Offset: offset,
+				Size:   size,
+			}
+
+			return
+		}
+	}
+
+	// Fast path: if everything has been faulted in, there's no need to ask the
+	// content what's missing.
+	if f.cached {
+		n, err = f.readContent(dst, offset)
+		return
+	}
+
+	// Make sure f.content != nil.
+	err = f.ensureContent(ctx)
+	if err != nil {
+		err = fmt.Errorf("ensureContent: %v", err)
+		return
+	}
+
+	// Fault in what we need, starting at the offset of the read rather than at
+	// the start of the object.
+	start, limit := f.faultInRangeForRead(offset, int64(len(dst)))
+	err = f.faultIn(ctx, start, limit)
+
+	// Special case: we never serve data from any generation other than our
+	// source generation. If it's gone, say so clearly.
+	if nfe, ok := err.(*gcs.NotFoundError); ok {
+		err = &gcsx.ClobberedError{
+			Name:       f.src.Name,
+			Generation: f.src.Generation,
+			Err:        nfe,
+		}
+
+		return
+	}
+
+	// If GCS served a newer generation, we may be able to follow it.
+	if sre, ok := err.(*gcsx.StaleReadError); ok {
+		err = f.followGeneration(ctx, sre)
+		if err != nil {
+			return
+		}
+
+		n, err = f.Read(ctx, dst, offset)
+		return
+	}
+
+	// Don't mangle other typed errors.
+	switch err.(type) {
+	case *gcsx.SizeMismatchError, *gcsx.ChecksumMismatchError, *gcsx.NoSpaceError:
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("faultIn: %v", err)
+		return
+	}
+
+	f.maybePrefetch(limit)
+
+	n, err = f.readContent(dst, offset)
+	return
+}
+
+// A read within a batch passed to FileInode.ReadV.
+type ReadRequest struct {
+	Offset int64
+	Length int
+}
+
+// The result of a ReadRequest. Data is shorter than the requested length
+// exactly when the read reached the end of the file.
+type ReadResult struct {
+	Data []byte
+}
+
+// Serve a batch of reads, returning a result for each in the same order.
+// Requests whose ranges overlap or are adjacent are served together by a
+// single Read, so that anything they need from GCS is fetched at once rather
+// than piecemeal. Fails if any of those Reads fails with something other than
+// io.EOF.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) ReadV(
+	ctx context.Context,
+	reqs []ReadRequest) (results []ReadResult, err error) {
+	results = make([]ReadResult, len(reqs))
+
+	// Visit the requests in order of offset.
+	order := make([]int, len(reqs))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.Stable(byReadOffset{reqs, order})
+
+	for len(order) > 0 {
+		// Gather a run of requests that overlap or touch.
+		start := reqs[order[0]].Offset
+		limit := start + int64(reqs[order[0]].Length)
+
+		run := 1
+		for ; run < len(order); run++ {
+			r := reqs[order[run]]
+			if r.Offset > limit {
+				break
+			}
+
+			if end := r.Offset + int64(r.Length); end > limit {
+				limit = end
+			}
+		}
+
+		// Read them all at once, then hand out the pieces.
+		buf := make([]byte, limit-start)
+		var n int
+		n, err = f.Read(ctx, buf, start)
+		if err != nil && err != io.EOF {
+			results = nil
+			return
+		}
+
+		err = nil
+		buf = buf[:n]
+
+		for _, i := range order[:run] {
+			lo := reqs[i].Offset - start
+			hi := lo + int64(reqs[i].Length)
+			if hi > int64(n) {
+				hi = int64(n)
+			}
+
+			if lo > hi {
+				lo = hi
+			}
+
+			results[i].Data = buf[lo:hi:hi]
+		}
+
+		order = order[run:]
+	}
+
+	return
+}
+
+// Sorts indices into reqs by the offsets of the requests they refer to.
+type byReadOffset struct {
+	reqs  []ReadRequest
+	order []int
+}
+
+func (s byReadOffset) Len() int {
+	return len(s.order)
+}
+
+func (s byReadOffset) Less(i, j int) bool {
+	return s.reqs[s.order[i]].Offset < s.reqs[s.order[j]].Offset
+}
+
+func (s byReadOffset) Swap(i, j int) {
+	s.order[i], s.order[j] = s.order[j], s.order[i]
+}
+
+// Read from the local content, propagating io.EOF.
+//
+// LOCKS_REQUIRED(f.mu)
+// REQUIRES: f.content != nil
+func (f *FileInode) readContent(dst []byte, offset int64) (n int, err error) {
+	n, err = f.content.ReadAt(dst, offset)
+	switch {
+	case err == io.EOF:
+		return
+
+	case err != nil:
+		err = fmt.Errorf("content.ReadAt: %v", err)
+		return
+	}
+
+	return
+}
+
+// Like Read, but rather than returning io.EOF, report whether the read
+// reached the end of the file's current contents. atEOF is true exactly when
+// offset + n is at least the current size, including when dst was filled by
+// the final bytes of the file. Errors other than io.EOF are as for Read.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) ReadAtMore(
+	ctx context.Context,
+	dst []byte,
+	offset int64) (n int, atEOF bool, err error) {
+	n, err = f.Read(ctx, dst, offset)
+	switch {
+	case err == io.EOF:
+		err = nil
+		atEOF = true
+		return
+
+	case err != nil:
+		return
+	}
+
+	size, err := f.Size()
+	if err != nil {
+		err = fmt.Errorf("Size: %v", err)
+		return
+	}
+
+	atEOF = offset+int64(n) >= size
+	return
+}
+
+// Like Read, but also return the generation of the backing object from which
+// the inode's contents are branched, for callers that tag the data they cache
+// with its source. If the inode has unsynced modifications, this is the
+// generation they are anchored to rather than one containing them; use
+// f.Version to tell the two apart.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) ReadAtWithGeneration(
+	ctx context.Context,
+	dst []byte,
+	offset int64) (n int, gen int64, err error) {
+	n, err = f.Read(ctx, dst, offset)
+	gen = f.src.Generation
+	return
+}
+
+// Like Read, but also report whether the read was served entirely from
+// contents already held locally (a cache hit), as opposed to needing to fetch
+// from GCS (a miss), e.g. for hit-rate metrics. A read that fails while
+// fetching counts as a miss.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) ReadAtCacheStatus(
+	ctx context.Context,
+	dst []byte,
+	offset int64) (n int, hit bool, err error) {
+	before := f.fetches
+	n, err = f.Read(ctx, dst, offset)
+	hit = f.fetches == before
+	return
+}
+
+// Read from the given generation of the inode's backing object, with
+// semantics matching io.ReaderAt. This neither consults nor modifies the
+// inode's contents or source generation, so may be used e.g. to compare
+// against a previous version.
+//
+// If the generation doesn't exist, returns *gcs.NotFoundError. If GCS serves
+// another generation, returns *gcsx.StaleReadError.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) ReadAtGeneration(
+	ctx context.Context,
+	dst []byte,
+	offset int64,
+	generation int64) (n int, err error) {
+	if offset < 0 {
+		err = fmt.Errorf("Invalid offset: %d", offset)
+		return
+	}
+
+	// Transformed contents must be decoded from the start, and a bucket that
+	// can't serve ranges must be read from the start. Otherwise we can ask for
+	// just the range we want.
+	var rc io.ReadCloser
+	whole := f.transform() != nil || f.rangesUnsupported
+	if !whole {
+		req := &gcs.ReadObjectRequest{
+			Name:       f.name,
+			Generation: generation,
+			Range: &gcs.ByteRange{
+				Start: uint64(offset),
+				Limit: uint64(offset) + uint64(len(dst)),
+			},
+		}
+
+		rc, err = f.bucket.NewReader(ctx, req)
+		if err == nil {
+			err = gcsx.CheckReadGeneration(req, rc)
+			if err != nil {
+				rc.Close()
+			}
+		}
+
+		if _, ok := err.(*gcsx.RangeUnsupportedError); ok {
+			f.rangesUnsupported = true
+			whole = true
+		}
+	}
+
+	if whole {
+		rc, err = f.openDecodedGeneration(ctx, generation)
+	}
+
+	// Don't mangle not found or stale read errors.
+	switch err.(type) {
+	case *gcs.NotFoundError, *gcsx.StaleReadError:
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("NewReader: %v", err)
+		return
+	}
+
+	defer rc.Close()
+
+	// Skip to the offset, if we didn't ask for a range.
+	if whole {
+		_, err = io.CopyN(ioutil.Discard, rc, offset)
+		switch {
+		case err == io.EOF:
+			return
+
+		case err != nil:
+			err = fmt.Errorf("CopyN: %v", err)
+			return
+		}
+	}
+
+	// Read, propagating io.EOF if the generation is too short.
+	n, err = io.ReadFull(rc, dst)
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		err = io.EOF
+		return
+
+	case err != nil:
+		err = fmt.Errorf("ReadFull: %v", err)
+		return
+	}
+
+	return
+}
+
+// Write the file's current contents to h, returning the number of bytes
+// written. Local modifications are included, and the rest is faulted in from
+// the source object a chunk at a time, so the contents needn't fit in memory.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) HashContents(
+	ctx context.Context,
+	h hash.Hash) (n int64, err error) {
+	buf := make([]byte, copyChunkSize)
+	for {
+		var m int
+		m, err = f.Read(ctx, buf, n)
+		if m > 0 {
+			h.Write(buf[:m])
+			n += int64(m)
+		}
+
+		if err == io.EOF {
+			err = nil
+			return
+		}
+
+		if err != nil {
+			err = fmt.Errorf("Read: %v", err)
+			return
+		}
+	}
+}
+
+// Map the file's contents into memory read-only, returning the bytes and a
+// function that unmaps them, without copying. This is possible only when the
+// contents have been entirely faulted in and hold no local modifications.
+//
+// The mapping shares pages with the inode's local contents, so the caller must
+// unmap before anything modifies the inode; truncating it can make accessing
+// the mapping fault. Holding f.mu for the mapping's lifetime ensures this.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) MMap() (data []byte, unmap func() error, err error) {
+	dirty, err := f.dirty()
+	if err != nil {
+		err = fmt.Errorf("dirty: %v", err)
+		return
+	}
+
+	if dirty {
+		err = fmt.Errorf("Refusing to map %q with local modifications", f.name)
+		return
+	}
+
+	if f.content == nil {
+		err = fmt.Errorf("Can't map %q before it is faulted in", f.name)
+		return
+	}
+
+	data, unmap, err = gcsx.MapTempFile(f.content)
+	if err != nil {
+		err = fmt.Errorf("MapTempFile: %v", err)
+		return
+	}
+
+	return
+}
+
+// Serve a write for this file with semantics matching fuseops.WriteFileOp.
+//
+// If FileConfig.WriteThrough is set, the inode is then synced. Should that
+// fail, the error is returned but the write remains in the local contents,
+// to be persisted by a later sync.
+//
+// If the temp directory's file system is full, returns *gcsx.NoSpaceError and
+// leaves the contents unmodified, unless FileConfig.MemoryFallback is set.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) Write(
+	ctx context.Context,
+	data []byte,
+	offset int64) (err error) {
+	err = f.checkWritable("Write")
+	if err != nil {
+		return
+	}
+
+	f.noteUse()
+
+	// Make sure f.content != nil.
 	err = f.ensureContent(ctx)
 	if err != nil {
-		err = fmt.Errorf("ensureContent: %v", err)
+		err = fmt.Errorf("ensureContent: %v", err)
+		return
+	}
+
+	// Write to the mutable content. Note that io.WriterAt guarantees it returns
+	// an error for short writes.
+	_, err = f.content.WriteAt(data, offset)
+	if f.fallBackToMemory(err) {
+		_, err = f.content.WriteAt(data, offset)
+	}
+
+	if err != nil {
+		return
+	}
+
+	f.noteEdit()
+	f.noteModified(int64(len(data)))
+
+	err = f.updateTracker()
+	if err != nil {
+		return
+	}
+
+	if f.cfg.WriteThrough {
+		err = f.ForceSync(ctx)
+		if err != nil {
+			err = fmt.Errorf("Sync: %v", err)
+			return
+		}
+	}
+
+	return
+}
+
+// Set the mtime for this file. May involve a round trip to GCS.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) SetMtime(
+	ctx context.Context,
+	mtime time.Time) (err error) {
+	err = f.checkWritable("SetMtime")
+	if err != nil {
+		return
+	}
+
+	// If we have a local temp file, stat it.
+	var sr gcsx.StatResult
+	if f.content != nil {
+		sr, err = f.content.Stat()
+		if err != nil {
+			err = fmt.Errorf("Stat: %v", err)
+			return
+		}
+	}
+
+	// If the local content is dirty, simply update its mtime and return. This
+	// will cause the object in the bucket to be updated once we sync. If we lose
+	// power or something the mtime update will be lost, but so will the file
+	// data modifications so this doesn't seem so bad. It's worth saving the
+	// round trip to GCS for the common case of Linux writeback caching, where we
+	// always receive a setattr request just before a flush of a dirty file.
+	if sr.Mtime != nil {
+		f.content.SetMtime(mtime)
+		f.noteEdit()
+		return
+	}
+
+	// Otherwise, update the backing object's metadata.
+	formatted := mtime.UTC().Format(time.RFC3339Nano)
+	srcGen := f.SourceGeneration()
+
+	req := &gcs.UpdateObjectRequest{
+		Name:                       f.src.Name,
+		Generation:                 srcGen.Object,
+		MetaGenerationPrecondition: &srcGen.Metadata,
+		Metadata: map[string]*string{
+			FileMtimeMetadataKey: &formatted,
+		},
+	}
+
+	o, err := f.bucket.UpdateObject(ctx, req)
+	switch err.(type) {
+	case nil:
+		f.src = *o
+		return
+
+	case *gcs.NotFoundError:
+		// Special case: silently ignore not found errors, which mean the file has
+		// been unlinked.
+		err = nil
+		return
+
+	case *gcs.PreconditionError:
+		// Special case: silently ignore precondition errors, which we also take to
+		// mean the file has been unlinked.
+		err = nil
+		return
+
+	default:
+		err = fmt.Errorf("UpdateObject: %v", err)
+		return
+	}
+}
+
+// Write out contents to GCS. If this fails due to the generation having been
+// clobbered, treat it as a non-error (simulating the inode having been
+// unlinked).
+//
+// After this method succeeds, SourceGeneration will return the new generation
+// by which this inode should be known (which may be the same as before). If it
+// fails, the generation will not change.
+//
+// If GCS records a different size for the new generation than was uploaded,
+// returns *gcsx.SizeMismatchError and leaves the inode dirty. A short upload
+// is retried once first, returning *gcsx.TruncatedUploadError if the retry is
+// short too. The same goes for *gcsx.MirrorError, if the bucket mirrors writes
+// synchronously. In these cases the generation written nonetheless becomes the
+// inode's source generation, so that a later sync replaces it rather than
+// being mistaken for a clobber.
+//
+// Every file inode is backed by an existing object (new files are created as
+// empty objects up front; see DirInode.CreateChildFile), so syncing an inode
+// that has never been written is a no-op that creates nothing in GCS.
+//
+// A Sync can be cancelled with AbortSync, in which case it returns
+// context.Canceled.
+//
+// If FileConfig.MinSyncInterval is set, the upload may be deferred; see there.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) Sync(ctx context.Context) (err error) {
+	err = f.sync(ctx, false)
+	return
+}
+
+// Like Sync, but never deferred because of FileConfig.MinSyncInterval.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) ForceSync(ctx context.Context) (err error) {
+	err = f.sync(ctx, true)
+	return
+}
+
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) sync(ctx context.Context, force bool) (err error) {
+	err = f.checkWritable("Sync")
+	if err != nil {
+		return
+	}
+
+	// If we have not been dirtied, there is nothing to do.
+	if f.content == nil {
+		return
+	}
+
+	// Allow AbortSync to cancel what follows.
+	ctx, finish := f.abortableContext(ctx)
+	defer func() {
+		if finish() && err != nil {
+			err = context.Canceled
+		}
+	}()
+
+	// If the content is dirty, the syncer will need all of it, unless it is
+	// going to append to the source object, in which case it needs only what
+	// has been written locally.
+	sr, err := f.content.Stat()
+	if err != nil {
+		err = fmt.Errorf("Stat: %v", err)
+		return
+	}
+
+	srcSize := f.srcSize()
+	dirty := !(sr.Size == srcSize && sr.DirtyThreshold == srcSize)
+
+	// Coalesce this sync with a later one if the last upload was too recent.
+	if dirty && !force && f.deferSync() {
+		return
+	}
+
+	var appending bool
+	if dirty && f.transform() == nil {
+		var plan gcsx.SyncPlan
+		plan, err = f.syncer.PlanSync(&f.src, f.content)
+		if err != nil {
+			err = fmt.Errorf("PlanSync: %v", err)
+			return
+		}
+
+		appending = plan.Append
+	}
+
+	if dirty && !appending {
+		err = f.faultIn(ctx, 0, sr.Size)
+
+		// Special case: if the source generation no longer exists, or GCS
+		// serves a newer one, then we have been clobbered, which we treat as
+		// being unlinked as below.
+		switch err.(type) {
+		case *gcs.NotFoundError, *gcsx.StaleReadError:
+			err = nil
+			return
+		}
+
+		if err != nil {
+			err = fmt.Errorf("faultIn: %v", err)
+			return
+		}
+	}
+
+	// Skip the upload if GCS already has what we would write, if enabled.
+	var newObj *gcs.Object
+	if dirty && !appending && f.cfg.SkipIdenticalUploads && f.transform() == nil {
+		newObj, err = f.identicalObject(ctx, sr.Size)
+		if err != nil {
+			err = fmt.Errorf("identicalObject: %v", err)
+			return
+		}
+	}
+
+	// Write out the contents if they are dirty.
+	var uploaded bool
+	if newObj == nil {
+		newObj, err = f.syncContent(ctx, sr, dirty)
+		uploaded = err == nil && newObj != nil
+	}
+
+	// If the sync failed after GCS committed a new generation, e.g. because it
+	// recorded the wrong size, adopt that generation while staying dirty.
+	// Otherwise the next sync would fail its precondition, and be mistaken for
+	// a clobber below.
+	if err != nil && newObj != nil {
+		markErr := gcsx.MarkTempFileModified(f.content)
+		if markErr != nil {
+			err = fmt.Errorf("%v (MarkTempFileModified: %v)", err, markErr)
+			return
+		}
+
+		f.src = *newObj
+		f.srcGone = false
+		f.verified = prefixCRC{}
+	}
+
+	// Special case: a precondition error means we were clobbered, which we treat
+	// as being unlinked. There's no reason to return an error in that case.
+	if _, ok := err.(*gcs.PreconditionError); ok {
+		err = nil
+	}
+
+	// Don't mangle size errors, nor mirroring failures.
+	switch err.(type) {
+	case *gcsx.SizeMismatchError, *gcsx.TruncatedUploadError, *gcsx.MirrorError:
+		return
+	}
+
+	// Propagate other errors.
+	if err != nil {
+		err = fmt.Errorf("SyncObject: %v", err)
+		return
+	}
+
+	// If we wrote out a new object, we need to update our state. The syncer
+	// destroys the content it uploads, but nothing does so when we adopt an
+	// identical generation instead.
+	if newObj != nil {
+		if !uploaded {
+			f.content.Destroy()
+		}
+
+		f.src = *newObj
+		f.content = nil
+		f.cached = false
+		f.srcGone = false
+		f.editStamp = 0
+		f.verified = prefixCRC{}
+	}
+
+	if uploaded {
+		now := f.cfg.Clock.Now()
+		f.lastUpload = &now
+	}
+
+	if uploaded && f.cfg.OnSynced != nil {
+		f.cfg.OnSynced(f.src.Name, f.src.Generation)
+	}
+
+	f.noteSynced()
+
+	err = f.updateTracker()
+	if err != nil {
+		err = fmt.Errorf("updateTracker: %v", err)
+		return
+	}
+
+	return
+}
+
+// If f.cfg.MinSyncInterval hasn't elapsed since the last upload, make sure a
+// background sync is scheduled for when it has and return true.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) deferSync() bool {
+	if f.cfg.MinSyncInterval <= 0 || f.lastUpload == nil {
+		return false
+	}
+
+	wait := f.lastUpload.Add(f.cfg.MinSyncInterval).Sub(f.cfg.Clock.Now())
+	if wait <= 0 {
+		return false
+	}
+
+	if !f.syncDeferred {
+		f.syncDeferred = true
+		go f.deferredSync(f.cfg.Clock.After(wait))
+	}
+
+	return true
+}
+
+// Wait for the supplied channel to fire, then sync f unless it has been
+// destroyed. Failures are reported by LastSyncError, as with background syncs
+// for AutoSync.
+//
+// LOCKS_EXCLUDED(f.mu)
+func (f *FileInode) deferredSync(due <-chan time.Time) {
+	<-due
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.syncDeferred = false
+	if f.destroyed {
+		return
+	}
+
+	// This may itself be deferred, if there has been a forced sync since.
+	err := f.Sync(context.Background())
+	if err != nil {
+		f.autoSync.lastErr = err
+	}
+}
+
+// Derive a context for a Sync that AbortSync can cancel. The caller must call
+// finish once the Sync is done, which reports whether it was aborted.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) abortableContext(
+	ctx context.Context) (syncCtx context.Context, finish func() bool) {
+	syncCtx, cancel := context.WithCancel(ctx)
+
+	a := &f.abort
+	a.mu.Lock()
+	a.cancel = cancel
+	a.aborted = false
+	a.mu.Unlock()
+
+	finish = func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		cancel()
+		a.cancel = nil
+		return a.aborted
+	}
+
+	return
+}
+
+// Cancel the Sync in progress on f, if any, returning false if there is none.
+// The Sync fails with context.Canceled (unless it was just finishing
+// successfully) and leaves the inode dirty, so it may be retried later.
+//
+// Either the source generation or a complete new generation remains live in
+// GCS. However, if the abort races with an upload completing, the new
+// generation may have been written without the inode knowing, and a retry
+// will then see the object as clobbered. Temporary objects may also be left
+// behind.
+//
+// Unlike most methods, this doesn't require f.mu, which the Sync holds.
+func (f *FileInode) AbortSync() (aborted bool) {
+	a := &f.abort
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.cancel == nil {
+		return
+	}
+
+	a.cancel()
+	a.aborted = true
+	aborted = true
+	return
+}
+
+// Deal with GCS having served a read of the source object from a newer
+// generation, per FileConfig.FollowNewGenerations: either adopt the live
+// generation as the source, discarding the contents, or return
+// *gcsx.ClobberedError.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) followGeneration(
+	ctx context.Context,
+	sre *gcsx.StaleReadError) (err error) {
+	clobbered := &gcsx.ClobberedError{
+		Name:       f.src.Name,
+		Generation: f.src.Generation,
+		Err:        sre,
+	}
+
+	if !f.cfg.FollowNewGenerations {
+		err = clobbered
+		return
+	}
+
+	// Local modifications were made to the old generation, so there's nothing
+	// sensible to combine them with.
+	dirty, err := f.dirty()
+	if err != nil {
+		err = fmt.Errorf("dirty: %v", err)
+		return
+	}
+
+	if dirty {
+		err = clobbered
+		return
+	}
+
+	o, err := gcsx.StatObject(ctx, f.bucket, &gcs.StatObjectRequest{Name: f.name})
+	if err != nil {
+		err = fmt.Errorf("gcsx.StatObject: %v", err)
 		return
 	}
 
-	// Read from the local content, propagating io.EOF.
-	n, err = f.content.ReadAt(dst, offset)
-	switch {
-	case err == io.EOF:
+	// If there's nothing newer to follow after all, give up rather than
+	// retrying forever.
+	if o.Generation <= f.src.Generation {
+		err = clobbered
 		return
+	}
 
-	case err != nil:
-		err = fmt.Errorf("content.ReadAt: %v", err)
+	// Adopt it.
+	if f.content != nil {
+		f.content.Destroy()
+		f.content = nil
+		f.cached = false
+	}
+
+	f.src = *o
+	f.srcGone = false
+	f.editStamp = 0
+	f.verified = prefixCRC{}
+
+	err = f.updateTracker()
+	if err != nil {
+		err = fmt.Errorf("updateTracker: %v", err)
 		return
 	}
 
 	return
 }
 
-// Serve a write for this file with semantics matching fuseops.WriteFileOp.
+// Return the live generation of the object if its contents are identical to
+// the first size bytes of f.content, or nil otherwise.
 //
 // LOCKS_REQUIRED(f.mu)
-func (f *FileInode) Write(
+// REQUIRES: f.content != nil
+// REQUIRES: The first size bytes of f.content have been faulted in
+func (f *FileInode) identicalObject(
 	ctx context.Context,
-	data []byte,
-	offset int64) (err error) {
-	// Make sure f.content != nil.
-	err = f.ensureContent(ctx)
+	size int64) (o *gcs.Object, err error) {
+	o, err = gcsx.StatObject(ctx, f.bucket, &gcs.StatObjectRequest{Name: f.name})
+	switch err.(type) {
+	case nil:
+
+	case *gcs.NotFoundError, *gcsx.SoftDeletedError:
+		o = nil
+		err = nil
+		return
+
+	default:
+		err = fmt.Errorf("gcsx.StatObject: %v", err)
+		return
+	}
+
+	// Only checksum the contents if there's a chance.
+	if o.Size != uint64(size) {
+		o = nil
+		return
+	}
+
+	var local prefixCRC
+	err = local.extend(f.content, size)
 	if err != nil {
-		err = fmt.Errorf("ensureContent: %v", err)
+		o = nil
+		err = fmt.Errorf("extend: %v", err)
 		return
 	}
 
-	// Write to the mutable content. Note that io.WriterAt guarantees it returns
-	// an error for short writes.
-	_, err = f.content.WriteAt(data, offset)
+	if local.crc != o.CRC32C {
+		o = nil
+		return
+	}
 
 	return
 }
 
-// Set the mtime for this file. May involve a round trip to GCS.
+// Hand f.content to the syncer, encoding it first if necessary.
 //
 // LOCKS_REQUIRED(f.mu)
-func (f *FileInode) SetMtime(
+// REQUIRES: f.content != nil
+func (f *FileInode) syncContent(
 	ctx context.Context,
-	mtime time.Time) (err error) {
-	// If we have a local temp file, stat it.
-	var sr gcsx.StatResult
-	if f.content != nil {
-		sr, err = f.content.Stat()
-		if err != nil {
-			err = fmt.Errorf("Stat: %v", err)
-			return
-		}
+	sr gcsx.StatResult,
+	dirty bool) (o *gcs.Object, err error) {
+	if f.transform() == nil {
+		o, err = f.syncObject(ctx, f.content)
+		return
 	}
 
-	// If the local content is dirty, simply update its mtime and return. This
-	// will cause the object in the bucket to be updated once we sync. If we lose
-	// power or something the mtime update will be lost, but so will the file
-	// data modifications so this doesn't seem so bad. It's worth saving the
-	// round trip to GCS for the common case of Linux writeback caching, where we
-	// always receive a setattr request just before a flush of a dirty file.
-	if sr.Mtime != nil {
-		f.content.SetMtime(mtime)
+	if !dirty {
 		return
 	}
 
-	// Otherwise, update the backing object's metadata.
-	formatted := mtime.UTC().Format(time.RFC3339Nano)
-	srcGen := f.SourceGeneration()
-
-	req := &gcs.UpdateObjectRequest{
-		Name:                       f.src.Name,
-		Generation:                 srcGen.Object,
-		MetaGenerationPrecondition: &srcGen.Metadata,
-		Metadata: map[string]*string{
-			FileMtimeMetadataKey: &formatted,
-		},
+	encoded, err := f.encodeContent(sr)
+	if err != nil {
+		err = fmt.Errorf("encodeContent: %v", err)
+		return
 	}
 
-	o, err := f.bucket.UpdateObject(ctx, req)
-	switch err.(type) {
-	case nil:
-		f.src = *o
+	// The syncer destroys the temp file it is given only on success.
+	o, err = f.syncObject(ctx, encoded)
+	if err != nil || o == nil {
+		encoded.Destroy()
 		return
+	}
 
-	case *gcs.NotFoundError:
-		// Special case: silently ignore not found errors, which mean the file has
-		// been unlinked.
-		err = nil
-		return
+	f.content.Destroy()
+	return
+}
 
-	case *gcs.PreconditionError:
-		// Special case: silently ignore precondition errors, which we also take to
-		// mean the file has been unlinked.
-		err = nil
-		return
+// Hand content to the syncer, retrying as allowed by FileConfig.SyncRetries.
+// The syncer seeks content back to the start for each attempt and leaves it
+// intact on failure, so every attempt uploads all of it. A failure that comes
+// with a new generation isn't retried, since the source is then out of date.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) syncObject(
+	ctx context.Context,
+	content gcsx.TempFile) (o *gcs.Object, err error) {
+	for attempt := 0; ; attempt++ {
+		o, err = f.syncer.SyncObject(ctx, f.syncSource(), content)
+		if err == nil ||
+			o != nil ||
+			attempt >= f.cfg.SyncRetries ||
+			!gcsx.IsRetryable(err) ||
+			ctx.Err() != nil {
+			return
+		}
+	}
+}
 
-	default:
-		err = fmt.Errorf("UpdateObject: %v", err)
+// Return the source object record to hand to the syncer, carrying any
+// configured cache control and labels as the attributes the syncer
+// preserves.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) syncSource() (src *gcs.Object) {
+	if f.cfg.CacheControl == "" && len(f.cfg.Labels) == 0 {
+		src = &f.src
 		return
 	}
+
+	copied := f.src
+	if f.cfg.CacheControl != "" {
+		copied.CacheControl = f.cfg.CacheControl
+	}
+
+	if len(f.cfg.Labels) != 0 {
+		copied.Metadata = make(map[string]string)
+		for k, v := range f.src.Metadata {
+			copied.Metadata[k] = v
+		}
+	}
+
+	for k, v := range f.cfg.Labels {
+		copied.Metadata[gcsx.LabelMetadataPrefix+k] = v
+	}
+
+	src = &copied
+	return
 }
 
-// Write out contents to GCS. If this fails due to the generation having been
-// clobbered, treat it as a non-error (simulating the inode having been
-// unlinked).
-//
-// After this method succeeds, SourceGeneration will return the new generation
-// by which this inode should be known (which may be the same as before). If it
-// fails, the generation will not change.
+// Report what Sync would upload, without contacting GCS.
 //
 // LOCKS_REQUIRED(f.mu)
-func (f *FileInode) Sync(ctx context.Context) (err error) {
+func (f *FileInode) DrySync() (plan gcsx.SyncPlan, err error) {
 	// If we have not been dirtied, there is nothing to do.
 	if f.content == nil {
+		plan.NoOp = true
 		return
 	}
 
-	// Write out the contents if they are dirty.
-	newObj, err := f.syncer.SyncObject(ctx, &f.src, f.content)
+	// Transformed contents are always rewritten in full. We don't know their
+	// encoded size without encoding them, so report the logical size.
+	if f.transform() != nil {
+		var sr gcsx.StatResult
+		sr, err = f.content.Stat()
+		if err != nil {
+			err = fmt.Errorf("Stat: %v", err)
+			return
+		}
 
-	// Special case: a precondition error means we were clobbered, which we treat
-	// as being unlinked. There's no reason to return an error in that case.
-	if _, ok := err.(*gcs.PreconditionError); ok {
-		err = nil
+		srcSize := f.srcSize()
+		if sr.Size == srcSize && sr.DirtyThreshold == srcSize {
+			plan.NoOp = true
+			return
+		}
+
+		plan = f.syncer.PlanRewrite(f.syncSource(), sr.Size)
+		return
 	}
 
-	// Propagate other errors.
+	plan, err = f.syncer.PlanSync(f.syncSource(), f.content)
 	if err != nil {
-		err = fmt.Errorf("SyncObject: %v", err)
+		err = fmt.Errorf("PlanSync: %v", err)
 		return
 	}
 
-	// If we wrote out a new object, we need to update our state.
-	if newObj != nil {
-		f.src = *newObj
-		f.content = nil
+	return
+}
+
+// Estimate the work Sync would do, as the bytes of content it would upload
+// and the number of requests it would make, without contacting GCS. Both are
+// zero if there is nothing to sync.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) SyncCost() (bytes int64, requests int, err error) {
+	plan, err := f.DrySync()
+	if err != nil {
+		err = fmt.Errorf("DrySync: %v", err)
+		return
 	}
 
+	bytes = plan.Bytes
+	requests = plan.Requests
 	return
 }
 
-// Truncate the file to the specified size.
+// Truncate the file to the specified size. This never reads the source
+// object's contents: growing leaves the existing bytes alone and fills the new
+// region with zeroes, and shrinking only discards. Any remaining contents are
+// faulted in when read, as usual.
+//
+// Space for the new region is reserved where the backing store supports it.
+// If there isn't enough, returns *gcsx.NoSpaceError and leaves the contents
+// unmodified, unless FileConfig.MemoryFallback is set.
 //
 // LOCKS_REQUIRED(f.mu)
 func (f *FileInode) Truncate(
 	ctx context.Context,
 	size int64) (err error) {
+	err = f.checkWritable("Truncate")
+	if err != nil {
+		return
+	}
+
+	f.noteUse()
+
 	// Make sure f.content != nil.
 	err = f.ensureContent(ctx)
 	if err != nil {
@@ -499,6 +2738,234 @@ func (f *FileInode) Truncate(
 
 	// Call through.
 	err = f.content.Truncate(size)
+	if f.fallBackToMemory(err) {
+		err = f.content.Truncate(size)
+	}
+
+	if err != nil {
+		return
+	}
+
+	f.noteEdit()
+	f.noteModified(0)
+
+	err = f.updateTracker()
+
+	return
+}
+
+// Make the given generation of the backing object, which is expected to have
+// the given size, the inode's source, discarding any contents faulted in from
+// the previous one. This is for adopting a generation written by someone
+// else, e.g. when notified of it, without creating a new inode.
+//
+// The object is statted to find the rest of the generation's attributes. This
+// fails if the live generation isn't the one given, or if its size differs.
+// It also fails if the inode holds local modifications that have not been
+// synced, which it leaves untouched.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) Reanchor(
+	ctx context.Context,
+	generation int64,
+	size int64) (err error) {
+	// We mustn't throw away local modifications.
+	dirty, err := f.dirty()
+	if err != nil {
+		err = fmt.Errorf("dirty: %v", err)
+		return
+	}
+
+	if dirty {
+		err = fmt.Errorf("Refusing to re-anchor %q with unsynced modifications", f.name)
+		return
+	}
+
+	// Find the generation's attributes.
+	o, err := gcsx.StatObject(ctx, f.bucket, &gcs.StatObjectRequest{Name: f.name})
+	if err != nil {
+		err = fmt.Errorf("gcsx.StatObject: %v", err)
+		return
+	}
+
+	if o.Generation != generation {
+		err = fmt.Errorf(
+			"Live generation of %q is %d, not %d",
+			f.name,
+			o.Generation,
+			generation)
+
+		return
+	}
+
+	if o.Size != uint64(size) {
+		err = fmt.Errorf(
+			"Generation %d of %q has size %d, not %d",
+			generation,
+			f.name,
+			o.Size,
+			size)
+
+		return
+	}
+
+	// Adopt it.
+	if f.content != nil {
+		f.content.Destroy()
+		f.content = nil
+		f.cached = false
+	}
+
+	f.src = *o
+	f.srcGone = false
+	f.editStamp = 0
+	f.verified = prefixCRC{}
+
+	err = f.updateTracker()
+	if err != nil {
+		err = fmt.Errorf("updateTracker: %v", err)
+		return
+	}
+
+	return
+}
+
+// The size of the buffer used when streaming contents, e.g. by
+// CopyFileContents.
+const copyChunkSize = 1 << 20
+
+// Replace the contents of dst with those of src, without holding all of them
+// in memory at once. The caller must then Sync dst to persist the result.
+//
+// If src has no local modifications, both inodes are backed by the same
+// bucket, neither has a FileConfig.Transform, and src's object has no
+// Content-Encoding, this is done by composing src's source generation onto
+// dst's name, after which dst is clean and the subsequent Sync does nothing.
+// serverSide is set in that case. If dst's source generation has been
+// clobbered, nothing is written and no error is returned, as for Sync.
+//
+// LOCKS_REQUIRED(dst.mu)
+// LOCKS_REQUIRED(src.mu)
+// REQUIRES: dst != src
+func CopyFileContents(
+	ctx context.Context,
+	dst *FileInode,
+	src *FileInode) (serverSide bool, err error) {
+	err = dst.checkWritable("CopyFileContents")
+	if err != nil {
+		return
+	}
+
+	// Can we take the fast path?
+	srcDirty, err := src.dirty()
+	if err != nil {
+		err = fmt.Errorf("dirty: %v", err)
+		return
+	}
+
+	// The stored bytes are only meaningful to dst if neither inode transforms
+	// them.
+	if !srcDirty &&
+		dst.bucket.Name() == src.bucket.Name() &&
+		src.src.ContentEncoding == "" &&
+		src.transform() == nil &&
+		dst.transform() == nil {
+		serverSide = true
+		err = dst.copyFrom(ctx, &src.src)
+		if err != nil {
+			err = fmt.Errorf("copyFrom: %v", err)
+			return
+		}
+
+		return
+	}
+
+	// Otherwise stream the contents across.
+	err = dst.Truncate(ctx, 0)
+	if err != nil {
+		err = fmt.Errorf("Truncate: %v", err)
+		return
+	}
+
+	buf := make([]byte, copyChunkSize)
+	var offset int64
+	for {
+		var n int
+		n, err = src.Read(ctx, buf, offset)
+		if n > 0 {
+			writeErr := dst.Write(ctx, buf[:n], offset)
+			if writeErr != nil {
+				err = fmt.Errorf("Write: %v", writeErr)
+				return
+			}
+
+			offset += int64(n)
+		}
+
+		if err == io.EOF {
+			err = nil
+			return
+		}
+
+		if err != nil {
+			err = fmt.Errorf("Read: %v", err)
+			return
+		}
+	}
+}
+
+// Replace f's backing object with a server-side copy of the supplied source
+// object, discarding any local content. The copy is made by composition, which
+// unlike copying can be made conditional on f's source generation still being
+// current, and carries over only the content type and custom metadata.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) copyFrom(ctx context.Context, src *gcs.Object) (err error) {
+	o, err := f.bucket.ComposeObjects(
+		ctx,
+		&gcs.ComposeObjectsRequest{
+			DstName:                   f.name,
+			DstGenerationPrecondition: &f.src.Generation,
+			Sources: []gcs.ComposeSource{
+				{
+					Name:       src.Name,
+					Generation: src.Generation,
+				},
+			},
+			ContentType: src.ContentType,
+			Metadata:    src.Metadata,
+		})
+
+	// Special case: a precondition error means we were clobbered, which we treat
+	// as being unlinked, as in Sync.
+	if _, ok := err.(*gcs.PreconditionError); ok {
+		err = nil
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("ComposeObjects: %v", err)
+		return
+	}
+
+	// Adopt the new object as our source.
+	if f.content != nil {
+		f.content.Destroy()
+		f.content = nil
+		f.cached = false
+	}
+
+	f.src = *o
+	f.srcGone = false
+	f.editStamp = 0
+	f.verified = prefixCRC{}
+	f.noteSynced()
+
+	err = f.updateTracker()
+	if err != nil {
+		err = fmt.Errorf("updateTracker: %v", err)
+		return
+	}
 
 	return
 }
@@ -15,9 +15,16 @@
 package inode_test
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"os"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -29,12 +36,548 @@ import (
 	"github.com/jacobsa/gcloud/gcs"
 	"github.com/jacobsa/gcloud/gcs/gcsfake"
 	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/oglematchers"
 	. "github.com/jacobsa/ogletest"
 	"github.com/jacobsa/timeutil"
+	"google.golang.org/api/googleapi"
 )
 
 func TestFile(t *testing.T) { RunTests(t) }
 
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A bucket that records the read requests made to it.
+type recordingBucket struct {
+	gcs.Bucket
+	reads    []gcs.ReadObjectRequest
+	stats    []gcs.StatObjectRequest
+	creates  []string
+	composes []gcs.ComposeObjectsRequest
+}
+
+func (b *recordingBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	recorded := *req
+	if req.Range != nil {
+		r := *req.Range
+		recorded.Range = &r
+	}
+
+	b.reads = append(b.reads, recorded)
+	rc, err = b.Bucket.NewReader(ctx, req)
+	return
+}
+
+func (b *recordingBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	b.creates = append(b.creates, req.Name)
+	o, err = b.Bucket.CreateObject(ctx, req)
+	return
+}
+
+func (b *recordingBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	// The preconditions may point at state that changes afterward.
+	recorded := *req
+	if req.DstGenerationPrecondition != nil {
+		g := *req.DstGenerationPrecondition
+		recorded.DstGenerationPrecondition = &g
+	}
+
+	b.composes = append(b.composes, recorded)
+	o, err = b.Bucket.ComposeObjects(ctx, req)
+	return
+}
+
+func (b *recordingBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	b.stats = append(b.stats, *req)
+	o, err = b.Bucket.StatObject(ctx, req)
+	return
+}
+
+// A bucket that refuses ranged reads, as some GCS-compatible backends do,
+// counting the reads made to it.
+type rangeRejectingBucket struct {
+	gcs.Bucket
+	ranged int
+	full   int
+}
+
+func (b *rangeRejectingBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	if req.Range != nil {
+		b.ranged++
+		err = &gcsx.RangeUnsupportedError{
+			Name: req.Name,
+			Err:  fmt.Errorf("ranges not supported"),
+		}
+
+		return
+	}
+
+	b.full++
+	rc, err = b.Bucket.NewReader(ctx, req)
+	return
+}
+
+// Create a file inode for t.backingObj with DroppedWhileDirty set, run f on
+// it, then drop it and run the garbage collector. Return true iff the inode
+// was reported as dropped while dirty.
+func (t *FileTest) dropInode(f func(in *inode.FileInode)) (reported bool) {
+	dropped := make(chan string, 1)
+
+	// Do the work in a separate function so that nothing on our stack can keep
+	// the inode alive.
+	func() {
+		in := inode.NewFileInode(
+			fileInodeID+1,
+			t.backingObj,
+			fuseops.InodeAttributes{},
+			t.bucket,
+			gcsx.NewSyncer(1, ".gcsfuse_tmp/", t.bucket),
+			"",
+			&t.clock,
+			inode.FileConfig{
+				DroppedWhileDirty: func(name string) { dropped <- name },
+			})
+
+		in.Lock()
+		f(in)
+		in.Unlock()
+	}()
+
+	// Finalizers run asynchronously after collection, so give them a chance.
+	for i := 0; i < 10; i++ {
+		runtime.GC()
+		select {
+		case name := <-dropped:
+			AssertEq(fileInodeName, name)
+			reported = true
+			return
+
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	return
+}
+
+// A bucket whose readers return the supplied contents, regardless of the
+// request.
+type fixedContentsBucket struct {
+	gcs.Bucket
+	contents string
+}
+
+func (b *fixedContentsBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	rc = ioutil.NopCloser(strings.NewReader(b.contents))
+	return
+}
+
+// A bucket whose first CreateObject call signals started, then blocks until
+// its context is cancelled. Later calls go through.
+type blockingCreateBucket struct {
+	gcs.Bucket
+	started chan struct{}
+	blocked bool
+}
+
+func (b *blockingCreateBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	if !b.blocked {
+		b.blocked = true
+		close(b.started)
+		<-ctx.Done()
+		err = ctx.Err()
+		return
+	}
+
+	o, err = b.Bucket.CreateObject(ctx, req)
+	return
+}
+
+// A bucket whose NewReader, for reads of ranges starting at or beyond from,
+// signals started and then blocks until its context is cancelled.
+type blockingReadBucket struct {
+	gcs.Bucket
+	from    uint64
+	started chan struct{}
+}
+
+func (b *blockingReadBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	if req.Range != nil && req.Range.Start >= b.from {
+		close(b.started)
+		<-ctx.Done()
+		err = ctx.Err()
+		return
+	}
+
+	rc, err = b.Bucket.NewReader(ctx, req)
+	return
+}
+
+// A bucket that exaggerates the size of the objects it creates by one byte,
+// unless honest is set.
+type sizeLyingBucket struct {
+	gcs.Bucket
+	honest bool
+}
+
+func (b *sizeLyingBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.Bucket.CreateObject(ctx, req)
+	if err != nil || b.honest {
+		return
+	}
+
+	o.Size++
+	return
+}
+
+// A bucket whose next truncations calls to CreateObject store only the first
+// half of the contents they are given, as if the upload were cut short.
+type truncatingBucket struct {
+	gcs.Bucket
+	truncations int
+}
+
+func (b *truncatingBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	if b.truncations == 0 {
+		o, err = b.Bucket.CreateObject(ctx, req)
+		return
+	}
+
+	b.truncations--
+	buf, err := ioutil.ReadAll(req.Contents)
+	if err != nil {
+		return
+	}
+
+	copied := *req
+	copied.Contents = bytes.NewReader(buf[:len(buf)/2])
+	o, err = b.Bucket.CreateObject(ctx, &copied)
+	return
+}
+
+// A bucket whose next failures calls to CreateObject read all of the request's
+// contents and then fail with err. Records the contents received by each call.
+type flakyCreateBucket struct {
+	gcs.Bucket
+	failures int
+	err      error
+	contents []string
+}
+
+func (b *flakyCreateBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	buf, err := ioutil.ReadAll(req.Contents)
+	if err != nil {
+		return
+	}
+
+	b.contents = append(b.contents, string(buf))
+	if b.failures > 0 {
+		b.failures--
+		err = b.err
+		return
+	}
+
+	copied := *req
+	copied.Contents = bytes.NewReader(buf)
+	o, err = b.Bucket.CreateObject(ctx, &copied)
+	return
+}
+
+// A bucket that serves reads from the live generation of an object, whatever
+// generation is asked for, reporting the generation it served.
+type liveReadingBucket struct {
+	gcs.Bucket
+}
+
+func (b *liveReadingBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	o, err := b.StatObject(ctx, &gcs.StatObjectRequest{Name: req.Name})
+	if err != nil {
+		return
+	}
+
+	live := *req
+	live.Generation = o.Generation
+	rc, err = b.Bucket.NewReader(ctx, &live)
+	if err != nil {
+		return
+	}
+
+	rc = &generationReader{ReadCloser: rc, generation: o.Generation}
+	return
+}
+
+type generationReader struct {
+	io.ReadCloser
+	generation int64
+}
+
+func (gr *generationReader) Generation() int64 {
+	return gr.generation
+}
+
+// A bucket whose readers serve the first after bytes of each read and then
+// fail with the given error, as when the object is deleted mid-stream.
+type midStreamErrorBucket struct {
+	gcs.Bucket
+	after int64
+	err   error
+}
+
+func (b *midStreamErrorBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	rc, err = b.Bucket.NewReader(ctx, req)
+	if err != nil {
+		return
+	}
+
+	rc = &midStreamErrorReader{
+		Reader: io.MultiReader(io.LimitReader(rc, b.after), &errorReader{b.err}),
+		Closer: rc,
+	}
+
+	return
+}
+
+type midStreamErrorReader struct {
+	io.Reader
+	io.Closer
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r *errorReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+// A bucket that counts calls to CreateObject, and fails the first lose of
+// them with a retryable error after they succeed, as when a response is lost.
+type lostResponseBucket struct {
+	gcs.Bucket
+	lose    int
+	creates int
+}
+
+func (b *lostResponseBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	b.creates++
+	o, err = b.Bucket.CreateObject(ctx, req)
+	if err == nil && b.lose > 0 {
+		b.lose--
+		o = nil
+		err = &gcsx.TransientError{Err: errors.New("lost response")}
+	}
+
+	return
+}
+
+// A bucket that measures how many of the readers it has returned are open at
+// once. Each reader lingers a little, to give others a chance to overlap.
+type concurrencyMeasuringBucket struct {
+	gcs.Bucket
+
+	mu    sync.Mutex
+	open  int
+	max   int
+	total int
+}
+
+func (b *concurrencyMeasuringBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	rc, err = b.Bucket.NewReader(ctx, req)
+	if err != nil {
+		return
+	}
+
+	b.mu.Lock()
+	b.open++
+	b.total++
+	if b.open > b.max {
+		b.max = b.open
+	}
+	b.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+	rc = &closeNotifyingReader{ReadCloser: rc, onClose: b.closed}
+	return
+}
+
+func (b *concurrencyMeasuringBucket) closed() {
+	b.mu.Lock()
+	b.open--
+	b.mu.Unlock()
+}
+
+func (b *concurrencyMeasuringBucket) opened() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.total
+}
+
+func (b *concurrencyMeasuringBucket) maxOpen() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.max
+}
+
+type closeNotifyingReader struct {
+	io.ReadCloser
+	onClose func()
+}
+
+func (r *closeNotifyingReader) Close() (err error) {
+	err = r.ReadCloser.Close()
+	r.onClose()
+	return
+}
+
+// A gcsx.BackingStore that refuses to reserve space beyond a quota.
+type quotaStore struct {
+	gcsx.BackingStore
+	quota int64
+}
+
+func (s *quotaStore) Reserve(offset int64, length int64) (err error) {
+	if offset+length > s.quota {
+		err = &gcsx.NoSpaceError{Err: errors.New("quota exceeded")}
+	}
+
+	return
+}
+
+// A gcsx.BackingStore that counts the bytes written to it.
+type countingStore struct {
+	gcsx.BackingStore
+	written int
+}
+
+func (s *countingStore) WriteAt(p []byte, offset int64) (n int, err error) {
+	n, err = s.BackingStore.WriteAt(p, offset)
+	s.written += n
+	return
+}
+
+// A gcsx.BackingStore that records whether it has been closed.
+type closingStore struct {
+	gcsx.BackingStore
+	closed bool
+}
+
+func (s *closingStore) Close() (err error) {
+	err = s.BackingStore.Close()
+	s.closed = true
+	return
+}
+
+// A bucket that reports the given owner for the objects it creates.
+type owningBucket struct {
+	gcs.Bucket
+	owner string
+}
+
+func (b *owningBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.Bucket.CreateObject(ctx, req)
+	if err != nil {
+		return
+	}
+
+	o.Owner = b.owner
+	return
+}
+
+// A transform that XORs each byte with a key.
+type xorTransform struct {
+	key byte
+}
+
+func (xt xorTransform) apply(r io.Reader) (io.Reader, error) {
+	b, err := ioutil.ReadAll(r)
+	for i := range b {
+		b[i] ^= xt.key
+	}
+
+	return strings.NewReader(string(b)), err
+}
+
+func (xt xorTransform) Encode(r io.Reader) (io.Reader, error) { return xt.apply(r) }
+func (xt xorTransform) Decode(r io.Reader) (io.Reader, error) { return xt.apply(r) }
+func (xt xorTransform) DecodedSize(n int64) int64             { return n }
+
+// A transform that adds a fixed header to the contents.
+type headerTransform struct {
+	header string
+}
+
+func (ht headerTransform) Encode(r io.Reader) (io.Reader, error) {
+	return io.MultiReader(strings.NewReader(ht.header), r), nil
+}
+
+func (ht headerTransform) Decode(r io.Reader) (io.Reader, error) {
+	h := make([]byte, len(ht.header))
+	if _, err := io.ReadFull(r, h); err != nil || string(h) != ht.header {
+		return nil, fmt.Errorf("Bad header: %q", h)
+	}
+
+	return r, nil
+}
+
+func (ht headerTransform) DecodedSize(n int64) int64 {
+	return n - int64(len(ht.header))
+}
+
+// A fake compression format: rot13, padded with trailing '=' so that the
+// stored size differs from the uncompressed size.
+type rot13Decompressor struct{}
+
+func rot13(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z':
+			b[i] = 'a' + (c-'a'+13)%26
+		case c >= 'A' && c <= 'Z':
+			b[i] = 'A' + (c-'A'+13)%26
+		}
+	}
+
+	return string(b)
+}
+
+func (rot13Decompressor) Decompress(r io.Reader) (io.Reader, error) {
+	b, err := ioutil.ReadAll(r)
+	return strings.NewReader(rot13(strings.TrimRight(string(b), "="))), err
+}
+
 ////////////////////////////////////////////////////////////////////////
 // Boilerplate
 ////////////////////////////////////////////////////////////////////////
@@ -54,6 +597,9 @@ type FileTest struct {
 	initialContents string
 	backingObj      *gcs.Object
 
+	// Options used by createInode.
+	cfg inode.FileConfig
+
 	in *inode.FileInode
 }
 
@@ -106,7 +652,8 @@ func (t *FileTest) createInode() {
 			".gcsfuse_tmp/",
 			t.bucket),
 		"",
-		&t.clock)
+		&t.clock,
+		t.cfg)
 
 	t.in.Lock()
 }
@@ -123,6 +670,10 @@ func (t *FileTest) Name() {
 	ExpectEq(fileInodeName, t.in.Name())
 }
 
+func (t *FileTest) BucketName() {
+	ExpectEq("some_bucket", t.in.BucketName())
+}
+
 func (t *FileTest) InitialSourceGeneration() {
 	sg := t.in.SourceGeneration()
 	ExpectEq(t.backingObj.Generation, sg.Object)
@@ -161,6 +712,58 @@ func (t *FileTest) InitialAttributes_MtimeFromObjectMetadata() {
 	ExpectThat(attrs.Mtime, timeutil.TimeEq(mtime))
 }
 
+func (t *FileTest) OpenFileInode() {
+	bucket := &recordingBucket{Bucket: t.bucket}
+
+	in, err := inode.OpenFileInode(
+		t.ctx,
+		fileInodeID+1,
+		fileInodeName,
+		fuseops.InodeAttributes{},
+		bucket,
+		gcsx.NewSyncer(1, ".gcsfuse_tmp/", bucket),
+		"",
+		&t.clock,
+		inode.FileConfig{})
+
+	AssertEq(nil, err)
+	ExpectEq(1, len(bucket.stats))
+
+	in.Lock()
+	defer in.Unlock()
+
+	ExpectEq(fileInodeName, in.Name())
+	ExpectEq(t.backingObj.Generation, in.SourceGeneration().Object)
+	ExpectEq(t.backingObj.MetaGeneration, in.SourceGeneration().Metadata)
+
+	size, err := in.Size()
+	AssertEq(nil, err)
+	ExpectEq(len(t.initialContents), size)
+
+	// That should have been the only stat.
+	ExpectEq(1, len(bucket.stats))
+}
+
+func (t *FileTest) OpenFileInode_NotFound() {
+	bucket := &recordingBucket{Bucket: t.bucket}
+
+	in, err := inode.OpenFileInode(
+		t.ctx,
+		fileInodeID+1,
+		"bar",
+		fuseops.InodeAttributes{},
+		bucket,
+		gcsx.NewSyncer(1, ".gcsfuse_tmp/", bucket),
+		"",
+		&t.clock,
+		inode.FileConfig{})
+
+	_, ok := err.(*gcs.NotFoundError)
+	ExpectTrue(ok, "Unexpected error: %v", err)
+	ExpectEq(nil, in)
+	ExpectEq(1, len(bucket.stats))
+}
+
 func (t *FileTest) Read() {
 	AssertEq("taco", t.initialContents)
 
@@ -211,285 +814,4256 @@ func (t *FileTest) Read() {
 	}
 }
 
-func (t *FileTest) Write() {
+func (t *FileTest) Read_AtAndBeyondEnd() {
+	buf := make([]byte, 4)
+
+	// By default, both give EOF.
+	for _, offset := range []int64{4, 5, 100} {
+		n, err := t.in.Read(t.ctx, buf, offset)
+		ExpectEq(io.EOF, err, "offset: %d", offset)
+		ExpectEq(0, n, "offset: %d", offset)
+	}
+}
+
+func (t *FileTest) Read_AtAndBeyondEnd_Strict() {
 	var err error
+	t.cfg.StrictReadRange = true
+	t.createInode()
 
-	AssertEq("taco", t.initialContents)
+	buf := make([]byte, 4)
 
-	// Overwite a byte.
-	err = t.in.Write(t.ctx, []byte("p"), 0)
-	AssertEq(nil, err)
+	// At the end is still EOF.
+	n, err := t.in.Read(t.ctx, buf, 4)
+	ExpectEq(io.EOF, err)
+	ExpectEq(0, n)
 
-	// Add some data at the end.
-	t.clock.AdvanceTime(time.Second)
-	writeTime := t.clock.Now()
+	// Beyond it is an error.
+	for _, offset := range []int64{5, 100} {
+		_, err = t.in.Read(t.ctx, buf, offset)
+
+		oore, ok := err.(*gcsx.OutOfRangeError)
+		AssertTrue(ok, "offset %d: unexpected error: %v", offset, err)
+		ExpectEq(t.in.Name(), oore.Name)
+		ExpectEq(offset, oore.Offset)
+		ExpectEq(4, oore.Size)
+	}
 
+	// The end moves with local modifications.
 	err = t.in.Write(t.ctx, []byte("burrito"), 4)
 	AssertEq(nil, err)
 
-	t.clock.AdvanceTime(time.Second)
+	n, err = t.in.Read(t.ctx, buf, 5)
+	AssertEq(nil, err)
+	ExpectEq("urri", string(buf[:n]))
 
-	// Read back the content.
-	var buf [1024]byte
-	n, err := t.in.Read(t.ctx, buf[:], 0)
+	err = t.in.Truncate(t.ctx, 2)
+	AssertEq(nil, err)
 
-	if err == io.EOF {
-		err = nil
+	_, err = t.in.Read(t.ctx, buf, 3)
+	_, ok := err.(*gcsx.OutOfRangeError)
+	ExpectTrue(ok, "Unexpected error: %v", err)
+}
+
+func (t *FileTest) Read_FaultsInFromReadOffset() {
+	var err error
+
+	// Replace the backing object with a larger one, and watch the requests made
+	// to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+
+	contents := make([]byte, 2000)
+	for i := range contents {
+		contents[i] = byte(i)
 	}
 
+	t.backingObj, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		fileInodeName,
+		contents)
+
 	AssertEq(nil, err)
-	ExpectEq("pacoburrito", string(buf[:n]))
+	t.createInode()
+
+	// Read from the middle. Only the range from there on should be fetched.
+	buf := make([]byte, 10)
+	n, err := t.in.Read(t.ctx, buf, 1000)
 
-	// Check attributes.
-	attrs, err := t.in.Attributes(t.ctx)
 	AssertEq(nil, err)
+	ExpectEq(string(contents[1000:1010]), string(buf[:n]))
 
-	ExpectEq(len("pacoburrito"), attrs.Size)
-	ExpectThat(attrs.Mtime, timeutil.TimeEq(writeTime))
+	AssertEq(1, len(bucket.reads))
+	AssertNe(nil, bucket.reads[0].Range)
+	ExpectEq(t.backingObj.Generation, bucket.reads[0].Generation)
+	ExpectEq(1000, bucket.reads[0].Range.Start)
+	ExpectEq(2000, bucket.reads[0].Range.Limit)
+
+	// Reading again from the same place shouldn't hit the bucket.
+	_, err = t.in.Read(t.ctx, buf, 1500)
+
+	AssertEq(nil, err)
+	ExpectEq(1, len(bucket.reads))
+
+	// Reading from the start should fetch only what is missing.
+	n, err = t.in.Read(t.ctx, buf, 0)
+
+	AssertEq(nil, err)
+	ExpectEq(string(contents[0:10]), string(buf[:n]))
+
+	AssertEq(2, len(bucket.reads))
+	AssertNe(nil, bucket.reads[1].Range)
+	ExpectEq(0, bucket.reads[1].Range.Start)
+	ExpectEq(1000, bucket.reads[1].Range.Limit)
 }
 
-func (t *FileTest) Truncate() {
-	var attrs fuseops.InodeAttributes
+func (t *FileTest) Read_EmptySource() {
 	var err error
 
-	AssertEq("taco", t.initialContents)
+	// Replace the backing object with an empty one, and watch the requests made
+	// to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
 
-	// Truncate downward.
-	t.clock.AdvanceTime(time.Second)
-	truncateTime := t.clock.Now()
+	t.backingObj, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		fileInodeName,
+		[]byte{})
 
-	err = t.in.Truncate(t.ctx, 2)
 	AssertEq(nil, err)
+	t.createInode()
+
+	// Reading should give EOF without needing to fetch anything.
+	buf := make([]byte, 4)
+	n, err := t.in.Read(t.ctx, buf, 0)
+
+	ExpectEq(io.EOF, err)
+	ExpectEq(0, n)
+	ExpectEq(0, len(bucket.reads))
+
+	// The inode is still clean, so syncing should do nothing.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(t.backingObj.Generation, t.in.SourceGeneration().Object)
+
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: fileInodeName})
+	AssertEq(nil, err)
+	ExpectEq(t.backingObj.Generation, o.Generation)
+}
+
+func (t *FileTest) Read_MaxFaultInChunk() {
+	var err error
+
+	// Replace the backing object with a larger one, and watch the requests made
+	// to the bucket by an inode that fetches at most 300 bytes at a time.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+	t.cfg.MaxFaultInChunk = 300
+
+	contents := make([]byte, 1000)
+	for i := range contents {
+		contents[i] = byte(i)
+	}
+
+	t.backingObj, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		fileInodeName,
+		contents)
+
+	AssertEq(nil, err)
+	t.createInode()
+
+	// Read the whole thing.
+	buf := make([]byte, 1000)
+	n, err := t.in.Read(t.ctx, buf, 0)
+
+	AssertEq(nil, err)
+	ExpectEq(string(contents), string(buf[:n]))
+
+	// The contents should have been fetched in bounded pieces.
+	var ranges []gcs.ByteRange
+	for _, r := range bucket.reads {
+		AssertNe(nil, r.Range)
+		ranges = append(ranges, *r.Range)
+	}
+
+	ExpectThat(ranges, DeepEquals([]gcs.ByteRange{
+		{Start: 0, Limit: 300},
+		{Start: 300, Limit: 600},
+		{Start: 600, Limit: 900},
+		{Start: 900, Limit: 1000},
+	}))
+}
+
+func (t *FileTest) Read_MixedDirtyAndClean() {
+	var err error
+
+	// Replace the backing object, and watch the requests made to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+
+	t.backingObj, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		fileInodeName,
+		[]byte("AAAAAAA"))
+
+	AssertEq(nil, err)
+	t.createInode()
+
+	// Overwrite the middle without reading anything.
+	err = t.in.Write(t.ctx, []byte("xx"), 2)
+	AssertEq(nil, err)
+	ExpectEq(0, len(bucket.reads))
+
+	// Read across the boundaries. The dirty bytes should be stitched over the
+	// source, and only the clean parts should be fetched.
+	buf := make([]byte, 7)
+	n, err := t.in.Read(t.ctx, buf, 0)
+
+	AssertEq(nil, err)
+	ExpectEq("AAxxAAA", string(buf[:n]))
+
+	AssertEq(2, len(bucket.reads))
+	for _, r := range bucket.reads {
+		AssertNe(nil, r.Range)
+		ExpectEq(t.backingObj.Generation, r.Generation)
+	}
+
+	ExpectEq(0, bucket.reads[0].Range.Start)
+	ExpectEq(2, bucket.reads[0].Range.Limit)
+	ExpectEq(4, bucket.reads[1].Range.Start)
+	ExpectEq(7, bucket.reads[1].Range.Limit)
+}
+
+func (t *FileTest) Read_FaultInBlockSize() {
+	var err error
+
+	// Replace the backing object with a larger one, and watch the requests made
+	// to the bucket by an inode that faults in 100-byte blocks.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+
+	contents := make([]byte, 1000)
+	for i := range contents {
+		contents[i] = byte(i)
+	}
+
+	t.backingObj, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		fileInodeName,
+		contents)
+
+	AssertEq(nil, err)
+
+	t.cfg.FaultInBlockSize = 100
+	t.createInode()
+
+	// Make two small reads within the same block.
+	buf := make([]byte, 10)
+	n, err := t.in.Read(t.ctx, buf, 210)
+
+	AssertEq(nil, err)
+	ExpectEq(string(contents[210:220]), string(buf[:n]))
+
+	n, err = t.in.Read(t.ctx, buf, 280)
+
+	AssertEq(nil, err)
+	ExpectEq(string(contents[280:290]), string(buf[:n]))
+
+	// Only the aligned block should have been fetched, once.
+	AssertEq(1, len(bucket.reads))
+	AssertNe(nil, bucket.reads[0].Range)
+	ExpectEq(200, bucket.reads[0].Range.Start)
+	ExpectEq(300, bucket.reads[0].Range.Limit)
+
+	// A read spanning a block boundary fetches just the missing block.
+	n, err = t.in.Read(t.ctx, buf, 295)
+
+	AssertEq(nil, err)
+	ExpectEq(string(contents[295:305]), string(buf[:n]))
+
+	AssertEq(2, len(bucket.reads))
+	ExpectEq(300, bucket.reads[1].Range.Start)
+	ExpectEq(400, bucket.reads[1].Range.Limit)
+
+	// The final block is clipped to the object's size.
+	n, err = t.in.Read(t.ctx, buf, 995)
+
+	ExpectEq(io.EOF, err)
+	ExpectEq(string(contents[995:]), string(buf[:n]))
+
+	AssertEq(3, len(bucket.reads))
+	ExpectEq(900, bucket.reads[2].Range.Start)
+	ExpectEq(1000, bucket.reads[2].Range.Limit)
+}
+
+func (t *FileTest) Read_StitchesCachedBlocks() {
+	var err error
+
+	// Replace the backing object with one of three 100-byte blocks, and watch
+	// the requests made to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+
+	contents := make([]byte, 300)
+	for i := range contents {
+		contents[i] = byte(i)
+	}
+
+	t.backingObj, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		fileInodeName,
+		contents)
+
+	AssertEq(nil, err)
+
+	t.cfg.FaultInBlockSize = 100
+	t.createInode()
+
+	// Cache the first and last blocks.
+	buf := make([]byte, 10)
+	_, err = t.in.Read(t.ctx, buf, 0)
+	AssertEq(nil, err)
+
+	_, err = t.in.Read(t.ctx, buf, 200)
+	AssertEq(nil, err)
+
+	AssertEq(2, len(bucket.reads))
+
+	// A read spanning all three should fetch only the gap between them.
+	buf = make([]byte, 300)
+	n, err := t.in.Read(t.ctx, buf, 0)
+
+	AssertEq(nil, err)
+	ExpectTrue(bytes.Equal(contents, buf[:n]))
+
+	AssertEq(3, len(bucket.reads))
+	AssertNe(nil, bucket.reads[2].Range)
+	ExpectEq(100, bucket.reads[2].Range.Start)
+	ExpectEq(200, bucket.reads[2].Range.Limit)
+}
+
+func (t *FileTest) Read_PrefetchNextBlock() {
+	var err error
+
+	// Replace the backing object with a larger one, and watch the requests made
+	// to the bucket by an inode that faults in 100-byte blocks and prefetches.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+
+	contents := make([]byte, 450)
+	for i := range contents {
+		contents[i] = byte(i)
+	}
+
+	t.backingObj, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		fileInodeName,
+		contents)
+
+	AssertEq(nil, err)
+
+	fetched := make(chan int64, 10)
+	t.cfg.FaultInBlockSize = 100
+	t.cfg.PrefetchNextBlock = true
+	t.cfg.OnFaultInEnd = func(offset int64, length int64, err error) {
+		fetched <- offset
+	}
+
+	t.createInode()
+
+	// Wait for the fetch of the block at the given offset, with the inode
+	// unlocked so that a prefetch can run.
+	waitForFetch := func(offset int64) {
+		t.in.Unlock()
+		defer t.in.Lock()
+
+		select {
+		case got := <-fetched:
+			AssertEq(offset, got)
+
+		case <-time.After(5 * time.Second):
+			AddFailure("Timed out waiting for fetch at %d", offset)
+			AbortTest()
+		}
+	}
+
+	// The first read must fetch its own block, and prefetches the next.
+	buf := make([]byte, 100)
+	n, hit, err := t.in.ReadAtCacheStatus(t.ctx, buf, 0)
+
+	AssertEq(nil, err)
+	ExpectEq(string(contents[0:100]), string(buf[:n]))
+	ExpectFalse(hit)
+
+	AssertEq(0, <-fetched)
+	waitForFetch(100)
+
+	// Each subsequent sequential read should find its block already present,
+	// and prefetch the one after.
+	for offset := int64(100); offset < 400; offset += 100 {
+		n, hit, err = t.in.ReadAtCacheStatus(t.ctx, buf, offset)
+
+		AssertEq(nil, err)
+		ExpectEq(string(contents[offset:offset+100]), string(buf[:n]))
+		ExpectTrue(hit, "offset %d", offset)
+
+		waitForFetch(offset + 100)
+	}
+
+	// The last block is short.
+	n, hit, err = t.in.ReadAtCacheStatus(t.ctx, buf, 400)
+
+	ExpectEq(io.EOF, err)
+	ExpectEq(string(contents[400:]), string(buf[:n]))
+	ExpectTrue(hit)
+
+	// Each block should have been fetched exactly once.
+	AssertEq(5, len(bucket.reads))
+	for i, r := range bucket.reads {
+		ExpectEq(100*i, r.Range.Start)
+	}
+}
+
+func (t *FileTest) ReadV() {
+	var err error
+
+	// Watch the requests made to the bucket by an inode that faults in only
+	// what each read needs.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+
+	contents := make([]byte, 100)
+	for i := range contents {
+		contents[i] = byte(i)
+	}
+
+	t.backingObj, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		fileInodeName,
+		contents)
+
+	AssertEq(nil, err)
+
+	t.cfg.FaultInBlockSize = 1
+	t.createInode()
+
+	// Adjacent and overlapping requests, out of order, plus one on its own and
+	// one running off the end.
+	reqs := []inode.ReadRequest{
+		{Offset: 20, Length: 10},
+		{Offset: 10, Length: 10},
+		{Offset: 25, Length: 10},
+		{Offset: 50, Length: 5},
+		{Offset: 95, Length: 10},
+	}
+
+	results, err := t.in.ReadV(t.ctx, reqs)
+	AssertEq(nil, err)
+	AssertEq(len(reqs), len(results))
+
+	ExpectEq(string(contents[20:30]), string(results[0].Data))
+	ExpectEq(string(contents[10:20]), string(results[1].Data))
+	ExpectEq(string(contents[25:35]), string(results[2].Data))
+	ExpectEq(string(contents[50:55]), string(results[3].Data))
+	ExpectEq(string(contents[95:]), string(results[4].Data))
+
+	// The first three should have been merged into one fetch.
+	AssertEq(3, len(bucket.reads))
+	ExpectEq(10, bucket.reads[0].Range.Start)
+	ExpectEq(35, bucket.reads[0].Range.Limit)
+	ExpectEq(50, bucket.reads[1].Range.Start)
+	ExpectEq(55, bucket.reads[1].Range.Limit)
+	ExpectEq(95, bucket.reads[2].Range.Start)
+	ExpectEq(100, bucket.reads[2].Range.Limit)
+}
+
+func (t *FileTest) ReadV_Dirty() {
+	var err error
+
+	AssertEq("taco", t.initialContents)
+
+	err = t.in.Write(t.ctx, []byte("burrito"), 2)
+	AssertEq(nil, err)
+
+	results, err := t.in.ReadV(
+		t.ctx,
+		[]inode.ReadRequest{
+			{Offset: 0, Length: 3},
+			{Offset: 3, Length: 3},
+			{Offset: 9, Length: 1},
+			{Offset: 20, Length: 1},
+		})
+
+	AssertEq(nil, err)
+	AssertEq(4, len(results))
+	ExpectEq("tab", string(results[0].Data))
+	ExpectEq("urr", string(results[1].Data))
+	ExpectEq("", string(results[2].Data))
+	ExpectEq("", string(results[3].Data))
+}
+
+func (t *FileTest) Read_Clobbered() {
+	// Clobber the backing object before anything has been faulted in.
+	_, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		t.in.Name(),
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+
+	// Reading should fail with a clear error, rather than serving data from
+	// the new generation.
+	buf := make([]byte, 4)
+	_, err = t.in.Read(t.ctx, buf, 0)
+
+	ce, ok := err.(*gcsx.ClobberedError)
+	AssertTrue(ok, "Unexpected error: %v", err)
+	ExpectEq(t.backingObj.Name, ce.Name)
+	ExpectEq(t.backingObj.Generation, ce.Generation)
+}
+
+func (t *FileTest) Read_ClobberedMidStream() {
+	var err error
+
+	// Have reads fail part way through with what GCS says when the object has
+	// been deleted.
+	t.bucket = gcsx.NewClassifyingBucket(&midStreamErrorBucket{
+		Bucket: t.bucket,
+		after:  2,
+		err:    &googleapi.Error{Code: 404},
+	})
+
+	t.createInode()
+
+	// Reading should fail with a clear error.
+	buf := make([]byte, 4)
+	_, err = t.in.Read(t.ctx, buf, 0)
+
+	ce, ok := err.(*gcsx.ClobberedError)
+	AssertTrue(ok, "Unexpected error: %v", err)
+	ExpectEq(t.backingObj.Name, ce.Name)
+	ExpectEq(t.backingObj.Generation, ce.Generation)
+
+	_, ok = ce.Err.(*gcs.NotFoundError)
+	ExpectTrue(ok, "Unexpected error: %v", ce.Err)
+}
+
+func (t *FileTest) Read_ErrorMidStream() {
+	var err error
+
+	// Have reads fail part way through for some other reason.
+	t.bucket = gcsx.NewClassifyingBucket(&midStreamErrorBucket{
+		Bucket: t.bucket,
+		after:  2,
+		err:    &googleapi.Error{Code: 500},
+	})
+
+	t.createInode()
+
+	// That shouldn't be mistaken for the object having been clobbered.
+	buf := make([]byte, 4)
+	_, err = t.in.Read(t.ctx, buf, 0)
+
+	AssertNe(nil, err)
+	_, ok := err.(*gcsx.ClobberedError)
+	ExpectFalse(ok, "Unexpected error: %v", err)
+}
+
+func (t *FileTest) Read_FailFastOnClobber() {
+	var err error
+
+	// Watch the requests made to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+	t.cfg.FailFastOnClobber = true
+	t.createInode()
+
+	// Clobber the backing object, and notice with a stat.
+	_, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		t.in.Name(),
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(0, attrs.Nlink)
+
+	// Reading should now fail without contacting the bucket.
+	buf := make([]byte, 4)
+	_, err = t.in.Read(t.ctx, buf, 0)
+
+	ce, ok := err.(*gcsx.ClobberedError)
+	AssertTrue(ok, "Unexpected error: %v", err)
+	ExpectEq(t.backingObj.Generation, ce.Generation)
+	ExpectEq(0, len(bucket.reads))
+}
+
+func (t *FileTest) Read_FailFastOnClobber_AfterFailedRead() {
+	var err error
+
+	// Watch the requests made to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+	t.cfg.FailFastOnClobber = true
+	t.createInode()
+
+	// Clobber the backing object without the inode noticing.
+	_, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		t.in.Name(),
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+
+	// The first read finds out the hard way. The second needn't.
+	buf := make([]byte, 4)
+	for i := 0; i < 2; i++ {
+		_, err = t.in.Read(t.ctx, buf, 0)
+		_, ok := err.(*gcsx.ClobberedError)
+		AssertTrue(ok, "Unexpected error: %v", err)
+	}
+
+	ExpectEq(1, len(bucket.reads))
+}
+
+func (t *FileTest) Read_FailFastOnClobber_Disabled() {
+	var err error
+
+	// Watch the requests made to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+	t.createInode()
+
+	// Clobber the backing object, and notice with a stat.
+	_, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		t.in.Name(),
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+
+	_, err = t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+
+	// Each read should still try the bucket.
+	buf := make([]byte, 4)
+	for i := 0; i < 2; i++ {
+		_, err = t.in.Read(t.ctx, buf, 0)
+		_, ok := err.(*gcsx.ClobberedError)
+		AssertTrue(ok, "Unexpected error: %v", err)
+	}
+
+	ExpectEq(2, len(bucket.reads))
+}
+
+func (t *FileTest) Read_StaleGeneration() {
+	var err error
+
+	t.bucket = &liveReadingBucket{Bucket: t.bucket}
+	t.createInode()
+
+	// Clobber the backing object without the inode noticing.
+	o, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		t.in.Name(),
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+
+	// The read is served from the new generation, which we refuse.
+	buf := make([]byte, 4)
+	_, err = t.in.Read(t.ctx, buf, 0)
+
+	ce, ok := err.(*gcsx.ClobberedError)
+	AssertTrue(ok, "Unexpected error: %v", err)
+	ExpectEq(t.backingObj.Generation, ce.Generation)
+
+	sre, ok := ce.Err.(*gcsx.StaleReadError)
+	AssertTrue(ok, "Unexpected error: %v", ce.Err)
+	ExpectEq(t.backingObj.Generation, sre.Expected)
+	ExpectEq(o.Generation, sre.Actual)
+
+	ExpectEq(t.backingObj.Generation, t.in.SourceGeneration().Object)
+}
+
+func (t *FileTest) Read_StaleGeneration_Follow() {
+	var err error
+
+	t.bucket = &liveReadingBucket{Bucket: t.bucket}
+	t.cfg.FollowNewGenerations = true
+	t.createInode()
+
+	// Clobber the backing object without the inode noticing.
+	o, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		t.in.Name(),
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+
+	// The inode should switch to the new generation.
+	buf := make([]byte, 16)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq("burrito", string(buf[:n]))
+	ExpectEq(o.Generation, t.in.SourceGeneration().Object)
+}
+
+func (t *FileTest) Read_StaleGeneration_FollowMidRead() {
+	var err error
+
+	t.bucket = &liveReadingBucket{Bucket: t.bucket}
+	t.cfg.FollowNewGenerations = true
+	t.cfg.FaultInBlockSize = 2
+	t.createInode()
+
+	// Fault in the start of the old generation.
+	buf := make([]byte, 1)
+	_, err = t.in.Read(t.ctx, buf, 0)
+	AssertEq(nil, err)
+	ExpectEq("t", string(buf))
+
+	// Clobber the backing object, then read the rest. What we had of the old
+	// generation should be thrown away rather than mixed in.
+	o, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		t.in.Name(),
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+
+	buf = make([]byte, 4)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(nil, err)
+	ExpectEq("burr", string(buf[:n]))
+	ExpectEq(o.Generation, t.in.SourceGeneration().Object)
+}
+
+func (t *FileTest) Read_StaleGeneration_FollowDirty() {
+	var err error
+
+	t.bucket = &liveReadingBucket{Bucket: t.bucket}
+	t.cfg.FollowNewGenerations = true
+	t.cfg.FaultInBlockSize = 2
+	t.createInode()
+
+	// Modify the start of the old generation.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	// Clobber the backing object. The modification can't be carried over to
+	// the new generation, so the inode shouldn't follow.
+	_, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		t.in.Name(),
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+
+	buf := make([]byte, 2)
+	_, err = t.in.Read(t.ctx, buf, 2)
+	_, ok := err.(*gcsx.ClobberedError)
+	AssertTrue(ok, "Unexpected error: %v", err)
+	ExpectEq(t.backingObj.Generation, t.in.SourceGeneration().Object)
+}
+
+func (t *FileTest) Read_PrefetchDoesntHoldLock() {
+	var err error
+
+	// An inode that faults in 100-byte blocks and prefetches, with the prefetch
+	// of the second block never completing by itself.
+	bucket := &blockingReadBucket{
+		Bucket:  t.bucket,
+		from:    100,
+		started: make(chan struct{}),
+	}
+
+	t.bucket = bucket
+
+	contents := make([]byte, 300)
+	for i := range contents {
+		contents[i] = byte(i)
+	}
+
+	t.backingObj, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		fileInodeName,
+		contents)
+
+	AssertEq(nil, err)
+
+	fetchErrs := make(chan error, 10)
+	t.cfg.FaultInBlockSize = 100
+	t.cfg.PrefetchNextBlock = true
+	t.cfg.OnFaultInEnd = func(offset int64, length int64, err error) {
+		if offset == 100 {
+			fetchErrs <- err
+		}
+	}
+
+	t.createInode()
+
+	// Read the first block, starting the prefetch, and wait for it to block.
+	buf := make([]byte, 100)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(nil, err)
+	ExpectEq(string(contents[0:100]), string(buf[:n]))
+
+	t.in.Unlock()
+	select {
+	case <-bucket.started:
+	case <-time.After(5 * time.Second):
+		t.in.Lock()
+		AddFailure("Timed out waiting for the prefetch")
+		AbortTest()
+	}
+
+	// The inode should remain usable while the prefetch is in flight.
+	locked := make(chan struct{})
+	go func() {
+		t.in.Lock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		AddFailure("Timed out waiting for the inode lock")
+		AbortTest()
+	}
+
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(len(contents), attrs.Size)
+
+	n, err = t.in.Read(t.ctx, buf, 0)
+	AssertEq(nil, err)
+	ExpectEq(string(contents[0:100]), string(buf[:n]))
+
+	// Destroying the inode should cancel the prefetch, which should report
+	// the failure.
+	err = t.in.Destroy()
+	AssertEq(nil, err)
+
+	t.in.Unlock()
+	defer t.in.Lock()
+
+	select {
+	case err = <-fetchErrs:
+		ExpectThat(err, Error(HasSubstr("canceled")))
+
+	case <-time.After(5 * time.Second):
+		AddFailure("Timed out waiting for the prefetch to be cancelled")
+	}
+}
+
+func (t *FileTest) Read_ConcurrentFaultIns() {
+	const blockSize = 64
+	const readers = 8
+
+	// An object with a block for each reader.
+	bucket := &concurrencyMeasuringBucket{Bucket: t.bucket}
+	t.cfg.FaultInBlockSize = blockSize
+	t.cfg.PrefetchNextBlock = true
+
+	contents := strings.Repeat("x", blockSize*readers)
+	o, err := gcsutil.CreateObject(t.ctx, bucket, "foo", []byte(contents))
+	AssertEq(nil, err)
+
+	in := inode.NewFileInode(
+		fileInodeID+1,
+		o,
+		fuseops.InodeAttributes{},
+		bucket,
+		gcsx.NewSyncer(1, ".gcsfuse_tmp/", bucket),
+		"",
+		&t.clock,
+		t.cfg)
+
+	// Read disjoint blocks at once.
+	errs := make(chan error, readers)
+	for i := 0; i < readers; i++ {
+		go func(i int) {
+			buf := make([]byte, blockSize)
+
+			in.Lock()
+			_, err := in.Read(t.ctx, buf, int64(i*blockSize))
+			in.Unlock()
+
+			errs <- err
+		}(i)
+	}
+
+	for i := 0; i < readers; i++ {
+		err := <-errs
+		ExpectTrue(err == nil || err == io.EOF, "Unexpected error: %v", err)
+	}
+
+	// Besides the readers' own fetches, one prefetch may be in flight.
+	ExpectLe(1, bucket.opened())
+	ExpectLe(bucket.maxOpen(), 2)
+}
+
+func (t *FileTest) Read_FullyCached() {
+	var err error
+
+	// Replace the backing object, and watch the requests made to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+
+	t.backingObj, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		fileInodeName,
+		[]byte("taco"))
+
+	AssertEq(nil, err)
+	t.createInode()
+
+	// Read the whole thing, faulting it all in.
+	buf := make([]byte, 4)
+	n, err := t.in.Read(t.ctx, buf, 0)
+
+	AssertEq(nil, err)
+	ExpectEq("taco", string(buf[:n]))
+	AssertEq(1, len(bucket.reads))
+
+	// Clobber the backing object. Reads are now served locally, so this
+	// shouldn't matter.
+	_, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		fileInodeName,
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+
+	n, err = t.in.Read(t.ctx, buf[:2], 1)
+	AssertEq(nil, err)
+	ExpectEq("ac", string(buf[:n]))
+
+	// Local modifications should show through, including across truncations.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Truncate(t.ctx, 2)
+	AssertEq(nil, err)
+
+	err = t.in.Truncate(t.ctx, 3)
+	AssertEq(nil, err)
+
+	n, err = t.in.Read(t.ctx, buf, 0)
+	ExpectEq(io.EOF, err)
+	ExpectEq("pa\x00", string(buf[:n]))
+
+	n, err = t.in.Read(t.ctx, buf, 3)
+	ExpectEq(io.EOF, err)
+	ExpectEq(0, n)
+
+	ExpectEq(1, len(bucket.reads))
+}
+
+func (t *FileTest) Read_FullyCachedThenSynced() {
+	var err error
+
+	// Fault everything in and then make a modification.
+	buf := make([]byte, 4)
+	_, err = t.in.Read(t.ctx, buf, 0)
+	AssertEq(nil, err)
+
+	err = t.in.Write(t.ctx, []byte("burrito"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	// Clobber the new generation. Its contents are no longer local, so
+	// reading must notice.
+	_, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		t.in.Name(),
+		[]byte("enchilada"))
+
+	AssertEq(nil, err)
+
+	_, err = t.in.Read(t.ctx, buf, 0)
+	_, ok := err.(*gcsx.ClobberedError)
+	ExpectTrue(ok, "Unexpected error: %v", err)
+}
+
+func (t *FileTest) ReadAtGeneration() {
+	var err error
+
+	// Watch the requests made to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+	t.createInode()
+
+	// Dirty the inode.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	buf := make([]byte, 10)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	if err == io.EOF {
+		err = nil
+	}
+
+	AssertEq(nil, err)
+	AssertEq("paco", string(buf[:n]))
+
+	// Write a new generation of the object behind the inode's back.
+	o, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		t.in.Name(),
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+	sg := t.in.SourceGeneration()
+
+	// Read part of it.
+	bucket.reads = nil
+	buf = make([]byte, 3)
+	n, err = t.in.ReadAtGeneration(t.ctx, buf, 1, o.Generation)
+
+	AssertEq(nil, err)
+	ExpectEq("urr", string(buf[:n]))
+
+	AssertEq(1, len(bucket.reads))
+	ExpectEq(o.Generation, bucket.reads[0].Generation)
+	ExpectThat(
+		bucket.reads[0].Range,
+		Pointee(DeepEquals(gcs.ByteRange{Start: 1, Limit: 4})))
+
+	// Reading past the end gives io.EOF.
+	buf = make([]byte, 10)
+	n, err = t.in.ReadAtGeneration(t.ctx, buf, 4, o.Generation)
+
+	ExpectEq(io.EOF, err)
+	ExpectEq("ito", string(buf[:n]))
+
+	// The inode is unaffected.
+	ExpectThat(t.in.SourceGeneration(), DeepEquals(sg))
+	ExpectEq(t.backingObj.Generation, sg.Object)
+
+	n, err = t.in.Read(t.ctx, buf, 0)
+	if err == io.EOF {
+		err = nil
+	}
+
+	AssertEq(nil, err)
+	ExpectEq("paco", string(buf[:n]))
+}
+
+func (t *FileTest) ReadAtGeneration_NotFound() {
+	buf := make([]byte, 4)
+	_, err := t.in.ReadAtGeneration(t.ctx, buf, 0, t.backingObj.Generation+1)
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *FileTest) HashContents_Clean() {
+	h := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	n, err := t.in.HashContents(t.ctx, h)
+
+	AssertEq(nil, err)
+	ExpectEq(len(t.initialContents), n)
+	ExpectEq(t.backingObj.CRC32C, h.Sum32())
+}
+
+func (t *FileTest) HashContents_Dirty() {
+	var err error
+
+	AssertEq("taco", t.initialContents)
+
+	// Overwrite the middle and extend with a hole.
+	err = t.in.Write(t.ctx, []byte("bu"), 1)
+	AssertEq(nil, err)
+
+	err = t.in.Truncate(t.ctx, 6)
+	AssertEq(nil, err)
+
+	err = t.in.Write(t.ctx, []byte("s"), 6)
+	AssertEq(nil, err)
+
+	h := crc32.NewIEEE()
+	n, err := t.in.HashContents(t.ctx, h)
+
+	AssertEq(nil, err)
+	ExpectEq(7, n)
+	ExpectEq(crc32.ChecksumIEEE([]byte("tbuo\x00\x00s")), h.Sum32())
+}
+
+func (t *FileTest) MMap_Materialized() {
+	// Fault in the contents.
+	buf := make([]byte, len(t.initialContents))
+	_, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(nil, err)
+
+	data, unmap, err := t.in.MMap()
+	AssertEq(nil, err)
+	ExpectEq(t.initialContents, string(data))
+
+	err = unmap()
+	ExpectEq(nil, err)
+}
+
+func (t *FileTest) MMap_NotMaterialized() {
+	_, _, err := t.in.MMap()
+	ExpectThat(err, Error(HasSubstr("faulted in")))
+
+	// Partially faulted in.
+	t.cfg.FaultInBlockSize = 2
+	t.createInode()
+
+	buf := make([]byte, 1)
+	_, err = t.in.Read(t.ctx, buf, 0)
+	AssertEq(nil, err)
+
+	_, _, err = t.in.MMap()
+	ExpectThat(err, Error(HasSubstr("present")))
+}
+
+func (t *FileTest) MMap_Dirty() {
+	err := t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	_, _, err = t.in.MMap()
+	ExpectThat(err, Error(HasSubstr("local modifications")))
+}
+
+func (t *FileTest) NewBackingStore() {
+	var err error
+
+	var stores []*countingStore
+	t.cfg.NewBackingStore = func() (bs gcsx.BackingStore, err error) {
+		s := &countingStore{BackingStore: gcsx.NewMemoryBackingStore()}
+		stores = append(stores, s)
+		bs = s
+		return
+	}
+
+	t.createInode()
+
+	// Faulting in the contents should put them in the store.
+	buf := make([]byte, 16)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq("taco", string(buf[:n]))
+
+	AssertEq(1, len(stores))
+	ExpectEq(len("taco"), stores[0].written)
+
+	// As should writing.
+	err = t.in.Write(t.ctx, []byte("burrito"), 4)
+	AssertEq(nil, err)
+	ExpectEq(len("tacoburrito"), stores[0].written)
+
+	// Such contents can't be mapped.
+	_, _, err = t.in.MMap()
+	ExpectNe(nil, err)
+
+	// Syncing should upload what's in the store.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	actual, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq("tacoburrito", string(actual))
+	ExpectEq(1, len(stores))
+}
+
+func (t *FileTest) NewBackingStore_Fails() {
+	t.cfg.NewBackingStore = func() (gcsx.BackingStore, error) {
+		return nil, errors.New("taco")
+	}
+
+	t.createInode()
+
+	err := t.in.Write(t.ctx, []byte("burrito"), 0)
+	ExpectThat(err, Error(HasSubstr("NewBackingStore")))
+	ExpectThat(err, Error(HasSubstr("taco")))
+}
+
+func (t *FileTest) NewBackingStore_OutOfSpace() {
+	var err error
+
+	t.cfg.NewBackingStore = func() (bs gcsx.BackingStore, err error) {
+		bs = &quotaStore{BackingStore: gcsx.NewMemoryBackingStore(), quota: 8}
+		return
+	}
+
+	t.createInode()
+
+	// Writes and truncations that won't fit should be rejected outright.
+	err = t.in.Write(t.ctx, []byte("burrito"), 2)
+	ExpectThat(err, HasSameTypeAs(&gcsx.NoSpaceError{}))
+
+	err = t.in.Truncate(t.ctx, 9)
+	ExpectThat(err, HasSameTypeAs(&gcsx.NoSpaceError{}))
+
+	size, err := t.in.Size()
+	AssertEq(nil, err)
+	ExpectEq(len("taco"), size)
+
+	buf := make([]byte, 16)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq("taco", string(buf[:n]))
+	ExpectEq(nil, t.in.DirtyRanges())
+}
+
+func (t *FileTest) NewBackingStore_OutOfSpace_MemoryFallback() {
+	var err error
+
+	t.cfg.MemoryFallback = true
+	t.cfg.NewBackingStore = func() (bs gcsx.BackingStore, err error) {
+		bs = &quotaStore{BackingStore: gcsx.NewMemoryBackingStore(), quota: 8}
+		return
+	}
+
+	t.createInode()
+
+	// Once in memory, the quota no longer applies.
+	err = t.in.Truncate(t.ctx, 9)
+	AssertEq(nil, err)
+
+	err = t.in.Write(t.ctx, []byte("burrito"), 2)
+	AssertEq(nil, err)
+
+	buf := make([]byte, 16)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq("taburrito", string(buf[:n]))
+}
+
+func (t *FileTest) EvictClean() {
+	var err error
+
+	// Nothing to evict at first.
+	evicted, err := t.in.EvictClean()
+	AssertEq(nil, err)
+	ExpectEq(0, evicted)
+
+	// Fault in the contents, then evict them.
+	buf := make([]byte, 16)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq("taco", string(buf[:n]))
+
+	materialized, err := t.in.MaterializedBytes()
+	AssertEq(nil, err)
+	ExpectEq(4, materialized)
+
+	evicted, err = t.in.EvictClean()
+	AssertEq(nil, err)
+	ExpectEq(4, evicted)
+
+	materialized, err = t.in.MaterializedBytes()
+	AssertEq(nil, err)
+	ExpectEq(0, materialized)
+
+	// They should be faulted in again when needed.
+	n, err = t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq("taco", string(buf[:n]))
+}
+
+func (t *FileTest) EvictClean_Dirty() {
+	var err error
+
+	err = t.in.Write(t.ctx, []byte("burrito"), 4)
+	AssertEq(nil, err)
+
+	evicted, err := t.in.EvictClean()
+	AssertEq(nil, err)
+	ExpectEq(0, evicted)
+
+	buf := make([]byte, 16)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq("tacoburrito", string(buf[:n]))
+}
+
+func (t *FileTest) RangesUnsupported_FallsBackToFullRead() {
+	bucket := &rangeRejectingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+	t.createInode()
+
+	// Read part of the object. The ranged read should be refused, and the
+	// whole object read instead.
+	buf := make([]byte, 2)
+	n, err := t.in.Read(t.ctx, buf, 1)
+
+	AssertEq(nil, err)
+	ExpectEq("ac", string(buf[:n]))
+	ExpectEq(1, bucket.ranged)
+	ExpectEq(1, bucket.full)
+
+	// The rest should now be served locally.
+	buf = make([]byte, len(t.initialContents))
+	n, err = t.in.Read(t.ctx, buf, 0)
+
+	AssertEq(nil, err)
+	ExpectEq(t.initialContents, string(buf[:n]))
+	ExpectEq(1, bucket.full)
+}
+
+func (t *FileTest) RangesUnsupported_Remembered() {
+	bucket := &rangeRejectingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+	t.createInode()
+
+	buf := make([]byte, 2)
+	n, err := t.in.ReadAtGeneration(t.ctx, buf, 1, t.backingObj.Generation)
+
+	AssertEq(nil, err)
+	ExpectEq("ac", string(buf[:n]))
+	ExpectEq(1, bucket.ranged)
+	ExpectEq(1, bucket.full)
+
+	// Later reads shouldn't try ranges again.
+	n, err = t.in.ReadAtGeneration(t.ctx, buf, 2, t.backingObj.Generation)
+
+	AssertEq(nil, err)
+	ExpectEq("co", string(buf[:n]))
+
+	n, err = t.in.Read(t.ctx, buf, 0)
+
+	AssertEq(nil, err)
+	ExpectEq("ta", string(buf[:n]))
+
+	ExpectEq(1, bucket.ranged)
+	ExpectEq(3, bucket.full)
+}
+
+// A throttle that admits bytes at a fixed rate according to a simulated clock,
+// advancing the clock rather than sleeping. If block is set, it instead waits
+// for the context to be cancelled.
+type fakeThrottle struct {
+	rateHz   float64
+	capacity uint64
+	block    bool
+
+	clock  timeutil.SimulatedClock
+	tokens uint64
+	max    uint64
+}
+
+func (ft *fakeThrottle) Capacity() uint64 {
+	return ft.capacity
+}
+
+func (ft *fakeThrottle) Wait(ctx context.Context, tokens uint64) (err error) {
+	if ft.block {
+		<-ctx.Done()
+		err = ctx.Err()
+		return
+	}
+
+	ft.tokens += tokens
+	if tokens > ft.max {
+		ft.max = tokens
+	}
+
+	ft.clock.AdvanceTime(
+		time.Duration(float64(tokens) / ft.rateHz * float64(time.Second)))
+
+	return
+}
+
+func (t *FileTest) FaultInThrottle_PacesReads() {
+	var err error
+
+	// Set up a larger backing object.
+	contents := bytes.Repeat([]byte("taco"), 2500)
+	t.backingObj, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		fileInodeName,
+		contents)
+
+	AssertEq(nil, err)
+
+	throttle := &fakeThrottle{rateHz: 1000, capacity: 512}
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	throttle.clock.SetTime(start)
+
+	t.cfg.FaultInThrottle = throttle
+	t.createInode()
+
+	// Read everything.
+	buf := make([]byte, len(contents))
+	n, err := t.in.Read(t.ctx, buf, 0)
+	if err == io.EOF {
+		err = nil
+	}
+
+	AssertEq(nil, err)
+	AssertEq(len(contents), n)
+	ExpectTrue(bytes.Equal(contents, buf))
+
+	// Every byte should have gone through the throttle, in chunks no larger
+	// than its capacity, taking as long as the rate dictates.
+	ExpectEq(len(contents), throttle.tokens)
+	ExpectLe(throttle.max, throttle.capacity)
+	ExpectEq(10*time.Second, throttle.clock.Now().Sub(start))
+}
+
+func (t *FileTest) FaultInThrottle_Cancelled() {
+	t.cfg.FaultInThrottle = &fakeThrottle{capacity: 512, block: true}
+	t.createInode()
+
+	ctx, cancel := context.WithCancel(t.ctx)
+	cancel()
+
+	buf := make([]byte, 4)
+	_, err := t.in.Read(ctx, buf, 0)
+	ExpectThat(err, Error(HasSubstr(context.Canceled.Error())))
+
+	// Nothing should have been dirtied.
+	plan, err := t.in.DrySync()
+	AssertEq(nil, err)
+	ExpectTrue(plan.NoOp)
+}
+
+// A record of calls to the fault-in hooks.
+type faultInEvent struct {
+	end    bool
+	offset int64
+	length int64
+	err    error
+}
+
+func (t *FileTest) recordFaultIns() (events *[]faultInEvent) {
+	events = new([]faultInEvent)
+	t.cfg.OnFaultInStart = func(offset int64, length int64) {
+		*events = append(*events, faultInEvent{offset: offset, length: length})
+	}
+
+	t.cfg.OnFaultInEnd = func(offset int64, length int64, err error) {
+		*events = append(
+			*events,
+			faultInEvent{end: true, offset: offset, length: length, err: err})
+	}
+
+	t.createInode()
+	return
+}
+
+func (t *FileTest) FaultInHooks() {
+	t.cfg.MaxFaultInChunk = 3
+	events := t.recordFaultIns()
+
+	buf := make([]byte, 4)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	if err == io.EOF {
+		err = nil
+	}
+
+	AssertEq(nil, err)
+	ExpectEq("taco", string(buf[:n]))
+
+	// Each fetch should be bracketed by a start and an end.
+	ExpectThat(*events, DeepEquals([]faultInEvent{
+		{end: false, offset: 0, length: 3},
+		{end: true, offset: 0, length: 3},
+		{end: false, offset: 3, length: 1},
+		{end: true, offset: 3, length: 1},
+	}))
+
+	// Reading again fetches nothing.
+	*events = nil
+	_, err = t.in.Read(t.ctx, buf, 0)
+	if err == io.EOF {
+		err = nil
+	}
+
+	AssertEq(nil, err)
+	ExpectEq(0, len(*events))
+}
+
+func (t *FileTest) FaultInHooks_Error() {
+	events := t.recordFaultIns()
+
+	// Clobber the backing object, so that fetching fails.
+	_, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		t.in.Name(),
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+
+	buf := make([]byte, 4)
+	_, err = t.in.Read(t.ctx, buf, 0)
+	AssertNe(nil, err)
+
+	// The end hook should have been told about the failure.
+	AssertEq(2, len(*events))
+	ExpectFalse((*events)[0].end)
+	ExpectEq(0, (*events)[0].offset)
+	ExpectEq(4, (*events)[0].length)
+
+	ExpectTrue((*events)[1].end)
+	ExpectEq(0, (*events)[1].offset)
+	ExpectEq(4, (*events)[1].length)
+	ExpectThat((*events)[1].err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *FileTest) Snapshot_SurvivesClobbering() {
+	var err error
+
+	// Take a snapshot.
+	s, err := t.in.Snapshot(t.ctx)
+	AssertEq(nil, err)
+	defer s.Destroy()
+
+	ExpectEq(t.backingObj.Generation, s.Generation())
+
+	// Clobber the backing object. The inode should notice.
+	_, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		t.in.Name(),
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+
+	_, err = t.in.Read(t.ctx, make([]byte, 4), 0)
+	_, ok := err.(*gcsx.ClobberedError)
+	AssertTrue(ok, "Unexpected error: %v", err)
+
+	// The snapshot should keep serving the original generation.
+	buf := make([]byte, 16)
+	n, err := s.ReadAt(buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq("taco", string(buf[:n]))
+
+	n, err = s.ReadAt(buf[:2], 1)
+	AssertEq(nil, err)
+	ExpectEq("ac", string(buf[:n]))
+	ExpectEq(t.backingObj.Generation, s.Generation())
+}
+
+func (t *FileTest) Snapshot_ExcludesLocalModifications() {
+	var err error
+
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	s, err := t.in.Snapshot(t.ctx)
+	AssertEq(nil, err)
+	defer s.Destroy()
+
+	buf := make([]byte, 4)
+	n, err := s.ReadAt(buf, 0)
+	AssertEq(nil, err)
+	ExpectEq("taco", string(buf[:n]))
+}
+
+func (t *FileTest) Snapshot_Clobbered() {
+	_, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		t.in.Name(),
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+
+	_, err = t.in.Snapshot(t.ctx)
+	_, ok := err.(*gcsx.ClobberedError)
+	ExpectTrue(ok, "Unexpected error: %v", err)
+}
+
+func (t *FileTest) Transform_RoundTrip() {
+	var err error
+	xt := xorTransform{key: 0x5a}
+	encode := func(s string) string {
+		r, err := xt.Encode(strings.NewReader(s))
+		AssertEq(nil, err)
+		b, err := ioutil.ReadAll(r)
+		AssertEq(nil, err)
+		return string(b)
+	}
+
+	// Store encoded contents, and create an inode that knows how to decode them.
+	t.backingObj, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		fileInodeName,
+		[]byte(encode("taco")))
+
+	AssertEq(nil, err)
+
+	t.cfg.Transform = xt
+	t.createInode()
+	ExpectFalse(t.in.SourceGenerationIsAuthoritative())
+
+	// Reads should see the logical contents.
+	buf := make([]byte, 16)
+	n, err := t.in.Read(t.ctx, buf, 1)
+	AssertEq(io.EOF, err)
+	ExpectEq("aco", string(buf[:n]))
+
+	// Modify and sync. GCS should see the encoded contents.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq(encode("paco"), string(contents))
+
+	// A fresh inode should read back the logical contents.
+	t.backingObj = t.in.Source()
+	t.createInode()
+
+	n, err = t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq("paco", string(buf[:n]))
+}
+
+// Replace the backing object with one stored in the fake rot13 format,
+// recording the given uncompressed size if non-empty.
+func (t *FileTest) createRot13Object(contents string, size string) {
+	var err error
+	metadata := make(map[string]string)
+	if size != "" {
+		metadata[inode.UncompressedSizeMetadataKey] = size
+	}
+
+	t.backingObj, err = t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:            fileInodeName,
+			Contents:        strings.NewReader(rot13(contents) + "===="),
+			ContentEncoding: "rot13",
+			Metadata:        metadata,
+		})
+
+	AssertEq(nil, err)
+
+	t.cfg.Decompressors = map[string]inode.Decompressor{
+		"rot13": rot13Decompressor{},
+	}
+
+	t.createInode()
+}
+
+func (t *FileTest) Decompressor_Read() {
+	t.createRot13Object("taco", "4")
+	ExpectFalse(t.in.SourceGenerationIsAuthoritative())
+
+	// Sizes should be uncompressed.
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(4, attrs.Size)
+
+	// Reads should be decoded.
+	buf := make([]byte, 16)
+	n, err := t.in.Read(t.ctx, buf, 1)
+	AssertEq(io.EOF, err)
+	ExpectEq("aco", string(buf[:n]))
+}
+
+func (t *FileTest) Decompressor_SyncWritesUncompressed() {
+	var err error
+	t.createRot13Object("taco", "4")
+
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	// GCS should have plain contents, with no encoding.
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq("paco", string(contents))
+	ExpectEq("", t.in.Source().ContentEncoding)
+
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(4, attrs.Size)
+
+	// A fresh inode should read the new generation as is.
+	t.backingObj = t.in.Source()
+	t.createInode()
+	ExpectTrue(t.in.SourceGenerationIsAuthoritative())
+
+	buf := make([]byte, 16)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq("paco", string(buf[:n]))
+}
+
+func (t *FileTest) Decompressor_NoUncompressedSize() {
+	t.createRot13Object("taco", "")
+
+	// Without a recorded size the decompressor doesn't apply.
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(8, attrs.Size)
+
+	buf := make([]byte, 16)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq(rot13("taco")+"====", string(buf[:n]))
+}
+
+func (t *FileTest) Transform_ChangesLength() {
+	var err error
+	ht := headerTransform{header: "GCSF"}
+
+	t.backingObj, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		fileInodeName,
+		[]byte("GCSFtaco"))
+
+	AssertEq(nil, err)
+
+	t.cfg.Transform = ht
+	t.createInode()
+
+	// Sizes should be logical.
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(4, attrs.Size)
+
+	// Append and sync. The object should be rewritten in full.
+	err = t.in.Write(t.ctx, []byte("burrito"), 4)
+	AssertEq(nil, err)
+
+	attrs, err = t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(len("tacoburrito"), attrs.Size)
+
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq("GCSFtacoburrito", string(contents))
+
+	attrs, err = t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(len("tacoburrito"), attrs.Size)
+
+	// Read it back with a fresh inode.
+	t.backingObj = t.in.Source()
+	t.createInode()
+
+	buf := make([]byte, 16)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq("tacoburrito", string(buf[:n]))
+}
+
+func (t *FileTest) Read_SourceShorterThanExpected() {
+	AssertEq("taco", t.initialContents)
+
+	t.bucket = &fixedContentsBucket{Bucket: t.bucket, contents: "tac"}
+	t.createInode()
+
+	_, err := t.in.Read(t.ctx, make([]byte, 4), 0)
+
+	sme, ok := err.(*gcsx.SizeMismatchError)
+	AssertTrue(ok, "Unexpected error: %v", err)
+	ExpectEq(fileInodeName, sme.Name)
+	ExpectEq(4, sme.Expected)
+	ExpectEq(3, sme.Actual)
+}
+
+func (t *FileTest) Read_SourceLongerThanExpected() {
+	AssertEq("taco", t.initialContents)
+
+	t.bucket = &fixedContentsBucket{Bucket: t.bucket, contents: "tacos"}
+	t.createInode()
+
+	_, err := t.in.Read(t.ctx, make([]byte, 4), 0)
+
+	sme, ok := err.(*gcsx.SizeMismatchError)
+	AssertTrue(ok, "Unexpected error: %v", err)
+	ExpectEq(fileInodeName, sme.Name)
+	ExpectEq(4, sme.Expected)
+	ExpectEq(5, sme.Actual)
+}
+
+func (t *FileTest) VerifiedPrefix_AccumulatesOverAdjacentRanges() {
+	var err error
+	table := crc32.MakeTable(crc32.Castagnoli)
+
+	// Set up a larger object, faulted in 100-byte blocks.
+	contents := make([]byte, 1000)
+	for i := range contents {
+		contents[i] = byte(i * 3)
+	}
+
+	t.backingObj, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		fileInodeName,
+		contents)
+
+	AssertEq(nil, err)
+
+	t.cfg.FaultInBlockSize = 100
+	t.createInode()
+
+	buf := make([]byte, 10)
+
+	// Nothing is verified initially.
+	length, crc := t.in.VerifiedPrefix()
+	ExpectEq(0, length)
+	ExpectEq(0, crc)
+
+	// Reading a block that's not at the start doesn't extend the prefix.
+	_, err = t.in.Read(t.ctx, buf, 150)
+	AssertEq(nil, err)
+
+	length, _ = t.in.VerifiedPrefix()
+	ExpectEq(0, length)
+
+	// Reading the first block joins up with the one already present.
+	_, err = t.in.Read(t.ctx, buf, 50)
+	AssertEq(nil, err)
+
+	length, crc = t.in.VerifiedPrefix()
+	ExpectEq(200, length)
+	ExpectEq(crc32.Checksum(contents[:200], table), crc)
+
+	// And the next adjacent block extends it further.
+	_, err = t.in.Read(t.ctx, buf, 250)
+	AssertEq(nil, err)
+
+	length, crc = t.in.VerifiedPrefix()
+	ExpectEq(300, length)
+	ExpectEq(crc32.Checksum(contents[:300], table), crc)
+
+	// Reading everything should give the object's checksum.
+	for offset := int64(300); offset < 1000; offset += 100 {
+		_, err = t.in.Read(t.ctx, buf, offset)
+		AssertEq(nil, err)
+	}
+
+	length, crc = t.in.VerifiedPrefix()
+	ExpectEq(1000, length)
+	ExpectEq(t.backingObj.CRC32C, crc)
+}
+
+func (t *FileTest) VerifiedPrefix_StopsAtModification() {
+	var err error
+	table := crc32.MakeTable(crc32.Castagnoli)
+
+	AssertEq("taco", t.initialContents)
+
+	// Modify the middle of the file, then fault in all of it.
+	err = t.in.Write(t.ctx, []byte("i"), 2)
+	AssertEq(nil, err)
+
+	buf := make([]byte, 4)
+	_, err = t.in.Read(t.ctx, buf, 0)
+	AssertEq(nil, err)
+	ExpectEq("taio", string(buf))
+
+	// Only the unmodified prefix should be covered.
+	length, crc := t.in.VerifiedPrefix()
+	ExpectEq(2, length)
+	ExpectEq(crc32.Checksum([]byte("ta"), table), crc)
+}
+
+func (t *FileTest) VerifiedPrefix_ClippedByLaterModification() {
+	var err error
+	table := crc32.MakeTable(crc32.Castagnoli)
+
+	AssertEq("taco", t.initialContents)
+
+	// Fault in the whole file.
+	buf := make([]byte, 4)
+	_, err = t.in.Read(t.ctx, buf, 0)
+	AssertEq(nil, err)
+
+	length, crc := t.in.VerifiedPrefix()
+	ExpectEq(4, length)
+	ExpectEq(crc32.Checksum([]byte("taco"), table), crc)
+
+	// Modifying the middle should clip the prefix.
+	err = t.in.Write(t.ctx, []byte("i"), 2)
+	AssertEq(nil, err)
+
+	length, crc = t.in.VerifiedPrefix()
+	ExpectEq(2, length)
+	ExpectEq(crc32.Checksum([]byte("ta"), table), crc)
+
+	// So should truncating.
+	err = t.in.Truncate(t.ctx, 1)
+	AssertEq(nil, err)
+
+	length, crc = t.in.VerifiedPrefix()
+	ExpectEq(1, length)
+	ExpectEq(crc32.Checksum([]byte("t"), table), crc)
+}
+
+func (t *FileTest) Read_ChecksumMismatch() {
+	AssertEq("taco", t.initialContents)
+
+	t.bucket = &fixedContentsBucket{Bucket: t.bucket, contents: "tacp"}
+	t.createInode()
+
+	_, err := t.in.Read(t.ctx, make([]byte, 4), 0)
+
+	cme, ok := err.(*gcsx.ChecksumMismatchError)
+	AssertTrue(ok, "Unexpected error: %v", err)
+	ExpectEq(fileInodeName, cme.Name)
+	ExpectEq(t.backingObj.CRC32C, cme.Expected)
+}
+
+func (t *FileTest) Write() {
+	var err error
+
+	AssertEq("taco", t.initialContents)
+
+	// Overwite a byte.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	// Add some data at the end.
+	t.clock.AdvanceTime(time.Second)
+	writeTime := t.clock.Now()
+
+	err = t.in.Write(t.ctx, []byte("burrito"), 4)
+	AssertEq(nil, err)
+
+	t.clock.AdvanceTime(time.Second)
+
+	// Read back the content.
+	var buf [1024]byte
+	n, err := t.in.Read(t.ctx, buf[:], 0)
+
+	if err == io.EOF {
+		err = nil
+	}
+
+	AssertEq(nil, err)
+	ExpectEq("pacoburrito", string(buf[:n]))
+
+	// Check attributes.
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+
+	ExpectEq(len("pacoburrito"), attrs.Size)
+	ExpectThat(attrs.Mtime, timeutil.TimeEq(writeTime))
+}
+
+func (t *FileTest) ReadAtMore() {
+	AssertEq("taco", t.initialContents)
+
+	testCases := []struct {
+		offset   int64
+		size     int
+		expected string
+		atEOF    bool
+	}{
+		{0, 2, "ta", false},
+		{0, 4, "taco", true},
+		{0, 5, "taco", true},
+		{1, 2, "ac", false},
+		{2, 2, "co", true},
+		{3, 0, "", false},
+		{4, 0, "", true},
+		{5, 1, "", true},
+	}
+
+	for _, tc := range testCases {
+		desc := fmt.Sprintf("offset: %d, size: %d", tc.offset, tc.size)
+
+		buf := make([]byte, tc.size)
+		n, atEOF, err := t.in.ReadAtMore(t.ctx, buf, tc.offset)
+
+		AssertEq(nil, err, "%s", desc)
+		ExpectEq(tc.expected, string(buf[:n]), "%s", desc)
+		ExpectEq(tc.atEOF, atEOF, "%s", desc)
+	}
+}
+
+func (t *FileTest) WriteToEndOfObjectThenReadAtMore() {
+	var err error
+
+	// Extend the object.
+	err = t.in.Write(t.ctx, []byte("burrito"), 4)
+	AssertEq(nil, err)
+
+	// A read of the old contents shouldn't claim to be at the end any more.
+	buf := make([]byte, 4)
+	n, atEOF, err := t.in.ReadAtMore(t.ctx, buf, 0)
+
+	AssertEq(nil, err)
+	ExpectEq("taco", string(buf[:n]))
+	ExpectFalse(atEOF)
+
+	// Reading the new end should.
+	buf = make([]byte, 1024)
+	n, atEOF, err = t.in.ReadAtMore(t.ctx, buf, 4)
+
+	AssertEq(nil, err)
+	ExpectEq("burrito", string(buf[:n]))
+	ExpectTrue(atEOF)
+
+	// As should an exact read of the whole thing.
+	buf = make([]byte, len("tacoburrito"))
+	n, atEOF, err = t.in.ReadAtMore(t.ctx, buf, 0)
+
+	AssertEq(nil, err)
+	ExpectEq("tacoburrito", string(buf[:n]))
+	ExpectTrue(atEOF)
+}
+
+func (t *FileTest) ReadAtWithGeneration_Clean() {
+	buf := make([]byte, 4)
+	n, gen, err := t.in.ReadAtWithGeneration(t.ctx, buf, 0)
+
+	AssertEq(nil, err)
+	ExpectEq("taco", string(buf[:n]))
+	ExpectEq(t.backingObj.Generation, gen)
+}
+
+func (t *FileTest) ReadAtWithGeneration_AfterSync() {
+	var err error
+
+	err = t.in.Write(t.ctx, []byte("burrito"), 4)
+	AssertEq(nil, err)
+
+	// Before syncing, the reported generation is the one we're anchored to.
+	buf := make([]byte, len("tacoburrito"))
+	n, gen, err := t.in.ReadAtWithGeneration(t.ctx, buf, 0)
+
+	AssertEq(nil, err)
+	ExpectEq("tacoburrito", string(buf[:n]))
+	ExpectEq(t.backingObj.Generation, gen)
+
+	// After syncing, it's the new generation.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	o, err := t.bucket.StatObject(
+		t.ctx,
+		&gcs.StatObjectRequest{Name: t.in.Name()})
+	AssertEq(nil, err)
+
+	n, gen, err = t.in.ReadAtWithGeneration(t.ctx, buf, 0)
+
+	AssertEq(nil, err)
+	ExpectEq("tacoburrito", string(buf[:n]))
+	ExpectEq(o.Generation, gen)
+	ExpectNe(t.backingObj.Generation, gen)
+}
+
+func (t *FileTest) ReadAtCacheStatus() {
+	var err error
+
+	// Watch the requests made to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+	t.createInode()
+
+	// The first read must fetch from GCS.
+	buf := make([]byte, 2)
+	n, hit, err := t.in.ReadAtCacheStatus(t.ctx, buf, 1)
+
+	AssertEq(nil, err)
+	ExpectEq("ac", string(buf[:n]))
+	ExpectFalse(hit)
+	ExpectEq(1, len(bucket.reads))
+
+	// Reading the same range again needn't.
+	n, hit, err = t.in.ReadAtCacheStatus(t.ctx, buf, 1)
+
+	AssertEq(nil, err)
+	ExpectEq("ac", string(buf[:n]))
+	ExpectTrue(hit)
+	ExpectEq(1, len(bucket.reads))
+
+	// Nor should reading a range we've written.
+	err = t.in.Write(t.ctx, []byte("burrito"), 4)
+	AssertEq(nil, err)
+
+	buf = make([]byte, 7)
+	n, hit, err = t.in.ReadAtCacheStatus(t.ctx, buf, 4)
+
+	AssertEq(nil, err)
+	ExpectEq("burrito", string(buf[:n]))
+	ExpectTrue(hit)
+	ExpectEq(1, len(bucket.reads))
+}
+
+func (t *FileTest) DirtyRanges() {
+	var err error
+
+	// Initially clean.
+	ExpectThat(t.in.DirtyRanges(), ElementsAre())
+
+	// A single write gives a single range.
+	err = t.in.Write(t.ctx, []byte("x"), 1)
+	AssertEq(nil, err)
+
+	ExpectThat(t.in.DirtyRanges(), DeepEquals([]gcs.ByteRange{
+		{Start: 1, Limit: 2},
+	}))
+
+	// An adjacent write is merged with it, and a separate one isn't.
+	err = t.in.Write(t.ctx, []byte("y"), 2)
+	AssertEq(nil, err)
+
+	err = t.in.Write(t.ctx, []byte("burrito"), 5)
+	AssertEq(nil, err)
+
+	ExpectThat(t.in.DirtyRanges(), DeepEquals([]gcs.ByteRange{
+		{Start: 1, Limit: 3},
+		{Start: 4, Limit: 12},
+	}))
+
+	// Syncing clears them.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	ExpectThat(t.in.DirtyRanges(), ElementsAre())
+}
+
+func (t *FileTest) Truncate() {
+	var attrs fuseops.InodeAttributes
+	var err error
+
+	AssertEq("taco", t.initialContents)
+
+	// Truncate downward.
+	t.clock.AdvanceTime(time.Second)
+	truncateTime := t.clock.Now()
+
+	err = t.in.Truncate(t.ctx, 2)
+	AssertEq(nil, err)
+
+	t.clock.AdvanceTime(time.Second)
+
+	// Read the contents.
+	var buf [1024]byte
+	n, err := t.in.Read(t.ctx, buf[:], 0)
+
+	if err == io.EOF {
+		err = nil
+	}
+
+	AssertEq(nil, err)
+	ExpectEq("ta", string(buf[:n]))
+
+	// Check attributes.
+	attrs, err = t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+
+	ExpectEq(len("ta"), attrs.Size)
+	ExpectThat(attrs.Mtime, timeutil.TimeEq(truncateTime))
+}
+
+func (t *FileTest) Size() {
+	var err error
+
+	// Watch the requests made to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+	t.createInode()
+
+	// Initially, the size is that of the source object.
+	size, err := t.in.Size()
+	AssertEq(nil, err)
+	ExpectEq(len(t.initialContents), size)
+
+	// Afterward, it reflects local modifications.
+	err = t.in.Write(t.ctx, []byte("burrito"), 4)
+	AssertEq(nil, err)
+
+	err = t.in.Truncate(t.ctx, 9)
+	AssertEq(nil, err)
+
+	reads := len(bucket.reads)
+	size, err = t.in.Size()
+	AssertEq(nil, err)
+	ExpectEq(len("tacoburri"), size)
+
+	// None of that needed a stat, or any further reads.
+	ExpectEq(0, len(bucket.stats))
+	ExpectEq(reads, len(bucket.reads))
+
+	// It agrees with Attributes, which does stat the object.
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(size, attrs.Size)
+	ExpectEq(1, len(bucket.stats))
+}
+
+func (t *FileTest) RemainingAt() {
+	var err error
+
+	// Watch the requests made to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+	t.createInode()
+
+	check := func(size int64) {
+		offsets := []int64{0, 1, size - 1, size, size + 1, size + 100}
+		for _, offset := range offsets {
+			if offset < 0 {
+				continue
+			}
+
+			n, err := t.in.RemainingAt(offset)
+			AssertEq(nil, err)
+
+			if offset < size {
+				ExpectEq(size-offset, n, "offset: %d", offset)
+			} else {
+				ExpectEq(0, n, "offset: %d", offset)
+			}
+		}
+	}
+
+	// Initially, the count is based on the source object.
+	check(int64(len(t.initialContents)))
+
+	// It reflects writes...
+	err = t.in.Write(t.ctx, []byte("burrito"), 4)
+	AssertEq(nil, err)
+
+	check(int64(len("tacoburrito")))
+
+	// ...and truncations, both shrinking and growing.
+	err = t.in.Truncate(t.ctx, 2)
+	AssertEq(nil, err)
+
+	check(2)
+
+	err = t.in.Truncate(t.ctx, 17)
+	AssertEq(nil, err)
+
+	check(17)
+
+	err = t.in.Truncate(t.ctx, 0)
+	AssertEq(nil, err)
+
+	check(0)
+
+	// It agrees with Attributes.
+	err = t.in.Write(t.ctx, []byte("enchilada"), 3)
+	AssertEq(nil, err)
+
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	check(int64(attrs.Size))
+
+	// Only Attributes needed a stat.
+	ExpectEq(1, len(bucket.stats))
+}
+
+func (t *FileTest) Sync_SkipIdenticalUploads_Identical() {
+	var err error
+
+	// Watch the requests made to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+	t.cfg.SkipIdenticalUploads = true
+	t.createInode()
+
+	// Overwrite the contents with themselves.
+	err = t.in.Write(t.ctx, []byte("taco"), 0)
+	AssertEq(nil, err)
+
+	// Sync. Nothing should be uploaded, and the inode should be clean with the
+	// existing generation as its source.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	ExpectEq(0, len(bucket.creates))
+	ExpectEq(0, len(bucket.composes))
+	ExpectEq(t.backingObj.Generation, t.in.SourceGeneration().Object)
+
+	plan, err := t.in.DrySync()
+	AssertEq(nil, err)
+	ExpectTrue(plan.NoOp)
+}
+
+func (t *FileTest) Sync_SkipIdenticalUploads_ReleasesContent() {
+	var err error
+
+	var stores []*closingStore
+	t.cfg.NewBackingStore = func() (bs gcsx.BackingStore, err error) {
+		s := &closingStore{BackingStore: gcsx.NewMemoryBackingStore()}
+		stores = append(stores, s)
+		bs = s
+		return
+	}
+
+	t.cfg.SkipIdenticalUploads = true
+	t.createInode()
+
+	// Overwrite the contents with themselves.
+	err = t.in.Write(t.ctx, []byte("taco"), 0)
+	AssertEq(nil, err)
+
+	// Sync. Though nothing is uploaded, the local contents should be released.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	AssertEq(1, len(stores))
+	ExpectTrue(stores[0].closed)
+}
+
+func (t *FileTest) Sync_SkipIdenticalUploads_Different() {
+	var err error
+
+	// Watch the requests made to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+	t.cfg.SkipIdenticalUploads = true
+	t.createInode()
+
+	// Change the contents without changing the size.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	// Sync. This time an upload is necessary.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	ExpectEq(1, len(bucket.creates))
+	ExpectNe(t.backingObj.Generation, t.in.SourceGeneration().Object)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq("paco", string(contents))
+}
+
+func (t *FileTest) Reanchor_Clean() {
+	var err error
+
+	// Fault in the current contents.
+	buf := make([]byte, 1024)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+	AssertEq("taco", string(buf[:n]))
+
+	// Someone else writes a new generation.
+	o, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		t.in.Name(),
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+
+	// Re-anchor to it.
+	err = t.in.Reanchor(t.ctx, o.Generation, int64(o.Size))
+	AssertEq(nil, err)
+
+	ExpectEq(o.Generation, t.in.SourceGeneration().Object)
+	ExpectEq(o.MetaGeneration, t.in.SourceGeneration().Metadata)
+
+	// The new contents should be served, rather than the cached ones.
+	n, err = t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq("burrito", string(buf[:n]))
+
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(len("burrito"), attrs.Size)
+	ExpectEq(1, attrs.Nlink)
+}
+
+func (t *FileTest) Reanchor_Dirty() {
+	var err error
+
+	// Fault in the contents and dirty the inode.
+	buf := make([]byte, 1024)
+	_, err = t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	// Someone else writes a new generation.
+	o, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		t.in.Name(),
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+
+	// Re-anchoring should be refused, leaving the inode alone.
+	err = t.in.Reanchor(t.ctx, o.Generation, int64(o.Size))
+	ExpectThat(err, Error(HasSubstr("unsynced")))
+
+	ExpectEq(t.backingObj.Generation, t.in.SourceGeneration().Object)
+
+	n, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq("paco", string(buf[:n]))
+}
+
+func (t *FileTest) Reanchor_WrongGeneration() {
+	var err error
+
+	o, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		t.in.Name(),
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+
+	// Neither a stale generation nor the wrong size should be accepted.
+	err = t.in.Reanchor(t.ctx, t.backingObj.Generation, int64(t.backingObj.Size))
+	ExpectThat(err, Error(HasSubstr("Live generation")))
+
+	err = t.in.Reanchor(t.ctx, o.Generation, 3)
+	ExpectThat(err, Error(HasSubstr("size")))
+
+	ExpectEq(t.backingObj.Generation, t.in.SourceGeneration().Object)
+}
+
+func (t *FileTest) WriteThenSync() {
+	var attrs fuseops.InodeAttributes
+	var err error
+
+	AssertEq("taco", t.initialContents)
+
+	// Overwite a byte.
+	t.clock.AdvanceTime(time.Second)
+	writeTime := t.clock.Now()
+
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	t.clock.AdvanceTime(time.Second)
+
+	// Sync.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	// The generation should have advanced.
+	ExpectLt(t.backingObj.Generation, t.in.SourceGeneration().Object)
+
+	// Stat the current object in the bucket.
+	statReq := &gcs.StatObjectRequest{Name: t.in.Name()}
+	o, err := t.bucket.StatObject(t.ctx, statReq)
+
+	AssertEq(nil, err)
+	ExpectEq(t.in.SourceGeneration().Object, o.Generation)
+	ExpectEq(t.in.SourceGeneration().Metadata, o.MetaGeneration)
+	ExpectEq(len("paco"), o.Size)
+	ExpectEq(
+		writeTime.UTC().Format(time.RFC3339Nano),
+		o.Metadata["gcsfuse_mtime"])
+
+	// Read the object's contents.
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+
+	AssertEq(nil, err)
+	ExpectEq("paco", string(contents))
+
+	// Check attributes.
+	attrs, err = t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+
+	ExpectEq(len("paco"), attrs.Size)
+	ExpectThat(attrs.Mtime, timeutil.TimeEq(writeTime.UTC()))
+}
+
+func (t *FileTest) AppendThenSync() {
+	var attrs fuseops.InodeAttributes
+	var err error
+
+	AssertEq("taco", t.initialContents)
+
+	// Append some data.
+	t.clock.AdvanceTime(time.Second)
+	writeTime := t.clock.Now()
+
+	err = t.in.Write(t.ctx, []byte("burrito"), int64(len("taco")))
+	AssertEq(nil, err)
+
+	t.clock.AdvanceTime(time.Second)
+
+	// Sync.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	// The generation should have advanced.
+	ExpectLt(t.backingObj.Generation, t.in.SourceGeneration().Object)
+
+	// Stat the current object in the bucket.
+	statReq := &gcs.StatObjectRequest{Name: t.in.Name()}
+	o, err := t.bucket.StatObject(t.ctx, statReq)
+
+	AssertEq(nil, err)
+	ExpectEq(t.in.SourceGeneration().Object, o.Generation)
+	ExpectEq(t.in.SourceGeneration().Metadata, o.MetaGeneration)
+	ExpectEq(len("tacoburrito"), o.Size)
+	ExpectEq(
+		writeTime.UTC().Format(time.RFC3339Nano),
+		o.Metadata["gcsfuse_mtime"])
+
+	// Read the object's contents.
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+
+	AssertEq(nil, err)
+	ExpectEq("tacoburrito", string(contents))
+
+	// Check attributes.
+	attrs, err = t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+
+	ExpectEq(len("tacoburrito"), attrs.Size)
+	ExpectThat(attrs.Mtime, timeutil.TimeEq(writeTime.UTC()))
+}
+
+func (t *FileTest) RepeatedAppendThenSync() {
+	var err error
+
+	// Watch the requests made to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+	t.createInode()
+
+	// Each cycle of appending and syncing should upload only the new tail and
+	// compose it onto the previous generation, without reading anything back.
+	expected := t.initialContents
+	for _, tail := range []string{"burrito", "enchilada"} {
+		bucket.creates = nil
+		bucket.composes = nil
+		prev := t.in.SourceGeneration()
+
+		err = t.in.Write(t.ctx, []byte(tail), int64(len(expected)))
+		AssertEq(nil, err)
+
+		err = t.in.Sync(t.ctx)
+		AssertEq(nil, err)
+
+		expected += tail
+
+		AssertEq(1, len(bucket.creates))
+		ExpectTrue(
+			strings.HasPrefix(bucket.creates[0], ".gcsfuse_tmp/"),
+			"Name: %s", bucket.creates[0])
+
+		AssertEq(1, len(bucket.composes))
+		req := bucket.composes[0]
+		ExpectEq(t.in.Name(), req.DstName)
+		ExpectThat(req.DstGenerationPrecondition, Pointee(Equals(prev.Object)))
+		AssertEq(2, len(req.Sources))
+		ExpectEq(prev.Object, req.Sources[0].Generation)
+
+		ExpectEq(0, len(bucket.reads))
+	}
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq(expected, string(contents))
+}
+
+func (t *FileTest) TruncateDownwardThenSync() {
+	var attrs fuseops.InodeAttributes
+	var err error
+
+	// Truncate downward.
+	t.clock.AdvanceTime(time.Second)
+	truncateTime := t.clock.Now()
+
+	err = t.in.Truncate(t.ctx, 2)
+	AssertEq(nil, err)
+
+	t.clock.AdvanceTime(time.Second)
+
+	// Sync.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	// The generation should have advanced.
+	ExpectLt(t.backingObj.Generation, t.in.SourceGeneration().Object)
+
+	// Stat the current object in the bucket.
+	statReq := &gcs.StatObjectRequest{Name: t.in.Name()}
+	o, err := t.bucket.StatObject(t.ctx, statReq)
+
+	AssertEq(nil, err)
+	ExpectEq(t.in.SourceGeneration().Object, o.Generation)
+	ExpectEq(t.in.SourceGeneration().Metadata, o.MetaGeneration)
+	ExpectEq(2, o.Size)
+	ExpectEq(
+		truncateTime.UTC().Format(time.RFC3339Nano),
+		o.Metadata["gcsfuse_mtime"])
+
+	// Check attributes.
+	attrs, err = t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+
+	ExpectEq(2, attrs.Size)
+	ExpectThat(attrs.Mtime, timeutil.TimeEq(truncateTime.UTC()))
+}
+
+func (t *FileTest) TruncateUpwardThenSync() {
+	var attrs fuseops.InodeAttributes
+	var err error
+
+	AssertEq(4, len(t.initialContents))
+
+	// Truncate upward.
+	t.clock.AdvanceTime(time.Second)
+	truncateTime := t.clock.Now()
+
+	err = t.in.Truncate(t.ctx, 6)
+	AssertEq(nil, err)
+
+	t.clock.AdvanceTime(time.Second)
+
+	// Sync.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	// The generation should have advanced.
+	ExpectLt(t.backingObj.Generation, t.in.SourceGeneration().Object)
+
+	// Stat the current object in the bucket.
+	statReq := &gcs.StatObjectRequest{Name: t.in.Name()}
+	o, err := t.bucket.StatObject(t.ctx, statReq)
+	ExpectEq(
+		truncateTime.UTC().Format(time.RFC3339Nano),
+		o.Metadata["gcsfuse_mtime"])
+
+	AssertEq(nil, err)
+	ExpectEq(t.in.SourceGeneration().Object, o.Generation)
+	ExpectEq(t.in.SourceGeneration().Metadata, o.MetaGeneration)
+	ExpectEq(6, o.Size)
+
+	// Check attributes.
+	attrs, err = t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+
+	ExpectEq(6, attrs.Size)
+	ExpectThat(attrs.Mtime, timeutil.TimeEq(truncateTime.UTC()))
+}
+
+func (t *FileTest) TruncateDownward_AfterPartialRead() {
+	var err error
+
+	AssertEq("taco", t.initialContents)
+
+	// Watch the requests made to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+	t.createInode()
+
+	// Grow, then fault in part of the source.
+	err = t.in.Truncate(t.ctx, 4+17)
+	AssertEq(nil, err)
+	ExpectEq(0, len(bucket.reads))
+
+	buf := make([]byte, 2)
+	n, err := t.in.Read(t.ctx, buf, 0)
+
+	AssertEq(nil, err)
+	ExpectEq("ta", string(buf[:n]))
+
+	// Shrink below the end of the source. This shouldn't read anything.
+	reads := len(bucket.reads)
+	err = t.in.Truncate(t.ctx, 3)
+
+	AssertEq(nil, err)
+	ExpectEq(reads, len(bucket.reads))
+
+	// The remaining contents should be intact, with nothing beyond the new end.
+	buf = make([]byte, 3)
+	n, err = t.in.Read(t.ctx, buf, 0)
+
+	AssertEq(nil, err)
+	ExpectEq("tac", string(buf[:n]))
+
+	attrs, err := t.in.Attributes(t.ctx)
+
+	AssertEq(nil, err)
+	ExpectEq(3, attrs.Size)
+}
+
+func (t *FileTest) TruncateUpward_DoesntReadSource() {
+	var err error
+
+	AssertEq("taco", t.initialContents)
+
+	// Watch the requests made to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+	t.createInode()
+
+	// Truncate upward.
+	err = t.in.Truncate(t.ctx, 4+17)
+	AssertEq(nil, err)
+
+	// Stat.
+	attrs, err := t.in.Attributes(t.ctx)
+
+	AssertEq(nil, err)
+	ExpectEq(4+17, attrs.Size)
+
+	// Read the extension, which should consist of zeroes.
+	buf := make([]byte, 17)
+	n, err := t.in.Read(t.ctx, buf, 4)
+
+	AssertEq(nil, err)
+	ExpectEq(17, n)
+	ExpectEq(string(make([]byte, 17)), string(buf))
+
+	// None of that needed the source object's contents.
+	ExpectEq(0, len(bucket.reads))
+
+	// Reading the original region does.
+	n, err = t.in.Read(t.ctx, buf[:4], 0)
+
+	AssertEq(nil, err)
+	ExpectEq("taco", string(buf[:n]))
+	ExpectEq(1, len(bucket.reads))
+}
+
+// Wait for the object in the bucket to have a generation other than the
+// backing object's, with t.in unlocked so that background work can proceed.
+// Return false if this doesn't happen within the timeout.
+func (t *FileTest) waitForNewGeneration(timeout time.Duration) bool {
+	t.in.Unlock()
+	defer t.in.Lock()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		o, err := t.bucket.StatObject(
+			t.ctx,
+			&gcs.StatObjectRequest{Name: t.in.Name()})
+
+		AssertEq(nil, err)
+		if o.Generation != t.backingObj.Generation {
+			return true
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	return false
+}
+
+// Return true if a goroutine is running background syncs for the inode.
+func autoSyncRunning(in *inode.FileInode) bool {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			frame := fmt.Sprintf("(*FileInode).autoSyncLoop(%p", in)
+			return strings.Contains(string(buf[:n]), frame)
+		}
+
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// Wait until autoSyncRunning returns the given value for the inode, returning
+// false if this doesn't happen within the timeout.
+func waitForAutoSync(
+	in *inode.FileInode,
+	running bool,
+	timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if autoSyncRunning(in) == running {
+			return true
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	return false
+}
+
+func (t *FileTest) AutoSync_IdleInterval() {
+	var err error
+
+	var clock gcsx.SimulatedClock
+	t.cfg.Clock = &clock
+	t.cfg.AutoSync = inode.AutoSyncConfig{
+		IdleInterval: time.Minute,
+		PollInterval: time.Millisecond,
+	}
+
+	t.createInode()
+
+	// Dirty the inode.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	// Nothing should happen until the idle interval has elapsed, according to
+	// the configured clock rather than the one used for mtimes.
+	t.clock.AdvanceTime(time.Hour)
+	clock.AdvanceTime(time.Minute - time.Second)
+	ExpectFalse(t.waitForNewGeneration(50 * time.Millisecond))
+
+	// Once it has, the contents should be flushed.
+	clock.AdvanceTime(time.Second)
+	AssertTrue(t.waitForNewGeneration(5 * time.Second))
+
+	ExpectLt(t.backingObj.Generation, t.in.SourceGeneration().Object)
+	ExpectTrue(t.in.SourceGenerationIsAuthoritative())
+	ExpectEq(nil, t.in.LastSyncError())
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq("paco", string(contents))
+
+	// A subsequent explicit sync shouldn't upload anything more.
+	gen := t.in.SourceGeneration()
+	err = t.in.Sync(t.ctx)
+
+	AssertEq(nil, err)
+	ExpectEq(0, gen.Compare(t.in.SourceGeneration()))
+}
+
+func (t *FileTest) AutoSync_SimulatedClock() {
+	var err error
+
+	// Poll according to a simulated clock, so that nothing happens unless the
+	// test says so.
+	var pollClock gcsx.SimulatedClock
+	t.cfg.Clock = &pollClock
+	t.cfg.AutoSync = inode.AutoSyncConfig{
+		IdleInterval: time.Minute,
+		PollInterval: time.Second,
+	}
+
+	t.createInode()
+
+	// Dirty the inode and let time pass for mtimes. The idle interval is
+	// measured by the poll clock too, so nothing should happen.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	t.clock.AdvanceTime(time.Hour)
+	ExpectFalse(t.waitForNewGeneration(50 * time.Millisecond))
+
+	// Advancing the poll clock past the idle interval should cause a flush.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+				pollClock.AdvanceTime(time.Second)
+			}
+		}
+	}()
+
+	AssertTrue(t.waitForNewGeneration(5 * time.Second))
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq("paco", string(contents))
+}
+
+func (t *FileTest) AutoSync_DirtyBytes() {
+	var err error
+
+	t.cfg.AutoSync = inode.AutoSyncConfig{
+		DirtyBytes: 10,
+	}
+
+	t.createInode()
+
+	// Write fewer bytes than the threshold. Nothing should happen, no matter
+	// how much time passes.
+	err = t.in.Write(t.ctx, []byte("burri"), 4)
+	AssertEq(nil, err)
+
+	t.clock.AdvanceTime(time.Hour)
+	ExpectFalse(t.waitForNewGeneration(50 * time.Millisecond))
+
+	// Crossing the threshold should cause a flush.
+	err = t.in.Write(t.ctx, []byte("to"), 9)
+	AssertEq(nil, err)
+
+	err = t.in.Write(t.ctx, []byte("!!!"), 11)
+	AssertEq(nil, err)
+
+	AssertTrue(t.waitForNewGeneration(5 * time.Second))
+	ExpectEq(nil, t.in.LastSyncError())
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq("tacoburrito!!!", string(contents))
+}
+
+func (t *FileTest) AutoSync_DirtyBytes_DestroyedBelowThreshold() {
+	var err error
+
+	t.cfg.AutoSync = inode.AutoSyncConfig{
+		DirtyBytes: 10,
+	}
+
+	t.createInode()
+
+	// Write fewer bytes than the threshold, starting the background goroutine.
+	err = t.in.Write(t.ctx, []byte("burri"), 4)
+	AssertEq(nil, err)
+	AssertTrue(waitForAutoSync(t.in, true, 5*time.Second))
+
+	// Destroying the inode should cause the goroutine to exit, without
+	// syncing.
+	err = t.in.Destroy()
+	AssertEq(nil, err)
+
+	t.in.Unlock()
+	exited := waitForAutoSync(t.in, false, 5*time.Second)
+	t.in.Lock()
+
+	ExpectTrue(exited)
+	ExpectFalse(t.waitForNewGeneration(50 * time.Millisecond))
+}
+
+func (t *FileTest) WriteThrough() {
+	var err error
+
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+
+	t.cfg.WriteThrough = true
+	t.createInode()
+
+	// Each write should be persisted before it returns, leaving the inode clean.
+	writes := []struct {
+		offset   int64
+		data     string
+		expected string
+	}{
+		{4, "burrito", "tacoburrito"},
+		{0, "p", "pacoburrito"},
+		{11, "!", "pacoburrito!"},
+	}
+
+	for i, w := range writes {
+		bucketWrites := len(bucket.creates) + len(bucket.composes)
+		prevGen := t.in.SourceGeneration().Object
+
+		err = t.in.Write(t.ctx, []byte(w.data), w.offset)
+		AssertEq(nil, err)
+
+		ExpectLt(bucketWrites, len(bucket.creates)+len(bucket.composes), "write %d", i)
+		ExpectNe(prevGen, t.in.SourceGeneration().Object, "write %d", i)
+		ExpectTrue(t.in.SourceGenerationIsAuthoritative(), "write %d", i)
+
+		contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+		AssertEq(nil, err)
+		ExpectEq(w.expected, string(contents))
+
+		o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: t.in.Name()})
+		AssertEq(nil, err)
+		ExpectEq(o.Generation, t.in.SourceGeneration().Object)
+	}
+}
+
+func (t *FileTest) WriteThrough_SyncFails() {
+	var err error
+
+	t.bucket = &sizeLyingBucket{Bucket: t.bucket}
+	t.cfg.WriteThrough = true
+	t.createInode()
+
+	// The write should report the failed sync.
+	err = t.in.Write(t.ctx, []byte("burrito"), 0)
+	ExpectThat(err, Error(HasSubstr("Sync")))
+	ExpectThat(err, Error(HasSubstr("SizeMismatchError")))
+
+	// But its data should still be there, waiting to be synced over the
+	// generation that was written.
+	ExpectNe(t.backingObj.Generation, t.in.SourceGeneration().Object)
+	ExpectFalse(t.in.SourceGenerationIsAuthoritative())
+
+	buf := make([]byte, 16)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq("burrito", string(buf[:n]))
+}
+
+func (t *FileTest) ReadOnly() {
+	var err error
+
+	t.cfg.ReadOnly = true
+	t.createInode()
+
+	// Reads should work as usual.
+	buf := make([]byte, 16)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq("taco", string(buf[:n]))
+
+	// But nothing that would modify the file.
+	mutations := map[string]func() error{
+		"Write":    func() error { return t.in.Write(t.ctx, []byte("burrito"), 0) },
+		"Truncate": func() error { return t.in.Truncate(t.ctx, 2) },
+		"SetMtime": func() error { return t.in.SetMtime(t.ctx, time.Now()) },
+		"Sync":     func() error { return t.in.Sync(t.ctx) },
+	}
+
+	for op, f := range mutations {
+		err = f()
+		roe, ok := err.(*gcsx.ReadOnlyError)
+		AssertTrue(ok, "%s: unexpected error: %v", op, err)
+		ExpectEq(op, roe.Op)
+		ExpectEq(t.in.Name(), roe.Name)
+	}
+
+	// The inode and the object should be untouched.
+	ExpectEq(0, len(t.in.DirtyRanges()))
+	ExpectEq(t.backingObj.Generation, t.in.SourceGeneration().Object)
+
+	attrs, err := t.in.Attributes(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(len("taco"), attrs.Size)
+
+	n, err = t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq("taco", string(buf[:n]))
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *FileTest) Sync_MinSyncInterval() {
+	var err error
+
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+
+	var clock gcsx.SimulatedClock
+	t.cfg.Clock = &clock
+	t.cfg.MinSyncInterval = time.Minute
+	t.createInode()
+
+	// The first sync uploads right away.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+	AssertEq(1, len(bucket.creates))
+
+	// Further syncs within the interval do nothing.
+	clock.AdvanceTime(30 * time.Second)
+
+	err = t.in.Write(t.ctx, []byte("b"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	err = t.in.Write(t.ctx, []byte("t"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	ExpectEq(1, len(bucket.creates))
+	ExpectFalse(t.in.SourceGenerationIsAuthoritative())
+
+	// Once the interval is up, the latest contents are uploaded in the
+	// background.
+	clock.AdvanceTime(30 * time.Second)
+
+	t.in.Unlock()
+	ctx, cancel := context.WithTimeout(t.ctx, 5*time.Second)
+	err = t.in.WaitClean(ctx)
+	cancel()
+	t.in.Lock()
+
+	AssertEq(nil, err)
+	ExpectEq(2, len(bucket.creates))
+	ExpectEq(nil, t.in.LastSyncError())
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *FileTest) Destroy_SyncDeferred() {
+	var err error
+
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+
+	var clock gcsx.SimulatedClock
+	t.cfg.Clock = &clock
+	t.cfg.MinSyncInterval = time.Minute
+	t.createInode()
+
+	// Sync once, then again within the interval so that the upload is
+	// deferred.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	clock.AdvanceTime(30 * time.Second)
+
+	err = t.in.Write(t.ctx, []byte("b"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+	AssertEq(1, len(bucket.creates))
+
+	// Destroying the inode before the interval is up should upload the
+	// contents rather than discarding them.
+	err = t.in.Destroy()
+	AssertEq(nil, err)
+	ExpectEq(2, len(bucket.creates))
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq("baco", string(contents))
+}
+
+func (t *FileTest) ForceSync_MinSyncInterval() {
+	var err error
+
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+
+	var clock gcsx.SimulatedClock
+	t.cfg.Clock = &clock
+	t.cfg.MinSyncInterval = time.Minute
+	t.createInode()
+
+	for _, b := range []string{"p", "b"} {
+		err = t.in.Write(t.ctx, []byte(b), 0)
+		AssertEq(nil, err)
+
+		err = t.in.ForceSync(t.ctx)
+		AssertEq(nil, err)
+	}
+
+	ExpectEq(2, len(bucket.creates))
+	ExpectTrue(t.in.SourceGenerationIsAuthoritative())
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq("baco", string(contents))
+}
+
+func (t *FileTest) Owner() {
+	var err error
+
+	t.backingObj.Owner = "user-taco@example.com"
+	t.createInode()
+
+	// Clean, including once the contents have been read.
+	owner, err := t.in.Owner()
+	AssertEq(nil, err)
+	ExpectEq("user-taco@example.com", owner)
+
+	buf := make([]byte, 4)
+	_, err = t.in.Read(t.ctx, buf, 0)
+	AssertEq(nil, err)
+
+	owner, err = t.in.Owner()
+	AssertEq(nil, err)
+	ExpectEq("user-taco@example.com", owner)
+
+	// Dirty.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	owner, err = t.in.Owner()
+	AssertEq(nil, err)
+	ExpectEq("", owner)
+}
+
+func (t *FileTest) Owner_Synced() {
+	var err error
+
+	// A bucket that reports an owner for the generations it creates.
+	t.bucket = &owningBucket{Bucket: t.bucket, owner: "user-burrito@example.com"}
+	t.backingObj.Owner = "user-taco@example.com"
+	t.createInode()
+
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	owner, err := t.in.Owner()
+	AssertEq(nil, err)
+	ExpectEq("user-burrito@example.com", owner)
+}
+
+func (t *FileTest) WaitClean_Clean() {
+	t.in.Unlock()
+	defer t.in.Lock()
+
+	err := t.in.WaitClean(t.ctx)
+	ExpectEq(nil, err)
+}
+
+func (t *FileTest) WaitClean_Dirty() {
+	var err error
+
+	// Dirty the inode, then start waiting.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	t.in.Unlock()
+	done := make(chan error, 1)
+	go func() { done <- t.in.WaitClean(t.ctx) }()
+
+	select {
+	case err = <-done:
+		AddFailure("WaitClean returned early: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Syncing should release the waiter.
+	t.in.Lock()
+	err = t.in.Sync(t.ctx)
+	t.in.Unlock()
+	AssertEq(nil, err)
+
+	select {
+	case err = <-done:
+		ExpectEq(nil, err)
+	case <-time.After(5 * time.Second):
+		AddFailure("Timed out waiting for WaitClean")
+	}
+
+	t.in.Lock()
+}
+
+func (t *FileTest) WaitClean_Cancelled() {
+	var err error
+
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	ctx, cancel := context.WithCancel(t.ctx)
+	cancel()
+
+	t.in.Unlock()
+	err = t.in.WaitClean(ctx)
+	t.in.Lock()
+
+	ExpectEq(context.Canceled, err)
+}
+
+func (t *FileTest) Version() {
+	var err error
+
+	// The initial version should be stable across reads, and across inodes for
+	// the same generation.
+	v0 := t.in.Version()
+
+	buf := make([]byte, 4)
+	_, err = t.in.Read(t.ctx, buf, 0)
+	AssertEq(nil, err)
+
+	ExpectEq(v0, t.in.Version())
+
+	t.createInode()
+	ExpectEq(v0, t.in.Version())
+
+	// Each write should produce a new version.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	v1 := t.in.Version()
+	ExpectNe(v0, v1)
+
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	v2 := t.in.Version()
+	ExpectNe(v0, v2)
+	ExpectNe(v1, v2)
+
+	// Reading doesn't change it.
+	_, err = t.in.Read(t.ctx, buf, 0)
+	AssertEq(nil, err)
+
+	ExpectEq(v2, t.in.Version())
+
+	// After syncing, the version should reflect the new generation, as it would
+	// for any other inode for that generation.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	v3 := t.in.Version()
+	ExpectNe(v0, v3)
+	ExpectNe(v2, v3)
+
+	t.backingObj = t.in.Source()
+	t.createInode()
+	ExpectEq(v3, t.in.Version())
+}
+
+// Create a locked file inode for a new object with the given name and
+// contents in the supplied bucket.
+func (t *FileTest) createOtherInode(
+	bucket gcs.Bucket,
+	name string,
+	contents string) (in *inode.FileInode) {
+	o, err := gcsutil.CreateObject(t.ctx, bucket, name, []byte(contents))
+	AssertEq(nil, err)
+
+	in = inode.NewFileInode(
+		fileInodeID+1,
+		o,
+		fuseops.InodeAttributes{},
+		bucket,
+		gcsx.NewSyncer(1, ".gcsfuse_tmp/", bucket),
+		"",
+		&t.clock,
+		inode.FileConfig{})
+
+	in.Lock()
+	return
+}
+
+func (t *FileTest) Sync_Idempotent_LostResponse() {
+	var err error
+
+	// The first upload lands, but its response is lost.
+	bucket := &lostResponseBucket{Bucket: t.bucket, lose: 1}
+	t.cfg.SyncRetries = 2
+
+	t.in.Unlock()
+	t.in = inode.NewFileInode(
+		fileInodeID,
+		t.backingObj,
+		fuseops.InodeAttributes{},
+		bucket,
+		gcsx.NewIdempotentSyncer(1, ".gcsfuse_tmp/", bucket),
+		"",
+		&t.clock,
+		t.cfg)
+
+	t.in.Lock()
+
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	// The sync should recognize its own write rather than retrying, so that
+	// only one new generation (and one notification) results.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(1, bucket.creates)
+
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: t.in.Name()})
+	AssertEq(nil, err)
+	ExpectEq(o.Generation, t.in.SourceGeneration().Object)
+	ExpectNe("", o.Metadata[gcsx.SyncTokenMetadataKey])
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq("paco", string(contents))
+}
+
+func (t *FileTest) Sync_NotIdempotent_LostResponse() {
+	var err error
+
+	// As above, but with the default syncer.
+	bucket := &lostResponseBucket{Bucket: t.bucket, lose: 1}
+	t.bucket = bucket
+	t.cfg.SyncRetries = 2
+	t.createInode()
+
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	// The retry tries to create the object again, and finds it clobbered.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(2, bucket.creates)
+	ExpectEq(t.backingObj.Generation, t.in.SourceGeneration().Object)
+}
+
+func (t *FileTest) CopyFileContents_Streaming() {
+	var err error
+
+	// Dirty the source, so that a server-side copy isn't possible.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Write(t.ctx, []byte("!"), 4)
+	AssertEq(nil, err)
+
+	dst := t.createOtherInode(t.bucket, "baz", "enchilada")
+	defer dst.Unlock()
+
+	// Copy.
+	serverSide, err := inode.CopyFileContents(t.ctx, dst, t.in)
+
+	AssertEq(nil, err)
+	ExpectFalse(serverSide)
+
+	// Sync and check the result.
+	err = dst.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "baz")
+	AssertEq(nil, err)
+	ExpectEq("paco!", string(contents))
+
+	// The source should be unaffected.
+	ExpectFalse(t.in.SourceGenerationIsAuthoritative())
+}
+
+func (t *FileTest) CopyFileContents_StreamingLarge() {
+	var err error
+
+	// Create a source object spanning several chunks.
+	contents := make([]byte, 3<<20+17)
+	for i := range contents {
+		contents[i] = byte(i * 7)
+	}
+
+	t.backingObj, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		fileInodeName,
+		contents)
+
+	AssertEq(nil, err)
+	t.createInode()
+
+	// Copy into an inode in another bucket.
+	otherBucket := gcsfake.NewFakeBucket(&t.clock, "other_bucket")
+	dst := t.createOtherInode(otherBucket, "baz", "enchilada")
+	defer dst.Unlock()
+
+	serverSide, err := inode.CopyFileContents(t.ctx, dst, t.in)
+
+	AssertEq(nil, err)
+	ExpectFalse(serverSide)
+
+	// Sync and check the result.
+	err = dst.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	actual, err := gcsutil.ReadObject(t.ctx, otherBucket, "baz")
+	AssertEq(nil, err)
+	ExpectTrue(string(contents) == string(actual))
+}
+
+func (t *FileTest) CopyFileContents_ServerSide_DestinationClobbered() {
+	var err error
+
+	dst := t.createOtherInode(t.bucket, "baz", "enchilada")
+	defer dst.Unlock()
+
+	// Overwrite the destination's object behind its back.
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, "baz", []byte("queso"))
+	AssertEq(nil, err)
+
+	// The copy should be treated as if the destination had been unlinked,
+	// leaving the other writer's contents alone.
+	serverSide, err := inode.CopyFileContents(t.ctx, dst, t.in)
+
+	AssertEq(nil, err)
+	ExpectTrue(serverSide)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "baz")
+	AssertEq(nil, err)
+	ExpectEq("queso", string(contents))
+}
+
+func (t *FileTest) CopyFileContents_ServerSide() {
+	var err error
+
+	// Watch the requests made to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+	t.createInode()
+
+	dst := t.createOtherInode(t.bucket, "baz", "enchilada")
+	defer dst.Unlock()
+
+	// Dirty the destination; the copy should overwrite that.
+	err = dst.Write(t.ctx, []byte("burrito"), 0)
+	AssertEq(nil, err)
+
+	// Copy. The source is clean, so this should happen without reading it.
+	serverSide, err := inode.CopyFileContents(t.ctx, dst, t.in)
+
+	AssertEq(nil, err)
+	ExpectTrue(serverSide)
+	ExpectEq(0, len(bucket.reads))
+
+	// The destination should now be clean, and backed by the copy.
+	ExpectTrue(dst.SourceGenerationIsAuthoritative())
+
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "baz"})
+	AssertEq(nil, err)
+	ExpectEq(o.Generation, dst.SourceGeneration().Object)
+	ExpectEq(o.MetaGeneration, dst.SourceGeneration().Metadata)
+
+	// Syncing does nothing further.
+	err = dst.Sync(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(o.Generation, dst.SourceGeneration().Object)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "baz")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *FileTest) CopyFileContents_Transformed() {
+	var err error
+	xt := xorTransform{key: 0x5a}
+	encode := func(s string) string {
+		r, err := xt.Encode(strings.NewReader(s))
+		AssertEq(nil, err)
+		b, err := ioutil.ReadAll(r)
+		AssertEq(nil, err)
+		return string(b)
+	}
+
+	// A clean source whose object holds encoded contents.
+	t.backingObj, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		fileInodeName,
+		[]byte(encode("taco")))
+
+	AssertEq(nil, err)
+
+	t.cfg.Transform = xt
+	t.createInode()
+
+	dst := t.createOtherInode(t.bucket, "baz", "enchilada")
+	defer dst.Unlock()
+
+	// The encoded bytes mean nothing to the destination, so the contents must
+	// be streamed across.
+	serverSide, err := inode.CopyFileContents(t.ctx, dst, t.in)
+
+	AssertEq(nil, err)
+	ExpectFalse(serverSide)
+
+	err = dst.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "baz")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *FileTest) CopyFileContents_ReadOnly() {
+	var err error
+
+	t.cfg.ReadOnly = true
+	t.createInode()
+
+	src := t.createOtherInode(t.bucket, "baz", "enchilada")
+	defer src.Unlock()
+
+	// The copy should be refused, even though it could be made server-side.
+	serverSide, err := inode.CopyFileContents(t.ctx, t.in, src)
+
+	roe, ok := err.(*gcsx.ReadOnlyError)
+	AssertTrue(ok, "unexpected error: %v", err)
+	ExpectEq("CopyFileContents", roe.Op)
+	ExpectEq(t.in.Name(), roe.Name)
+	ExpectFalse(serverSide)
+
+	// The object should be untouched.
+	ExpectEq(t.backingObj.Generation, t.in.SourceGeneration().Object)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *FileTest) Sync_CacheControl() {
+	var err error
+
+	const cacheControl = "no-cache"
+	t.cfg.CacheControl = cacheControl
+	t.createInode()
+
+	// Sync a modification. The new generation should carry the header.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: t.in.Name()})
+	AssertEq(nil, err)
+	ExpectEq(cacheControl, o.CacheControl)
+
+	// An inode without a configured header preserves it, including when
+	// appending.
+	t.backingObj = o
+	t.cfg.CacheControl = ""
+	t.createInode()
+
+	err = t.in.Write(t.ctx, []byte("s"), 4)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	o, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: t.in.Name()})
+	AssertEq(nil, err)
+	ExpectNe(t.backingObj.Generation, o.Generation)
+	ExpectEq(cacheControl, o.CacheControl)
+}
+
+func (t *FileTest) Sync_PreservesCreator() {
+	var err error
+
+	// Start with an object created by another mount.
+	t.backingObj, err = t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     fileInodeName,
+			Contents: strings.NewReader("taco"),
+			Metadata: map[string]string{
+				gcsx.CreatorMetadataKey: "original-host",
+			},
+		})
+
+	AssertEq(nil, err)
+
+	t.cfg.Creator = "other-host"
+	t.createInode()
+
+	// Rewrite it. The creator should be preserved, not replaced.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: t.in.Name()})
+	AssertEq(nil, err)
+	ExpectNe(t.backingObj.Generation, o.Generation)
+	ExpectEq("original-host", o.Metadata[gcsx.CreatorMetadataKey])
+}
+
+func (t *FileTest) Sync_Labels() {
+	var err error
+
+	// Start with an object that already has some labels, along with other
+	// metadata that is preserved.
+	t.backingObj, err = t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     fileInodeName,
+			Contents: strings.NewReader("taco"),
+			Metadata: map[string]string{
+				gcsx.CreatorMetadataKey:           "some-host",
+				gcsx.LabelMetadataPrefix + "team": "burrito",
+				gcsx.LabelMetadataPrefix + "env":  "dev",
+			},
+		})
+
+	AssertEq(nil, err)
+
+	t.cfg.Labels = map[string]string{"env": "prod", "cost": "enchilada"}
+	t.createInode()
+
+	// Sync a modification. Configured labels should be merged with the source's.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: t.in.Name()})
+	AssertEq(nil, err)
+	ExpectEq("burrito", o.Metadata[gcsx.LabelMetadataPrefix+"team"])
+	ExpectEq("prod", o.Metadata[gcsx.LabelMetadataPrefix+"env"])
+	ExpectEq("enchilada", o.Metadata[gcsx.LabelMetadataPrefix+"cost"])
+	ExpectEq("some-host", o.Metadata[gcsx.CreatorMetadataKey])
+	ExpectNe("", o.Metadata[gcsx.MtimeMetadataKey])
+
+	// An inode without configured labels preserves them, including when
+	// appending.
+	t.backingObj = o
+	t.cfg.Labels = nil
+	t.createInode()
+
+	err = t.in.Write(t.ctx, []byte("s"), 4)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+
+	o, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: t.in.Name()})
+	AssertEq(nil, err)
+	ExpectNe(t.backingObj.Generation, o.Generation)
+	ExpectEq("burrito", o.Metadata[gcsx.LabelMetadataPrefix+"team"])
+	ExpectEq("prod", o.Metadata[gcsx.LabelMetadataPrefix+"env"])
+	ExpectEq("enchilada", o.Metadata[gcsx.LabelMetadataPrefix+"cost"])
+}
+
+func (t *FileTest) DrySync_Clean() {
+	// Before any content has been faulted in.
+	plan, err := t.in.DrySync()
+
+	AssertEq(nil, err)
+	ExpectTrue(plan.NoOp)
+
+	// After reading.
+	buf := make([]byte, 4)
+	_, err = t.in.Read(t.ctx, buf, 0)
+	AssertEq(nil, err)
+
+	plan, err = t.in.DrySync()
+
+	AssertEq(nil, err)
+	ExpectTrue(plan.NoOp)
+}
+
+func (t *FileTest) DrySync_Dirty() {
+	var err error
+
+	// Watch the requests made to the bucket.
+	bucket := &recordingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+	t.createInode()
+
+	err = t.in.Write(t.ctx, []byte("burrito"), 1)
+	AssertEq(nil, err)
+
+	plan, err := t.in.DrySync()
+
+	AssertEq(nil, err)
+	ExpectFalse(plan.NoOp)
+	ExpectEq(t.backingObj.Generation, plan.GenerationPrecondition)
+	ExpectEq(len("tburrito"), plan.Bytes)
+
+	// Nothing should have been read or written.
+	ExpectEq(0, len(bucket.reads))
+	ExpectEq(t.backingObj.Generation, t.in.SourceGeneration().Object)
+
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: t.in.Name()})
+	AssertEq(nil, err)
+	ExpectEq(t.backingObj.Generation, o.Generation)
+}
+
+func (t *FileTest) SyncCost_Clean() {
+	bytes, requests, err := t.in.SyncCost()
+
+	AssertEq(nil, err)
+	ExpectEq(0, bytes)
+	ExpectEq(0, requests)
+}
+
+func (t *FileTest) SyncCost_Full() {
+	var err error
+
+	// Overwrite a byte, so the whole object must be rewritten.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	bytes, requests, err := t.in.SyncCost()
+
+	AssertEq(nil, err)
+	ExpectEq(len(t.initialContents), bytes)
+	ExpectEq(1, requests)
+}
+
+func (t *FileTest) SyncCost_Append() {
+	var err error
+
+	// Append a little. This should be composed onto the existing object.
+	err = t.in.Write(t.ctx, []byte("burrito"), int64(len(t.initialContents)))
+	AssertEq(nil, err)
+
+	bytes, requests, err := t.in.SyncCost()
+
+	AssertEq(nil, err)
+	ExpectEq(len("burrito"), bytes)
+	ExpectLt(bytes, len(t.initialContents)+len("burrito"))
+	ExpectEq(3, requests)
+}
+
+func (t *FileTest) AbortSync() {
+	var err error
+
+	bucket := &blockingCreateBucket{
+		Bucket:  t.bucket,
+		started: make(chan struct{}),
+	}
+
+	t.bucket = bucket
+	t.createInode()
+
+	// Nothing to abort yet.
+	ExpectFalse(t.in.AbortSync())
+
+	// Dirty the inode, then start syncing it.
+	err = t.in.Write(t.ctx, []byte("p"), 0)
+	AssertEq(nil, err)
+
+	syncErr := make(chan error, 1)
+	go func() {
+		syncErr <- t.in.Sync(t.ctx)
+	}()
+
+	// Abort once the upload is under way.
+	<-bucket.started
+	ExpectTrue(t.in.AbortSync())
+
+	select {
+	case err = <-syncErr:
+		ExpectEq(context.Canceled, err)
+
+	case <-time.After(5 * time.Second):
+		AddFailure("Sync wasn't aborted")
+		AbortTest()
+	}
+
+	// The inode should still be dirty, and GCS unchanged.
+	ExpectEq(t.backingObj.Generation, t.in.SourceGeneration().Object)
+
+	plan, err := t.in.DrySync()
+	AssertEq(nil, err)
+	ExpectFalse(plan.NoOp)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+
+	// A later Sync should succeed.
+	ExpectFalse(t.in.AbortSync())
+
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+	ExpectNe(t.backingObj.Generation, t.in.SourceGeneration().Object)
+
+	contents, err = gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq("paco", string(contents))
+}
+
+func (t *FileTest) Sync_SizeMismatch() {
+	var err error
+
+	t.bucket = &sizeLyingBucket{Bucket: t.bucket}
+	t.createInode()
+
+	// Dirty the inode.
+	err = t.in.Write(t.ctx, []byte("burrito"), 0)
+	AssertEq(nil, err)
+
+	// Sync. GCS's report of the size shouldn't be believed.
+	err = t.in.Sync(t.ctx)
+
+	_, ok := err.(*gcsx.SizeMismatchError)
+	AssertTrue(ok, "Unexpected error: %v", err)
+
+	// The inode should still be dirty, but with the generation that was written
+	// as its source.
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: t.in.Name()})
+	AssertEq(nil, err)
+	ExpectNe(t.backingObj.Generation, o.Generation)
+	ExpectEq(o.Generation, t.in.SourceGeneration().Object)
+	ExpectFalse(t.in.SourceGenerationIsAuthoritative())
+
+	buf := make([]byte, 16)
+	n, err := t.in.Read(t.ctx, buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq("burrito", string(buf[:n]))
+}
+
+func (t *FileTest) Sync_TruncatedUpload_ThenSync() {
+	var err error
+
+	bucket := &truncatingBucket{Bucket: t.bucket, truncations: 2}
+	t.bucket = bucket
+	t.createInode()
+
+	// Dirty the inode, and fail to sync it even with the retry.
+	err = t.in.Write(t.ctx, []byte("burrito"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	_, ok := err.(*gcsx.TruncatedUploadError)
+	AssertTrue(ok, "Unexpected error: %v", err)
+
+	// The short generation should now be the source.
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: t.in.Name()})
+	AssertEq(nil, err)
+	ExpectEq(len("bur"), o.Size)
+	ExpectEq(o.Generation, t.in.SourceGeneration().Object)
+	ExpectFalse(t.in.SourceGenerationIsAuthoritative())
+
+	// Write some more, and sync again. The data should land.
+	err = t.in.Write(t.ctx, []byte("s"), 7)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
+	ExpectTrue(t.in.SourceGenerationIsAuthoritative())
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq("burritos", string(contents))
+}
 
-	t.clock.AdvanceTime(time.Second)
+func (t *FileTest) Sync_SizeMismatch_ThenSync() {
+	var err error
 
-	// Read the contents.
-	var buf [1024]byte
-	n, err := t.in.Read(t.ctx, buf[:], 0)
+	bucket := &sizeLyingBucket{Bucket: t.bucket}
+	t.bucket = bucket
+	t.createInode()
 
-	if err == io.EOF {
-		err = nil
-	}
+	// Dirty the inode, and fail to sync it.
+	err = t.in.Write(t.ctx, []byte("burrito"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	_, ok := err.(*gcsx.SizeMismatchError)
+	AssertTrue(ok, "Unexpected error: %v", err)
 
+	// Write some more, and sync again once GCS behaves.
+	err = t.in.Write(t.ctx, []byte("s"), 7)
 	AssertEq(nil, err)
-	ExpectEq("ta", string(buf[:n]))
 
-	// Check attributes.
-	attrs, err = t.in.Attributes(t.ctx)
+	bucket.honest = true
+	err = t.in.Sync(t.ctx)
 	AssertEq(nil, err)
 
-	ExpectEq(len("ta"), attrs.Size)
-	ExpectThat(attrs.Mtime, timeutil.TimeEq(truncateTime))
+	// The data should have landed, rather than the sync having been taken for
+	// a clobber.
+	ExpectTrue(t.in.SourceGenerationIsAuthoritative())
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectEq("burritos", string(contents))
 }
 
-func (t *FileTest) WriteThenSync() {
-	var attrs fuseops.InodeAttributes
+func (t *FileTest) Sync_MirrorFails_ThenSync() {
 	var err error
 
-	AssertEq("taco", t.initialContents)
+	primary := t.bucket
+	secondary := &flakyCreateBucket{
+		Bucket:   gcsfake.NewFakeBucket(&t.clock, "secondary"),
+		failures: 1,
+		err:      errors.New("taco"),
+	}
 
-	// Overwite a byte.
-	t.clock.AdvanceTime(time.Second)
-	writeTime := t.clock.Now()
+	t.bucket = gcsx.NewMirroringBucket(
+		gcsx.MirrorConfig{Synchronous: true},
+		primary,
+		secondary)
 
-	err = t.in.Write(t.ctx, []byte("p"), 0)
+	t.createInode()
+
+	// Dirty the inode, and sync it. Mirroring should fail after the primary has
+	// committed the new generation, which the inode should adopt.
+	err = t.in.Write(t.ctx, []byte("burrito"), 0)
 	AssertEq(nil, err)
 
-	t.clock.AdvanceTime(time.Second)
+	err = t.in.Sync(t.ctx)
+	_, ok := err.(*gcsx.MirrorError)
+	AssertTrue(ok, "Unexpected error: %v", err)
 
-	// Sync.
+	o, err := primary.StatObject(t.ctx, &gcs.StatObjectRequest{Name: t.in.Name()})
+	AssertEq(nil, err)
+	ExpectEq(o.Generation, t.in.SourceGeneration().Object)
+
+	// Syncing again should bring the secondary up to date, rather than being
+	// taken for a clobber.
 	err = t.in.Sync(t.ctx)
 	AssertEq(nil, err)
+	ExpectTrue(t.in.SourceGenerationIsAuthoritative())
 
-	// The generation should have advanced.
-	ExpectLt(t.backingObj.Generation, t.in.SourceGeneration().Object)
+	for _, b := range []gcs.Bucket{primary, secondary} {
+		contents, err := gcsutil.ReadObject(t.ctx, b, t.in.Name())
+		AssertEq(nil, err)
+		ExpectEq("burrito", string(contents))
+	}
+}
 
-	// Stat the current object in the bucket.
-	statReq := &gcs.StatObjectRequest{Name: t.in.Name()}
-	o, err := t.bucket.StatObject(t.ctx, statReq)
+func (t *FileTest) Sync_NeverWritten() {
+	var err error
 
-	AssertEq(nil, err)
-	ExpectEq(t.in.SourceGeneration().Object, o.Generation)
-	ExpectEq(t.in.SourceGeneration().Metadata, o.MetaGeneration)
-	ExpectEq(len("paco"), o.Size)
-	ExpectEq(
-		writeTime.UTC().Format(time.RFC3339Nano),
-		o.Metadata["gcsfuse_mtime"])
+	// Set up an empty backing object, as for a newly created file.
+	t.backingObj, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		fileInodeName,
+		[]byte{})
 
-	// Read the object's contents.
-	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	t.createInode()
 
+	// Syncing shouldn't create another empty object, with or without the
+	// contents having been faulted in.
+	err = t.in.Sync(t.ctx)
 	AssertEq(nil, err)
-	ExpectEq("paco", string(contents))
 
-	// Check attributes.
-	attrs, err = t.in.Attributes(t.ctx)
+	_, err = t.in.Read(t.ctx, make([]byte, 1), 0)
+	AssertEq(io.EOF, err)
+
+	err = t.in.Sync(t.ctx)
 	AssertEq(nil, err)
 
-	ExpectEq(len("paco"), attrs.Size)
-	ExpectThat(attrs.Mtime, timeutil.TimeEq(writeTime.UTC()))
+	ExpectEq(t.backingObj.Generation, t.in.SourceGeneration().Object)
+
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: t.in.Name()})
+	AssertEq(nil, err)
+	ExpectEq(t.backingObj.Generation, o.Generation)
 }
 
-func (t *FileTest) AppendThenSync() {
-	var attrs fuseops.InodeAttributes
+func (t *FileTest) Sync_Clobbered() {
 	var err error
 
-	AssertEq("taco", t.initialContents)
+	// Truncate downward.
+	err = t.in.Truncate(t.ctx, 2)
+	AssertEq(nil, err)
 
-	// Append some data.
-	t.clock.AdvanceTime(time.Second)
-	writeTime := t.clock.Now()
+	// Clobber the backing object.
+	newObj, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		t.in.Name(),
+		[]byte("burrito"))
 
-	err = t.in.Write(t.ctx, []byte("burrito"), int64(len("taco")))
 	AssertEq(nil, err)
 
-	t.clock.AdvanceTime(time.Second)
-
-	// Sync.
+	// Sync. The call should succeed, but nothing should change.
 	err = t.in.Sync(t.ctx)
-	AssertEq(nil, err)
 
-	// The generation should have advanced.
-	ExpectLt(t.backingObj.Generation, t.in.SourceGeneration().Object)
+	AssertEq(nil, err)
+	ExpectEq(t.backingObj.Generation, t.in.SourceGeneration().Object)
+	ExpectEq(t.backingObj.MetaGeneration, t.in.SourceGeneration().Metadata)
 
-	// Stat the current object in the bucket.
+	// The object in the bucket should not have been changed.
 	statReq := &gcs.StatObjectRequest{Name: t.in.Name()}
 	o, err := t.bucket.StatObject(t.ctx, statReq)
 
 	AssertEq(nil, err)
-	ExpectEq(t.in.SourceGeneration().Object, o.Generation)
-	ExpectEq(t.in.SourceGeneration().Metadata, o.MetaGeneration)
-	ExpectEq(len("tacoburrito"), o.Size)
-	ExpectEq(
-		writeTime.UTC().Format(time.RFC3339Nano),
-		o.Metadata["gcsfuse_mtime"])
-
-	// Read the object's contents.
-	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	ExpectEq(newObj.Generation, o.Generation)
+	ExpectEq(newObj.Size, o.Size)
+}
 
-	AssertEq(nil, err)
-	ExpectEq("tacoburrito", string(contents))
+type syncedGeneration struct {
+	name       string
+	generation int64
+}
 
-	// Check attributes.
-	attrs, err = t.in.Attributes(t.ctx)
-	AssertEq(nil, err)
+// Arrange for calls to OnSynced to be recorded in the returned slice.
+func (t *FileTest) recordSynced() *[]syncedGeneration {
+	synced := new([]syncedGeneration)
+	t.cfg.OnSynced = func(name string, generation int64) {
+		*synced = append(*synced, syncedGeneration{name, generation})
+	}
 
-	ExpectEq(len("tacoburrito"), attrs.Size)
-	ExpectThat(attrs.Mtime, timeutil.TimeEq(writeTime.UTC()))
+	t.createInode()
+	return synced
 }
 
-func (t *FileTest) TruncateDownwardThenSync() {
-	var attrs fuseops.InodeAttributes
+func (t *FileTest) Sync_OnSynced() {
 	var err error
+	synced := t.recordSynced()
 
-	// Truncate downward.
-	t.clock.AdvanceTime(time.Second)
-	truncateTime := t.clock.Now()
+	err = t.in.Write(t.ctx, []byte("burrito"), 4)
+	AssertEq(nil, err)
 
-	err = t.in.Truncate(t.ctx, 2)
+	err = t.in.Sync(t.ctx)
 	AssertEq(nil, err)
 
-	t.clock.AdvanceTime(time.Second)
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: t.in.Name()})
+	AssertEq(nil, err)
 
-	// Sync.
+	AssertEq(1, len(*synced))
+	ExpectEq(t.in.Name(), (*synced)[0].name)
+	ExpectEq(o.Generation, (*synced)[0].generation)
+	ExpectEq(t.in.SourceGeneration().Object, (*synced)[0].generation)
+
+	// Syncing again has nothing to upload.
 	err = t.in.Sync(t.ctx)
 	AssertEq(nil, err)
+	ExpectEq(1, len(*synced))
+}
 
-	// The generation should have advanced.
-	ExpectLt(t.backingObj.Generation, t.in.SourceGeneration().Object)
+func (t *FileTest) Sync_OnSynced_Clean() {
+	var err error
+	synced := t.recordSynced()
 
-	// Stat the current object in the bucket.
-	statReq := &gcs.StatObjectRequest{Name: t.in.Name()}
-	o, err := t.bucket.StatObject(t.ctx, statReq)
+	// Neither a pristine inode nor one whose contents have merely been read
+	// has anything to upload.
+	err = t.in.Sync(t.ctx)
+	AssertEq(nil, err)
 
+	buf := make([]byte, 4)
+	_, err = t.in.Read(t.ctx, buf, 0)
 	AssertEq(nil, err)
-	ExpectEq(t.in.SourceGeneration().Object, o.Generation)
-	ExpectEq(t.in.SourceGeneration().Metadata, o.MetaGeneration)
-	ExpectEq(2, o.Size)
-	ExpectEq(
-		truncateTime.UTC().Format(time.RFC3339Nano),
-		o.Metadata["gcsfuse_mtime"])
 
-	// Check attributes.
-	attrs, err = t.in.Attributes(t.ctx)
+	err = t.in.Sync(t.ctx)
 	AssertEq(nil, err)
 
-	ExpectEq(2, attrs.Size)
-	ExpectThat(attrs.Mtime, timeutil.TimeEq(truncateTime.UTC()))
+	ExpectEq(0, len(*synced))
 }
 
-func (t *FileTest) TruncateUpwardThenSync() {
-	var attrs fuseops.InodeAttributes
+func (t *FileTest) Sync_OnSynced_Failed() {
 	var err error
 
-	AssertEq(4, len(t.initialContents))
+	t.bucket = &sizeLyingBucket{Bucket: t.bucket}
+	synced := t.recordSynced()
 
-	// Truncate upward.
-	t.clock.AdvanceTime(time.Second)
-	truncateTime := t.clock.Now()
+	err = t.in.Write(t.ctx, []byte("burrito"), 0)
+	AssertEq(nil, err)
 
-	err = t.in.Truncate(t.ctx, 6)
+	err = t.in.Sync(t.ctx)
+	ExpectNe(nil, err)
+	ExpectEq(0, len(*synced))
+}
+
+func (t *FileTest) Sync_OnSynced_Clobbered() {
+	var err error
+	synced := t.recordSynced()
+
+	err = t.in.Truncate(t.ctx, 2)
 	AssertEq(nil, err)
 
-	t.clock.AdvanceTime(time.Second)
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, t.in.Name(), []byte("burrito"))
+	AssertEq(nil, err)
 
-	// Sync.
+	// The sync succeeds without writing anything.
 	err = t.in.Sync(t.ctx)
 	AssertEq(nil, err)
+	ExpectEq(0, len(*synced))
+}
 
-	// The generation should have advanced.
-	ExpectLt(t.backingObj.Generation, t.in.SourceGeneration().Object)
+func (t *FileTest) Sync_Retries() {
+	var err error
 
-	// Stat the current object in the bucket.
-	statReq := &gcs.StatObjectRequest{Name: t.in.Name()}
-	o, err := t.bucket.StatObject(t.ctx, statReq)
-	ExpectEq(
-		truncateTime.UTC().Format(time.RFC3339Nano),
-		o.Metadata["gcsfuse_mtime"])
+	// The first upload consumes its contents and then fails, as though the
+	// connection dropped at the end.
+	bucket := &flakyCreateBucket{
+		Bucket:   t.bucket,
+		failures: 1,
+		err:      &gcsx.TransientError{Err: errors.New("taco")},
+	}
 
+	t.bucket = bucket
+	t.cfg.SyncRetries = 2
+	t.createInode()
+
+	// Large enough to be streamed from the temp file, rather than read into
+	// memory first.
+	contents := strings.Repeat("burrito", 1<<14)
+	err = t.in.Write(t.ctx, []byte(contents), 0)
 	AssertEq(nil, err)
-	ExpectEq(t.in.SourceGeneration().Object, o.Generation)
-	ExpectEq(t.in.SourceGeneration().Metadata, o.MetaGeneration)
-	ExpectEq(6, o.Size)
 
-	// Check attributes.
-	attrs, err = t.in.Attributes(t.ctx)
+	err = t.in.Sync(t.ctx)
 	AssertEq(nil, err)
 
-	ExpectEq(6, attrs.Size)
-	ExpectThat(attrs.Mtime, timeutil.TimeEq(truncateTime.UTC()))
+	// Both attempts should have sent everything.
+	AssertEq(2, len(bucket.contents))
+	ExpectTrue(bucket.contents[0] == contents)
+	ExpectTrue(bucket.contents[1] == contents)
+
+	// And the new generation should have it all.
+	actual, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
+	AssertEq(nil, err)
+	ExpectTrue(string(actual) == contents)
+	ExpectEq(len(contents), t.in.Source().Size)
 }
 
-func (t *FileTest) Sync_Clobbered() {
+func (t *FileTest) Sync_RetriesExhausted() {
 	var err error
 
-	// Truncate downward.
-	err = t.in.Truncate(t.ctx, 2)
-	AssertEq(nil, err)
+	bucket := &flakyCreateBucket{
+		Bucket:   t.bucket,
+		failures: 10,
+		err:      &gcsx.TransientError{Err: errors.New("taco")},
+	}
 
-	// Clobber the backing object.
-	newObj, err := gcsutil.CreateObject(
-		t.ctx,
-		t.bucket,
-		t.in.Name(),
-		[]byte("burrito"))
+	t.bucket = bucket
+	t.cfg.SyncRetries = 2
+	t.createInode()
 
+	err = t.in.Write(t.ctx, []byte("burrito"), 0)
 	AssertEq(nil, err)
 
-	// Sync. The call should succeed, but nothing should change.
 	err = t.in.Sync(t.ctx)
+	ExpectThat(err, Error(HasSubstr("TransientError")))
+	ExpectEq(3, len(bucket.contents))
 
+	// The data should still be there, and a further sync should upload it in
+	// full once the bucket recovers.
+	bucket.failures = 0
+	err = t.in.Sync(t.ctx)
 	AssertEq(nil, err)
-	ExpectEq(t.backingObj.Generation, t.in.SourceGeneration().Object)
-	ExpectEq(t.backingObj.MetaGeneration, t.in.SourceGeneration().Metadata)
 
-	// The object in the bucket should not have been changed.
-	statReq := &gcs.StatObjectRequest{Name: t.in.Name()}
-	o, err := t.bucket.StatObject(t.ctx, statReq)
+	AssertEq(4, len(bucket.contents))
+	ExpectEq("burrito", bucket.contents[3])
 
+	actual, err := gcsutil.ReadObject(t.ctx, t.bucket, t.in.Name())
 	AssertEq(nil, err)
-	ExpectEq(newObj.Generation, o.Generation)
-	ExpectEq(newObj.Size, o.Size)
+	ExpectEq("burrito", string(actual))
+}
+
+func (t *FileTest) Sync_NoRetryForPermanentErrors() {
+	var err error
+
+	bucket := &flakyCreateBucket{
+		Bucket:   t.bucket,
+		failures: 1,
+		err:      errors.New("taco"),
+	}
+
+	t.bucket = bucket
+	t.cfg.SyncRetries = 2
+	t.createInode()
+
+	err = t.in.Write(t.ctx, []byte("burrito"), 0)
+	AssertEq(nil, err)
+
+	err = t.in.Sync(t.ctx)
+	ExpectThat(err, Error(HasSubstr("taco")))
+	ExpectEq(1, len(bucket.contents))
 }
 
 func (t *FileTest) SetMtime_ContentNotFaultedIn() {
@@ -635,3 +5209,87 @@ func (t *FileTest) SetMtime_SourceObjectMetaGenerationChanged() {
 	ExpectEq(newObj.Generation, o.Generation)
 	ExpectEq(newObj.MetaGeneration, o.MetaGeneration)
 }
+
+func (t *FileTest) DroppedWhileDirty_Dirty() {
+	reported := t.dropInode(func(in *inode.FileInode) {
+		err := in.Write(t.ctx, []byte("p"), 0)
+		AssertEq(nil, err)
+	})
+
+	ExpectTrue(reported)
+}
+
+func (t *FileTest) DroppedWhileDirty_Clean() {
+	reported := t.dropInode(func(in *inode.FileInode) {
+		buf := make([]byte, 4)
+		_, err := in.Read(t.ctx, buf, 0)
+		AssertEq(nil, err)
+	})
+
+	ExpectFalse(reported)
+}
+
+func (t *FileTest) DroppedWhileDirty_Synced() {
+	reported := t.dropInode(func(in *inode.FileInode) {
+		err := in.Write(t.ctx, []byte("p"), 0)
+		AssertEq(nil, err)
+
+		err = in.Sync(t.ctx)
+		AssertEq(nil, err)
+	})
+
+	ExpectFalse(reported)
+}
+
+func (t *FileTest) DroppedWhileDirty_Destroyed() {
+	reported := t.dropInode(func(in *inode.FileInode) {
+		err := in.Write(t.ctx, []byte("p"), 0)
+		AssertEq(nil, err)
+
+		err = in.Destroy()
+		AssertEq(nil, err)
+	})
+
+	ExpectFalse(reported)
+}
+
+func BenchmarkRead_FullyCached(b *testing.B) {
+	ctx := context.Background()
+	clock := timeutil.RealClock()
+	bucket := gcsfake.NewFakeBucket(clock, "some_bucket")
+
+	contents := bytes.Repeat([]byte("taco"), 1<<18)
+	o, err := gcsutil.CreateObject(ctx, bucket, fileInodeName, contents)
+	if err != nil {
+		b.Fatalf("CreateObject: %v", err)
+	}
+
+	in := inode.NewFileInode(
+		fileInodeID,
+		o,
+		fuseops.InodeAttributes{Mode: fileMode},
+		bucket,
+		gcsx.NewSyncer(1, ".gcsfuse_tmp/", bucket),
+		"",
+		clock,
+		inode.FileConfig{})
+
+	in.Lock()
+	defer in.Unlock()
+
+	// Fault everything in.
+	buf := make([]byte, 4096)
+	if _, err = in.Read(ctx, buf, 0); err != nil {
+		b.Fatalf("Read: %v", err)
+	}
+
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		offset := int64(i*len(buf)) % int64(len(contents))
+		if _, err = in.Read(ctx, buf, offset); err != nil {
+			b.Fatalf("Read: %v", err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// A helper struct that maintains the CRC32C checksum of a prefix of an
+// object's contents, extending it incrementally as more of the contents become
+// available. External synchronization is required.
+//
+// The zero value represents the empty prefix.
+type prefixCRC struct {
+	// The length of the prefix covered.
+	length int64
+
+	// The CRC32C checksum of the bytes [0, length).
+	crc uint32
+}
+
+// Extend the prefix to cover [0, limit), reading the additional bytes from r.
+// Does nothing if the prefix already extends that far.
+func (p *prefixCRC) extend(r io.ReaderAt, limit int64) (err error) {
+	const bufSize = 1 << 16
+	var buf []byte
+
+	for p.length < limit {
+		if buf == nil {
+			buf = make([]byte, bufSize)
+		}
+
+		chunk := buf
+		if remaining := limit - p.length; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+
+		var n int
+		n, err = r.ReadAt(chunk, p.length)
+		p.crc = crc32.Update(p.crc, crc32cTable, chunk[:n])
+		p.length += int64(n)
+
+		if err == io.EOF && p.length == limit {
+			err = nil
+		}
+
+		if err != nil {
+			err = fmt.Errorf("ReadAt: %v", err)
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,148 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// A set of live file inodes, for observing and bounding how much of their
+// contents they hold locally in aggregate, e.g. for a global memory guard.
+// Inodes join when created with FileConfig.Registry set, and leave when
+// destroyed.
+//
+// Safe for concurrent access.
+type Registry struct {
+	mu sync.Mutex
+
+	// The number of uses noted so far, for ordering inodes by recency.
+	//
+	// GUARDED_BY(mu)
+	ticks uint64
+
+	// The live inodes, each with the tick of its most recent use.
+	//
+	// GUARDED_BY(mu)
+	inodes map[*FileInode]uint64
+}
+
+// Create an empty registry.
+func NewRegistry() (r *Registry) {
+	r = &Registry{
+		inodes: make(map[*FileInode]uint64),
+	}
+
+	return
+}
+
+// Note that f is live and has just been used.
+//
+// LOCKS_REQUIRED(f.mu)
+func (r *Registry) touch(f *FileInode) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ticks++
+	r.inodes[f] = r.ticks
+}
+
+// LOCKS_REQUIRED(f.mu)
+func (r *Registry) remove(f *FileInode) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.inodes, f)
+}
+
+// Return the live inodes, least recently used first.
+func (r *Registry) leastRecentlyUsed() (inodes []*FileInode) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for f := range r.inodes {
+		inodes = append(inodes, f)
+	}
+
+	sort.Sort(byUse{inodes, r.inodes})
+	return
+}
+
+type byUse struct {
+	inodes []*FileInode
+	ticks  map[*FileInode]uint64
+}
+
+func (s byUse) Len() int           { return len(s.inodes) }
+func (s byUse) Less(i, j int) bool { return s.ticks[s.inodes[i]] < s.ticks[s.inodes[j]] }
+func (s byUse) Swap(i, j int)      { s.inodes[i], s.inodes[j] = s.inodes[j], s.inodes[i] }
+
+// Return the total number of bytes of contents held locally by the live
+// inodes. See FileInode.MaterializedBytes.
+//
+// LOCKS_EXCLUDED(f.mu) for each live inode f
+func (r *Registry) TotalMemory() (total int64, err error) {
+	for _, f := range r.leastRecentlyUsed() {
+		var n int64
+		f.Lock()
+		n, err = f.MaterializedBytes()
+		f.Unlock()
+
+		if err != nil {
+			err = fmt.Errorf("MaterializedBytes(%q): %v", f.Name(), err)
+			return
+		}
+
+		total += n
+	}
+
+	return
+}
+
+// Evict the contents of inodes without modifications, least recently used
+// first, until the live inodes hold at most target bytes locally. Returns the
+// number of bytes evicted. Modified contents are never evicted, so this may
+// fall short of the target.
+//
+// LOCKS_EXCLUDED(f.mu) for each live inode f
+func (r *Registry) EvictToTarget(target int64) (evicted int64, err error) {
+	total, err := r.TotalMemory()
+	if err != nil {
+		err = fmt.Errorf("TotalMemory: %v", err)
+		return
+	}
+
+	for _, f := range r.leastRecentlyUsed() {
+		if total <= target {
+			break
+		}
+
+		var n int64
+		f.Lock()
+		n, err = f.EvictClean()
+		f.Unlock()
+
+		if err != nil {
+			err = fmt.Errorf("EvictClean(%q): %v", f.Name(), err)
+			return
+		}
+
+		total -= n
+		evicted += n
+	}
+
+	return
+}
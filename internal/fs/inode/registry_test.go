@@ -0,0 +1,150 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	clock := timeutil.RealClock()
+	bucket := gcsfake.NewFakeBucket(clock, "some_bucket")
+	r := inode.NewRegistry()
+
+	// Create inodes with their contents faulted in.
+	var inodes []*inode.FileInode
+	for i, name := range []string{"a", "b", "c"} {
+		o, err := gcsutil.CreateObject(ctx, bucket, name, []byte("taco"))
+		if err != nil {
+			t.Fatalf("CreateObject: %v", err)
+		}
+
+		in := inode.NewFileInode(
+			fuseops.InodeID(fileInodeID+i),
+			o,
+			fuseops.InodeAttributes{Mode: fileMode},
+			bucket,
+			gcsx.NewSyncer(1, ".gcsfuse_tmp/", bucket),
+			"",
+			clock,
+			inode.FileConfig{Registry: r})
+
+		inodes = append(inodes, in)
+	}
+
+	read := func(in *inode.FileInode) {
+		buf := make([]byte, 16)
+		in.Lock()
+		n, err := in.Read(ctx, buf, 0)
+		in.Unlock()
+
+		if err != io.EOF || string(buf[:n]) != "taco" {
+			t.Fatalf("%s: Read: %q, %v", in.Name(), buf[:n], err)
+		}
+	}
+
+	for _, in := range inodes {
+		read(in)
+	}
+
+	// Modify b, and use a again. From least to most recently used, that's c,
+	// b, a.
+	a, b, c := inodes[0], inodes[1], inodes[2]
+	b.Lock()
+	err := b.Write(ctx, []byte("p"), 0)
+	b.Unlock()
+
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	read(a)
+
+	checkTotal := func(expected int64) {
+		total, err := r.TotalMemory()
+		if err != nil {
+			t.Fatalf("TotalMemory: %v", err)
+		}
+
+		if total != expected {
+			t.Errorf("Got total %d, want %d", total, expected)
+		}
+	}
+
+	checkTotal(12)
+
+	// Getting down to 8 bytes should evict only c.
+	evicted, err := r.EvictToTarget(8)
+	if err != nil {
+		t.Fatalf("EvictToTarget: %v", err)
+	}
+
+	if evicted != 4 {
+		t.Errorf("Got %d bytes evicted, want 4", evicted)
+	}
+
+	checkTotal(8)
+
+	for in, expected := range map[*inode.FileInode]int64{a: 4, c: 0} {
+		in.Lock()
+		n, err := in.MaterializedBytes()
+		in.Unlock()
+
+		if err != nil {
+			t.Fatalf("MaterializedBytes: %v", err)
+		}
+
+		if n != expected {
+			t.Errorf("%s: got %d bytes, want %d", in.Name(), n, expected)
+		}
+	}
+
+	// Evicted contents should come back when needed.
+	read(c)
+	checkTotal(12)
+
+	// Getting down to nothing can't evict b's modified contents.
+	evicted, err = r.EvictToTarget(0)
+	if err != nil {
+		t.Fatalf("EvictToTarget: %v", err)
+	}
+
+	if evicted != 8 {
+		t.Errorf("Got %d bytes evicted, want 8", evicted)
+	}
+
+	checkTotal(4)
+
+	// Destroyed inodes leave the registry.
+	b.Lock()
+	err = b.Destroy()
+	b.Unlock()
+
+	if err != nil {
+		t.Fatalf("Destroy: %v", err)
+	}
+
+	checkTotal(0)
+}
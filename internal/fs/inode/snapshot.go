@@ -0,0 +1,132 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// A read-only copy of the contents of one generation of a file inode's
+// backing object, cached locally. Reads from the snapshot always see that
+// generation, no matter what happens to the inode or the object afterward.
+//
+// Safe for concurrent access.
+type FileSnapshot struct {
+	name       string
+	generation int64
+
+	mu sync.Mutex
+
+	// Set to nil by Destroy.
+	//
+	// GUARDED_BY(mu)
+	content gcsx.TempFile
+}
+
+// Take a snapshot of the inode's source generation, reading its entire
+// contents from GCS. Local modifications that have not been synced are not
+// included. The caller must call Destroy on the result when done with it.
+//
+// If the source generation no longer exists, returns *gcsx.ClobberedError. If
+// GCS returns the wrong amount of data, returns *gcsx.SizeMismatchError.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) Snapshot(ctx context.Context) (s *FileSnapshot, err error) {
+	rc, err := f.openDecoded(ctx)
+	if nfe, ok := err.(*gcs.NotFoundError); ok {
+		err = &gcsx.ClobberedError{
+			Name:       f.src.Name,
+			Generation: f.src.Generation,
+			Err:        nfe,
+		}
+
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("openDecoded: %v", err)
+		return
+	}
+
+	defer rc.Close()
+
+	content, err := f.newTempFile(rc)
+	if err != nil {
+		err = fmt.Errorf("newTempFile: %v", err)
+		return
+	}
+
+	// Make sure we got what we expected.
+	sr, err := content.Stat()
+	if err != nil {
+		content.Destroy()
+		err = fmt.Errorf("Stat: %v", err)
+		return
+	}
+
+	if sr.Size != f.srcSize() {
+		content.Destroy()
+		err = &gcsx.SizeMismatchError{
+			Name:     f.src.Name,
+			Expected: f.srcSize(),
+			Actual:   sr.Size,
+		}
+
+		return
+	}
+
+	s = &FileSnapshot{
+		name:       f.src.Name,
+		generation: f.src.Generation,
+		content:    content,
+	}
+
+	return
+}
+
+// Return the generation of the object captured by the snapshot.
+func (s *FileSnapshot) Generation() int64 {
+	return s.generation
+}
+
+// Read from the snapshot with semantics matching io.ReaderAt.
+func (s *FileSnapshot) ReadAt(p []byte, offset int64) (n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.content == nil {
+		err = fmt.Errorf("Snapshot of %q has been destroyed", s.name)
+		return
+	}
+
+	n, err = s.content.ReadAt(p, offset)
+	return
+}
+
+// Release the resources held by the snapshot. Further reads will fail.
+func (s *FileSnapshot) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.content != nil {
+		s.content.Destroy()
+		s.content = nil
+	}
+}
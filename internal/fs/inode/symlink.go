@@ -15,8 +15,11 @@
 package inode
 
 import (
+	"fmt"
+	"strings"
 	"sync"
 
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/gcloud/gcs"
 	"golang.org/x/net/context"
@@ -33,6 +36,48 @@ func IsSymlink(o *gcs.Object) bool {
 	return ok
 }
 
+// Create an empty object with the given name that represents a symlink to the
+// supplied target. Fails with *gcsx.AlreadyExistsError if an object with the
+// name already exists.
+func CreateSymlinkObject(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	name string,
+	target string) (o *gcs.Object, err error) {
+	o, err = gcsx.CreateObjectIfAbsent(
+		ctx,
+		bucket,
+		&gcs.CreateObjectRequest{
+			Name:     name,
+			Contents: strings.NewReader(""),
+			Metadata: map[string]string{
+				SymlinkMetadataKey: target,
+			},
+		})
+
+	return
+}
+
+// Stat the object with the given name and return the target of the symlink it
+// represents. Returns an error if the object exists but is not a symlink.
+func ReadSymlinkTarget(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	name string) (target string, err error) {
+	o, err := bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: name})
+	if err != nil {
+		return
+	}
+
+	if !IsSymlink(o) {
+		err = fmt.Errorf("Object %q is not a symlink", name)
+		return
+	}
+
+	target = o.Metadata[SymlinkMetadataKey]
+	return
+}
+
 type SymlinkInode struct {
 	/////////////////////////
 	// Constant data
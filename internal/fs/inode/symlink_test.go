@@ -0,0 +1,112 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestSymlink(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type SymlinkTest struct {
+	ctx    context.Context
+	bucket gcs.Bucket
+	clock  timeutil.SimulatedClock
+}
+
+var _ SetUpInterface = &SymlinkTest{}
+
+func init() { RegisterTestSuite(&SymlinkTest{}) }
+
+func (t *SymlinkTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+	t.bucket = gcsfake.NewFakeBucket(&t.clock, "some_bucket")
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *SymlinkTest) CreateThenRead() {
+	const name = "foo/bar"
+	const target = "../baz/qux"
+
+	// Create.
+	o, err := inode.CreateSymlinkObject(t.ctx, t.bucket, name, target)
+
+	AssertEq(nil, err)
+	ExpectEq(name, o.Name)
+	ExpectEq(0, o.Size)
+	ExpectEq(target, o.Metadata[inode.SymlinkMetadataKey])
+	ExpectTrue(inode.IsSymlink(o))
+
+	// Read the target back.
+	actual, err := inode.ReadSymlinkTarget(t.ctx, t.bucket, name)
+
+	AssertEq(nil, err)
+	ExpectEq(target, actual)
+
+	// An inode for the object should agree.
+	in := inode.NewSymlinkInode(17, o, fuseops.InodeAttributes{})
+	ExpectEq(target, in.Target())
+}
+
+func (t *SymlinkTest) Create_AlreadyExists() {
+	const name = "foo"
+
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, name, []byte("taco"))
+	AssertEq(nil, err)
+
+	_, err = inode.CreateSymlinkObject(t.ctx, t.bucket, name, "bar")
+
+	_, ok := err.(*gcsx.AlreadyExistsError)
+	ExpectTrue(ok, "Unexpected error: %v", err)
+}
+
+func (t *SymlinkTest) Read_NotASymlink() {
+	const name = "foo"
+
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, name, []byte("taco"))
+	AssertEq(nil, err)
+	ExpectFalse(inode.IsSymlink(o))
+
+	_, err = inode.ReadSymlinkTarget(t.ctx, t.bucket, name)
+	ExpectThat(err, Error(HasSubstr("not a symlink")))
+}
+
+func (t *SymlinkTest) Read_NotFound() {
+	_, err := inode.ReadSymlinkTarget(t.ctx, t.bucket, "foo")
+
+	_, ok := err.(*gcs.NotFoundError)
+	ExpectTrue(ok, "Unexpected error: %v", err)
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// The number of inodes SyncAll syncs at once.
+const syncAllWorkers = 8
+
+// The outcome of syncing a single inode with SyncAll.
+type SyncResult struct {
+	// The inode's source generation after syncing. Meaningful only if Err is
+	// nil.
+	Generation Generation
+
+	// The error returned by ForceSync, if any. An inode whose sync failed is
+	// left dirty, so that it can be retried.
+	Err error
+}
+
+// Sync the supplied file inodes, using some parallelism. The result has an
+// entry for each inode, in the same order. Uploads are never deferred because
+// of FileConfig.MinSyncInterval; see ForceSync.
+//
+// This is not transactional: if some inodes fail to sync, those that
+// succeeded stay synced.
+//
+// LOCKS_EXCLUDED(in.mu) for each in in inodes
+func SyncAll(
+	ctx context.Context,
+	inodes []*FileInode) (results []SyncResult) {
+	results = make([]SyncResult, len(inodes))
+
+	// Feed indices to the workers.
+	todo := make(chan int)
+	go func() {
+		defer close(todo)
+		for i := range inodes {
+			todo <- i
+		}
+	}()
+
+	// Sync, recording results. Each worker writes only to the entries for the
+	// indices it receives.
+	var wg sync.WaitGroup
+	for i := 0; i < syncAllWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range todo {
+				in := inodes[i]
+
+				in.Lock()
+				err := in.ForceSync(ctx)
+				results[i] = SyncResult{
+					Generation: in.SourceGeneration(),
+					Err:        err,
+				}
+				in.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	return
+}
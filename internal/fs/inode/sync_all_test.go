@@ -0,0 +1,249 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// A bucket that refuses to create objects with particular names.
+type createFailingBucket struct {
+	gcs.Bucket
+	fail map[string]bool
+}
+
+func (b *createFailingBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	if b.fail[req.Name] {
+		err = errors.New("injected failure")
+		return
+	}
+
+	o, err = b.Bucket.CreateObject(ctx, req)
+	return
+}
+
+// Create dirty file inodes for objects with the given names.
+func dirtyInodes(
+	t *testing.T,
+	bucket gcs.Bucket,
+	names []string) (inodes []*inode.FileInode) {
+	ctx := context.Background()
+	clock := timeutil.RealClock()
+
+	for i, name := range names {
+		o, err := gcsutil.CreateObject(ctx, bucket, name, []byte("taco"))
+		if err != nil {
+			t.Fatalf("CreateObject: %v", err)
+		}
+
+		in := inode.NewFileInode(
+			fuseops.InodeID(fileInodeID+i),
+			o,
+			fuseops.InodeAttributes{Mode: fileMode},
+			bucket,
+			gcsx.NewSyncer(1, ".gcsfuse_tmp/", bucket),
+			"",
+			clock,
+			inode.FileConfig{})
+
+		in.Lock()
+		err = in.Write(ctx, []byte("p"), 0)
+		in.Unlock()
+
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+
+		inodes = append(inodes, in)
+	}
+
+	return
+}
+
+func isDirty(t *testing.T, in *inode.FileInode) bool {
+	in.Lock()
+	defer in.Unlock()
+
+	plan, err := in.DrySync()
+	if err != nil {
+		t.Fatalf("DrySync: %v", err)
+	}
+
+	return !plan.NoOp
+}
+
+func TestSyncAll_AllSucceed(t *testing.T) {
+	ctx := context.Background()
+	bucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	var names []string
+	for i := 0; i < 20; i++ {
+		names = append(names, fmt.Sprintf("foo/%d", i))
+	}
+
+	inodes := dirtyInodes(t, bucket, names)
+	results := inode.SyncAll(ctx, inodes)
+
+	if len(results) != len(inodes) {
+		t.Fatalf("Got %d results, want %d", len(results), len(inodes))
+	}
+
+	for i, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s: %v", names[i], r.Err)
+			continue
+		}
+
+		o, err := bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: names[i]})
+		if err != nil {
+			t.Fatalf("StatObject: %v", err)
+		}
+
+		if r.Generation.Object != o.Generation {
+			t.Errorf(
+				"%s: got generation %d, want %d",
+				names[i],
+				r.Generation.Object,
+				o.Generation)
+		}
+
+		if isDirty(t, inodes[i]) {
+			t.Errorf("%s: still dirty", names[i])
+		}
+	}
+}
+
+func TestSyncAll_PartialFailure(t *testing.T) {
+	ctx := context.Background()
+	bucket := &createFailingBucket{
+		Bucket: gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"),
+	}
+
+	names := []string{"foo/0", "foo/1", "foo/2"}
+	inodes := dirtyInodes(t, bucket, names)
+
+	inodes[1].Lock()
+	before := inodes[1].SourceGeneration()
+	inodes[1].Unlock()
+
+	bucket.fail = map[string]bool{"foo/1": true}
+	results := inode.SyncAll(ctx, inodes)
+
+	// The failed inode should report its error and stay dirty.
+	if results[1].Err == nil {
+		t.Errorf("Expected an error for foo/1")
+	}
+
+	if results[1].Generation != before {
+		t.Errorf("foo/1 generation changed: %v -> %v", before, results[1].Generation)
+	}
+
+	if !isDirty(t, inodes[1]) {
+		t.Errorf("foo/1 is no longer dirty")
+	}
+
+	// The others should have been synced.
+	for _, i := range []int{0, 2} {
+		if results[i].Err != nil {
+			t.Errorf("%s: %v", names[i], results[i].Err)
+		}
+
+		if isDirty(t, inodes[i]) {
+			t.Errorf("%s: still dirty", names[i])
+		}
+	}
+
+	// Retrying succeeds once the failure clears.
+	bucket.fail = nil
+	results = inode.SyncAll(ctx, inodes[1:2])
+	if results[0].Err != nil {
+		t.Errorf("Retry: %v", results[0].Err)
+	}
+
+	if isDirty(t, inodes[1]) {
+		t.Errorf("foo/1 still dirty after retry")
+	}
+}
+
+func TestSyncAll_MinSyncInterval(t *testing.T) {
+	ctx := context.Background()
+	bucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	o, err := gcsutil.CreateObject(ctx, bucket, "foo", []byte("taco"))
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	var clock gcsx.SimulatedClock
+	in := inode.NewFileInode(
+		fileInodeID,
+		o,
+		fuseops.InodeAttributes{Mode: fileMode},
+		bucket,
+		gcsx.NewSyncer(1, ".gcsfuse_tmp/", bucket),
+		"",
+		timeutil.RealClock(),
+		inode.FileConfig{Clock: &clock, MinSyncInterval: time.Minute})
+
+	// Sync once, so that another sync right away would be deferred.
+	in.Lock()
+	err = in.Write(ctx, []byte("p"), 0)
+	if err == nil {
+		err = in.Sync(ctx)
+	}
+
+	if err == nil {
+		err = in.Write(ctx, []byte("b"), 0)
+	}
+
+	in.Unlock()
+
+	if err != nil {
+		t.Fatalf("Write or Sync: %v", err)
+	}
+
+	// SyncAll shouldn't report success without uploading.
+	results := inode.SyncAll(ctx, []*inode.FileInode{in})
+	if results[0].Err != nil {
+		t.Fatalf("SyncAll: %v", results[0].Err)
+	}
+
+	if isDirty(t, in) {
+		t.Errorf("Still dirty")
+	}
+
+	contents, err := gcsutil.ReadObject(ctx, bucket, "foo")
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+
+	if got, want := string(contents), "baco"; got != want {
+		t.Errorf("Got contents %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"golang.org/x/net/context"
+)
+
+// A set of file inodes to be synced together, where some must not be synced
+// until others have been, e.g. an index object that refers to data objects.
+//
+// Not safe for concurrent access.
+type OrderedSyncGroup struct {
+	// The inodes in the group, in the order they were first added.
+	inodes []*FileInode
+
+	// The dependencies of each inode in the group.
+	//
+	// INVARIANT: For each in in inodes, deps contains in
+	// INVARIANT: For each value v in deps, every element of v is a key
+	deps map[*FileInode][]*FileInode
+}
+
+// Create an empty group.
+func NewOrderedSyncGroup() *OrderedSyncGroup {
+	return &OrderedSyncGroup{
+		deps: make(map[*FileInode][]*FileInode),
+	}
+}
+
+// Add the inode to the group if it isn't already there, recording that it
+// must not be synced until each of dependsOn has been. The dependencies are
+// added to the group too.
+func (g *OrderedSyncGroup) Add(in *FileInode, dependsOn ...*FileInode) {
+	g.add(in)
+	for _, d := range dependsOn {
+		g.add(d)
+	}
+
+	g.deps[in] = append(g.deps[in], dependsOn...)
+}
+
+func (g *OrderedSyncGroup) add(in *FileInode) {
+	if _, ok := g.deps[in]; ok {
+		return
+	}
+
+	g.inodes = append(g.inodes, in)
+	g.deps[in] = nil
+}
+
+// Sync every inode in the group, each only after all of its dependencies have
+// been successfully synced, using some parallelism. The result has an entry
+// for each inode, in the order in which they were first added.
+//
+// If an inode fails to sync, its dependents (and theirs, and so on) are left
+// alone and report *gcsx.DependencyFailedError. So does a dependent of an
+// inode that was clobbered, since syncing then writes nothing. Unrelated inodes
+// are synced regardless.
+//
+// Returns an error without syncing anything if the dependencies have a cycle.
+//
+// LOCKS_EXCLUDED(in.mu) for each in in the group
+func (g *OrderedSyncGroup) Flush(
+	ctx context.Context) (results []SyncResult, err error) {
+	err = g.checkAcyclic()
+	if err != nil {
+		return
+	}
+
+	results = make([]SyncResult, len(g.inodes))
+
+	// Each inode gets a channel that is closed once it has a result.
+	done := make(map[*FileInode]chan struct{})
+	index := make(map[*FileInode]int)
+	for i, in := range g.inodes {
+		done[in] = make(chan struct{})
+		index[in] = i
+	}
+
+	// Start a goroutine per inode to wait on its dependencies, limiting how many
+	// sync at once.
+	sem := make(chan struct{}, syncAllWorkers)
+	for i, in := range g.inodes {
+		go func(i int, in *FileInode) {
+			defer close(done[in])
+
+			// Wait for the dependencies, checking they were written.
+			for _, d := range g.deps[in] {
+				<-done[d]
+				if results[index[d]].Err != nil {
+					results[i].Err = &gcsx.DependencyFailedError{
+						Name:       in.Name(),
+						Dependency: d.Name(),
+					}
+
+					return
+				}
+			}
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i] = syncWritten(ctx, in)
+		}(i, in)
+	}
+
+	for _, in := range g.inodes {
+		<-done[in]
+	}
+
+	return
+}
+
+// Sync the inode, treating its having been clobbered as an error rather than
+// as success, since then nothing was written. The upload is never deferred,
+// since dependents must not be synced until it has happened.
+//
+// LOCKS_EXCLUDED(in.mu)
+func syncWritten(ctx context.Context, in *FileInode) (r SyncResult) {
+	in.Lock()
+	defer in.Unlock()
+
+	r.Err = in.ForceSync(ctx)
+	r.Generation = in.SourceGeneration()
+	if r.Err != nil {
+		return
+	}
+
+	plan, err := in.DrySync()
+	if err != nil {
+		r.Err = fmt.Errorf("DrySync: %v", err)
+		return
+	}
+
+	if !plan.NoOp {
+		r.Err = &gcsx.ClobberedError{
+			Name:       in.Name(),
+			Generation: r.Generation.Object,
+			Err:        errors.New("nothing was written"),
+		}
+	}
+
+	return
+}
+
+// Return an error if the dependencies have a cycle.
+func (g *OrderedSyncGroup) checkAcyclic() (err error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[*FileInode]int)
+
+	var visit func(in *FileInode) error
+	visit = func(in *FileInode) error {
+		switch state[in] {
+		case visiting:
+			return fmt.Errorf("Dependency cycle involving %q", in.Name())
+
+		case visited:
+			return nil
+		}
+
+		state[in] = visiting
+		for _, d := range g.deps[in] {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+
+		state[in] = visited
+		return nil
+	}
+
+	for _, in := range g.inodes {
+		err = visit(in)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
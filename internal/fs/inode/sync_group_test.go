@@ -0,0 +1,154 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/fs/inode"
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// A bucket that records the names of the objects it successfully creates, in
+// order.
+type creationRecordingBucket struct {
+	gcs.Bucket
+
+	mu      sync.Mutex
+	created []string
+}
+
+func (b *creationRecordingBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.Bucket.CreateObject(ctx, req)
+	if err == nil {
+		b.mu.Lock()
+		b.created = append(b.created, req.Name)
+		b.mu.Unlock()
+	}
+
+	return
+}
+
+func TestOrderedSyncGroup_DependenciesFirst(t *testing.T) {
+	ctx := context.Background()
+	bucket := &creationRecordingBucket{
+		Bucket: gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"),
+	}
+
+	names := []string{"index", "data/0", "data/1", "data/2"}
+	inodes := dirtyInodes(t, bucket, names)
+	bucket.created = nil
+
+	g := inode.NewOrderedSyncGroup()
+	g.Add(inodes[0], inodes[1], inodes[2], inodes[3])
+
+	results, err := g.Flush(ctx)
+	if err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	for i, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s: %v", names[i], r.Err)
+		}
+	}
+
+	if len(bucket.created) != 4 || bucket.created[3] != "index" {
+		t.Errorf("Unexpected creation order: %q", bucket.created)
+	}
+}
+
+func TestOrderedSyncGroup_FailedDependency(t *testing.T) {
+	ctx := context.Background()
+	failing := &createFailingBucket{
+		Bucket: gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"),
+	}
+
+	bucket := &creationRecordingBucket{Bucket: failing}
+
+	// index depends on data, which fails; summary depends on index. other is
+	// unrelated.
+	names := []string{"summary", "index", "data", "other"}
+	inodes := dirtyInodes(t, bucket, names)
+	bucket.created = nil
+	failing.fail = map[string]bool{"data": true}
+
+	g := inode.NewOrderedSyncGroup()
+	g.Add(inodes[0], inodes[1])
+	g.Add(inodes[1], inodes[2])
+	g.Add(inodes[3])
+
+	results, err := g.Flush(ctx)
+	if err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	if len(results) != 4 {
+		t.Fatalf("Got %d results, want 4", len(results))
+	}
+
+	// The results are in the order the inodes were first added.
+	if results[2].Err == nil {
+		t.Errorf("Expected an error for data")
+	}
+
+	for _, i := range []int{0, 1} {
+		if _, ok := results[i].Err.(*gcsx.DependencyFailedError); !ok {
+			t.Errorf("%s: unexpected error: %v", names[i], results[i].Err)
+		}
+
+		if !isDirty(t, inodes[i]) {
+			t.Errorf("%s: no longer dirty", names[i])
+		}
+	}
+
+	if results[3].Err != nil {
+		t.Errorf("other: %v", results[3].Err)
+	}
+
+	if len(bucket.created) != 1 || bucket.created[0] != "other" {
+		t.Errorf("Unexpected creations: %q", bucket.created)
+	}
+}
+
+func TestOrderedSyncGroup_Cycle(t *testing.T) {
+	ctx := context.Background()
+	bucket := &creationRecordingBucket{
+		Bucket: gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"),
+	}
+
+	inodes := dirtyInodes(t, bucket, []string{"a", "b"})
+	bucket.created = nil
+
+	g := inode.NewOrderedSyncGroup()
+	g.Add(inodes[0], inodes[1])
+	g.Add(inodes[1], inodes[0])
+
+	_, err := g.Flush(ctx)
+	if err == nil {
+		t.Fatalf("Expected an error")
+	}
+
+	if len(bucket.created) != 0 {
+		t.Errorf("Unexpected creations: %q", bucket.created)
+	}
+}
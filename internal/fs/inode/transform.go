@@ -0,0 +1,308 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inode
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// A reversible transformation of file contents, applied on their way to GCS
+// and undone on their way back, e.g. for client-side encryption or custom
+// framing.
+//
+// A range of the logical contents needn't correspond to any range of the
+// encoded object, so transformed objects are always read and written in full.
+type ContentTransform interface {
+	// Return a reader for the encoded form of the logical contents supplied by
+	// r.
+	Encode(r io.Reader) (io.Reader, error)
+
+	// Return a reader for the logical contents whose encoded form is supplied
+	// by r.
+	Decode(r io.Reader) (io.Reader, error)
+
+	// Return the length of the logical contents of an object whose encoded form
+	// has the given length.
+	DecodedSize(encodedSize int64) int64
+}
+
+// A GCS object metadata key under which an object stored in a compressed
+// format records the size of its uncompressed contents, in decimal. See
+// FileConfig.Decompressors.
+const UncompressedSizeMetadataKey = "gcsfuse_uncompressed_size"
+
+// A decoder for a compressed format such as zstd or snappy, used to read
+// objects whose Content-Encoding names it. See FileConfig.Decompressors.
+//
+// Note that GCS itself decompresses gzip-encoded objects when serving them,
+// so there should be no need to register a decompressor for "gzip".
+type Decompressor interface {
+	// Return a reader for the uncompressed form of the contents supplied by r.
+	Decompress(r io.Reader) (io.Reader, error)
+}
+
+// A ContentTransform for reading a compressed object. Contents are encoded by
+// leaving them uncompressed.
+type decompressingTransform struct {
+	d    Decompressor
+	size int64
+}
+
+func (dt *decompressingTransform) Encode(r io.Reader) (io.Reader, error) {
+	return r, nil
+}
+
+func (dt *decompressingTransform) Decode(r io.Reader) (io.Reader, error) {
+	return dt.d.Decompress(r)
+}
+
+func (dt *decompressingTransform) DecodedSize(encodedSize int64) int64 {
+	return dt.size
+}
+
+// Return the transform through which the source object's contents must be
+// read, or nil if none. This is the configured Transform if any, and
+// otherwise a decompressor matching the source object's Content-Encoding.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) transform() ContentTransform {
+	if f.cfg.Transform != nil {
+		return f.cfg.Transform
+	}
+
+	if f.src.ContentEncoding == "" {
+		return nil
+	}
+
+	d, ok := f.cfg.Decompressors[f.src.ContentEncoding]
+	if !ok {
+		return nil
+	}
+
+	size, err := strconv.ParseInt(f.src.Metadata[UncompressedSizeMetadataKey], 10, 64)
+	if err != nil || size < 0 {
+		return nil
+	}
+
+	return &decompressingTransform{d: d, size: size}
+}
+
+// Return the logical size of the source object's contents.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) srcSize() int64 {
+	if t := f.transform(); t != nil {
+		return t.DecodedSize(int64(f.src.Size))
+	}
+
+	return int64(f.src.Size)
+}
+
+// Open a reader for the logical contents of the source generation.
+//
+// Returns *gcs.NotFoundError unmodified if the source generation no longer
+// exists.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) openDecoded(ctx context.Context) (rc io.ReadCloser, err error) {
+	rc, err = f.openDecodedGeneration(ctx, f.src.Generation)
+	return
+}
+
+// Open a reader for the logical contents of the given generation of the
+// inode's backing object.
+//
+// Returns *gcs.NotFoundError unmodified if the generation doesn't exist, and
+// *gcsx.StaleReadError if GCS serves another.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *FileInode) openDecodedGeneration(
+	ctx context.Context,
+	generation int64) (rc io.ReadCloser, err error) {
+	req := &gcs.ReadObjectRequest{
+		Name:       f.name,
+		Generation: generation,
+	}
+
+	rc, err = f.bucket.NewReader(ctx, req)
+
+	// Don't mangle not found errors.
+	if _, ok := err.(*gcs.NotFoundError); ok {
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("NewReader: %v", err)
+		return
+	}
+
+	err = gcsx.CheckReadGeneration(req, rc)
+	if err != nil {
+		rc.Close()
+		rc = nil
+		return
+	}
+
+	t := f.transform()
+	if t == nil {
+		return
+	}
+
+	r, err := t.Decode(rc)
+	if err != nil {
+		rc.Close()
+		rc = nil
+		err = fmt.Errorf("Decode: %v", err)
+		return
+	}
+
+	rc = &decodedReader{Reader: r, Closer: rc}
+	return
+}
+
+type decodedReader struct {
+	io.Reader
+	io.Closer
+}
+
+// Fault in the supplied missing ranges of f.content by reading the entire
+// source generation, decoding it if transformed.
+//
+// Returns *gcs.NotFoundError unmodified if the source generation no longer
+// exists, even if it disappears part way through the read, and
+// *gcsx.SizeMismatchError if it decodes to too few bytes.
+//
+// LOCKS_REQUIRED(f.mu)
+// REQUIRES: f.content != nil
+// REQUIRES: missing was returned by f.content.Missing
+func (f *FileInode) faultInDecoded(
+	ctx context.Context,
+	missing []gcs.ByteRange) (err error) {
+	rc, err := f.openDecoded(ctx)
+	if err != nil {
+		return
+	}
+
+	defer rc.Close()
+	decoded := f.throttled(ctx, rc)
+
+	// Walk through the logical contents, keeping the parts that are missing.
+	var offset int64
+	for _, r := range missing {
+		start := int64(r.Start)
+		expected := int64(r.Limit - r.Start)
+
+		var n int64
+		n, err = io.CopyN(ioutil.Discard, decoded, start-offset)
+		offset += n
+		if err == nil {
+			n, err = f.content.Materialize(io.LimitReader(decoded, expected), start)
+			offset += n
+		}
+
+		if err == io.EOF || (err == nil && n != expected) {
+			err = &gcsx.SizeMismatchError{
+				Name:     f.src.Name,
+				Expected: f.srcSize(),
+				Actual:   offset,
+			}
+
+			return
+		}
+
+		switch err.(type) {
+		case *gcs.NotFoundError, *gcsx.NoSpaceError:
+			return
+		}
+
+		if err != nil {
+			err = fmt.Errorf("Materialize: %v", err)
+			return
+		}
+	}
+
+	return
+}
+
+// Create a temp file holding the encoded form of f.content, for handing to
+// the syncer. The result counts as entirely dirty, so the syncer always
+// rewrites the object in full.
+//
+// LOCKS_REQUIRED(f.mu)
+// REQUIRES: f.content != nil
+// REQUIRES: f.content has been entirely faulted in
+func (f *FileInode) encodeContent(
+	sr gcsx.StatResult) (tf gcsx.TempFile, err error) {
+	_, err = f.content.Seek(0, 0)
+	if err != nil {
+		err = fmt.Errorf("Seek: %v", err)
+		return
+	}
+
+	r, err := f.transform().Encode(f.content)
+	if err != nil {
+		err = fmt.Errorf("Encode: %v", err)
+		return
+	}
+
+	tf, err = f.newSparseTempFile(0)
+	if err != nil {
+		err = fmt.Errorf("newSparseTempFile: %v", err)
+		return
+	}
+
+	// Copy the encoded contents in.
+	buf := make([]byte, copyChunkSize)
+	var offset int64
+	for {
+		var n int
+		n, err = io.ReadFull(r, buf)
+		if n > 0 {
+			_, werr := tf.WriteAt(buf[:n], offset)
+			if werr != nil {
+				tf.Destroy()
+				err = fmt.Errorf("WriteAt: %v", werr)
+				return
+			}
+
+			offset += int64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+			break
+		}
+
+		if err != nil {
+			tf.Destroy()
+			err = fmt.Errorf("Read: %v", err)
+			return
+		}
+	}
+
+	// Preserve the logical contents' mtime.
+	if sr.Mtime != nil {
+		tf.SetMtime(*sr.Mtime)
+	}
+
+	return
+}
@@ -54,6 +54,13 @@ type appendObjectCreator struct {
 }
 
 func (oc *appendObjectCreator) chooseName() (name string, err error) {
+	name, err = randomObjectName(oc.prefix)
+	return
+}
+
+// Choose a random name for a temporary object, beginning with the supplied
+// prefix.
+func randomObjectName(prefix string) (name string, err error) {
 	// Generate a good 64-bit random number.
 	var buf [8]byte
 	_, err = io.ReadFull(rand.Reader, buf[:])
@@ -72,11 +79,15 @@ func (oc *appendObjectCreator) chooseName() (name string, err error) {
 		uint64(buf[7])<<56
 
 	// Turn it into a name.
-	name = fmt.Sprintf("%s%016x", oc.prefix, x)
+	name = fmt.Sprintf("%s%016x", prefix, x)
 
 	return
 }
 
+func (oc *appendObjectCreator) requests(srcObject *gcs.Object) int {
+	return composeRequests(srcObject)
+}
+
 func (oc *appendObjectCreator) Create(
 	ctx context.Context,
 	srcObject *gcs.Object,
@@ -110,6 +121,11 @@ func (oc *appendObjectCreator) Create(
 		return
 
 	default:
+		// Don't mangle errors worth retrying.
+		if IsRetryable(err) {
+			return
+		}
+
 		err = fmt.Errorf("CreateObject: %v", err)
 		return
 	}
@@ -145,9 +161,7 @@ func (oc *appendObjectCreator) Create(
 					Generation: tmp.Generation,
 				},
 			},
-			Metadata: map[string]string{
-				MtimeMetadataKey: mtime.Format(time.RFC3339Nano),
-			},
+			Metadata: syncedMetadata(srcObject, mtime),
 		})
 
 	switch typed := err.(type) {
@@ -171,9 +185,16 @@ func (oc *appendObjectCreator) Create(
 		return
 
 	default:
+		// Don't mangle errors worth retrying.
+		if IsRetryable(err) {
+			return
+		}
+
 		err = fmt.Errorf("ComposeObjects: %v", err)
 		return
 	}
 
+	o = preserveCacheControl(ctx, oc.bucket, srcObject, o)
+
 	return
 }
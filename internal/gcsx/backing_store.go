@@ -0,0 +1,283 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jacobsa/fuse/fsutil"
+	"github.com/jacobsa/timeutil"
+)
+
+// Storage for the contents of a temp file, for use with NewTempFileWithStore
+// and NewSparseTempFileWithStore. This allows contents to be staged somewhere
+// other than a local file, e.g. encrypted, or on another machine.
+//
+// Implementations needn't be safe for concurrent access.
+type BackingStore interface {
+	// As with io.ReaderAt.
+	ReadAt(p []byte, offset int64) (n int, err error)
+
+	// As with io.WriterAt. Writing beyond the end extends the contents,
+	// filling any gap with zeroes.
+	WriteAt(p []byte, offset int64) (n int, err error)
+
+	// Set the size of the contents, discarding bytes or appending zeroes as
+	// necessary.
+	Truncate(size int64) error
+
+	// Return the current size of the contents.
+	Size() (int64, error)
+
+	// Release the store's resources. It is not used afterward.
+	Close() error
+}
+
+// A BackingStore may additionally implement SpaceReserver, in which case temp
+// files reserve space before each write and each truncation that extends
+// their contents, so that running out of space fails the operation before
+// anything has been modified rather than part way through. Stores created by
+// NewFileBackingStore do this where the file system supports it.
+type SpaceReserver interface {
+	// Ensure that there is storage for length bytes of contents starting at
+	// offset, without modifying the contents or changing their size. Return
+	// *NoSpaceError if there isn't enough.
+	Reserve(offset int64, length int64) error
+}
+
+// Create an empty backing store that keeps its contents in memory.
+func NewMemoryBackingStore() (bs BackingStore) {
+	bs = &memStore{}
+	return
+}
+
+// Create an empty backing store that keeps its contents in an anonymous file
+// in dir, or the system default temporary location if dir is empty. This is
+// what NewTempFile and NewSparseTempFile use. Unlike others, temp files backed
+// by such a store can be mapped with MapTempFile.
+func NewFileBackingStore(dir string) (bs BackingStore, err error) {
+	// When we close the file, its resources will be magically cleaned up.
+	f, err := fsutil.AnonymousFile(dir)
+	if err != nil {
+		err = fmt.Errorf("AnonymousFile: %v", err)
+		return
+	}
+
+	bs = &fileStore{File: f}
+	return
+}
+
+// Like NewTempFile, but keep the contents in the supplied store, which must
+// be empty. The temp file takes ownership of the store, closing it when
+// destroyed, and is responsible for closing it if this function fails.
+func NewTempFileWithStore(
+	content io.Reader,
+	store BackingStore,
+	clock timeutil.Clock) (tf TempFile, err error) {
+	f := newBackingFile(store)
+
+	// Copy into the store.
+	w := &offsetWriter{f: f}
+	size, err := io.Copy(w, content)
+	if err != nil {
+		f.Close()
+
+		err = classifyBackingError(err)
+		if _, ok := err.(*NoSpaceError); !ok {
+			err = fmt.Errorf("copy: %v", err)
+		}
+
+		return
+	}
+
+	typed := &tempFile{
+		clock:          clock,
+		f:              f,
+		dirtyThreshold: size,
+		initialSize:    size,
+	}
+
+	// All of the initial content is present.
+	typed.present.add(0, size)
+	tf = typed
+
+	return
+}
+
+// Like NewSparseTempFile, but keep the contents in the supplied store, which
+// must be empty. Ownership of the store is as with NewTempFileWithStore.
+func NewSparseTempFileWithStore(
+	size int64,
+	store BackingStore,
+	clock timeutil.Clock) (tf TempFile, err error) {
+	f := newBackingFile(store)
+
+	// Extend the store without writing anything, so that for a file it takes
+	// up no space on file systems that support sparse files.
+	err = f.Truncate(size)
+	if err != nil {
+		f.Close()
+		err = fmt.Errorf("Truncate: %v", err)
+		return
+	}
+
+	tf = &tempFile{
+		clock:          clock,
+		f:              f,
+		dirtyThreshold: size,
+		initialSize:    size,
+	}
+
+	return
+}
+
+// Return a backingFile that keeps its contents in the supplied store.
+func newBackingFile(store BackingStore) backingFile {
+	// Use files directly, so that they can be mapped.
+	if fs, ok := store.(*fileStore); ok {
+		return fs.File
+	}
+
+	return &storeFile{store: store}
+}
+
+// A BackingStore that keeps its contents in a file.
+type fileStore struct {
+	*os.File
+}
+
+func (fs *fileStore) Size() (size int64, err error) {
+	fi, err := fs.Stat()
+	if err != nil {
+		return
+	}
+
+	size = fi.Size()
+	return
+}
+
+func (fs *fileStore) Reserve(offset int64, length int64) error {
+	return reserveFile(fs.File, offset, length)
+}
+
+// Reserve space for the given range of a backing file, if its store supports
+// it. Doesn't mangle *NoSpaceError.
+func reserve(f backingFile, offset int64, length int64) (err error) {
+	if length <= 0 {
+		return
+	}
+
+	switch typed := f.(type) {
+	case *os.File:
+		err = reserveFile(typed, offset, length)
+
+	case *storeFile:
+		if sr, ok := typed.store.(SpaceReserver); ok {
+			err = sr.Reserve(offset, length)
+		}
+	}
+
+	if _, ok := err.(*NoSpaceError); err != nil && !ok {
+		err = fmt.Errorf("Reserve: %v", err)
+	}
+
+	return
+}
+
+// A backingFile whose contents live in a BackingStore, keeping track of the
+// seek position itself.
+type storeFile struct {
+	store BackingStore
+	pos   int64
+}
+
+func (sf *storeFile) Read(p []byte) (n int, err error) {
+	n, err = sf.store.ReadAt(p, sf.pos)
+	sf.pos += int64(n)
+	return
+}
+
+func (sf *storeFile) Seek(offset int64, whence int) (pos int64, err error) {
+	switch whence {
+	case 0:
+		pos = offset
+
+	case 1:
+		pos = sf.pos + offset
+
+	case 2:
+		var size int64
+		size, err = sf.store.Size()
+		if err != nil {
+			err = fmt.Errorf("Size: %v", err)
+			return
+		}
+
+		pos = size + offset
+
+	default:
+		err = fmt.Errorf("Invalid whence: %d", whence)
+		return
+	}
+
+	if pos < 0 {
+		err = fmt.Errorf("Invalid offset: %d", pos)
+		return
+	}
+
+	sf.pos = pos
+	return
+}
+
+func (sf *storeFile) ReadAt(p []byte, offset int64) (int, error) {
+	return sf.store.ReadAt(p, offset)
+}
+
+func (sf *storeFile) WriteAt(p []byte, offset int64) (int, error) {
+	return sf.store.WriteAt(p, offset)
+}
+
+func (sf *storeFile) Truncate(size int64) error {
+	return sf.store.Truncate(size)
+}
+
+func (sf *storeFile) Stat() (fi os.FileInfo, err error) {
+	size, err := sf.store.Size()
+	if err != nil {
+		return
+	}
+
+	fi = storeFileInfo{size: size}
+	return
+}
+
+func (sf *storeFile) Close() error {
+	return sf.store.Close()
+}
+
+// The os.FileInfo for a storeFile, of which only the size is meaningful.
+type storeFileInfo struct {
+	size int64
+}
+
+func (fi storeFileInfo) Name() string       { return "" }
+func (fi storeFileInfo) Size() int64        { return fi.size }
+func (fi storeFileInfo) Mode() os.FileMode  { return 0600 }
+func (fi storeFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi storeFileInfo) IsDir() bool        { return false }
+func (fi storeFileInfo) Sys() interface{}   { return nil }
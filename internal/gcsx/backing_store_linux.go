@@ -0,0 +1,47 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"os"
+	"syscall"
+)
+
+// FALLOC_FL_KEEP_SIZE, which isn't defined by package syscall.
+const fallocKeepSize = 0x1
+
+// Allocate storage for the given range of f with fallocate, leaving its size
+// alone. Reservation is only an optimization, so failures other than running
+// out of space (e.g. because the file system doesn't support fallocate) are
+// ignored, leaving the write to fail if it must.
+func reserveFile(f *os.File, offset int64, length int64) (err error) {
+	for {
+		err = syscall.Fallocate(int(f.Fd()), fallocKeepSize, offset, length)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+
+	if err == nil {
+		return
+	}
+
+	err = classifyBackingError(os.NewSyscallError("fallocate", err))
+	if _, ok := err.(*NoSpaceError); !ok {
+		err = nil
+	}
+
+	return
+}
@@ -0,0 +1,253 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Create a bucket that allows at most n requests to the wrapped bucket to be
+// awaiting a response at once, and at most n readers returned by NewReader to
+// be open at once, in order to bound the load placed on GCS and the number of
+// open connections (and hence file descriptors and sockets) in use. Callers
+// beyond either limit block until a request completes or a reader is closed,
+// or until their context is cancelled, in which case they receive the
+// context's error.
+//
+// The limits are separate. Readers are often held open for a long time, e.g.
+// between reads of an open file, so an open reader counts against the limit
+// on requests only until NewReader returns. Idle readers therefore can't
+// starve other requests, nor deadlock a caller that holds a reader while
+// making another request, as Transfer does.
+//
+// REQUIRES: n > 0
+func NewBoundedConcurrencyBucket(n int, wrapped gcs.Bucket) gcs.Bucket {
+	if n <= 0 {
+		panic(fmt.Sprintf("Illegal concurrency limit: %d", n))
+	}
+
+	return &boundedConcurrencyBucket{
+		sem:     make(chan struct{}, n),
+		readers: make(chan struct{}, n),
+		wrapped: wrapped,
+	}
+}
+
+type boundedConcurrencyBucket struct {
+	// A semaphore with one element for each in-flight request.
+	sem chan struct{}
+
+	// A semaphore with one element for each open reader.
+	readers chan struct{}
+
+	wrapped gcs.Bucket
+}
+
+// Wait for a free slot in the semaphore, returning an error if the context is
+// cancelled first.
+func acquireSlot(ctx context.Context, sem chan struct{}) (err error) {
+	select {
+	case sem <- struct{}{}:
+		return
+
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	}
+}
+
+func (b *boundedConcurrencyBucket) acquire(ctx context.Context) (err error) {
+	err = acquireSlot(ctx, b.sem)
+	return
+}
+
+func (b *boundedConcurrencyBucket) release() {
+	<-b.sem
+}
+
+func (b *boundedConcurrencyBucket) releaseReader() {
+	<-b.readers
+}
+
+func (b *boundedConcurrencyBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+func (b *boundedConcurrencyBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	// Wait for a reader to be closed, if necessary, before taking up a request
+	// slot, so that we don't hold one while waiting.
+	err = acquireSlot(ctx, b.readers)
+	if err != nil {
+		return
+	}
+
+	err = b.acquire(ctx)
+	if err != nil {
+		b.releaseReader()
+		return
+	}
+
+	rc, err = b.wrapped.NewReader(ctx, req)
+	b.release()
+
+	if err != nil {
+		b.releaseReader()
+		return
+	}
+
+	// Hold on to the reader slot until the caller is done with the connection,
+	// without hiding the generation the reader serves, if it knows.
+	rr := &releasingReader{
+		wrapped: rc,
+		release: b.releaseReader,
+	}
+
+	rc = rr
+	if gr, ok := rr.wrapped.(GenerationReader); ok {
+		rc = &releasingGenerationReader{releasingReader: rr, gr: gr}
+	}
+
+	return
+}
+
+func (b *boundedConcurrencyBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	err = b.acquire(ctx)
+	if err != nil {
+		return
+	}
+
+	defer b.release()
+	o, err = b.wrapped.CreateObject(ctx, req)
+	return
+}
+
+func (b *boundedConcurrencyBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	err = b.acquire(ctx)
+	if err != nil {
+		return
+	}
+
+	defer b.release()
+	o, err = b.wrapped.CopyObject(ctx, req)
+	return
+}
+
+func (b *boundedConcurrencyBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	err = b.acquire(ctx)
+	if err != nil {
+		return
+	}
+
+	defer b.release()
+	o, err = b.wrapped.ComposeObjects(ctx, req)
+	return
+}
+
+func (b *boundedConcurrencyBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	err = b.acquire(ctx)
+	if err != nil {
+		return
+	}
+
+	defer b.release()
+	o, err = b.wrapped.StatObject(ctx, req)
+	return
+}
+
+func (b *boundedConcurrencyBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (l *gcs.Listing, err error) {
+	err = b.acquire(ctx)
+	if err != nil {
+		return
+	}
+
+	defer b.release()
+	l, err = b.wrapped.ListObjects(ctx, req)
+	return
+}
+
+func (b *boundedConcurrencyBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	err = b.acquire(ctx)
+	if err != nil {
+		return
+	}
+
+	defer b.release()
+	o, err = b.wrapped.UpdateObject(ctx, req)
+	return
+}
+
+func (b *boundedConcurrencyBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	err = b.acquire(ctx)
+	if err != nil {
+		return
+	}
+
+	defer b.release()
+	err = b.wrapped.DeleteObject(ctx, req)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// releasingReader
+////////////////////////////////////////////////////////////////////////
+
+// A reader that calls a function the first time it is closed.
+type releasingReader struct {
+	wrapped io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (rc *releasingReader) Read(p []byte) (n int, err error) {
+	n, err = rc.wrapped.Read(p)
+	return
+}
+
+func (rc *releasingReader) Close() (err error) {
+	err = rc.wrapped.Close()
+	rc.once.Do(rc.release)
+	return
+}
+
+// A releasingReader for a GenerationReader.
+type releasingGenerationReader struct {
+	*releasingReader
+	gr GenerationReader
+}
+
+func (rc *releasingGenerationReader) Generation() int64 {
+	return rc.gr.Generation()
+}
@@ -0,0 +1,324 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// A bucket whose StatObject calls announce themselves on started and then
+// block until a value is received on proceed.
+type blockingBucket struct {
+	gcs.Bucket
+	started chan struct{}
+	proceed chan struct{}
+}
+
+func newBlockingBucket() *blockingBucket {
+	return &blockingBucket{
+		started: make(chan struct{}, 100),
+		proceed: make(chan struct{}),
+	}
+}
+
+func (b *blockingBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	b.started <- struct{}{}
+	<-b.proceed
+	o = &gcs.Object{Name: req.Name}
+	return
+}
+
+func (b *blockingBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	rc = ioutil.NopCloser(strings.NewReader("taco"))
+	return
+}
+
+// A bucket whose readers report that they serve the given generation.
+type generationServingBucket struct {
+	gcs.Bucket
+	generation int64
+}
+
+func (b *generationServingBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	rc = &servedGenerationReader{
+		ReadCloser: ioutil.NopCloser(strings.NewReader("taco")),
+		generation: b.generation,
+	}
+
+	return
+}
+
+type servedGenerationReader struct {
+	io.ReadCloser
+	generation int64
+}
+
+func (r *servedGenerationReader) Generation() int64 {
+	return r.generation
+}
+
+// Expect that a value arrives on c soon.
+func expectStarted(t *testing.T, c chan struct{}) {
+	select {
+	case <-c:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for call to start")
+	}
+}
+
+// Expect that no value arrives on c for a little while.
+func expectNotStarted(t *testing.T, c chan struct{}) {
+	select {
+	case <-c:
+		t.Fatalf("Call started unexpectedly")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBoundedConcurrencyBucket_BlocksBeyondLimit(t *testing.T) {
+	const n = 3
+	wrapped := newBlockingBucket()
+	bucket := gcsx.NewBoundedConcurrencyBucket(n, wrapped)
+
+	// Start n+1 calls. Only n of them should reach the wrapped bucket.
+	errs := make(chan error, n+1)
+	for i := 0; i < n+1; i++ {
+		go func() {
+			_, err := bucket.StatObject(
+				context.Background(),
+				&gcs.StatObjectRequest{Name: "foo"})
+			errs <- err
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		expectStarted(t, wrapped.started)
+	}
+
+	expectNotStarted(t, wrapped.started)
+
+	// Once one completes, the last should be let through.
+	wrapped.proceed <- struct{}{}
+	if err := <-errs; err != nil {
+		t.Fatalf("StatObject: %v", err)
+	}
+
+	expectStarted(t, wrapped.started)
+
+	// Let everything finish.
+	for i := 0; i < n; i++ {
+		wrapped.proceed <- struct{}{}
+		if err := <-errs; err != nil {
+			t.Fatalf("StatObject: %v", err)
+		}
+	}
+}
+
+func TestBoundedConcurrencyBucket_CancelWhileBlocked(t *testing.T) {
+	wrapped := newBlockingBucket()
+	bucket := gcsx.NewBoundedConcurrencyBucket(1, wrapped)
+
+	// Occupy the only slot.
+	go bucket.StatObject(
+		context.Background(),
+		&gcs.StatObjectRequest{Name: "foo"})
+
+	expectStarted(t, wrapped.started)
+	defer func() { wrapped.proceed <- struct{}{} }()
+
+	// Start another call, then cancel it.
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: "bar"})
+		errs <- err
+	}()
+
+	expectNotStarted(t, wrapped.started)
+	cancel()
+
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Errorf("Unexpected error: %v", err)
+		}
+
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for cancelled call to return")
+	}
+}
+
+func TestBoundedConcurrencyBucket_OpenReadersDontHoldRequestSlots(t *testing.T) {
+	const n = 2
+	wrapped := newBlockingBucket()
+	bucket := gcsx.NewBoundedConcurrencyBucket(n, wrapped)
+
+	// Open n readers, and leave them idle.
+	for i := 0; i < n; i++ {
+		rc, err := bucket.NewReader(
+			context.Background(),
+			&gcs.ReadObjectRequest{Name: "foo"})
+
+		if err != nil {
+			t.Fatalf("NewReader: %v", err)
+		}
+
+		defer rc.Close()
+	}
+
+	// Other calls should still go through.
+	done := make(chan struct{})
+	go func() {
+		bucket.StatObject(
+			context.Background(),
+			&gcs.StatObjectRequest{Name: "foo"})
+		close(done)
+	}()
+
+	expectStarted(t, wrapped.started)
+	wrapped.proceed <- struct{}{}
+	<-done
+}
+
+func TestBoundedConcurrencyBucket_OpenReadersBounded(t *testing.T) {
+	wrapped := newBlockingBucket()
+	bucket := gcsx.NewBoundedConcurrencyBucket(1, wrapped)
+
+	// Open a reader.
+	rc, err := bucket.NewReader(
+		context.Background(),
+		&gcs.ReadObjectRequest{Name: "foo"})
+
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+
+	// Opening another should block.
+	opened := make(chan struct{}, 1)
+	go func() {
+		rc, err := bucket.NewReader(
+			context.Background(),
+			&gcs.ReadObjectRequest{Name: "bar"})
+
+		if err != nil {
+			t.Errorf("NewReader: %v", err)
+			return
+		}
+
+		rc.Close()
+		opened <- struct{}{}
+	}()
+
+	expectNotStarted(t, opened)
+
+	// Closing the first reader should free up its slot.
+	if err := rc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	expectStarted(t, opened)
+}
+
+func TestBoundedConcurrencyBucket_CancelWhileWaitingForReader(t *testing.T) {
+	wrapped := newBlockingBucket()
+	bucket := gcsx.NewBoundedConcurrencyBucket(1, wrapped)
+
+	// Occupy the only reader slot.
+	rc, err := bucket.NewReader(
+		context.Background(),
+		&gcs.ReadObjectRequest{Name: "foo"})
+
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+
+	defer rc.Close()
+
+	// A cancelled wait for another should return the context's error.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = bucket.NewReader(ctx, &gcs.ReadObjectRequest{Name: "bar"})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestBoundedConcurrencyBucket_ReadersReportGeneration(t *testing.T) {
+	bucket := gcsx.NewBoundedConcurrencyBucket(
+		1,
+		&generationServingBucket{generation: 17})
+
+	req := &gcs.ReadObjectRequest{Name: "foo", Generation: 19}
+	rc, err := bucket.NewReader(context.Background(), req)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+
+	defer rc.Close()
+
+	// The wrapper mustn't hide the generation actually served.
+	err = gcsx.CheckReadGeneration(req, rc)
+	if _, ok := err.(*gcsx.StaleReadError); !ok {
+		t.Errorf("Expected *gcsx.StaleReadError, got %v", err)
+	}
+}
+
+func TestBoundedConcurrencyBucket_TransferWithLimitOne(t *testing.T) {
+	bucket := gcsx.NewBoundedConcurrencyBucket(
+		1,
+		gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := gcsutil.CreateObject(ctx, bucket, "foo", []byte("taco"))
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	// Transfer holds a reader open while creating the destination, which
+	// mustn't deadlock.
+	_, err = gcsx.Transfer(ctx, bucket, "foo", bucket, "bar")
+	if err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+
+	contents, err := gcsutil.ReadObject(ctx, bucket, "bar")
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+
+	if string(contents) != "taco" {
+		t.Errorf("Got contents %q, want %q", contents, "taco")
+	}
+}
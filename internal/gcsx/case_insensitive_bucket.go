@@ -0,0 +1,201 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"golang.org/x/net/context"
+)
+
+// Create a bucket that refuses to create an object whose name differs from
+// that of an existing object only by case (under Unicode simple case folding),
+// returning *CollisionError instead. This is useful for buckets shared with
+// case-insensitive file systems, where such names would otherwise silently
+// shadow each other. Names aren't otherwise normalized.
+//
+// Only creations of names that don't yet exist are checked, i.e. requests
+// whose generation precondition is zero, plus copies, which can't say. Names
+// beginning with tmpObjectPrefix are exempt. Only the final path component is
+// compared, against the other entries in the same directory. Each creation
+// costs a listing of that directory, and the check is not atomic with respect
+// to concurrent creations.
+func NewCaseInsensitiveBucket(
+	tmpObjectPrefix string,
+	wrapped gcs.Bucket) gcs.Bucket {
+	return &caseInsensitiveBucket{
+		tmpObjectPrefix: tmpObjectPrefix,
+		wrapped:         wrapped,
+	}
+}
+
+type caseInsensitiveBucket struct {
+	tmpObjectPrefix string
+	wrapped         gcs.Bucket
+}
+
+// Fold each rune to the smallest member of its case folding orbit.
+func foldCase(s string) string {
+	return strings.Map(
+		func(r rune) rune {
+			min := r
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				if f < min {
+					min = f
+				}
+			}
+
+			return min
+		},
+		s)
+}
+
+// Return *CollisionError if the supplied name is being created, as opposed to
+// overwritten, and collides with an existing entry in its directory.
+func (b *caseInsensitiveBucket) checkCollision(
+	ctx context.Context,
+	name string,
+	generationPrecondition *int64) (err error) {
+	if generationPrecondition == nil || *generationPrecondition != 0 {
+		return
+	}
+
+	if b.tmpObjectPrefix != "" && strings.HasPrefix(name, b.tmpObjectPrefix) {
+		return
+	}
+
+	// Find the directory containing the name.
+	dir := ""
+	if i := strings.LastIndex(strings.TrimSuffix(name, "/"), "/"); i >= 0 {
+		dir = name[:i+1]
+	}
+
+	// List it.
+	objects, runs, err := gcsutil.ListAll(
+		ctx,
+		b.wrapped,
+		&gcs.ListObjectsRequest{
+			Prefix:    dir,
+			Delimiter: "/",
+		})
+
+	if err != nil {
+		err = fmt.Errorf("ListAll: %v", err)
+		return
+	}
+
+	// Look for anything that differs from the name but folds to the same thing.
+	folded := foldCase(name)
+	candidates := runs
+	for _, o := range objects {
+		candidates = append(candidates, o.Name)
+	}
+
+	for _, c := range candidates {
+		if c != name && foldCase(c) == folded {
+			err = &CollisionError{
+				Name:     name,
+				Existing: c,
+			}
+
+			return
+		}
+	}
+
+	return
+}
+
+func (b *caseInsensitiveBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+func (b *caseInsensitiveBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	rc, err = b.wrapped.NewReader(ctx, req)
+	return
+}
+
+func (b *caseInsensitiveBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	err = b.checkCollision(ctx, req.Name, req.GenerationPrecondition)
+	if err != nil {
+		return
+	}
+
+	o, err = b.wrapped.CreateObject(ctx, req)
+	return
+}
+
+func (b *caseInsensitiveBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	// A copy may overwrite an existing object, but has no way of saying whether
+	// it means to, so check as if it were a creation.
+	var zero int64
+	err = b.checkCollision(ctx, req.DstName, &zero)
+	if err != nil {
+		return
+	}
+
+	o, err = b.wrapped.CopyObject(ctx, req)
+	return
+}
+
+func (b *caseInsensitiveBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	err = b.checkCollision(ctx, req.DstName, req.DstGenerationPrecondition)
+	if err != nil {
+		return
+	}
+
+	o, err = b.wrapped.ComposeObjects(ctx, req)
+	return
+}
+
+func (b *caseInsensitiveBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.wrapped.StatObject(ctx, req)
+	return
+}
+
+func (b *caseInsensitiveBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (l *gcs.Listing, err error) {
+	l, err = b.wrapped.ListObjects(ctx, req)
+	return
+}
+
+func (b *caseInsensitiveBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.wrapped.UpdateObject(ctx, req)
+	return
+}
+
+func (b *caseInsensitiveBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	err = b.wrapped.DeleteObject(ctx, req)
+	return
+}
@@ -0,0 +1,179 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+const tmpObjectPrefix = ".gcsfuse_tmp/"
+
+// Create an object that mustn't already exist, as the file system does for
+// new files and directories.
+func createIfAbsent(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	name string,
+	contents string) (err error) {
+	_, err = gcsx.CreateObjectIfAbsent(
+		ctx,
+		bucket,
+		&gcs.CreateObjectRequest{
+			Name:     name,
+			Contents: strings.NewReader(contents),
+		})
+
+	return
+}
+
+func TestCaseInsensitiveBucket_Collision(t *testing.T) {
+	ctx := context.Background()
+	bucket := gcsx.NewCaseInsensitiveBucket(
+		tmpObjectPrefix,
+		gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"))
+
+	err := createIfAbsent(ctx, bucket, "dir/File", "taco")
+	if err != nil {
+		t.Fatalf("CreateObjectIfAbsent: %v", err)
+	}
+
+	// A name differing only in case should be refused.
+	err = createIfAbsent(ctx, bucket, "dir/file", "burrito")
+	ce, ok := err.(*gcsx.CollisionError)
+	if !ok {
+		t.Fatalf("Unexpected error: %#v", err)
+	}
+
+	if ce.Name != "dir/file" || ce.Existing != "dir/File" {
+		t.Errorf("Unexpected error: %v", ce)
+	}
+
+	// Creating the name elsewhere is fine.
+	for _, name := range []string{"file", "other/file"} {
+		err = createIfAbsent(ctx, bucket, name, "queso")
+		if err != nil {
+			t.Errorf("CreateObjectIfAbsent(%q): %v", name, err)
+		}
+	}
+}
+
+func TestCaseInsensitiveBucket_OnlyCreationsChecked(t *testing.T) {
+	ctx := context.Background()
+	bucket := gcsx.NewCaseInsensitiveBucket(
+		tmpObjectPrefix,
+		gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"))
+
+	for _, name := range []string{"dir/File", tmpObjectPrefix + "File"} {
+		err := createIfAbsent(ctx, bucket, name, "taco")
+		if err != nil {
+			t.Fatalf("CreateObjectIfAbsent: %v", err)
+		}
+	}
+
+	// Overwrites aren't checked, even if they create a colliding name, since
+	// they don't say that they mean to create one. Neither are temporary
+	// objects, which the user never sees.
+	_, err := gcsutil.CreateObject(ctx, bucket, "dir/file", []byte("burrito"))
+	if err != nil {
+		t.Errorf("CreateObject: %v", err)
+	}
+
+	err = createIfAbsent(ctx, bucket, tmpObjectPrefix+"file", "burrito")
+	if err != nil {
+		t.Errorf("CreateObjectIfAbsent: %v", err)
+	}
+
+	// Composition is checked only if it says that it creates the name.
+	composed := "dir/FILE"
+	var zero int64
+	_, err = bucket.ComposeObjects(ctx, &gcs.ComposeObjectsRequest{
+		DstName:                   composed,
+		DstGenerationPrecondition: &zero,
+		Sources:                   []gcs.ComposeSource{{Name: "dir/File"}},
+	})
+
+	if _, ok := err.(*gcsx.CollisionError); !ok {
+		t.Errorf("Unexpected error: %#v", err)
+	}
+
+	_, err = bucket.ComposeObjects(ctx, &gcs.ComposeObjectsRequest{
+		DstName: composed,
+		Sources: []gcs.ComposeSource{{Name: "dir/File"}},
+	})
+
+	if err != nil {
+		t.Errorf("ComposeObjects: %v", err)
+	}
+}
+
+func TestCaseInsensitiveBucket_DirectoryCollision(t *testing.T) {
+	ctx := context.Background()
+	bucket := gcsx.NewCaseInsensitiveBucket(
+		tmpObjectPrefix,
+		gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"))
+
+	err := createIfAbsent(ctx, bucket, "Dir/", "")
+	if err != nil {
+		t.Fatalf("CreateObjectIfAbsent: %v", err)
+	}
+
+	err = createIfAbsent(ctx, bucket, "dir/", "")
+	if _, ok := err.(*gcsx.CollisionError); !ok {
+		t.Errorf("Unexpected error: %#v", err)
+	}
+}
+
+func TestCaseInsensitiveBucket_CopyObject(t *testing.T) {
+	ctx := context.Background()
+	bucket := gcsx.NewCaseInsensitiveBucket(
+		tmpObjectPrefix,
+		gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"))
+
+	for _, name := range []string{"FILE", "src"} {
+		_, err := gcsutil.CreateObject(ctx, bucket, name, []byte("taco"))
+		if err != nil {
+			t.Fatalf("CreateObject: %v", err)
+		}
+	}
+
+	_, err := bucket.CopyObject(ctx, &gcs.CopyObjectRequest{
+		SrcName: "src",
+		DstName: "file",
+	})
+
+	if _, ok := err.(*gcsx.CollisionError); !ok {
+		t.Errorf("Unexpected error: %#v", err)
+	}
+}
+
+func TestCaseInsensitiveBucket_DefaultAllowsBoth(t *testing.T) {
+	ctx := context.Background()
+	bucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	for _, name := range []string{"File", "file"} {
+		_, err := gcsutil.CreateObject(ctx, bucket, name, []byte("taco"))
+		if err != nil {
+			t.Errorf("CreateObject(%q): %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Configuration for NewChaosBucket. The zero value injects nothing.
+type ChaosConfig struct {
+	// The seed for the bucket's source of randomness. Two buckets with the same
+	// config that see the same sequence of calls make the same decisions.
+	Seed int64
+
+	// Each affected call is delayed by a duration chosen uniformly from
+	// [0, MaxLatency).
+	MaxLatency time.Duration
+
+	// The probability in [0, 1] that an affected call fails.
+	FailureRate float64
+
+	// The probability in [0, 1] that the first affected call of each kind for
+	// each object name fails, regardless of FailureRate. Setting this to one
+	// makes every operation fail once before behaving normally.
+	FirstFailureRate float64
+
+	// The clock used to wait out injected latency. If nil, RealClock() is used.
+	Clock Clock
+}
+
+// The error returned by calls that a chaos bucket decides should fail. It is
+// wrapped in *TransientError, so it looks like a failure worth retrying.
+var ErrChaos = errors.New("Injected failure")
+
+// Create a bucket that injects latency and failures into the CreateObject,
+// StatObject, and NewReader calls made to the wrapped bucket, according to the
+// supplied config. Failures are *TransientError values wrapping ErrChaos, and
+// are returned without calling the wrapped bucket. This is intended for
+// testing timeout and retry behavior repeatably.
+func NewChaosBucket(cfg ChaosConfig, wrapped gcs.Bucket) gcs.Bucket {
+	if cfg.Clock == nil {
+		cfg.Clock = RealClock()
+	}
+
+	return &chaosBucket{
+		cfg:     cfg,
+		wrapped: wrapped,
+		rand:    rand.New(rand.NewSource(cfg.Seed)),
+		seen:    make(map[chaosKey]struct{}),
+	}
+}
+
+type chaosBucket struct {
+	cfg     ChaosConfig
+	wrapped gcs.Bucket
+
+	mu sync.Mutex
+
+	// GUARDED_BY(mu)
+	rand *rand.Rand
+
+	// The calls for which we have already made a first-call decision.
+	//
+	// GUARDED_BY(mu)
+	seen map[chaosKey]struct{}
+}
+
+type chaosKey struct {
+	method string
+	name   string
+}
+
+// Decide what to do with a call, then wait out any latency. Returns an error
+// if the call should fail.
+func (b *chaosBucket) inject(
+	ctx context.Context,
+	method string,
+	name string) (err error) {
+	// Make all random decisions up front and in a fixed order, so that they
+	// depend only on the sequence of calls.
+	b.mu.Lock()
+
+	var latency time.Duration
+	if b.cfg.MaxLatency > 0 {
+		latency = time.Duration(b.rand.Int63n(int64(b.cfg.MaxLatency)))
+	}
+
+	fail := b.rand.Float64() < b.cfg.FailureRate
+
+	key := chaosKey{method, name}
+	if _, ok := b.seen[key]; !ok {
+		b.seen[key] = struct{}{}
+		if b.rand.Float64() < b.cfg.FirstFailureRate {
+			fail = true
+		}
+	}
+
+	b.mu.Unlock()
+
+	// Wait.
+	if latency > 0 {
+		select {
+		case <-b.cfg.Clock.After(latency):
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+
+	if fail {
+		err = &TransientError{Err: ErrChaos}
+		return
+	}
+
+	return
+}
+
+func (b *chaosBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+func (b *chaosBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	err = b.inject(ctx, "NewReader", req.Name)
+	if err != nil {
+		return
+	}
+
+	rc, err = b.wrapped.NewReader(ctx, req)
+	return
+}
+
+func (b *chaosBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	err = b.inject(ctx, "CreateObject", req.Name)
+	if err != nil {
+		return
+	}
+
+	o, err = b.wrapped.CreateObject(ctx, req)
+	return
+}
+
+func (b *chaosBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.wrapped.CopyObject(ctx, req)
+	return
+}
+
+func (b *chaosBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	o, err = b.wrapped.ComposeObjects(ctx, req)
+	return
+}
+
+func (b *chaosBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	err = b.inject(ctx, "StatObject", req.Name)
+	if err != nil {
+		return
+	}
+
+	o, err = b.wrapped.StatObject(ctx, req)
+	return
+}
+
+func (b *chaosBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (l *gcs.Listing, err error) {
+	l, err = b.wrapped.ListObjects(ctx, req)
+	return
+}
+
+func (b *chaosBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.wrapped.UpdateObject(ctx, req)
+	return
+}
+
+func (b *chaosBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	err = b.wrapped.DeleteObject(ctx, req)
+	return
+}
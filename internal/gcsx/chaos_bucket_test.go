@@ -0,0 +1,163 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func newChaosTestBucket(t *testing.T, cfg gcsx.ChaosConfig) gcs.Bucket {
+	wrapped := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+	for _, name := range []string{"foo", "bar"} {
+		_, err := gcsutil.CreateObject(
+			context.Background(),
+			wrapped,
+			name,
+			[]byte("taco"))
+
+		if err != nil {
+			t.Fatalf("CreateObject: %v", err)
+		}
+	}
+
+	return gcsx.NewChaosBucket(cfg, wrapped)
+}
+
+// Stat the named object, retrying transient errors up to ten times. Return the
+// number of attempts made.
+func statWithRetry(
+	bucket gcs.Bucket,
+	name string) (attempts int, err error) {
+	for attempts < 10 {
+		attempts++
+		_, err = bucket.StatObject(
+			context.Background(),
+			&gcs.StatObjectRequest{Name: name})
+
+		if _, ok := err.(*gcsx.TransientError); !ok {
+			return
+		}
+	}
+
+	return
+}
+
+func TestChaosBucket_FirstFailure(t *testing.T) {
+	bucket := newChaosTestBucket(t, gcsx.ChaosConfig{
+		Seed:             17,
+		FirstFailureRate: 1,
+	})
+
+	// Each name should fail exactly once, then succeed.
+	for _, name := range []string{"foo", "bar"} {
+		attempts, err := statWithRetry(bucket, name)
+		if err != nil {
+			t.Fatalf("statWithRetry(%q): %v", name, err)
+		}
+
+		if attempts != 2 {
+			t.Errorf("%q took %d attempts, want 2", name, attempts)
+		}
+	}
+
+	// Later calls should succeed first time.
+	attempts, err := statWithRetry(bucket, "foo")
+	if err != nil || attempts != 1 {
+		t.Errorf("Got %d attempts and error %v", attempts, err)
+	}
+}
+
+func TestChaosBucket_SameSeedSameDecisions(t *testing.T) {
+	cfg := gcsx.ChaosConfig{
+		Seed:        17,
+		FailureRate: 0.5,
+	}
+
+	// Record which calls fail for two buckets with the same config.
+	outcomes := func() (failed []bool) {
+		bucket := newChaosTestBucket(t, cfg)
+		for i := 0; i < 32; i++ {
+			_, err := bucket.StatObject(
+				context.Background(),
+				&gcs.StatObjectRequest{Name: "foo"})
+
+			failed = append(failed, err != nil)
+		}
+
+		return
+	}
+
+	a := outcomes()
+	b := outcomes()
+
+	var failures int
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("Outcomes differ at call %d", i)
+		}
+
+		if a[i] {
+			failures++
+		}
+	}
+
+	if failures == 0 || failures == len(a) {
+		t.Errorf("Expected a mix of outcomes, got %d failures", failures)
+	}
+}
+
+func TestChaosBucket_Latency(t *testing.T) {
+	var clock gcsx.SimulatedClock
+	bucket := newChaosTestBucket(t, gcsx.ChaosConfig{
+		MaxLatency: time.Minute,
+		Clock:      &clock,
+	})
+
+	// Start a call. It shouldn't complete until the clock advances.
+	done := make(chan error, 1)
+	go func() {
+		_, err := bucket.StatObject(
+			context.Background(),
+			&gcs.StatObjectRequest{Name: "foo"})
+
+		done <- err
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("Call completed without waiting")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.AdvanceTime(time.Minute)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("StatObject: %v", err)
+		}
+
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for call to complete")
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+// NewClassifyingBucket creates a wrapper bucket that passes all errors
+// returned by the wrapped bucket (including those returned while reading
+// object contents) through ClassifyError, so that callers can switch on error
+// types rather than inspecting error strings.
+//
+// In addition, a ranged read refused with 416 (Range Not Satisfiable) or 501
+// (Not Implemented) becomes *RangeUnsupportedError. Callers never ask for
+// ranges beyond the generation they name, so these indicate a backend that
+// can't serve ranges.
+func NewClassifyingBucket(b gcs.Bucket) gcs.Bucket {
+	return classifyingBucket{b}
+}
+
+type classifyingBucket struct {
+	wrapped gcs.Bucket
+}
+
+func (b classifyingBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+func (b classifyingBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	rc, err = b.wrapped.NewReader(ctx, req)
+	if err != nil {
+		err = classifyReadError(req, err)
+		return
+	}
+
+	rc = &classifyingReader{wrapped: rc}
+	return
+}
+
+func classifyReadError(req *gcs.ReadObjectRequest, err error) error {
+	if typed, ok := err.(*googleapi.Error); ok && req.Range != nil {
+		switch typed.Code {
+		case http.StatusRequestedRangeNotSatisfiable, http.StatusNotImplemented:
+			return &RangeUnsupportedError{Name: req.Name, Err: typed}
+		}
+	}
+
+	return ClassifyError(err)
+}
+
+func (b classifyingBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.wrapped.CreateObject(ctx, req)
+	err = ClassifyError(err)
+	return
+}
+
+func (b classifyingBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.wrapped.CopyObject(ctx, req)
+	err = ClassifyError(err)
+	return
+}
+
+func (b classifyingBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	o, err = b.wrapped.ComposeObjects(ctx, req)
+	err = ClassifyError(err)
+	return
+}
+
+func (b classifyingBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.wrapped.StatObject(ctx, req)
+	err = ClassifyError(err)
+	return
+}
+
+func (b classifyingBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (l *gcs.Listing, err error) {
+	l, err = b.wrapped.ListObjects(ctx, req)
+	err = ClassifyError(err)
+	return
+}
+
+func (b classifyingBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.wrapped.UpdateObject(ctx, req)
+	err = ClassifyError(err)
+	return
+}
+
+func (b classifyingBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	err = b.wrapped.DeleteObject(ctx, req)
+	err = ClassifyError(err)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// classifyingReader
+////////////////////////////////////////////////////////////////////////
+
+type classifyingReader struct {
+	wrapped io.ReadCloser
+}
+
+func (rc *classifyingReader) Read(p []byte) (n int, err error) {
+	n, err = rc.wrapped.Read(p)
+	err = ClassifyError(err)
+	return
+}
+
+func (rc *classifyingReader) Close() (err error) {
+	err = rc.wrapped.Close()
+	err = ClassifyError(err)
+	return
+}
@@ -0,0 +1,197 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+// A bucket that returns the supplied error from each method, and readers that
+// fail with the error after returning some data.
+type errorBucket struct {
+	gcs.Bucket
+	err error
+}
+
+func (b errorBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	rc = ioutil.NopCloser(io.MultiReader(
+		strings.NewReader("taco"),
+		iotest.ErrReader(b.err)))
+
+	return
+}
+
+func (b errorBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	err = b.err
+	return
+}
+
+func (b errorBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	err = b.err
+	return
+}
+
+// A bucket whose readers fail to open with the supplied error.
+type openErrorBucket struct {
+	gcs.Bucket
+	err error
+}
+
+func (b openErrorBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	err = b.err
+	return
+}
+
+func TestClassifyingBucket_StatObject(t *testing.T) {
+	bucket := gcsx.NewClassifyingBucket(
+		errorBucket{err: &googleapi.Error{Code: 429}})
+
+	_, err := bucket.StatObject(
+		context.Background(),
+		&gcs.StatObjectRequest{Name: "foo"})
+
+	if _, ok := err.(*gcsx.ThrottledError); !ok {
+		t.Errorf("Unexpected error: %#v", err)
+	}
+}
+
+func TestClassifyingBucket_CreateObject(t *testing.T) {
+	bucket := gcsx.NewClassifyingBucket(
+		errorBucket{err: &googleapi.Error{Code: 412}})
+
+	_, err := bucket.CreateObject(
+		context.Background(),
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader(""),
+		})
+
+	if _, ok := err.(*gcs.PreconditionError); !ok {
+		t.Errorf("Unexpected error: %#v", err)
+	}
+}
+
+func TestClassifyingBucket_ReadError(t *testing.T) {
+	bucket := gcsx.NewClassifyingBucket(
+		errorBucket{err: &googleapi.Error{Code: 503}})
+
+	rc, err := bucket.NewReader(
+		context.Background(),
+		&gcs.ReadObjectRequest{Name: "foo"})
+
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+
+	defer rc.Close()
+
+	contents, err := ioutil.ReadAll(rc)
+	if _, ok := err.(*gcsx.TransientError); !ok {
+		t.Errorf("Unexpected error: %#v", err)
+	}
+
+	if got, want := string(contents), "taco"; got != want {
+		t.Errorf("Contents are %q, want %q", got, want)
+	}
+}
+
+func TestClassifyingBucket_RangeUnsupported(t *testing.T) {
+	testCases := []struct {
+		code      int
+		ranged    bool
+		supported bool
+	}{
+		{416, true, false},
+		{501, true, false},
+		{416, false, true},
+		{501, false, true},
+		{400, true, true},
+	}
+
+	for _, tc := range testCases {
+		bucket := gcsx.NewClassifyingBucket(
+			openErrorBucket{err: &googleapi.Error{Code: tc.code}})
+
+		req := &gcs.ReadObjectRequest{Name: "foo"}
+		if tc.ranged {
+			req.Range = &gcs.ByteRange{Start: 1, Limit: 3}
+		}
+
+		_, err := bucket.NewReader(context.Background(), req)
+		_, ok := err.(*gcsx.RangeUnsupportedError)
+		if ok == tc.supported {
+			t.Errorf("%d (ranged: %v): unexpected error: %#v", tc.code, tc.ranged, err)
+		}
+	}
+}
+
+func TestClassifyingBucket_PassesThroughSuccess(t *testing.T) {
+	ctx := context.Background()
+	bucket := gcsx.NewClassifyingBucket(
+		gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"))
+
+	// Create an object.
+	_, err := bucket.CreateObject(ctx, &gcs.CreateObjectRequest{
+		Name:     "foo",
+		Contents: strings.NewReader("taco"),
+	})
+
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	// Read it back, checking that io.EOF is propagated as-is.
+	rc, err := bucket.NewReader(ctx, &gcs.ReadObjectRequest{Name: "foo"})
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+
+	defer rc.Close()
+
+	buf := make([]byte, 16)
+	n, err := io.ReadFull(rc, buf)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadFull returned error %v", err)
+	}
+
+	if got, want := string(buf[:n]), "taco"; got != want {
+		t.Errorf("Contents are %q, want %q", got, want)
+	}
+
+	// A missing object should give a not found error.
+	_, err = bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: "bar"})
+	if _, ok := err.(*gcs.NotFoundError); !ok {
+		t.Errorf("Unexpected error: %#v", err)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jacobsa/timeutil"
+)
+
+// A clock that can also wait for time to pass, for use by features that must
+// act once an interval elapses (background syncing, retry backoff, and so on)
+// and that tests must be able to drive deterministically.
+type Clock interface {
+	timeutil.Clock
+
+	// Return a channel that receives the current time once at least d has
+	// passed, like time.After.
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct {
+	timeutil.Clock
+}
+
+func (c realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Return a clock that follows the system clock.
+func RealClock() Clock {
+	return realClock{timeutil.RealClock()}
+}
+
+// A clock that reports a time set by the user, firing channels returned by
+// After only when the time is advanced past their deadlines. Safe for
+// concurrent access. The zero value has the zero time.
+type SimulatedClock struct {
+	mu sync.Mutex
+
+	// GUARDED_BY(mu)
+	t time.Time
+
+	// Channels waiting for the time to reach their deadlines.
+	//
+	// GUARDED_BY(mu)
+	waiters []simulatedWaiter
+}
+
+type simulatedWaiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+func (sc *SimulatedClock) Now() time.Time {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return sc.t
+}
+
+func (sc *SimulatedClock) After(d time.Duration) <-chan time.Time {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	c := make(chan time.Time, 1)
+	sc.waiters = append(sc.waiters, simulatedWaiter{sc.t.Add(d), c})
+	sc.fireWaiters()
+
+	return c
+}
+
+// Set the current time according to the clock, firing any channels whose
+// deadlines have been reached.
+func (sc *SimulatedClock) SetTime(t time.Time) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.t = t
+	sc.fireWaiters()
+}
+
+// Advance the current time according to the clock by the supplied duration,
+// firing any channels whose deadlines have been reached.
+func (sc *SimulatedClock) AdvanceTime(d time.Duration) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.t = sc.t.Add(d)
+	sc.fireWaiters()
+}
+
+// LOCKS_REQUIRED(sc.mu)
+func (sc *SimulatedClock) fireWaiters() {
+	var remaining []simulatedWaiter
+	for _, w := range sc.waiters {
+		if sc.t.Before(w.deadline) {
+			remaining = append(remaining, w)
+			continue
+		}
+
+		w.c <- sc.t
+	}
+
+	sc.waiters = remaining
+}
@@ -0,0 +1,60 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+)
+
+func TestSimulatedClock_After(t *testing.T) {
+	var clock gcsx.SimulatedClock
+	start := time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local)
+	clock.SetTime(start)
+
+	c := clock.After(time.Minute)
+
+	// Nothing should arrive until the deadline.
+	clock.AdvanceTime(time.Minute - time.Second)
+	select {
+	case <-c:
+		t.Fatalf("Channel fired early")
+	default:
+	}
+
+	// Once it has been reached, the current time should arrive.
+	clock.AdvanceTime(time.Second)
+	select {
+	case now := <-c:
+		if want := start.Add(time.Minute); !now.Equal(want) {
+			t.Errorf("Received %v, want %v", now, want)
+		}
+
+	default:
+		t.Fatalf("Channel didn't fire")
+	}
+}
+
+func TestSimulatedClock_AfterNonPositive(t *testing.T) {
+	var clock gcsx.SimulatedClock
+
+	select {
+	case <-clock.After(0):
+	default:
+		t.Fatalf("Channel didn't fire")
+	}
+}
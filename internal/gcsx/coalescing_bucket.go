@@ -0,0 +1,356 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Create a bucket that merges ranged NewReader calls for the same object
+// generation that arrive within the given window of each other and that are
+// adjacent or overlapping, issuing a single request to the wrapped bucket for
+// the lot. This suits scatter-gather reads from the kernel, which otherwise
+// cost a round trip each.
+//
+// Each merged request is read into memory and handed out in pieces, so only
+// ranges of at most maxBytes are coalesced, and batches don't grow beyond
+// that. Other calls pass straight through, as do all calls for methods other
+// than NewReader.
+//
+// The merged request is made with a background context, so that one caller
+// giving up doesn't fail the others; each caller still stops waiting when its
+// own context is cancelled.
+func NewCoalescingBucket(
+	window time.Duration,
+	maxBytes int64,
+	wrapped gcs.Bucket) gcs.Bucket {
+	return &coalescingBucket{
+		window:   window,
+		maxBytes: uint64(maxBytes),
+		wrapped:  wrapped,
+		open:     make(map[coalesceKey][]*readBatch),
+	}
+}
+
+type coalescingBucket struct {
+	window   time.Duration
+	maxBytes uint64
+	wrapped  gcs.Bucket
+
+	mu sync.Mutex
+
+	// Batches that have not yet been sent, and so can accept more ranges.
+	//
+	// GUARDED_BY(mu)
+	open map[coalesceKey][]*readBatch
+}
+
+type coalesceKey struct {
+	name       string
+	generation int64
+}
+
+// A set of merged reads from a single object generation.
+type readBatch struct {
+	// The merged range.
+	//
+	// GUARDED_BY(coalescingBucket.mu), until the batch is sent
+	start uint64
+	limit uint64
+
+	// Other batches that were merged into this one after growing to touch it,
+	// and which are completed along with it.
+	//
+	// GUARDED_BY(coalescingBucket.mu), until the batch is sent
+	absorbed []*readBatch
+
+	// Closed once the request has completed, at which point data holds the
+	// contents starting at start (which may be short if the object is) and err
+	// holds any error.
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// Return the range covering both the batch and r, and whether it's small
+// enough to be read as one.
+func (b *coalescingBucket) merge(
+	batch *readBatch,
+	r gcs.ByteRange) (merged gcs.ByteRange, ok bool) {
+	if r.Start > batch.limit || r.Limit < batch.start {
+		return
+	}
+
+	merged.Start = minUint64(batch.start, r.Start)
+	merged.Limit = maxUint64(batch.limit, r.Limit)
+	ok = merged.Limit-merged.Start <= b.maxBytes
+
+	return
+}
+
+// Fold any other open batches that now touch the given one into it.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *coalescingBucket) absorb(key coalesceKey, batch *readBatch) {
+	for changed := true; changed; {
+		changed = false
+
+		var remaining []*readBatch
+		for _, other := range b.open[key] {
+			if other == batch {
+				remaining = append(remaining, other)
+				continue
+			}
+
+			merged, ok := b.merge(batch, gcs.ByteRange{Start: other.start, Limit: other.limit})
+			if !ok {
+				remaining = append(remaining, other)
+				continue
+			}
+
+			batch.start = merged.Start
+			batch.limit = merged.Limit
+			batch.absorbed = append(batch.absorbed, other)
+			changed = true
+		}
+
+		b.open[key] = remaining
+	}
+}
+
+// Find or create an open batch covering the supplied range.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *coalescingBucket) join(
+	key coalesceKey,
+	r gcs.ByteRange) (batch *readBatch) {
+	for _, candidate := range b.open[key] {
+		merged, ok := b.merge(candidate, r)
+		if !ok {
+			continue
+		}
+
+		candidate.start = merged.Start
+		candidate.limit = merged.Limit
+		b.absorb(key, candidate)
+
+		batch = candidate
+		return
+	}
+
+	// Start a new batch, to be sent once the window has passed.
+	batch = &readBatch{
+		start: r.Start,
+		limit: r.Limit,
+		done:  make(chan struct{}),
+	}
+
+	b.open[key] = append(b.open[key], batch)
+	time.AfterFunc(b.window, func() { b.send(key, batch) })
+
+	return
+}
+
+// Stop accepting new ranges for the batch, and read its contents.
+//
+// LOCKS_EXCLUDED(b.mu)
+func (b *coalescingBucket) send(key coalesceKey, batch *readBatch) {
+	b.mu.Lock()
+
+	var found bool
+	var remaining []*readBatch
+	for _, other := range b.open[key] {
+		if other == batch {
+			found = true
+		} else {
+			remaining = append(remaining, other)
+		}
+	}
+
+	// If the batch isn't open, it has been absorbed by another and will be
+	// completed along with it.
+	if !found {
+		b.mu.Unlock()
+		return
+	}
+
+	if len(remaining) == 0 {
+		delete(b.open, key)
+	} else {
+		b.open[key] = remaining
+	}
+
+	r := gcs.ByteRange{Start: batch.start, Limit: batch.limit}
+	b.mu.Unlock()
+
+	// Read the merged range.
+	defer batch.complete()
+
+	rc, err := b.wrapped.NewReader(
+		context.Background(),
+		&gcs.ReadObjectRequest{
+			Name:       key.name,
+			Generation: key.generation,
+			Range:      &r,
+		})
+
+	if err != nil {
+		batch.err = err
+		return
+	}
+
+	defer rc.Close()
+
+	batch.data, err = ioutil.ReadAll(rc)
+	if err != nil {
+		batch.err = fmt.Errorf("ReadAll: %v", err)
+		return
+	}
+}
+
+// Hand the batch's result to the batches it absorbed, then wake up everyone
+// waiting on any of them.
+//
+// REQUIRES: The batch has been sent and its request has finished.
+func (batch *readBatch) complete() {
+	for _, child := range batch.absorbed {
+		child.err = batch.err
+		if child.err == nil {
+			end := uint64(len(batch.data))
+			lo := minUint64(child.start-batch.start, end)
+			hi := minUint64(child.limit-batch.start, end)
+			child.data = batch.data[lo:hi]
+		}
+
+		child.complete()
+	}
+
+	close(batch.done)
+}
+
+func (b *coalescingBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+func (b *coalescingBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	// Pass through anything we don't coalesce.
+	r := req.Range
+	if r == nil || r.Limit <= r.Start || r.Limit-r.Start > b.maxBytes {
+		rc, err = b.wrapped.NewReader(ctx, req)
+		return
+	}
+
+	// Join a batch and wait for it.
+	key := coalesceKey{req.Name, req.Generation}
+
+	b.mu.Lock()
+	batch := b.join(key, *r)
+	b.mu.Unlock()
+
+	select {
+	case <-batch.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	}
+
+	if batch.err != nil {
+		err = batch.err
+		return
+	}
+
+	// Hand out our piece, clipped to what was actually returned.
+	end := uint64(len(batch.data))
+	lo := minUint64(r.Start-batch.start, end)
+	hi := minUint64(r.Limit-batch.start, end)
+	rc = ioutil.NopCloser(bytes.NewReader(batch.data[lo:hi]))
+
+	return
+}
+
+func (b *coalescingBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.wrapped.CreateObject(ctx, req)
+	return
+}
+
+func (b *coalescingBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.wrapped.CopyObject(ctx, req)
+	return
+}
+
+func (b *coalescingBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	o, err = b.wrapped.ComposeObjects(ctx, req)
+	return
+}
+
+func (b *coalescingBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.wrapped.StatObject(ctx, req)
+	return
+}
+
+func (b *coalescingBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (l *gcs.Listing, err error) {
+	l, err = b.wrapped.ListObjects(ctx, req)
+	return
+}
+
+func (b *coalescingBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.wrapped.UpdateObject(ctx, req)
+	return
+}
+
+func (b *coalescingBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	err = b.wrapped.DeleteObject(ctx, req)
+	return
+}
+
+func minUint64(a uint64, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func maxUint64(a uint64, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,178 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// A bucket that records the ranges passed to NewReader.
+type rangeRecordingBucket struct {
+	gcs.Bucket
+
+	mu     sync.Mutex
+	ranges []gcs.ByteRange
+}
+
+func (b *rangeRecordingBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	b.mu.Lock()
+	if req.Range != nil {
+		b.ranges = append(b.ranges, *req.Range)
+	}
+	b.mu.Unlock()
+
+	rc, err = b.Bucket.NewReader(ctx, req)
+	return
+}
+
+func (b *rangeRecordingBucket) recorded() (ranges []gcs.ByteRange) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ranges = append(ranges, b.ranges...)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	return
+}
+
+const coalesceContents = "abcdefghijklmnopqrstuvwxyz0123456789ABCD"
+
+func newCoalescingTestBucket(
+	t *testing.T,
+	maxBytes int64) (bucket gcs.Bucket, wrapped *rangeRecordingBucket, o *gcs.Object) {
+	fake := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+	o, err := gcsutil.CreateObject(
+		context.Background(),
+		fake,
+		"foo",
+		[]byte(coalesceContents))
+
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	wrapped = &rangeRecordingBucket{Bucket: fake}
+	bucket = gcsx.NewCoalescingBucket(100*time.Millisecond, maxBytes, wrapped)
+	return
+}
+
+// Read the given ranges concurrently, checking that each gets the right bytes.
+func readConcurrently(
+	t *testing.T,
+	bucket gcs.Bucket,
+	o *gcs.Object,
+	ranges []gcs.ByteRange) {
+	var wg sync.WaitGroup
+	for _, r := range ranges {
+		wg.Add(1)
+		go func(r gcs.ByteRange) {
+			defer wg.Done()
+
+			rc, err := bucket.NewReader(
+				context.Background(),
+				&gcs.ReadObjectRequest{
+					Name:       o.Name,
+					Generation: o.Generation,
+					Range:      &r,
+				})
+
+			if err != nil {
+				t.Errorf("NewReader(%v): %v", r, err)
+				return
+			}
+
+			defer rc.Close()
+
+			contents, err := ioutil.ReadAll(rc)
+			if err != nil {
+				t.Errorf("ReadAll(%v): %v", r, err)
+				return
+			}
+
+			limit := r.Limit
+			if limit > uint64(len(coalesceContents)) {
+				limit = uint64(len(coalesceContents))
+			}
+
+			if got, want := string(contents), coalesceContents[r.Start:limit]; got != want {
+				t.Errorf("Range %v: got %q, want %q", r, got, want)
+			}
+		}(r)
+	}
+
+	wg.Wait()
+}
+
+func TestCoalescingBucket_MergesAdjacentReads(t *testing.T) {
+	bucket, wrapped, o := newCoalescingTestBucket(t, 1<<20)
+
+	readConcurrently(t, bucket, o, []gcs.ByteRange{
+		{Start: 0, Limit: 10},
+		{Start: 10, Limit: 20},
+		{Start: 15, Limit: 30},
+		{Start: 30, Limit: 50},
+	})
+
+	ranges := wrapped.recorded()
+	if len(ranges) != 1 {
+		t.Fatalf("Expected a single merged read, got %v", ranges)
+	}
+
+	if ranges[0].Start != 0 || ranges[0].Limit != 50 {
+		t.Errorf("Unexpected merged range: %v", ranges[0])
+	}
+}
+
+func TestCoalescingBucket_DoesntMergeDisjointReads(t *testing.T) {
+	bucket, wrapped, o := newCoalescingTestBucket(t, 1<<20)
+
+	readConcurrently(t, bucket, o, []gcs.ByteRange{
+		{Start: 0, Limit: 10},
+		{Start: 20, Limit: 30},
+	})
+
+	if ranges := wrapped.recorded(); len(ranges) != 2 {
+		t.Errorf("Expected two reads, got %v", ranges)
+	}
+}
+
+func TestCoalescingBucket_RespectsMaxBytes(t *testing.T) {
+	bucket, wrapped, o := newCoalescingTestBucket(t, 15)
+
+	readConcurrently(t, bucket, o, []gcs.ByteRange{
+		{Start: 0, Limit: 10},
+		{Start: 10, Limit: 20},
+		{Start: 20, Limit: 40},
+	})
+
+	// The first two can't be merged without exceeding the limit, and the last
+	// is too large to be coalesced at all.
+	if ranges := wrapped.recorded(); len(ranges) != 3 {
+		t.Errorf("Expected three reads, got %v", ranges)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Create an empty placeholder object for the "directory" with the given name,
+// e.g. "foo/", unless a file object with the same name minus the slash ("foo")
+// exists, in which case return *ConflictError. If the placeholder itself
+// already exists, return *AlreadyExistsError.
+//
+// As with CreateObjectIfParentExists, GCS can't make the check and the
+// creation a single operation, so a conflicting file created concurrently
+// isn't noticed.
+//
+// REQUIRES: strings.HasSuffix(name, "/")
+func CreateDirObject(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	name string) (o *gcs.Object, err error) {
+	if !strings.HasSuffix(name, "/") {
+		panic(fmt.Sprintf("Not a directory name: %q", name))
+	}
+
+	// Is there a file in the way?
+	fileName := strings.TrimSuffix(name, "/")
+	_, err = StatObject(ctx, bucket, &gcs.StatObjectRequest{Name: fileName})
+	switch err.(type) {
+	case nil:
+		err = &ConflictError{
+			Name:        name,
+			Conflicting: fileName,
+		}
+
+		return
+
+	case *gcs.NotFoundError, *SoftDeletedError:
+		err = nil
+
+	default:
+		err = fmt.Errorf("StatObject: %v", err)
+		return
+	}
+
+	// Create the placeholder, if it doesn't already exist.
+	o, err = CreateObjectIfAbsent(
+		ctx,
+		bucket,
+		&gcs.CreateObjectRequest{
+			Name:     name,
+			Contents: strings.NewReader(""),
+		})
+
+	if _, ok := err.(*AlreadyExistsError); ok {
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("CreateObjectIfAbsent: %v", err)
+		return
+	}
+
+	return
+}
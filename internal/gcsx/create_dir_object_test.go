@@ -0,0 +1,80 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestCreateDirObject(t *testing.T) {
+	ctx := context.Background()
+	bucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	// Objects that share a prefix with the name don't conflict.
+	for _, name := range []string{"foobar", "foo/bar"} {
+		_, err := gcsutil.CreateObject(ctx, bucket, name, []byte("taco"))
+		if err != nil {
+			t.Fatalf("CreateObject: %v", err)
+		}
+	}
+
+	o, err := gcsx.CreateDirObject(ctx, bucket, "foo/")
+	if err != nil {
+		t.Fatalf("CreateDirObject: %v", err)
+	}
+
+	if o.Name != "foo/" || o.Size != 0 {
+		t.Errorf("Unexpected object: %#v", o)
+	}
+
+	// Creating it again should fail.
+	_, err = gcsx.CreateDirObject(ctx, bucket, "foo/")
+	if _, ok := err.(*gcsx.AlreadyExistsError); !ok {
+		t.Errorf("Got error %v, want AlreadyExistsError", err)
+	}
+}
+
+func TestCreateDirObject_Conflict(t *testing.T) {
+	ctx := context.Background()
+	bucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	_, err := gcsutil.CreateObject(ctx, bucket, "dir/foo", []byte("taco"))
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	_, err = gcsx.CreateDirObject(ctx, bucket, "dir/foo/")
+	ce, ok := err.(*gcsx.ConflictError)
+	if !ok {
+		t.Fatalf("Got error %v, want ConflictError", err)
+	}
+
+	if ce.Name != "dir/foo/" || ce.Conflicting != "dir/foo" {
+		t.Errorf("Unexpected error: %#v", ce)
+	}
+
+	// Nothing should have been created.
+	_, err = bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: "dir/foo/"})
+	if _, ok := err.(*gcs.NotFoundError); !ok {
+		t.Errorf("Got error %v, want NotFoundError", err)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Create an object according to the supplied request, but only if no object
+// with the name already exists. If one does, return *AlreadyExistsError.
+//
+// This is what a generation precondition of zero means to GCS, but spelling
+// it out here means callers needn't know that, and lets them distinguish the
+// failure from other precondition errors.
+//
+// REQUIRES: req.GenerationPrecondition == nil
+func CreateObjectIfAbsent(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	if req.GenerationPrecondition != nil {
+		panic(fmt.Sprintf(
+			"Unexpected generation precondition: %d",
+			*req.GenerationPrecondition))
+	}
+
+	// Don't modify the caller's request.
+	var precond int64
+	reqCopy := *req
+	reqCopy.GenerationPrecondition = &precond
+
+	o, err = bucket.CreateObject(ctx, &reqCopy)
+	if pe, ok := err.(*gcs.PreconditionError); ok {
+		err = &AlreadyExistsError{
+			Name: req.Name,
+			Err:  pe,
+		}
+
+		return
+	}
+
+	return
+}
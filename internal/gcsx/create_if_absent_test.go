@@ -0,0 +1,87 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestCreateObjectIfAbsent_Absent(t *testing.T) {
+	ctx := context.Background()
+	bucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	req := &gcs.CreateObjectRequest{
+		Name:     "foo",
+		Contents: strings.NewReader("taco"),
+	}
+
+	o, err := gcsx.CreateObjectIfAbsent(ctx, bucket, req)
+	if err != nil {
+		t.Fatalf("CreateObjectIfAbsent: %v", err)
+	}
+
+	if o.Name != "foo" {
+		t.Errorf("Unexpected name: %q", o.Name)
+	}
+
+	if req.GenerationPrecondition != nil {
+		t.Errorf("Request was modified")
+	}
+}
+
+func TestCreateObjectIfAbsent_AlreadyExists(t *testing.T) {
+	ctx := context.Background()
+	bucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	_, err := gcsutil.CreateObject(ctx, bucket, "foo", []byte("taco"))
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	_, err = gcsx.CreateObjectIfAbsent(
+		ctx,
+		bucket,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader("burrito"),
+		})
+
+	aee, ok := err.(*gcsx.AlreadyExistsError)
+	if !ok {
+		t.Fatalf("Unexpected error: %#v", err)
+	}
+
+	if aee.Name != "foo" {
+		t.Errorf("Unexpected name: %q", aee.Name)
+	}
+
+	// The existing object should be untouched.
+	contents, err := gcsutil.ReadObject(ctx, bucket, "foo")
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+
+	if got, want := string(contents), "taco"; got != want {
+		t.Errorf("Contents are %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Create an object according to the supplied request, but only if the
+// "directory" containing it exists, returning *NoParentError otherwise. The
+// parent of "foo/bar/baz" is "foo/bar/", which exists if there is a
+// placeholder object with that name or any object whose name begins with it.
+// Names without a slash live at the root of the bucket, which always exists.
+//
+// GCS has no way to make the check and the creation a single operation, so
+// this can't prevent the parent from being removed concurrently. It does
+// guarantee that the parent existed at some point before the object was
+// created.
+func CreateObjectIfParentExists(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	i := strings.LastIndex(req.Name, "/")
+	if i >= 0 {
+		parent := req.Name[:i+1]
+
+		var exists bool
+		exists, err = prefixExists(ctx, bucket, parent)
+		if err != nil {
+			err = fmt.Errorf("prefixExists: %v", err)
+			return
+		}
+
+		if !exists {
+			err = &NoParentError{
+				Name:   req.Name,
+				Parent: parent,
+			}
+
+			return
+		}
+	}
+
+	o, err = bucket.CreateObject(ctx, req)
+	return
+}
+
+// Is there a placeholder object with the given name, or failing that any
+// object at all under it?
+func prefixExists(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	prefix string) (exists bool, err error) {
+	_, err = StatObject(ctx, bucket, &gcs.StatObjectRequest{Name: prefix})
+	switch err.(type) {
+	case nil:
+		exists = true
+		return
+
+	case *gcs.NotFoundError, *SoftDeletedError:
+		err = nil
+
+	default:
+		err = fmt.Errorf("StatObject: %v", err)
+		return
+	}
+
+	listing, err := bucket.ListObjects(
+		ctx,
+		&gcs.ListObjectsRequest{
+			Prefix:     prefix,
+			MaxResults: 1,
+		})
+
+	if err != nil {
+		err = fmt.Errorf("ListObjects: %v", err)
+		return
+	}
+
+	exists = len(listing.Objects) > 0
+	return
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestCreateObjectIfParentExists_WithParent(t *testing.T) {
+	ctx := context.Background()
+	bucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	for _, name := range []string{"dir/", "implicit/sub/foo"} {
+		_, err := gcsutil.CreateObject(ctx, bucket, name, []byte(""))
+		if err != nil {
+			t.Fatalf("CreateObject: %v", err)
+		}
+	}
+
+	// A placeholder object, an implicit directory, and the root all count.
+	for _, name := range []string{"dir/foo", "implicit/bar", "foo"} {
+		o, err := gcsx.CreateObjectIfParentExists(
+			ctx,
+			bucket,
+			&gcs.CreateObjectRequest{
+				Name:     name,
+				Contents: strings.NewReader("taco"),
+			})
+
+		if err != nil {
+			t.Errorf("%s: CreateObjectIfParentExists: %v", name, err)
+			continue
+		}
+
+		if o.Name != name {
+			t.Errorf("%s: unexpected name: %q", name, o.Name)
+		}
+	}
+}
+
+func TestCreateObjectIfParentExists_WithoutParent(t *testing.T) {
+	ctx := context.Background()
+	bucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	// A sibling sharing a string prefix doesn't count.
+	_, err := gcsutil.CreateObject(ctx, bucket, "dirt", []byte(""))
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	_, err = gcsx.CreateObjectIfParentExists(
+		ctx,
+		bucket,
+		&gcs.CreateObjectRequest{
+			Name:     "dir/foo",
+			Contents: strings.NewReader("taco"),
+		})
+
+	npe, ok := err.(*gcsx.NoParentError)
+	if !ok {
+		t.Fatalf("Unexpected error: %#v", err)
+	}
+
+	if npe.Name != "dir/foo" || npe.Parent != "dir/" {
+		t.Errorf("Unexpected error contents: %#v", npe)
+	}
+
+	// Nothing should have been created.
+	_, err = bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: "dir/foo"})
+	if _, ok := err.(*gcs.NotFoundError); !ok {
+		t.Errorf("Unexpected StatObject error: %v", err)
+	}
+}
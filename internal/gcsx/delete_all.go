@@ -0,0 +1,69 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"sync"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// The number of DeleteObject calls DeleteAll makes at once.
+const deleteAllWorkers = 16
+
+// Delete the latest generations of the named objects, using some parallelism.
+// Return a map with an entry for each name that could not be deleted, which is
+// empty if all went well. Names that don't exist count as deleted.
+func DeleteAll(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	names []string) (failures map[string]error) {
+	failures = make(map[string]error)
+
+	// Feed names to the workers.
+	todo := make(chan string)
+	go func() {
+		defer close(todo)
+		for _, name := range names {
+			todo <- name
+		}
+	}()
+
+	// Delete, recording failures.
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < deleteAllWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range todo {
+				err := bucket.DeleteObject(ctx, &gcs.DeleteObjectRequest{Name: name})
+				if _, ok := err.(*gcs.NotFoundError); ok {
+					err = nil
+				}
+
+				if err != nil {
+					mu.Lock()
+					failures[name] = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	return
+}
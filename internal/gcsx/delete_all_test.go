@@ -0,0 +1,134 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// A bucket that fails to delete particular names, and returns not found
+// errors for missing objects.
+type failingDeleteBucket struct {
+	gcs.Bucket
+	errs map[string]error
+}
+
+func (b *failingDeleteBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	if err = b.errs[req.Name]; err != nil {
+		return
+	}
+
+	_, err = b.Bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: req.Name})
+	if err != nil {
+		return
+	}
+
+	err = b.Bucket.DeleteObject(ctx, req)
+	return
+}
+
+func newDeleteAllTestBucket(
+	t *testing.T,
+	names []string) *failingDeleteBucket {
+	bucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+	for _, name := range names {
+		_, err := gcsutil.CreateObject(
+			context.Background(),
+			bucket,
+			name,
+			[]byte("taco"))
+
+		if err != nil {
+			t.Fatalf("CreateObject: %v", err)
+		}
+	}
+
+	return &failingDeleteBucket{Bucket: bucket}
+}
+
+func TestDeleteAll_AllSucceed(t *testing.T) {
+	var names []string
+	for i := 0; i < 100; i++ {
+		names = append(names, fmt.Sprintf("dir/%d", i))
+	}
+
+	bucket := newDeleteAllTestBucket(t, names)
+	failures := gcsx.DeleteAll(context.Background(), bucket, names)
+	if len(failures) != 0 {
+		t.Errorf("Unexpected failures: %v", failures)
+	}
+
+	for _, name := range names {
+		_, err := bucket.StatObject(
+			context.Background(),
+			&gcs.StatObjectRequest{Name: name})
+
+		if _, ok := err.(*gcs.NotFoundError); !ok {
+			t.Errorf("Unexpected error statting %q: %v", name, err)
+		}
+	}
+}
+
+func TestDeleteAll_NotFound(t *testing.T) {
+	bucket := newDeleteAllTestBucket(t, []string{"foo"})
+	failures := gcsx.DeleteAll(
+		context.Background(),
+		bucket,
+		[]string{"foo", "bar"})
+
+	if len(failures) != 0 {
+		t.Errorf("Unexpected failures: %v", failures)
+	}
+}
+
+func TestDeleteAll_PartialFailure(t *testing.T) {
+	bucket := newDeleteAllTestBucket(t, []string{"foo", "bar", "baz"})
+	someErr := errors.New("taco")
+	bucket.errs = map[string]error{"bar": someErr}
+
+	failures := gcsx.DeleteAll(
+		context.Background(),
+		bucket,
+		[]string{"foo", "bar", "baz", "qux"})
+
+	if len(failures) != 1 || failures["bar"] != someErr {
+		t.Errorf("Unexpected failures: %v", failures)
+	}
+
+	// The others should be gone, and the failure left alone.
+	remaining, _, err := gcsutil.ListAll(
+		context.Background(),
+		bucket,
+		&gcs.ListObjectsRequest{})
+
+	if err != nil {
+		t.Fatalf("ListAll: %v", err)
+	}
+
+	if len(remaining) != 1 || remaining[0].Name != "bar" {
+		t.Errorf("Unexpected remaining objects: %v", remaining)
+	}
+}
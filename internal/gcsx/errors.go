@@ -0,0 +1,391 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"google.golang.org/api/googleapi"
+)
+
+// An error indicating that GCS asked us to back off, e.g. with HTTP 429.
+type ThrottledError struct {
+	Err error
+}
+
+func (te *ThrottledError) Error() string {
+	return fmt.Sprintf("gcsx.ThrottledError: %v", te.Err)
+}
+
+// An error that is likely to go away if the request is retried, e.g. an HTTP
+// 5xx response.
+type TransientError struct {
+	Err error
+}
+
+func (te *TransientError) Error() string {
+	return fmt.Sprintf("gcsx.TransientError: %v", te.Err)
+}
+
+// An error indicating that the caller is not authorized to perform the
+// operation, e.g. an HTTP 401 or 403 response.
+type PermissionDeniedError struct {
+	Err error
+}
+
+func (pde *PermissionDeniedError) Error() string {
+	return fmt.Sprintf("gcsx.PermissionDeniedError: %v", pde.Err)
+}
+
+// An error returned by GCS that will not go away if the request is retried,
+// and that doesn't fall into one of the more specific categories.
+type PermanentError struct {
+	Err error
+}
+
+func (pe *PermanentError) Error() string {
+	return fmt.Sprintf("gcsx.PermanentError: %v", pe.Err)
+}
+
+// An error indicating that the generation of an object that we were reading
+// no longer exists, because the object has been overwritten or deleted since
+// we recorded it.
+type ClobberedError struct {
+	Name       string
+	Generation int64
+	Err        error
+}
+
+func (ce *ClobberedError) Error() string {
+	return fmt.Sprintf(
+		"gcsx.ClobberedError: %q generation %d: %v",
+		ce.Name,
+		ce.Generation,
+		ce.Err)
+}
+
+// An error indicating that GCS served a read of an object from a different
+// generation than the one asked for, e.g. because a backend that reports the
+// generation it served (see GenerationReader) resolved the read against the
+// live generation.
+type StaleReadError struct {
+	Name     string
+	Expected int64
+	Actual   int64
+}
+
+func (sre *StaleReadError) Error() string {
+	return fmt.Sprintf(
+		"gcsx.StaleReadError: %q: asked for generation %d, got %d",
+		sre.Name,
+		sre.Expected,
+		sre.Actual)
+}
+
+// An error indicating that the bucket itself doesn't exist or isn't visible to
+// us, as opposed to some object within it. Unlike *gcs.NotFoundError, this
+// affects every operation on the bucket.
+type BucketNotFoundError struct {
+	Err error
+}
+
+func (bnfe *BucketNotFoundError) Error() string {
+	return fmt.Sprintf("gcsx.BucketNotFoundError: %v", bnfe.Err)
+}
+
+// An error indicating that an object is absent but recoverable: GCS still
+// retains it in a soft-deleted state, and will do so until Deleted plus the
+// bucket's retention window. See StatObject.
+type SoftDeletedError struct {
+	Name       string
+	Generation int64
+	Deleted    time.Time
+}
+
+func (sde *SoftDeletedError) Error() string {
+	return fmt.Sprintf(
+		"gcsx.SoftDeletedError: %q generation %d deleted at %v",
+		sde.Name,
+		sde.Generation,
+		sde.Deleted)
+}
+
+// An error indicating that GCS returned a different number of bytes for an
+// object or range of an object than we expected, or recorded a different size
+// for an object than we uploaded.
+type SizeMismatchError struct {
+	Name     string
+	Expected int64
+
+	// The number of bytes received. When more than Expected, this is a lower
+	// bound; we don't read the excess.
+	Actual int64
+}
+
+func (sme *SizeMismatchError) Error() string {
+	return fmt.Sprintf(
+		"gcsx.SizeMismatchError: %q: expected %d bytes, received %d",
+		sme.Name,
+		sme.Expected,
+		sme.Actual)
+}
+
+// An error indicating that a backend refused a ranged read in a way that
+// suggests it can't serve byte ranges at all, as with some GCS-compatible
+// services. The object may still be readable in full.
+type RangeUnsupportedError struct {
+	Name string
+	Err  error
+}
+
+func (rue *RangeUnsupportedError) Error() string {
+	return fmt.Sprintf("gcsx.RangeUnsupportedError: %q: %v", rue.Name, rue.Err)
+}
+
+// An error indicating that the file system holding a temp file's contents
+// ran out of space, or of the user's quota on it, e.g. while writing to it or
+// faulting in its contents.
+type NoSpaceError struct {
+	Err error
+}
+
+func (nse *NoSpaceError) Error() string {
+	return fmt.Sprintf("gcsx.NoSpaceError: %v", nse.Err)
+}
+
+// An error indicating that an operation would modify a file that was opened
+// read-only.
+type ReadOnlyError struct {
+	Name string
+	Op   string
+}
+
+func (roe *ReadOnlyError) Error() string {
+	return fmt.Sprintf("gcsx.ReadOnlyError: %s of %q", roe.Op, roe.Name)
+}
+
+// An error indicating that an object couldn't be created because another
+// object exists whose name differs only by a trailing slash, so that the two
+// would be ambiguous in listings, e.g. a placeholder "foo/" and a file "foo".
+type ConflictError struct {
+	Name        string
+	Conflicting string
+}
+
+func (ce *ConflictError) Error() string {
+	return fmt.Sprintf(
+		"gcsx.ConflictError: %q conflicts with existing %q",
+		ce.Name,
+		ce.Conflicting)
+}
+
+// An error indicating that a read started strictly beyond the end of a file's
+// contents, as opposed to at the end.
+type OutOfRangeError struct {
+	Name   string
+	Offset int64
+	Size   int64
+}
+
+func (oore *OutOfRangeError) Error() string {
+	return fmt.Sprintf(
+		"gcsx.OutOfRangeError: %q: read at offset %d beyond size %d",
+		oore.Name,
+		oore.Offset,
+		oore.Size)
+}
+
+// An error indicating that GCS recorded fewer bytes for a new generation than
+// we uploaded, even after uploading it a second time. The generations written
+// remain in GCS.
+type TruncatedUploadError struct {
+	Name     string
+	Expected int64
+	Actual   int64
+}
+
+func (tue *TruncatedUploadError) Error() string {
+	return fmt.Sprintf(
+		"gcsx.TruncatedUploadError: %q: uploaded %d bytes, but only %d stored",
+		tue.Name,
+		tue.Expected,
+		tue.Actual)
+}
+
+// An error indicating that the CRC32C checksum of an object's contents didn't
+// match the expected one, e.g. the contents as received from GCS vs. the
+// checksum recorded for the object.
+type ChecksumMismatchError struct {
+	Name     string
+	Expected uint32
+	Actual   uint32
+}
+
+func (cme *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf(
+		"gcsx.ChecksumMismatchError: %q: expected CRC32C 0x%08x, got 0x%08x",
+		cme.Name,
+		cme.Expected,
+		cme.Actual)
+}
+
+// An error indicating that an object could not be created because one with
+// the same name already exists. See CreateObjectIfAbsent.
+type AlreadyExistsError struct {
+	Name string
+	Err  error
+}
+
+func (aee *AlreadyExistsError) Error() string {
+	return fmt.Sprintf("gcsx.AlreadyExistsError: %q: %v", aee.Name, aee.Err)
+}
+
+// An error indicating that an object was not written because an object it
+// depends on could not be written first. See inode.OrderedSyncGroup.
+type DependencyFailedError struct {
+	Name       string
+	Dependency string
+}
+
+func (dfe *DependencyFailedError) Error() string {
+	return fmt.Sprintf(
+		"gcsx.DependencyFailedError: %q: dependency %q was not written",
+		dfe.Name,
+		dfe.Dependency)
+}
+
+// An error indicating that an object could not be created because the
+// "directory" that would contain it doesn't exist. See
+// CreateObjectIfParentExists.
+type NoParentError struct {
+	Name   string
+	Parent string
+}
+
+func (npe *NoParentError) Error() string {
+	return fmt.Sprintf(
+		"gcsx.NoParentError: %q: parent %q does not exist",
+		npe.Name,
+		npe.Parent)
+}
+
+// An error indicating that an object could not be created because its name
+// collides with that of an existing object under case folding. See
+// NewCaseInsensitiveBucket.
+type CollisionError struct {
+	Name     string
+	Existing string
+}
+
+func (ce *CollisionError) Error() string {
+	return fmt.Sprintf(
+		"gcsx.CollisionError: %q collides with existing %q",
+		ce.Name,
+		ce.Existing)
+}
+
+// An error indicating that a mutation was applied to the primary bucket of a
+// mirroring bucket but not to the secondary. See NewMirroringBucket.
+type MirrorError struct {
+	Method string
+	Name   string
+	Err    error
+}
+
+func (me *MirrorError) Error() string {
+	return fmt.Sprintf(
+		"gcsx.MirrorError: %s(%q) on the secondary: %v",
+		me.Method,
+		me.Name,
+		me.Err)
+}
+
+// Map an error returned by a gcs.Bucket to a typed error according to the
+// HTTP status code it carries:
+//
+// *   404 becomes *BucketNotFoundError or *gcs.NotFoundError; see below.
+// *   412 becomes *gcs.PreconditionError.
+// *   429 becomes *ThrottledError.
+// *   5xx becomes *TransientError.
+// *   401 and 403 become *PermissionDeniedError.
+// *   Other codes become *PermanentError.
+//
+// A 404 is attributed to the bucket only if GCS says that it is the bucket
+// that doesn't exist.
+//
+// Errors that are already typed, and errors that don't carry a status code,
+// are returned unmodified. In particular, nil maps to nil.
+func ClassifyError(err error) error {
+	typed, ok := err.(*googleapi.Error)
+	if !ok {
+		return err
+	}
+
+	switch {
+	case typed.Code == http.StatusNotFound && isBucketNotFound(typed):
+		return &BucketNotFoundError{Err: typed}
+
+	case typed.Code == http.StatusNotFound:
+		return &gcs.NotFoundError{Err: typed}
+
+	case typed.Code == http.StatusPreconditionFailed:
+		return &gcs.PreconditionError{Err: typed}
+
+	case typed.Code == http.StatusTooManyRequests:
+		return &ThrottledError{Err: typed}
+
+	case typed.Code >= 500 && typed.Code < 600:
+		return &TransientError{Err: typed}
+
+	case typed.Code == http.StatusUnauthorized ||
+		typed.Code == http.StatusForbidden:
+		return &PermissionDeniedError{Err: typed}
+
+	default:
+		return &PermanentError{Err: typed}
+	}
+}
+
+// GCS doesn't use a distinct status or reason for a missing bucket, so we go
+// by the message, e.g. "The specified bucket does not exist."
+func isBucketNotFound(err *googleapi.Error) bool {
+	messages := []string{err.Message}
+	for _, item := range err.Errors {
+		messages = append(messages, item.Message)
+	}
+
+	for _, m := range messages {
+		if strings.Contains(strings.ToLower(m), "bucket does not exist") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Return true if the supplied error, as classified by ClassifyError, is worth
+// retrying.
+func IsRetryable(err error) bool {
+	switch ClassifyError(err).(type) {
+	case *ThrottledError, *TransientError:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,123 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"google.golang.org/api/googleapi"
+)
+
+func TestClassifyError(t *testing.T) {
+	notFound := &gcs.NotFoundError{Err: errors.New("taco")}
+	precondition := &gcs.PreconditionError{Err: errors.New("taco")}
+	other := errors.New("taco")
+
+	testCases := []struct {
+		in        error
+		expected  string // Type of result
+		retryable bool
+	}{
+		// Status codes
+		0: {&googleapi.Error{Code: 404}, "*gcs.NotFoundError", false},
+		1: {&googleapi.Error{Code: 412}, "*gcs.PreconditionError", false},
+		2: {&googleapi.Error{Code: 429}, "*gcsx.ThrottledError", true},
+		3: {&googleapi.Error{Code: 500}, "*gcsx.TransientError", true},
+		4: {&googleapi.Error{Code: 503}, "*gcsx.TransientError", true},
+		5: {&googleapi.Error{Code: 401}, "*gcsx.PermissionDeniedError", false},
+		6: {&googleapi.Error{Code: 403}, "*gcsx.PermissionDeniedError", false},
+		7: {&googleapi.Error{Code: 400}, "*gcsx.PermanentError", false},
+		8: {&googleapi.Error{Code: 409}, "*gcsx.PermanentError", false},
+		9: {&googleapi.Error{Code: 600}, "*gcsx.PermanentError", false},
+
+		// Missing buckets vs. missing objects
+		10: {
+			&googleapi.Error{Code: 404, Message: "The specified bucket does not exist."},
+			"*gcsx.BucketNotFoundError",
+			false,
+		},
+		11: {
+			&googleapi.Error{
+				Code:   404,
+				Errors: []googleapi.ErrorItem{{Reason: "notFound", Message: "The specified bucket does not exist."}},
+			},
+			"*gcsx.BucketNotFoundError",
+			false,
+		},
+		12: {
+			&googleapi.Error{Code: 404, Message: "No such object: some-bucket/foo"},
+			"*gcs.NotFoundError",
+			false,
+		},
+		13: {
+			&googleapi.Error{Code: 400, Message: "The specified bucket does not exist."},
+			"*gcsx.PermanentError",
+			false,
+		},
+
+		// Already typed
+		14: {notFound, "*gcs.NotFoundError", false},
+		15: {precondition, "*gcs.PreconditionError", false},
+
+		// No status code
+		16: {other, "*errors.errorString", false},
+	}
+
+	for i, tc := range testCases {
+		out := gcsx.ClassifyError(tc.in)
+		if got := reflect.TypeOf(out).String(); got != tc.expected {
+			t.Errorf("Test case %d: got type %s, want %s", i, got, tc.expected)
+		}
+
+		if got := gcsx.IsRetryable(tc.in); got != tc.retryable {
+			t.Errorf("Test case %d: IsRetryable is %v, want %v", i, got, tc.retryable)
+		}
+	}
+
+	// Typed errors should be passed through unmodified.
+	if out := gcsx.ClassifyError(notFound); out != notFound {
+		t.Errorf("NotFoundError was modified: %v", out)
+	}
+
+	if out := gcsx.ClassifyError(precondition); out != precondition {
+		t.Errorf("PreconditionError was modified: %v", out)
+	}
+
+	if out := gcsx.ClassifyError(nil); out != nil {
+		t.Errorf("nil was modified: %v", out)
+	}
+}
+
+func TestClassifyError_PreservesOriginalError(t *testing.T) {
+	in := &googleapi.Error{Code: 503, Message: "backend error"}
+
+	out, ok := gcsx.ClassifyError(in).(*gcsx.TransientError)
+	if !ok {
+		t.Fatalf("Unexpected result: %v", out)
+	}
+
+	if out.Err != in {
+		t.Errorf("Wrapped error is %v, want %v", out.Err, in)
+	}
+
+	if got, want := out.Error(), fmt.Sprintf("gcsx.TransientError: %v", in); got != want {
+		t.Errorf("Error() is %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,320 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+)
+
+// Create a view on the wrapped bucket in which each of the supplied characters
+// is percent-encoded in object names before they reach the wrapped bucket,
+// and decoded again in names it returns. This helps with backends whose URL
+// handling is confused by characters like '#' and '?'. A percent sign is
+// encoded too when what follows it would otherwise be read as an escape, so
+// that decoding is unambiguous.
+//
+// chars must consist of printable ASCII characters other than '/', which must
+// be left alone for listings with a delimiter to work, and other than letters
+// and digits, which make up the escapes. Only escapes of those characters are
+// decoded, so a name in the wrapped bucket created by other means, e.g.
+// "a%2Fb", is exposed unchanged and maps back to itself. The exception is a
+// name containing one of the characters unescaped, which can be listed but not
+// otherwise reached.
+func NewEscapingBucket(
+	chars string,
+	wrapped gcs.Bucket) (b gcs.Bucket, err error) {
+	for i := 0; i < len(chars); i++ {
+		c := chars[i]
+		if c <= ' ' || c > '~' || c == '/' || isAlphanumeric(c) {
+			err = fmt.Errorf("Can't escape character %q", c)
+			return
+		}
+	}
+
+	b = &escapingBucket{
+		chars:   chars,
+		wrapped: wrapped,
+	}
+
+	return
+}
+
+type escapingBucket struct {
+	chars   string
+	wrapped gcs.Bucket
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
+
+// Parse two upper-case hex digits, as written by wrappedName.
+func unhex(s string) (c byte, ok bool) {
+	for i := 0; i < 2; i++ {
+		d := s[i]
+		switch {
+		case d >= '0' && d <= '9':
+			c = c<<4 | (d - '0')
+
+		case d >= 'A' && d <= 'F':
+			c = c<<4 | (d - 'A' + 10)
+
+		default:
+			return
+		}
+	}
+
+	ok = true
+	return
+}
+
+// Would a percent sign followed by s be read as an escape? That's the case if
+// s begins with the hex digits of one of b.chars, or with those of a percent
+// sign followed by something that would again be read as an escape. Only
+// digits are examined, and b.chars contains none of them, so the answer is the
+// same for s as for its escaped and unescaped forms.
+func (b *escapingBucket) escapes(s string) bool {
+	for len(s) >= 2 {
+		c, ok := unhex(s[:2])
+		switch {
+		case !ok:
+			return false
+
+		case c != '%':
+			return strings.IndexByte(b.chars, c) >= 0
+		}
+
+		s = s[2:]
+	}
+
+	return false
+}
+
+func (b *escapingBucket) wrappedName(n string) string {
+	if !strings.ContainsAny(n, b.chars+"%") {
+		return n
+	}
+
+	var buf []byte
+	for i := 0; i < len(n); i++ {
+		c := n[i]
+		if strings.IndexByte(b.chars, c) >= 0 || c == '%' && b.escapes(n[i+1:]) {
+			buf = append(buf, fmt.Sprintf("%%%02X", c)...)
+		} else {
+			buf = append(buf, c)
+		}
+	}
+
+	return string(buf)
+}
+
+// The inverse of wrappedName, so that wrappedName(localName(n)) == n for any n
+// not containing one of b.chars unescaped.
+func (b *escapingBucket) localName(n string) string {
+	if !strings.Contains(n, "%") {
+		return n
+	}
+
+	var buf []byte
+	for i := 0; i < len(n); i++ {
+		c := n[i]
+		if c == '%' && b.escapes(n[i+1:]) {
+			c, _ = unhex(n[i+1 : i+3])
+			i += 2
+		}
+
+		buf = append(buf, c)
+	}
+
+	return string(buf)
+}
+
+func (b *escapingBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+func (b *escapingBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	// Modify the request and call through.
+	mReq := new(gcs.ReadObjectRequest)
+	*mReq = *req
+	mReq.Name = b.wrappedName(req.Name)
+
+	rc, err = b.wrapped.NewReader(ctx, mReq)
+	return
+}
+
+func (b *escapingBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	// Modify the request and call through.
+	mReq := new(gcs.CreateObjectRequest)
+	*mReq = *req
+	mReq.Name = b.wrappedName(req.Name)
+
+	o, err = b.wrapped.CreateObject(ctx, mReq)
+
+	// Modify the returned object.
+	if o != nil {
+		o.Name = b.localName(o.Name)
+	}
+
+	return
+}
+
+func (b *escapingBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	// Modify the request and call through.
+	mReq := new(gcs.CopyObjectRequest)
+	*mReq = *req
+	mReq.SrcName = b.wrappedName(req.SrcName)
+	mReq.DstName = b.wrappedName(req.DstName)
+
+	o, err = b.wrapped.CopyObject(ctx, mReq)
+
+	// Modify the returned object.
+	if o != nil {
+		o.Name = b.localName(o.Name)
+	}
+
+	return
+}
+
+func (b *escapingBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	// Modify the request and call through.
+	mReq := new(gcs.ComposeObjectsRequest)
+	*mReq = *req
+	mReq.DstName = b.wrappedName(req.DstName)
+
+	mReq.Sources = nil
+	for _, s := range req.Sources {
+		s.Name = b.wrappedName(s.Name)
+		mReq.Sources = append(mReq.Sources, s)
+	}
+
+	o, err = b.wrapped.ComposeObjects(ctx, mReq)
+
+	// Modify the returned object.
+	if o != nil {
+		o.Name = b.localName(o.Name)
+	}
+
+	return
+}
+
+func (b *escapingBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	// Modify the request and call through.
+	mReq := new(gcs.StatObjectRequest)
+	*mReq = *req
+	mReq.Name = b.wrappedName(req.Name)
+
+	o, err = b.wrapped.StatObject(ctx, mReq)
+
+	// Modify the returned object.
+	if o != nil {
+		o.Name = b.localName(o.Name)
+	}
+
+	return
+}
+
+func (b *escapingBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (l *gcs.Listing, err error) {
+	// Modify the request and call through. Escaping works character by
+	// character, so the escaped prefix and delimiter behave on the escaped
+	// names just as the originals would on the originals. The exception is a
+	// percent sign, whose escaping depends on the digits after it. If the
+	// prefix ends with one followed by nothing but digits, that isn't decided
+	// yet, so we list from before it and filter the results.
+	prefix := req.Prefix
+	if i := strings.LastIndex(prefix, "%"); i >= 0 &&
+		strings.Trim(prefix[i+1:], "0123456789ABCDEF") == "" {
+		prefix = prefix[:i]
+	}
+
+	mReq := new(gcs.ListObjectsRequest)
+	*mReq = *req
+	mReq.Prefix = b.wrappedName(prefix)
+	mReq.Delimiter = b.wrappedName(req.Delimiter)
+
+	l, err = b.wrapped.ListObjects(ctx, mReq)
+
+	// Modify the returned listing.
+	if l != nil {
+		var objects []*gcs.Object
+		for _, o := range l.Objects {
+			o.Name = b.localName(o.Name)
+			if strings.HasPrefix(o.Name, req.Prefix) {
+				objects = append(objects, o)
+			}
+		}
+
+		var runs []string
+		for _, n := range l.CollapsedRuns {
+			n = b.localName(n)
+			if strings.HasPrefix(n, req.Prefix) {
+				runs = append(runs, n)
+			}
+		}
+
+		l.Objects = objects
+		l.CollapsedRuns = runs
+	}
+
+	return
+}
+
+func (b *escapingBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	// Modify the request and call through.
+	mReq := new(gcs.UpdateObjectRequest)
+	*mReq = *req
+	mReq.Name = b.wrappedName(req.Name)
+
+	o, err = b.wrapped.UpdateObject(ctx, mReq)
+
+	// Modify the returned object.
+	if o != nil {
+		o.Name = b.localName(o.Name)
+	}
+
+	return
+}
+
+func (b *escapingBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	// Modify the request and call through.
+	mReq := new(gcs.DeleteObjectRequest)
+	*mReq = *req
+	mReq.Name = b.wrappedName(req.Name)
+
+	err = b.wrapped.DeleteObject(ctx, mReq)
+	return
+}
@@ -0,0 +1,307 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestEscapingBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type EscapingBucketTest struct {
+	ctx     context.Context
+	wrapped gcs.Bucket
+	bucket  gcs.Bucket
+}
+
+var _ SetUpInterface = &EscapingBucketTest{}
+
+func init() { RegisterTestSuite(&EscapingBucketTest{}) }
+
+func (t *EscapingBucketTest) SetUp(ti *TestInfo) {
+	var err error
+
+	t.ctx = ti.Ctx
+	t.wrapped = gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	t.bucket, err = gcsx.NewEscapingBucket("#?", t.wrapped)
+	AssertEq(nil, err)
+}
+
+// Return the names of all objects in the wrapped bucket.
+func (t *EscapingBucketTest) wrappedNames() (names []string) {
+	listing, err := t.wrapped.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+
+	for _, o := range listing.Objects {
+		names = append(names, o.Name)
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *EscapingBucketTest) BadCharacters() {
+	for _, chars := range []string{"/", "\n", "\x7f", "é", "a", "F", "0"} {
+		_, err := gcsx.NewEscapingBucket(chars, t.wrapped)
+		ExpectThat(err, Error(HasSubstr("escape")), "%q", chars)
+	}
+}
+
+func (t *EscapingBucketTest) CreateThenRead() {
+	name := "dir/taco#1?burrito 100%"
+
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, name, []byte("enchilada"))
+	AssertEq(nil, err)
+	ExpectEq(name, o.Name)
+
+	// The wrapped bucket should see the escaped name.
+	ExpectThat(
+		t.wrappedNames(),
+		ElementsAre("dir/taco%231%3Fburrito 100%"))
+
+	// Stat and read it back through the escaping bucket.
+	o, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: name})
+	AssertEq(nil, err)
+	ExpectEq(name, o.Name)
+
+	rc, err := t.bucket.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: name})
+	AssertEq(nil, err)
+	defer rc.Close()
+
+	contents, err := ioutil.ReadAll(rc)
+	AssertEq(nil, err)
+	ExpectEq("enchilada", string(contents))
+}
+
+func (t *EscapingBucketTest) PlainNamesUnchanged() {
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo/bar", []byte(""))
+	AssertEq(nil, err)
+
+	ExpectThat(t.wrappedNames(), ElementsAre("foo/bar"))
+}
+
+func (t *EscapingBucketTest) ForeignNamesUnchanged() {
+	// An object created through the back door, whose name isn't a valid
+	// escaping.
+	_, err := gcsutil.CreateObject(t.ctx, t.wrapped, "100%", []byte(""))
+	AssertEq(nil, err)
+
+	listing, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+	AssertEq(1, len(listing.Objects))
+	ExpectEq("100%", listing.Objects[0].Name)
+}
+
+func (t *EscapingBucketTest) PercentSignsEscapedWhenAmbiguous() {
+	// Percent signs followed by something that would read as an escape must
+	// themselves be escaped.
+	names := map[string]string{
+		"50%23":   "50%2523",
+		"50%2523": "50%252523",
+		"50%25":   "50%25",
+		"50%2F":   "50%2F",
+		"50%%3F":  "50%%253F",
+	}
+
+	for local, wrapped := range names {
+		o, err := gcsutil.CreateObject(t.ctx, t.bucket, local, []byte(""))
+		AssertEq(nil, err)
+		ExpectEq(local, o.Name)
+
+		_, err = t.wrapped.StatObject(t.ctx, &gcs.StatObjectRequest{Name: wrapped})
+		ExpectEq(nil, err, "%q", local)
+	}
+
+	// Listings are in the order of the escaped names.
+	listing, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+
+	var listed []string
+	for _, o := range listing.Objects {
+		listed = append(listed, o.Name)
+	}
+
+	ExpectThat(
+		listed,
+		ElementsAre("50%%3F", "50%25", "50%23", "50%2523", "50%2F"))
+}
+
+func (t *EscapingBucketTest) ForeignEscapesUnchanged() {
+	// Objects created through the back door whose names contain escapes of
+	// characters we don't escape.
+	for _, name := range []string{"a%2Fb", "100%25", "%41%3f"} {
+		_, err := gcsutil.CreateObject(t.ctx, t.wrapped, name, []byte(name))
+		AssertEq(nil, err)
+
+		// They should be reachable under the same names through the escaping
+		// bucket.
+		o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: name})
+		AssertEq(nil, err, "%q", name)
+		ExpectEq(name, o.Name)
+
+		rc, err := t.bucket.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: name})
+		AssertEq(nil, err)
+
+		contents, err := ioutil.ReadAll(rc)
+		rc.Close()
+		AssertEq(nil, err)
+		ExpectEq(name, string(contents))
+	}
+
+	listing, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+
+	var listed []string
+	for _, o := range listing.Objects {
+		listed = append(listed, o.Name)
+	}
+
+	ExpectThat(listed, ElementsAre("%41%3f", "100%25", "a%2Fb"))
+}
+
+func (t *EscapingBucketTest) ListObjects_PrefixEndsWithPercentSign() {
+	for _, name := range []string{"a%", "a%23", "a%2F", "a%3F", "b"} {
+		_, err := gcsutil.CreateObject(t.ctx, t.bucket, name, []byte(""))
+		AssertEq(nil, err)
+	}
+
+	// Whether the percent sign is escaped isn't known from these prefixes.
+	testCases := []struct {
+		prefix   string
+		expected []interface{}
+	}{
+		{"a%", []interface{}{"a%", "a%23", "a%3F", "a%2F"}},
+		{"a%2", []interface{}{"a%23", "a%2F"}},
+		{"a%3", []interface{}{"a%3F"}},
+	}
+
+	for _, tc := range testCases {
+		listing, err := t.bucket.ListObjects(
+			t.ctx,
+			&gcs.ListObjectsRequest{Prefix: tc.prefix})
+
+		AssertEq(nil, err)
+
+		var listed []string
+		for _, o := range listing.Objects {
+			listed = append(listed, o.Name)
+		}
+
+		ExpectThat(listed, ElementsAre(tc.expected...), "%q", tc.prefix)
+	}
+}
+
+func (t *EscapingBucketTest) ListObjects() {
+	for _, name := range []string{"a#/0", "a#/b?/1", "a#0", "a?/0", "b"} {
+		_, err := gcsutil.CreateObject(t.ctx, t.bucket, name, []byte(""))
+		AssertEq(nil, err)
+	}
+
+	listing, err := t.bucket.ListObjects(
+		t.ctx,
+		&gcs.ListObjectsRequest{
+			Prefix:    "a#/",
+			Delimiter: "/",
+		})
+
+	AssertEq(nil, err)
+	AssertEq(1, len(listing.Objects))
+	ExpectEq("a#/0", listing.Objects[0].Name)
+	ExpectThat(listing.CollapsedRuns, ElementsAre("a#/b?/"))
+
+	// A delimiter needing escaping should work too.
+	listing, err = t.bucket.ListObjects(
+		t.ctx,
+		&gcs.ListObjectsRequest{
+			Prefix:    "a",
+			Delimiter: "#",
+		})
+
+	AssertEq(nil, err)
+	AssertEq(1, len(listing.Objects))
+	ExpectEq("a?/0", listing.Objects[0].Name)
+	ExpectThat(listing.CollapsedRuns, ElementsAre("a#"))
+}
+
+func (t *EscapingBucketTest) CopyComposeUpdateDelete() {
+	src, err := gcsutil.CreateObject(t.ctx, t.bucket, "src#", []byte("taco"))
+	AssertEq(nil, err)
+
+	o, err := t.bucket.CopyObject(
+		t.ctx,
+		&gcs.CopyObjectRequest{
+			SrcName: "src#",
+			DstName: "copy?",
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("copy?", o.Name)
+
+	o, err = t.bucket.ComposeObjects(
+		t.ctx,
+		&gcs.ComposeObjectsRequest{
+			DstName: "composed#?",
+			Sources: []gcs.ComposeSource{
+				{Name: "src#", Generation: src.Generation},
+				{Name: "copy?"},
+			},
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("composed#?", o.Name)
+	ExpectEq(len("tacotaco"), o.Size)
+
+	contentType := "text/plain"
+	o, err = t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:        "composed#?",
+			ContentType: &contentType,
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("composed#?", o.Name)
+	ExpectEq(contentType, o.ContentType)
+
+	ExpectThat(
+		t.wrappedNames(),
+		ElementsAre("composed%23%3F", "copy%3F", "src%23"))
+
+	for _, name := range []string{"src#", "copy?", "composed#?"} {
+		err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: name})
+		AssertEq(nil, err)
+	}
+
+	ExpectThat(t.wrappedNames(), ElementsAre())
+}
@@ -16,10 +16,14 @@ package gcsx_test
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 
@@ -58,6 +62,37 @@ func randBytes(n int) (b []byte) {
 	return
 }
 
+// A bucket whose CreateObject calls fail after (if lose is set) or instead of
+// (if fail is set) creating the object, as when a response is lost in
+// transit. Counts the objects actually created.
+type ambiguousBucket struct {
+	gcs.Bucket
+	lose    bool
+	fail    bool
+	created int
+}
+
+func (b *ambiguousBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	if b.fail {
+		err = errors.New("Injected failure")
+		return
+	}
+
+	o, err = b.Bucket.CreateObject(ctx, req)
+	if err == nil {
+		b.created++
+	}
+
+	if err == nil && b.lose {
+		o = nil
+		err = errors.New("Injected lost response")
+	}
+
+	return
+}
+
 ////////////////////////////////////////////////////////////////////////
 // Boilerplate
 ////////////////////////////////////////////////////////////////////////
@@ -223,6 +258,299 @@ func (t *IntegrationTest) WriteThenSync() {
 	ExpectEq("foo", objects[0].Name)
 }
 
+func (t *IntegrationTest) SyncPreservesCreator() {
+	const creator = "some-host"
+
+	// Create.
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader("taco"),
+			Metadata: map[string]string{
+				gcsx.CreatorMetadataKey: creator,
+			},
+		})
+
+	AssertEq(nil, err)
+
+	// Overwrite the first byte and sync, rewriting the object in full.
+	t.create(o)
+	_, err = t.tf.WriteAt([]byte("p"), 0)
+	AssertEq(nil, err)
+
+	o, err = t.sync(o)
+	AssertEq(nil, err)
+	ExpectEq(creator, o.Metadata[gcsx.CreatorMetadataKey])
+
+	// Append and sync, composing the new generation.
+	t.create(o)
+	_, err = t.tf.WriteAt([]byte("s"), 4)
+	AssertEq(nil, err)
+
+	o, err = t.sync(o)
+	AssertEq(nil, err)
+	ExpectEq(creator, o.Metadata[gcsx.CreatorMetadataKey])
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq("pacos", string(contents))
+}
+
+func (t *IntegrationTest) IdempotentSync_LostResponse() {
+	bucket := &ambiguousBucket{Bucket: t.bucket}
+	t.syncer = gcsx.NewIdempotentSyncer(math.MaxInt64, ".gcsfuse_tmp/", bucket)
+
+	// Create.
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	t.create(o)
+
+	// Dirty.
+	_, err = t.tf.WriteAt([]byte("p"), 0)
+	AssertEq(nil, err)
+
+	// Sync, losing the response. The syncer should find that the write
+	// happened and adopt its generation, without uploading again.
+	bucket.lose = true
+	newObj, err := t.sync(o)
+	AssertEq(nil, err)
+
+	ExpectEq(1, bucket.created)
+	ExpectNe(o.Generation, newObj.Generation)
+	ExpectEq(t.objectGeneration("foo"), newObj.Generation)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq("paco", string(contents))
+}
+
+func (t *IntegrationTest) IdempotentSync_RealFailure() {
+	bucket := &ambiguousBucket{Bucket: t.bucket}
+	t.syncer = gcsx.NewIdempotentSyncer(math.MaxInt64, ".gcsfuse_tmp/", bucket)
+
+	// Create.
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	t.create(o)
+
+	// Dirty.
+	_, err = t.tf.WriteAt([]byte("p"), 0)
+	AssertEq(nil, err)
+
+	// A failure that really didn't write anything should be reported, and
+	// leave the source object alone.
+	bucket.fail = true
+	_, err = t.sync(o)
+	ExpectThat(err, Error(HasSubstr("Injected failure")))
+	ExpectEq(o.Generation, t.objectGeneration("foo"))
+
+	// Retrying once the failure clears should work.
+	bucket.fail = false
+	newObj, err := t.sync(o)
+	AssertEq(nil, err)
+
+	ExpectEq(1, bucket.created)
+	ExpectEq(t.objectGeneration("foo"), newObj.Generation)
+	ExpectNe("", newObj.Metadata[gcsx.SyncTokenMetadataKey])
+}
+
+func (t *IntegrationTest) NonIdempotentSync_LostResponse() {
+	bucket := &ambiguousBucket{Bucket: t.bucket}
+	t.syncer = gcsx.NewSyncer(math.MaxInt64, ".gcsfuse_tmp/", bucket)
+
+	// Create.
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	t.create(o)
+
+	// Dirty.
+	_, err = t.tf.WriteAt([]byte("p"), 0)
+	AssertEq(nil, err)
+
+	// Without tokens, a lost response looks like a failure.
+	bucket.lose = true
+	_, err = t.sync(o)
+	ExpectThat(err, Error(HasSubstr("lost response")))
+	ExpectEq(1, bucket.created)
+}
+
+// A bucket that records the requests made to CreateObject.
+type createRecordingBucket struct {
+	gcs.Bucket
+	creates []gcs.CreateObjectRequest
+}
+
+func (b *createRecordingBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	b.creates = append(b.creates, *req)
+	o, err = b.Bucket.CreateObject(ctx, req)
+	return
+}
+
+func (t *IntegrationTest) HashingSync() {
+	bucket := &createRecordingBucket{Bucket: t.bucket}
+	t.syncer = gcsx.NewHashingSyncer(bucket)
+
+	// Small contents are uploaded from memory, and large ones streamed from the
+	// temp file.
+	payloads := []string{
+		"paco",
+		strings.Repeat("taco", 1<<17),
+	}
+
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	for _, payload := range payloads {
+		// Overwrite from the start.
+		t.create(o)
+		_, err = t.tf.WriteAt([]byte(payload), 0)
+		AssertEq(nil, err)
+
+		bucket.creates = nil
+		o, err = t.sync(o)
+		AssertEq(nil, err)
+
+		sum := sha256.Sum256([]byte(payload))
+		expected := hex.EncodeToString(sum[:])
+
+		AssertEq(1, len(bucket.creates))
+		ExpectEq("foo", bucket.creates[0].Name)
+		ExpectEq(expected, bucket.creates[0].Metadata[gcsx.SHA256MetadataKey])
+		ExpectEq(expected, o.Metadata[gcsx.SHA256MetadataKey])
+
+		contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+		AssertEq(nil, err)
+		ExpectEq(payload, string(contents))
+	}
+
+	// Even a pure append should be rewritten in full, so it can be hashed.
+	t.create(o)
+	_, err = t.tf.WriteAt([]byte("s"), int64(o.Size))
+	AssertEq(nil, err)
+
+	bucket.creates = nil
+	o, err = t.sync(o)
+	AssertEq(nil, err)
+
+	AssertEq(1, len(bucket.creates))
+	ExpectEq("foo", bucket.creates[0].Name)
+	ExpectEq(1, o.ComponentCount)
+}
+
+func (t *IntegrationTest) HashingSync_NotCarriedForward() {
+	t.syncer = gcsx.NewHashingSyncer(t.bucket)
+
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	t.create(o)
+	_, err = t.tf.WriteAt([]byte("p"), 0)
+	AssertEq(nil, err)
+
+	o, err = t.sync(o)
+	AssertEq(nil, err)
+	AssertNe("", o.Metadata[gcsx.SHA256MetadataKey])
+
+	// A syncer that doesn't hash mustn't leave the old hash in place, whether
+	// appending or rewriting.
+	t.syncer = gcsx.NewSyncer(0, ".gcsfuse_tmp/", t.bucket)
+
+	t.create(o)
+	_, err = t.tf.WriteAt([]byte("s"), 4)
+	AssertEq(nil, err)
+
+	o, err = t.sync(o)
+	AssertEq(nil, err)
+	ExpectEq(2, o.ComponentCount)
+	_, ok := o.Metadata[gcsx.SHA256MetadataKey]
+	ExpectFalse(ok)
+}
+
+func (t *IntegrationTest) StagedWriteThenSync() {
+	t.syncer = gcsx.NewStagedSyncer(
+		math.MaxInt64,
+		".gcsfuse_tmp/",
+		t.bucket)
+
+	// Create.
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	t.create(o)
+
+	// Append, which with this threshold still means a full rewrite.
+	_, err = t.tf.WriteAt([]byte("s"), 4)
+	AssertEq(nil, err)
+
+	// Sync should save out the new generation.
+	newObj, err := t.sync(o)
+	AssertEq(nil, err)
+
+	ExpectNe(o.Generation, newObj.Generation)
+	ExpectEq(t.objectGeneration("foo"), newObj.Generation)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq("tacos", string(contents))
+
+	// The temporary object should be gone.
+	objects, runs, err := gcsutil.ListAll(
+		t.ctx,
+		t.bucket,
+		&gcs.ListObjectsRequest{})
+
+	AssertEq(nil, err)
+	AssertEq(1, len(objects))
+	AssertEq(0, len(runs))
+
+	ExpectEq("foo", objects[0].Name)
+}
+
+func (t *IntegrationTest) SyncPreservesCacheControl() {
+	const cacheControl = "public, max-age=3600"
+
+	// Create.
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:         "foo",
+			Contents:     strings.NewReader("taco"),
+			CacheControl: cacheControl,
+		})
+
+	AssertEq(nil, err)
+
+	// Overwrite the first byte and sync, rewriting the object in full.
+	t.create(o)
+	_, err = t.tf.WriteAt([]byte("p"), 0)
+	AssertEq(nil, err)
+
+	o, err = t.sync(o)
+	AssertEq(nil, err)
+	ExpectEq(cacheControl, o.CacheControl)
+
+	// Append and sync, composing the new generation.
+	t.create(o)
+	_, err = t.tf.WriteAt([]byte("s"), 4)
+	AssertEq(nil, err)
+
+	o, err = t.sync(o)
+	AssertEq(nil, err)
+	ExpectEq(cacheControl, o.CacheControl)
+
+	// The bucket agrees.
+	o, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectEq(cacheControl, o.CacheControl)
+	ExpectEq(len("pacos"), o.Size)
+}
+
 func (t *IntegrationTest) AppendThenSync() {
 	// Create.
 	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
@@ -0,0 +1,141 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// A bucket that can restrict a listing to a lexicographic range of names on
+// the server side, as with the startOffset and endOffset parameters of the
+// GCS objects.list API.
+type RangeListingBucket interface {
+	gcs.Bucket
+
+	// Like ListObjects, but return only objects and collapsed runs whose
+	// names are at least startOffset and less than endOffset. An empty bound
+	// is no bound.
+	ListObjectRange(
+		ctx context.Context,
+		req *gcs.ListObjectsRequest,
+		startOffset string,
+		endOffset string) (*gcs.Listing, error)
+}
+
+// Return a page of the listing described by the request, restricted to
+// objects and collapsed runs whose names lie in [startOffset, endOffset). An
+// empty bound is no bound. To continue, call again with the same arguments
+// and req.ContinuationToken set from the result.
+//
+// If the bucket is a RangeListingBucket the bounds are passed on to it.
+// Otherwise the listing is narrowed by prefix where the bounds allow, results
+// are filtered locally, and paging stops early once names pass endOffset.
+// Pages may then be empty without the listing being finished.
+func ListObjectRange(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	req *gcs.ListObjectsRequest,
+	startOffset string,
+	endOffset string) (listing *gcs.Listing, err error) {
+	if rlb, ok := bucket.(RangeListingBucket); ok {
+		listing, err = rlb.ListObjectRange(ctx, req, startOffset, endOffset)
+		if err != nil {
+			err = fmt.Errorf("ListObjectRange: %v", err)
+			return
+		}
+
+		return
+	}
+
+	// Make a copy of the request that we can narrow.
+	narrowed := *req
+	narrowed.Prefix = rangePrefix(req.Prefix, req.Delimiter, startOffset, endOffset)
+
+	listing, err = bucket.ListObjects(ctx, &narrowed)
+	if err != nil {
+		err = fmt.Errorf("ListObjects: %v", err)
+		return
+	}
+
+	inRange := func(name string) bool {
+		return name >= startOffset && (endOffset == "" || name < endOffset)
+	}
+
+	// Filter, noting whether we've seen anything at or beyond the end. Since
+	// listings are ordered, nothing after that can be in range.
+	var pastEnd bool
+	var objects []*gcs.Object
+	for _, o := range listing.Objects {
+		if inRange(o.Name) {
+			objects = append(objects, o)
+		} else if o.Name >= startOffset {
+			pastEnd = true
+		}
+	}
+
+	var collapsed []string
+	for _, p := range listing.CollapsedRuns {
+		if inRange(p) {
+			collapsed = append(collapsed, p)
+		} else if p >= startOffset {
+			pastEnd = true
+		}
+	}
+
+	listing.Objects = objects
+	listing.CollapsedRuns = collapsed
+	if pastEnd {
+		listing.ContinuationToken = ""
+	}
+
+	return
+}
+
+// Choose the longest prefix that can be listed in place of the supplied one
+// without changing the results within [startOffset, endOffset), given the
+// delimiter in use.
+func rangePrefix(
+	prefix string,
+	delimiter string,
+	startOffset string,
+	endOffset string) string {
+	if startOffset == "" || endOffset == "" {
+		return prefix
+	}
+
+	// Every name in the range shares the bounds' common prefix.
+	common := startOffset
+	for !strings.HasPrefix(endOffset, common) {
+		common = common[:len(common)-1]
+	}
+
+	if len(common) <= len(prefix) || !strings.HasPrefix(common, prefix) {
+		return prefix
+	}
+
+	// Collapsed runs are formed from the part of the name after the prefix, so
+	// we mustn't extend the prefix past a delimiter.
+	if delimiter != "" {
+		if i := strings.Index(common[len(prefix):], delimiter); i >= 0 {
+			common = common[:len(prefix)+i]
+		}
+	}
+
+	return common
+}
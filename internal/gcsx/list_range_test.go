@@ -0,0 +1,248 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// A RangeListingBucket that records the arguments to ListObjectRange and
+// returns a canned listing.
+type boundsRecordingBucket struct {
+	gcs.Bucket
+	req         *gcs.ListObjectsRequest
+	startOffset string
+	endOffset   string
+	listing     *gcs.Listing
+}
+
+func (b *boundsRecordingBucket) ListObjectRange(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest,
+	startOffset string,
+	endOffset string) (*gcs.Listing, error) {
+	b.req = req
+	b.startOffset = startOffset
+	b.endOffset = endOffset
+	return b.listing, nil
+}
+
+// List the whole range, returning the names of objects and collapsed runs.
+func listWholeRange(
+	t *testing.T,
+	bucket gcs.Bucket,
+	req gcs.ListObjectsRequest,
+	startOffset string,
+	endOffset string) (names []string, collapsed []string) {
+	for {
+		listing, err := gcsx.ListObjectRange(
+			context.Background(),
+			bucket,
+			&req,
+			startOffset,
+			endOffset)
+
+		if err != nil {
+			t.Fatalf("ListObjectRange: %v", err)
+		}
+
+		for _, o := range listing.Objects {
+			names = append(names, o.Name)
+		}
+
+		collapsed = append(collapsed, listing.CollapsedRuns...)
+
+		if listing.ContinuationToken == "" {
+			return
+		}
+
+		req.ContinuationToken = listing.ContinuationToken
+	}
+}
+
+func TestListObjectRange_ForwardsBounds(t *testing.T) {
+	expected := &gcs.Listing{
+		Objects: []*gcs.Object{{Name: "dir/b"}},
+	}
+
+	bucket := &boundsRecordingBucket{listing: expected}
+	req := &gcs.ListObjectsRequest{Prefix: "dir/", Delimiter: "/"}
+
+	listing, err := gcsx.ListObjectRange(
+		context.Background(),
+		bucket,
+		req,
+		"dir/a",
+		"dir/c")
+
+	if err != nil {
+		t.Fatalf("ListObjectRange: %v", err)
+	}
+
+	if listing != expected {
+		t.Errorf("Got listing %v, want %v", listing, expected)
+	}
+
+	if bucket.req != req {
+		t.Errorf("Request not forwarded: %v", bucket.req)
+	}
+
+	if bucket.startOffset != "dir/a" || bucket.endOffset != "dir/c" {
+		t.Errorf(
+			"Got bounds (%q, %q), want (%q, %q)",
+			bucket.startOffset,
+			bucket.endOffset,
+			"dir/a",
+			"dir/c")
+	}
+}
+
+func TestListObjectRange_Fallback(t *testing.T) {
+	contents := map[string]string{
+		"foo":        "",
+		"dir/sub/0":  "",
+		"dir/sub2/0": "",
+	}
+
+	for i := 0; i < 10; i++ {
+		contents[fmt.Sprintf("dir/%d", i)] = ""
+	}
+
+	testCases := []struct {
+		desc        string
+		req         gcs.ListObjectsRequest
+		startOffset string
+		endOffset   string
+		names       []string
+		collapsed   []string
+	}{
+		{
+			desc:        "both bounds",
+			req:         gcs.ListObjectsRequest{Prefix: "dir/"},
+			startOffset: "dir/3",
+			endOffset:   "dir/7",
+			names:       []string{"dir/3", "dir/4", "dir/5", "dir/6"},
+		},
+		{
+			desc:        "start only",
+			req:         gcs.ListObjectsRequest{Prefix: "dir/"},
+			startOffset: "dir/8",
+			names:       []string{"dir/8", "dir/9", "dir/sub/0", "dir/sub2/0"},
+		},
+		{
+			desc:      "end only",
+			req:       gcs.ListObjectsRequest{},
+			endOffset: "dir/2",
+			names:     []string{"dir/0", "dir/1"},
+		},
+		{
+			desc:        "delimiter",
+			req:         gcs.ListObjectsRequest{Prefix: "dir/", Delimiter: "/"},
+			startOffset: "dir/9",
+			endOffset:   "dir/sub2/",
+			names:       []string{"dir/9"},
+			collapsed:   []string{"dir/sub/"},
+		},
+		{
+			desc:        "empty",
+			req:         gcs.ListObjectsRequest{Prefix: "dir/"},
+			startOffset: "dir/5a",
+			endOffset:   "dir/6",
+		},
+	}
+
+	for _, tc := range testCases {
+		bucket := newPagingBucket(t, contents)
+		names, collapsed := listWholeRange(
+			t,
+			bucket,
+			tc.req,
+			tc.startOffset,
+			tc.endOffset)
+
+		if !reflect.DeepEqual(names, tc.names) {
+			t.Errorf("%s: got names %q, want %q", tc.desc, names, tc.names)
+		}
+
+		if !reflect.DeepEqual(collapsed, tc.collapsed) {
+			t.Errorf("%s: got collapsed %q, want %q", tc.desc, collapsed, tc.collapsed)
+		}
+	}
+}
+
+func TestListObjectRange_StopsAtEnd(t *testing.T) {
+	contents := make(map[string]string)
+	for i := 0; i < 30; i++ {
+		contents[fmt.Sprintf("dir/%02d", i)] = ""
+	}
+
+	bucket := newPagingBucket(t, contents)
+	names, _ := listWholeRange(
+		t,
+		bucket,
+		gcs.ListObjectsRequest{Prefix: "dir/"},
+		"",
+		"dir/04")
+
+	if len(names) != 4 {
+		t.Errorf("Got names %q, want four", names)
+	}
+
+	// Pages of three, and the second shows we've passed the end.
+	if bucket.calls != 2 {
+		t.Errorf("Got %d ListObjects calls, want 2", bucket.calls)
+	}
+}
+
+func TestListObjectRange_NarrowsPrefix(t *testing.T) {
+	var prefixes []string
+	bucket := newPagingBucket(t, map[string]string{"dir/sub/0": ""})
+	bucket.onRequest = func(req *gcs.ListObjectsRequest) {
+		prefixes = append(prefixes, req.Prefix)
+	}
+
+	testCases := []struct {
+		req         gcs.ListObjectsRequest
+		startOffset string
+		endOffset   string
+		prefix      string
+	}{
+		{gcs.ListObjectsRequest{Prefix: "dir/"}, "dir/sub/0", "dir/sub/5", "dir/sub/"},
+		{gcs.ListObjectsRequest{Prefix: "dir/", Delimiter: "/"}, "dir/sub/0", "dir/sub/5", "dir/sub"},
+		{gcs.ListObjectsRequest{Prefix: "dir/"}, "dir/sub/0", "", "dir/"},
+		{gcs.ListObjectsRequest{Prefix: "dir/"}, "a", "z", "dir/"},
+	}
+
+	for _, tc := range testCases {
+		prefixes = nil
+		listWholeRange(t, bucket, tc.req, tc.startOffset, tc.endOffset)
+
+		if len(prefixes) != 1 || prefixes[0] != tc.prefix {
+			t.Errorf(
+				"%v [%q, %q): got prefixes %q, want %q",
+				tc.req,
+				tc.startOffset,
+				tc.endOffset,
+				prefixes,
+				tc.prefix)
+		}
+	}
+}
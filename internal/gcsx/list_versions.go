@@ -0,0 +1,77 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// A bucket that can enumerate the stored generations of an object, as with a
+// versioned GCS bucket listed with versions=true.
+type VersionListingBucket interface {
+	gcs.Bucket
+
+	// Return records for all stored generations of the named object, live or
+	// not, in any order. Returns an empty slice if there are none.
+	ListObjectVersions(
+		ctx context.Context,
+		name string) ([]*gcs.Object, error)
+}
+
+// Return records for the stored generations of the named object, in
+// increasing order of generation, without reading any contents. Each record
+// carries the generation's size, update time, and meta-generation.
+//
+// If the bucket is not a VersionListingBucket, only the live generation (if
+// any) can be found.
+func ListVersions(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	name string) (versions []*gcs.Object, err error) {
+	if vlb, ok := bucket.(VersionListingBucket); ok {
+		versions, err = vlb.ListObjectVersions(ctx, name)
+		if err != nil {
+			err = fmt.Errorf("ListObjectVersions: %v", err)
+			return
+		}
+	} else {
+		var o *gcs.Object
+		o, err = bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: name})
+		switch err.(type) {
+		case nil:
+			versions = []*gcs.Object{o}
+
+		case *gcs.NotFoundError:
+			err = nil
+
+		default:
+			err = fmt.Errorf("StatObject: %v", err)
+			return
+		}
+	}
+
+	sort.Sort(byGeneration(versions))
+	return
+}
+
+type byGeneration []*gcs.Object
+
+func (s byGeneration) Len() int           { return len(s) }
+func (s byGeneration) Less(i, j int) bool { return s[i].Generation < s[j].Generation }
+func (s byGeneration) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,112 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// A bucket that reports a fixed set of versions for every name, and refuses
+// to read contents.
+type versionedBucket struct {
+	gcs.Bucket
+	versions []*gcs.Object
+}
+
+func (b *versionedBucket) ListObjectVersions(
+	ctx context.Context,
+	name string) (versions []*gcs.Object, err error) {
+	versions = append(versions, b.versions...)
+	return
+}
+
+func TestListVersions_Versioned(t *testing.T) {
+	t0 := time.Date(2012, 8, 15, 22, 56, 0, 0, time.UTC)
+	bucket := &versionedBucket{
+		versions: []*gcs.Object{
+			{Name: "foo", Generation: 17, MetaGeneration: 1, Size: 3, Updated: t0.Add(2 * time.Hour)},
+			{Name: "foo", Generation: 2, MetaGeneration: 5, Size: 1, Updated: t0},
+			{Name: "foo", Generation: 9, MetaGeneration: 2, Size: 2, Updated: t0.Add(time.Hour)},
+		},
+	}
+
+	versions, err := gcsx.ListVersions(context.Background(), bucket, "foo")
+	if err != nil {
+		t.Fatalf("ListVersions: %v", err)
+	}
+
+	if len(versions) != 3 {
+		t.Fatalf("Got %d versions, want 3", len(versions))
+	}
+
+	expected := []struct {
+		generation     int64
+		metaGeneration int64
+		size           uint64
+		updated        time.Time
+	}{
+		{2, 5, 1, t0},
+		{9, 2, 2, t0.Add(time.Hour)},
+		{17, 1, 3, t0.Add(2 * time.Hour)},
+	}
+
+	for i, e := range expected {
+		v := versions[i]
+		if v.Generation != e.generation ||
+			v.MetaGeneration != e.metaGeneration ||
+			v.Size != e.size ||
+			!v.Updated.Equal(e.updated) {
+			t.Errorf("Version %d: got %#v", i, v)
+		}
+	}
+}
+
+func TestListVersions_Unversioned(t *testing.T) {
+	ctx := context.Background()
+	bucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	// A missing object has no versions.
+	versions, err := gcsx.ListVersions(ctx, bucket, "foo")
+	if err != nil {
+		t.Fatalf("ListVersions: %v", err)
+	}
+
+	if len(versions) != 0 {
+		t.Errorf("Got %d versions, want 0", len(versions))
+	}
+
+	// Otherwise only the live generation is visible.
+	o, err := gcsutil.CreateObject(ctx, bucket, "foo", []byte("taco"))
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	versions, err = gcsx.ListVersions(ctx, bucket, "foo")
+	if err != nil {
+		t.Fatalf("ListVersions: %v", err)
+	}
+
+	if len(versions) != 1 || versions[0].Generation != o.Generation {
+		t.Errorf("Unexpected versions: %v", versions)
+	}
+}
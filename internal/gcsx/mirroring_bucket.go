@@ -0,0 +1,241 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Configuration for NewMirroringBucket.
+type MirrorConfig struct {
+	// If set, CreateObject and DeleteObject don't return until the secondary
+	// bucket has been updated too. If that fails they return *MirrorError, even
+	// though the primary has been updated; CreateObject returns the primary's
+	// new object along with it. Otherwise the secondary is updated in the
+	// background once the primary call has succeeded, in order for each name.
+	Synchronous bool
+
+	// If non-nil, called with the name of the method and object whenever
+	// updating the secondary bucket fails in the background. Called on an
+	// arbitrary goroutine. Unused when Synchronous is set.
+	OnMirrorError func(method string, name string, err error)
+}
+
+// Create a bucket that applies CreateObject and DeleteObject calls to both
+// primary and secondary, e.g. to keep a copy in another region. Everything
+// else, including reads and other mutations such as ComposeObjects, goes only
+// to primary. The bucket's name is primary's.
+//
+// The secondary is updated only after the primary call succeeds, without
+// preconditions, since generation numbers differ between buckets; the
+// secondary simply follows the primary. Deleting an object that is already
+// absent from the secondary counts as success.
+//
+// CreateObject buffers the contents in memory so that they can be written
+// twice.
+func NewMirroringBucket(
+	cfg MirrorConfig,
+	primary gcs.Bucket,
+	secondary gcs.Bucket) gcs.Bucket {
+	return &mirroringBucket{
+		cfg:       cfg,
+		primary:   primary,
+		secondary: secondary,
+		pending:   make(map[string][]mirrorOp),
+	}
+}
+
+type mirroringBucket struct {
+	cfg       MirrorConfig
+	primary   gcs.Bucket
+	secondary gcs.Bucket
+
+	mu sync.Mutex
+
+	// Background updates of the secondary not yet started, by object name. A
+	// name is present while a goroutine is working through its updates, so
+	// that those for one name are applied in the order they were made to the
+	// primary.
+	//
+	// GUARDED_BY(mu)
+	pending map[string][]mirrorOp
+}
+
+type mirrorOp struct {
+	method string
+	f      func(ctx context.Context) error
+}
+
+// Call f against the secondary bucket, either now, returning its error, or
+// in the background according to b.cfg.
+func (b *mirroringBucket) mirror(
+	ctx context.Context,
+	method string,
+	name string,
+	f func(ctx context.Context) error) (err error) {
+	if b.cfg.Synchronous {
+		err = f(ctx)
+		if err != nil {
+			err = &MirrorError{
+				Method: method,
+				Name:   name,
+				Err:    err,
+			}
+		}
+
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ops, running := b.pending[name]
+	b.pending[name] = append(ops, mirrorOp{method, f})
+	if !running {
+		go b.drain(name)
+	}
+
+	return
+}
+
+// Apply the pending updates for the supplied name in order, until there are
+// none left.
+func (b *mirroringBucket) drain(name string) {
+	for {
+		b.mu.Lock()
+		ops := b.pending[name]
+		if len(ops) == 0 {
+			delete(b.pending, name)
+			b.mu.Unlock()
+			return
+		}
+
+		op := ops[0]
+		b.pending[name] = ops[1:]
+		b.mu.Unlock()
+
+		// The caller's context may have ended by now, so don't use it.
+		err := op.f(context.Background())
+		if err != nil && b.cfg.OnMirrorError != nil {
+			b.cfg.OnMirrorError(op.method, name, err)
+		}
+	}
+}
+
+func (b *mirroringBucket) Name() string {
+	return b.primary.Name()
+}
+
+func (b *mirroringBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	rc, err = b.primary.NewReader(ctx, req)
+	return
+}
+
+func (b *mirroringBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	// Buffer the contents so that we can write them twice.
+	contents, err := ioutil.ReadAll(req.Contents)
+	if err != nil {
+		err = fmt.Errorf("ReadAll: %v", err)
+		return
+	}
+
+	primaryReq := *req
+	primaryReq.Contents = bytes.NewReader(contents)
+
+	o, err = b.primary.CreateObject(ctx, &primaryReq)
+	if err != nil {
+		return
+	}
+
+	// Mirror, without preconditions.
+	secondaryReq := *req
+	secondaryReq.Contents = bytes.NewReader(contents)
+	secondaryReq.GenerationPrecondition = nil
+	secondaryReq.MetaGenerationPrecondition = nil
+
+	err = b.mirror(ctx, "CreateObject", req.Name, func(ctx context.Context) error {
+		_, err := b.secondary.CreateObject(ctx, &secondaryReq)
+		return err
+	})
+
+	return
+}
+
+func (b *mirroringBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.primary.CopyObject(ctx, req)
+	return
+}
+
+func (b *mirroringBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	o, err = b.primary.ComposeObjects(ctx, req)
+	return
+}
+
+func (b *mirroringBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.primary.StatObject(ctx, req)
+	return
+}
+
+func (b *mirroringBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (l *gcs.Listing, err error) {
+	l, err = b.primary.ListObjects(ctx, req)
+	return
+}
+
+func (b *mirroringBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.primary.UpdateObject(ctx, req)
+	return
+}
+
+func (b *mirroringBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	err = b.primary.DeleteObject(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// Mirror, deleting whatever generation the secondary has.
+	err = b.mirror(ctx, "DeleteObject", req.Name, func(ctx context.Context) error {
+		err := b.secondary.DeleteObject(ctx, &gcs.DeleteObjectRequest{Name: req.Name})
+		if _, ok := err.(*gcs.NotFoundError); ok {
+			err = nil
+		}
+
+		return err
+	})
+
+	return
+}
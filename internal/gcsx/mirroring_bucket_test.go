@@ -0,0 +1,291 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// A bucket whose CreateObject and DeleteObject calls fail while err is set.
+type failingMutationBucket struct {
+	gcs.Bucket
+	err error
+}
+
+func (b *failingMutationBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	if b.err != nil {
+		err = b.err
+		return
+	}
+
+	o, err = b.Bucket.CreateObject(ctx, req)
+	return
+}
+
+func (b *failingMutationBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	if b.err != nil {
+		err = b.err
+		return
+	}
+
+	err = b.Bucket.DeleteObject(ctx, req)
+	return
+}
+
+type mirrorError struct {
+	method string
+	name   string
+	err    error
+}
+
+func newMirrorTestBuckets() (primary gcs.Bucket, secondary *failingMutationBucket) {
+	clock := timeutil.RealClock()
+	primary = gcsfake.NewFakeBucket(clock, "primary")
+	secondary = &failingMutationBucket{
+		Bucket: gcsfake.NewFakeBucket(clock, "secondary"),
+	}
+
+	return
+}
+
+func readString(t *testing.T, bucket gcs.Bucket, name string) string {
+	contents, err := gcsutil.ReadObject(context.Background(), bucket, name)
+	if err != nil {
+		t.Fatalf("ReadObject(%q): %v", name, err)
+	}
+
+	return string(contents)
+}
+
+func TestMirroringBucket_SynchronousSuccess(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := newMirrorTestBuckets()
+	bucket := gcsx.NewMirroringBucket(
+		gcsx.MirrorConfig{Synchronous: true},
+		primary,
+		secondary)
+
+	if bucket.Name() != "primary" {
+		t.Errorf("Got name %q", bucket.Name())
+	}
+
+	// Create. Both buckets should have the object.
+	_, err := gcsutil.CreateObject(ctx, bucket, "foo", []byte("taco"))
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	if got := readString(t, primary, "foo"); got != "taco" {
+		t.Errorf("Primary: got %q", got)
+	}
+
+	if got := readString(t, secondary, "foo"); got != "taco" {
+		t.Errorf("Secondary: got %q", got)
+	}
+
+	// Reads go only to the primary.
+	_, err = gcsutil.CreateObject(ctx, secondary, "bar", []byte("burrito"))
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	_, err = bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: "bar"})
+	if _, ok := err.(*gcs.NotFoundError); !ok {
+		t.Errorf("Got %v, want *gcs.NotFoundError", err)
+	}
+
+	// Delete. The object should be gone from both.
+	err = bucket.DeleteObject(ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	if err != nil {
+		t.Fatalf("DeleteObject: %v", err)
+	}
+
+	for _, b := range []gcs.Bucket{primary, secondary} {
+		_, err = b.StatObject(ctx, &gcs.StatObjectRequest{Name: "foo"})
+		if _, ok := err.(*gcs.NotFoundError); !ok {
+			t.Errorf("%s: got %v, want *gcs.NotFoundError", b.Name(), err)
+		}
+	}
+}
+
+func TestMirroringBucket_SynchronousFailure(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := newMirrorTestBuckets()
+	bucket := gcsx.NewMirroringBucket(
+		gcsx.MirrorConfig{Synchronous: true},
+		primary,
+		secondary)
+
+	// The secondary failing should fail the call, but the primary should have
+	// been updated.
+	someErr := errors.New("taco")
+	secondary.err = someErr
+
+	o, err := gcsutil.CreateObject(ctx, bucket, "foo", []byte("burrito"))
+	me, ok := err.(*gcsx.MirrorError)
+	if !ok {
+		t.Fatalf("Got %v, want *gcsx.MirrorError", err)
+	}
+
+	if me.Method != "CreateObject" || me.Name != "foo" || me.Err != someErr {
+		t.Errorf("Unexpected error: %v", me)
+	}
+
+	if got := readString(t, primary, "foo"); got != "burrito" {
+		t.Errorf("Primary: got %q", got)
+	}
+
+	// The primary's new object should be returned along with the error.
+	live, err := primary.StatObject(ctx, &gcs.StatObjectRequest{Name: "foo"})
+	if err != nil {
+		t.Fatalf("StatObject: %v", err)
+	}
+
+	if o == nil || o.Generation != live.Generation {
+		t.Errorf("Got object %#v, want generation %d", o, live.Generation)
+	}
+
+	// Likewise for deletion.
+	err = bucket.DeleteObject(ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	if _, ok := err.(*gcsx.MirrorError); !ok {
+		t.Fatalf("Got %v, want *gcsx.MirrorError", err)
+	}
+
+	_, err = primary.StatObject(ctx, &gcs.StatObjectRequest{Name: "foo"})
+	if _, ok := err.(*gcs.NotFoundError); !ok {
+		t.Errorf("Got %v, want *gcs.NotFoundError", err)
+	}
+}
+
+func TestMirroringBucket_AsynchronousFailure(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := newMirrorTestBuckets()
+
+	errs := make(chan mirrorError, 2)
+	bucket := gcsx.NewMirroringBucket(
+		gcsx.MirrorConfig{
+			OnMirrorError: func(method string, name string, err error) {
+				errs <- mirrorError{method, name, err}
+			},
+		},
+		primary,
+		secondary)
+
+	// The primary write should succeed regardless.
+	someErr := errors.New("taco")
+	secondary.err = someErr
+
+	_, err := gcsutil.CreateObject(ctx, bucket, "foo", []byte("burrito"))
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	if got := readString(t, primary, "foo"); got != "burrito" {
+		t.Errorf("Primary: got %q", got)
+	}
+
+	// The failure should be reported.
+	select {
+	case e := <-errs:
+		if e.method != "CreateObject" || e.name != "foo" || e.err != someErr {
+			t.Errorf("Unexpected report: %#v", e)
+		}
+
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for a report")
+	}
+}
+
+// A bucket whose CreateObject calls block until release is closed, and which
+// reports each CreateObject and DeleteObject call to done once it finishes.
+type blockingMutationBucket struct {
+	gcs.Bucket
+	release chan struct{}
+	done    chan string
+}
+
+func (b *blockingMutationBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	<-b.release
+	o, err = b.Bucket.CreateObject(ctx, req)
+	b.done <- "CreateObject"
+	return
+}
+
+func (b *blockingMutationBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	err = b.Bucket.DeleteObject(ctx, req)
+	b.done <- "DeleteObject"
+	return
+}
+
+func TestMirroringBucket_AsynchronousOrdering(t *testing.T) {
+	ctx := context.Background()
+	primary := gcsfake.NewFakeBucket(timeutil.RealClock(), "primary")
+	secondary := &blockingMutationBucket{
+		Bucket:  gcsfake.NewFakeBucket(timeutil.RealClock(), "secondary"),
+		release: make(chan struct{}),
+		done:    make(chan string, 2),
+	}
+
+	bucket := gcsx.NewMirroringBucket(gcsx.MirrorConfig{}, primary, secondary)
+
+	// Create and then delete an object, while the secondary is stuck on the
+	// creation.
+	_, err := gcsutil.CreateObject(ctx, bucket, "foo", []byte("taco"))
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	err = bucket.DeleteObject(ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	if err != nil {
+		t.Fatalf("DeleteObject: %v", err)
+	}
+
+	// The deletion shouldn't overtake the creation, which would leave the
+	// object behind in the secondary.
+	close(secondary.release)
+	for _, want := range []string{"CreateObject", "DeleteObject"} {
+		select {
+		case got := <-secondary.done:
+			if got != want {
+				t.Fatalf("Got %s, want %s", got, want)
+			}
+
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for %s", want)
+		}
+	}
+
+	_, err = secondary.StatObject(ctx, &gcs.StatObjectRequest{Name: "foo"})
+	if _, ok := err.(*gcs.NotFoundError); !ok {
+		t.Errorf("Got %v, want *gcs.NotFoundError", err)
+	}
+}
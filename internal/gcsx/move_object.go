@@ -0,0 +1,150 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// A request to move an object to a new name within the same bucket.
+type MoveObjectRequest struct {
+	// The name of the object to move. Must be specified.
+	SrcName string
+
+	// The generation of the source object to move. Zero means the latest
+	// generation.
+	SrcGeneration int64
+
+	// The name the object should have once moved. Must be specified, and must
+	// differ from SrcName.
+	DstName string
+
+	// If non-nil, the request will fail with *gcs.PreconditionError and without
+	// effect unless the current generation of the destination name is equal to
+	// this value. Zero means the destination must not exist.
+	DstGenerationPrecondition *int64
+}
+
+// A bucket that supports moving objects natively, more cheaply than a copy
+// followed by a delete. Some GCS-compatible backends offer this.
+type MovingBucket interface {
+	gcs.Bucket
+
+	// Move the source object to the destination name, returning a record for
+	// the object at its new name.
+	MoveObject(
+		ctx context.Context,
+		req *MoveObjectRequest) (*gcs.Object, error)
+}
+
+// Move an object within the bucket, using its native support if it is a
+// MovingBucket and otherwise copying the object and then deleting the
+// source.
+//
+// In the fallback case, a destination precondition is enforced by GCS itself,
+// by composing the source into the destination rather than copying it, since
+// copies can't carry one. A composed object keeps only the source's content
+// type and custom metadata. The source is deleted at exactly the generation
+// copied; if deleting fails, the object will exist under both names.
+func MoveObject(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	req *MoveObjectRequest) (o *gcs.Object, err error) {
+	if req.SrcName == req.DstName {
+		err = fmt.Errorf("Can't move %q onto itself", req.SrcName)
+		return
+	}
+
+	// Use native support, if any.
+	if mb, ok := bucket.(MovingBucket); ok {
+		o, err = mb.MoveObject(ctx, req)
+		return
+	}
+
+	// Find the source generation, so we delete exactly what we copied, along
+	// with the attributes a composed copy must be given explicitly.
+	srcGeneration := req.SrcGeneration
+	var src *gcs.Object
+	if srcGeneration == 0 || req.DstGenerationPrecondition != nil {
+		src, err = bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: req.SrcName})
+		if err != nil {
+			err = fmt.Errorf("StatObject(%q): %v", req.SrcName, err)
+			return
+		}
+
+		if srcGeneration == 0 {
+			srcGeneration = src.Generation
+		}
+	}
+
+	// Copy.
+	if req.DstGenerationPrecondition != nil {
+		o, err = bucket.ComposeObjects(
+			ctx,
+			&gcs.ComposeObjectsRequest{
+				DstName:                   req.DstName,
+				DstGenerationPrecondition: req.DstGenerationPrecondition,
+				Sources: []gcs.ComposeSource{
+					{
+						Name:       req.SrcName,
+						Generation: srcGeneration,
+					},
+				},
+				ContentType: src.ContentType,
+				Metadata:    src.Metadata,
+			})
+
+		// Don't mangle precondition errors.
+		if _, ok := err.(*gcs.PreconditionError); ok {
+			return
+		}
+
+		if err != nil {
+			err = fmt.Errorf("ComposeObjects: %v", err)
+			return
+		}
+	} else {
+		o, err = bucket.CopyObject(
+			ctx,
+			&gcs.CopyObjectRequest{
+				SrcName:       req.SrcName,
+				SrcGeneration: srcGeneration,
+				DstName:       req.DstName,
+			})
+
+		if err != nil {
+			err = fmt.Errorf("CopyObject: %v", err)
+			return
+		}
+	}
+
+	// Delete behind.
+	err = bucket.DeleteObject(
+		ctx,
+		&gcs.DeleteObjectRequest{
+			Name:       req.SrcName,
+			Generation: srcGeneration,
+		})
+
+	if err != nil {
+		err = fmt.Errorf("DeleteObject: %v", err)
+		return
+	}
+
+	return
+}
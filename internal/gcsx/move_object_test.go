@@ -0,0 +1,288 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// A bucket that records calls to MoveObject, and otherwise refuses to copy.
+type movingBucket struct {
+	gcs.Bucket
+	moves []*gcsx.MoveObjectRequest
+}
+
+func (b *movingBucket) MoveObject(
+	ctx context.Context,
+	req *gcsx.MoveObjectRequest) (o *gcs.Object, err error) {
+	b.moves = append(b.moves, req)
+	o = &gcs.Object{Name: req.DstName}
+	return
+}
+
+func (b *movingBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	panic("CopyObject called")
+}
+
+// A bucket in which another writer creates an object named "bar" just before
+// each copy or composition.
+type racingMoveBucket struct {
+	gcs.Bucket
+}
+
+func (b *racingMoveBucket) race(ctx context.Context) {
+	_, err := gcsutil.CreateObject(ctx, b.Bucket, "bar", []byte("burrito"))
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (b *racingMoveBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	b.race(ctx)
+	o, err = b.Bucket.CopyObject(ctx, req)
+	return
+}
+
+func (b *racingMoveBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	b.race(ctx)
+	o, err = b.Bucket.ComposeObjects(ctx, req)
+	return
+}
+
+func newMoveTestBucket(t *testing.T) gcs.Bucket {
+	bucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+	_, err := gcsutil.CreateObject(
+		context.Background(),
+		bucket,
+		"foo",
+		[]byte("taco"))
+
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	return bucket
+}
+
+func TestMoveObject_Native(t *testing.T) {
+	bucket := &movingBucket{Bucket: newMoveTestBucket(t)}
+
+	o, err := gcsx.MoveObject(
+		context.Background(),
+		bucket,
+		&gcsx.MoveObjectRequest{SrcName: "foo", DstName: "bar"})
+
+	if err != nil {
+		t.Fatalf("MoveObject: %v", err)
+	}
+
+	if o.Name != "bar" {
+		t.Errorf("Unexpected name: %q", o.Name)
+	}
+
+	if len(bucket.moves) != 1 {
+		t.Fatalf("Expected one native move, got %d", len(bucket.moves))
+	}
+
+	if got := bucket.moves[0]; got.SrcName != "foo" || got.DstName != "bar" {
+		t.Errorf("Unexpected request: %#v", got)
+	}
+}
+
+func TestMoveObject_Fallback(t *testing.T) {
+	ctx := context.Background()
+	bucket := newMoveTestBucket(t)
+
+	o, err := gcsx.MoveObject(
+		ctx,
+		bucket,
+		&gcsx.MoveObjectRequest{SrcName: "foo", DstName: "bar"})
+
+	if err != nil {
+		t.Fatalf("MoveObject: %v", err)
+	}
+
+	if o.Name != "bar" {
+		t.Errorf("Unexpected name: %q", o.Name)
+	}
+
+	// The contents should be at the new name only.
+	contents, err := gcsutil.ReadObject(ctx, bucket, "bar")
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+
+	if got, want := string(contents), "taco"; got != want {
+		t.Errorf("Contents are %q, want %q", got, want)
+	}
+
+	_, err = bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: "foo"})
+	if _, ok := err.(*gcs.NotFoundError); !ok {
+		t.Errorf("Unexpected error statting source: %#v", err)
+	}
+}
+
+func TestMoveObject_Fallback_DestinationExists(t *testing.T) {
+	ctx := context.Background()
+	bucket := newMoveTestBucket(t)
+
+	_, err := gcsutil.CreateObject(ctx, bucket, "bar", []byte("burrito"))
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	var precond int64
+	_, err = gcsx.MoveObject(
+		ctx,
+		bucket,
+		&gcsx.MoveObjectRequest{
+			SrcName:                   "foo",
+			DstName:                   "bar",
+			DstGenerationPrecondition: &precond,
+		})
+
+	if _, ok := err.(*gcs.PreconditionError); !ok {
+		t.Fatalf("Unexpected error: %#v", err)
+	}
+
+	// Nothing should have changed.
+	contents, err := gcsutil.ReadObject(ctx, bucket, "bar")
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+
+	if got, want := string(contents), "burrito"; got != want {
+		t.Errorf("Destination contents are %q, want %q", got, want)
+	}
+
+	_, err = bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: "foo"})
+	if err != nil {
+		t.Errorf("StatObject(foo): %v", err)
+	}
+}
+
+func TestMoveObject_Fallback_DestinationGenerationMatches(t *testing.T) {
+	ctx := context.Background()
+	bucket := newMoveTestBucket(t)
+
+	dst, err := gcsutil.CreateObject(ctx, bucket, "bar", []byte("burrito"))
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	_, err = gcsx.MoveObject(
+		ctx,
+		bucket,
+		&gcsx.MoveObjectRequest{
+			SrcName:                   "foo",
+			DstName:                   "bar",
+			DstGenerationPrecondition: &dst.Generation,
+		})
+
+	if err != nil {
+		t.Fatalf("MoveObject: %v", err)
+	}
+
+	contents, err := gcsutil.ReadObject(ctx, bucket, "bar")
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+
+	if got, want := string(contents), "taco"; got != want {
+		t.Errorf("Contents are %q, want %q", got, want)
+	}
+}
+
+func TestMoveObject_Fallback_DestinationCreatedConcurrently(t *testing.T) {
+	ctx := context.Background()
+	bucket := &racingMoveBucket{Bucket: newMoveTestBucket(t)}
+
+	var precond int64
+	_, err := gcsx.MoveObject(
+		ctx,
+		bucket,
+		&gcsx.MoveObjectRequest{
+			SrcName:                   "foo",
+			DstName:                   "bar",
+			DstGenerationPrecondition: &precond,
+		})
+
+	if _, ok := err.(*gcs.PreconditionError); !ok {
+		t.Fatalf("Unexpected error: %#v", err)
+	}
+
+	// The other writer's object should have survived, as should the source.
+	contents, err := gcsutil.ReadObject(ctx, bucket, "bar")
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+
+	if got, want := string(contents), "burrito"; got != want {
+		t.Errorf("Destination contents are %q, want %q", got, want)
+	}
+
+	_, err = bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: "foo"})
+	if err != nil {
+		t.Errorf("StatObject(foo): %v", err)
+	}
+}
+
+func TestMoveObject_Fallback_PreconditionKeepsMetadata(t *testing.T) {
+	ctx := context.Background()
+	bucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	_, err := bucket.CreateObject(ctx, &gcs.CreateObjectRequest{
+		Name:        "foo",
+		Contents:    strings.NewReader("taco"),
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"enchilada": "queso"},
+	})
+
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	var precond int64
+	o, err := gcsx.MoveObject(
+		ctx,
+		bucket,
+		&gcsx.MoveObjectRequest{
+			SrcName:                   "foo",
+			DstName:                   "bar",
+			DstGenerationPrecondition: &precond,
+		})
+
+	if err != nil {
+		t.Fatalf("MoveObject: %v", err)
+	}
+
+	if o.ContentType != "text/plain" || o.Metadata["enchilada"] != "queso" {
+		t.Errorf("Unexpected object: %#v", o)
+	}
+}
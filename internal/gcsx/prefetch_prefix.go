@@ -0,0 +1,162 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// List the objects whose names begin with the supplied prefix and read each
+// of them in full, with at most concurrency reads in flight at once. Each
+// object's contents, read at its listed generation, are handed to warm, which
+// may for example copy them into a local cache so that a later walk of the
+// prefix needn't contact GCS. warm may be called concurrently. If it is nil
+// the contents are discarded, which may still be useful for warming caches
+// between here and GCS.
+//
+// Stops and returns the first error encountered, including from warm. Objects
+// deleted or overwritten since the listing are skipped. Returns ctx.Err() if
+// the context is cancelled before all objects have been read.
+func PrefetchPrefix(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	prefix string,
+	concurrency int,
+	warm func(o *gcs.Object, r io.Reader) error) (err error) {
+	if concurrency < 1 {
+		err = fmt.Errorf("Invalid concurrency: %d", concurrency)
+		return
+	}
+
+	// Stop everything on the first error.
+	parent := ctx
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	var mu sync.Mutex
+	var firstErr error
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	// Feed listed objects to the workers.
+	todo := make(chan *gcs.Object)
+	go func() {
+		defer close(todo)
+
+		req := &gcs.ListObjectsRequest{Prefix: prefix}
+		for {
+			listing, err := bucket.ListObjects(ctx, req)
+			if err != nil {
+				fail(fmt.Errorf("ListObjects: %v", err))
+				return
+			}
+
+			for _, o := range listing.Objects {
+				select {
+				case todo <- o:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			if listing.ContinuationToken == "" {
+				return
+			}
+
+			req.ContinuationToken = listing.ContinuationToken
+		}
+	}()
+
+	// Read, recording failures.
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := range todo {
+				// Don't start anything new once we've been stopped.
+				if ctx.Err() != nil {
+					continue
+				}
+
+				if err := prefetchObject(ctx, bucket, o, warm); err != nil {
+					fail(fmt.Errorf("%q: %v", o.Name, err))
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// Report cancellation by the caller in preference to the failures it
+	// caused.
+	if err = parent.Err(); err != nil {
+		return
+	}
+
+	err = firstErr
+	return
+}
+
+func prefetchObject(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	o *gcs.Object,
+	warm func(o *gcs.Object, r io.Reader) error) (err error) {
+	rc, err := bucket.NewReader(
+		ctx,
+		&gcs.ReadObjectRequest{
+			Name:       o.Name,
+			Generation: o.Generation,
+		})
+
+	// Skip objects that have gone away.
+	if _, ok := err.(*gcs.NotFoundError); ok {
+		err = nil
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("NewReader: %v", err)
+		return
+	}
+
+	defer rc.Close()
+
+	if warm == nil {
+		_, err = io.Copy(ioutil.Discard, rc)
+		if err != nil {
+			err = fmt.Errorf("Copy: %v", err)
+		}
+
+		return
+	}
+
+	err = warm(o, rc)
+	return
+}
@@ -0,0 +1,207 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// A bucket that lists in small pages and records the reads made to it. Each
+// call to NewReader waits (for a while) until wantInFlight calls are in
+// flight at once, so that tests can check that reads really run in parallel.
+type prefetchTestBucket struct {
+	gcs.Bucket
+	wantInFlight int
+
+	mu          sync.Mutex
+	reads       map[string]int
+	inFlight    int
+	maxInFlight int
+}
+
+func (b *prefetchTestBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (l *gcs.Listing, err error) {
+	modified := *req
+	modified.MaxResults = 2
+	l, err = b.Bucket.ListObjects(ctx, &modified)
+	return
+}
+
+func (b *prefetchTestBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	b.mu.Lock()
+	b.reads[req.Name]++
+	b.inFlight++
+	if b.inFlight > b.maxInFlight {
+		b.maxInFlight = b.inFlight
+	}
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		b.inFlight--
+		b.mu.Unlock()
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		b.mu.Lock()
+		done := b.maxInFlight >= b.wantInFlight
+		b.mu.Unlock()
+
+		if done {
+			break
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	rc, err = b.Bucket.NewReader(ctx, req)
+	return
+}
+
+func newPrefetchTestBucket(
+	t *testing.T,
+	names []string) *prefetchTestBucket {
+	bucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+	for _, name := range names {
+		_, err := gcsutil.CreateObject(
+			context.Background(),
+			bucket,
+			name,
+			[]byte(name))
+
+		if err != nil {
+			t.Fatalf("CreateObject: %v", err)
+		}
+	}
+
+	return &prefetchTestBucket{
+		Bucket: bucket,
+		reads:  make(map[string]int),
+	}
+}
+
+func TestPrefetchPrefix_ReadsEachObject(t *testing.T) {
+	const concurrency = 3
+
+	var names []string
+	for i := 0; i < 10; i++ {
+		names = append(names, fmt.Sprintf("dir/%d", i))
+	}
+
+	bucket := newPrefetchTestBucket(t, append(names, "other"))
+	bucket.wantInFlight = concurrency
+
+	// Record what is handed to the callback.
+	var mu sync.Mutex
+	warmed := make(map[string]string)
+	warm := func(o *gcs.Object, r io.Reader) error {
+		contents, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		warmed[o.Name] = string(contents)
+		mu.Unlock()
+		return nil
+	}
+
+	err := gcsx.PrefetchPrefix(context.Background(), bucket, "dir/", concurrency, warm)
+	if err != nil {
+		t.Fatalf("PrefetchPrefix: %v", err)
+	}
+
+	// Each object under the prefix should have been read once, and nothing
+	// else.
+	if len(bucket.reads) != len(names) {
+		t.Errorf("Unexpected reads: %v", bucket.reads)
+	}
+
+	for _, name := range names {
+		if bucket.reads[name] != 1 {
+			t.Errorf("%q read %d times", name, bucket.reads[name])
+		}
+
+		if warmed[name] != name {
+			t.Errorf("%q: warmed with %q", name, warmed[name])
+		}
+	}
+
+	// The reads should have run in parallel, up to the bound.
+	if bucket.maxInFlight != concurrency {
+		t.Errorf("Got %d reads in flight, want %d", bucket.maxInFlight, concurrency)
+	}
+}
+
+func TestPrefetchPrefix_WarmFails(t *testing.T) {
+	bucket := newPrefetchTestBucket(t, []string{"dir/0", "dir/1", "dir/2"})
+
+	someErr := errors.New("taco")
+	warm := func(o *gcs.Object, r io.Reader) error {
+		return someErr
+	}
+
+	err := gcsx.PrefetchPrefix(context.Background(), bucket, "dir/", 1, warm)
+	if err == nil {
+		t.Fatalf("Expected an error")
+	}
+
+	// Nothing more should have been read after the failure.
+	if len(bucket.reads) != 1 {
+		t.Errorf("Unexpected reads: %v", bucket.reads)
+	}
+}
+
+func TestPrefetchPrefix_Cancelled(t *testing.T) {
+	var names []string
+	for i := 0; i < 10; i++ {
+		names = append(names, fmt.Sprintf("dir/%d", i))
+	}
+
+	bucket := newPrefetchTestBucket(t, names)
+
+	// Cancel after the first object.
+	ctx, cancel := context.WithCancel(context.Background())
+	warm := func(o *gcs.Object, r io.Reader) error {
+		cancel()
+		return nil
+	}
+
+	err := gcsx.PrefetchPrefix(ctx, bucket, "dir/", 1, warm)
+	if err != context.Canceled {
+		t.Errorf("Got %v, want context.Canceled", err)
+	}
+
+	if len(bucket.reads) >= len(names) {
+		t.Errorf("Unexpected reads: %v", bucket.reads)
+	}
+}
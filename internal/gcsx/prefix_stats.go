@@ -0,0 +1,70 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Return the number of objects whose names begin with the supplied prefix,
+// and the sum of their sizes, paging through ListObjects as necessary. Only
+// live generations are counted, and "directories" are not treated specially.
+//
+// Stops early and returns ctx.Err() if the context is cancelled between
+// pages.
+func PrefixStats(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	prefix string) (objects uint64, bytes uint64, err error) {
+	req := &gcs.ListObjectsRequest{
+		Prefix: prefix,
+	}
+
+	for {
+		// Have we been cancelled?
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+
+		default:
+		}
+
+		// Grab one page.
+		var listing *gcs.Listing
+		listing, err = bucket.ListObjects(ctx, req)
+		if err != nil {
+			err = fmt.Errorf("ListObjects: %v", err)
+			return
+		}
+
+		for _, o := range listing.Objects {
+			objects++
+			bytes += o.Size
+		}
+
+		// Are we done?
+		if listing.ContinuationToken == "" {
+			break
+		}
+
+		req.ContinuationToken = listing.ContinuationToken
+	}
+
+	return
+}
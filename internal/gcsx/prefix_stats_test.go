@@ -0,0 +1,152 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// A bucket that returns listings of at most pageSize objects, counting the
+// calls made, calling onRequest with each request, and calling onList after
+// each.
+type pagingBucket struct {
+	gcs.Bucket
+	pageSize  int
+	calls     int
+	onRequest func(req *gcs.ListObjectsRequest)
+	onList    func()
+}
+
+func (b *pagingBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (l *gcs.Listing, err error) {
+	b.calls++
+	if b.onRequest != nil {
+		b.onRequest(req)
+	}
+
+	modified := *req
+	modified.MaxResults = b.pageSize
+	l, err = b.Bucket.ListObjects(ctx, &modified)
+
+	if b.onList != nil {
+		b.onList()
+	}
+
+	return
+}
+
+func newPagingBucket(
+	t *testing.T,
+	contents map[string]string) *pagingBucket {
+	bucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+	for name, c := range contents {
+		_, err := gcsutil.CreateObject(
+			context.Background(),
+			bucket,
+			name,
+			[]byte(c))
+
+		if err != nil {
+			t.Fatalf("CreateObject: %v", err)
+		}
+	}
+
+	return &pagingBucket{Bucket: bucket, pageSize: 3}
+}
+
+func TestPrefixStats_MultiplePages(t *testing.T) {
+	contents := map[string]string{
+		"foo":     "taco",
+		"dir/bar": "burrito",
+		"dir2/":   "",
+	}
+
+	var expectedBytes uint64
+	for i := 0; i < 10; i++ {
+		c := strings.Repeat("x", i)
+		contents[fmt.Sprintf("dir/%d", i)] = c
+		contents[fmt.Sprintf("dir/sub/%d", i)] = c
+		expectedBytes += 2 * uint64(i)
+	}
+
+	expectedBytes += uint64(len("burrito"))
+
+	bucket := newPagingBucket(t, contents)
+	objects, bytes, err := gcsx.PrefixStats(context.Background(), bucket, "dir/")
+	if err != nil {
+		t.Fatalf("PrefixStats: %v", err)
+	}
+
+	if objects != 21 {
+		t.Errorf("Got %d objects, want 21", objects)
+	}
+
+	if bytes != expectedBytes {
+		t.Errorf("Got %d bytes, want %d", bytes, expectedBytes)
+	}
+
+	// 21 objects in pages of three.
+	if bucket.calls != 7 {
+		t.Errorf("Got %d ListObjects calls, want 7", bucket.calls)
+	}
+}
+
+func TestPrefixStats_Empty(t *testing.T) {
+	bucket := newPagingBucket(t, map[string]string{"foo": "taco"})
+	objects, bytes, err := gcsx.PrefixStats(context.Background(), bucket, "dir/")
+	if err != nil {
+		t.Fatalf("PrefixStats: %v", err)
+	}
+
+	if objects != 0 || bytes != 0 {
+		t.Errorf("Got (%d, %d), want (0, 0)", objects, bytes)
+	}
+
+	if bucket.calls != 1 {
+		t.Errorf("Got %d ListObjects calls, want 1", bucket.calls)
+	}
+}
+
+func TestPrefixStats_Cancelled(t *testing.T) {
+	contents := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		contents[fmt.Sprintf("dir/%d", i)] = "taco"
+	}
+
+	bucket := newPagingBucket(t, contents)
+
+	// Cancel after the first page.
+	ctx, cancel := context.WithCancel(context.Background())
+	bucket.onList = cancel
+
+	_, _, err := gcsx.PrefixStats(ctx, bucket, "dir/")
+	if err != context.Canceled {
+		t.Errorf("Got %v, want context.Canceled", err)
+	}
+
+	if bucket.calls != 1 {
+		t.Errorf("Got %d ListObjects calls, want 1", bucket.calls)
+	}
+}
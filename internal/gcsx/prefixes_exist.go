@@ -0,0 +1,82 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// The number of ListObjects calls PrefixesExist makes at once.
+const prefixesExistWorkers = 16
+
+// Determine for each of the supplied prefixes whether any object's name
+// begins with it, as when checking that the intermediate directories of a
+// deep path exist, using some parallelism. This takes one single-result
+// listing per prefix.
+//
+// The result has an entry for every prefix. If any listing fails, one of the
+// errors is returned.
+func PrefixesExist(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	prefixes []string) (exists map[string]bool, err error) {
+	exists = make(map[string]bool)
+
+	// Feed prefixes to the workers.
+	todo := make(chan string)
+	go func() {
+		defer close(todo)
+		for _, p := range prefixes {
+			todo <- p
+		}
+	}()
+
+	// List, recording results and the first failure.
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < prefixesExistWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range todo {
+				listing, listErr := bucket.ListObjects(
+					ctx,
+					&gcs.ListObjectsRequest{
+						Prefix:     p,
+						MaxResults: 1,
+					})
+
+				mu.Lock()
+				if listErr != nil && err == nil {
+					err = fmt.Errorf("ListObjects(%q): %v", p, listErr)
+				}
+
+				exists[p] = listErr == nil && len(listing.Objects) > 0
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	if err != nil {
+		exists = nil
+	}
+
+	return
+}
@@ -0,0 +1,158 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// A bucket that records the largest number of ListObjects calls it has seen
+// in flight at once, holding each call open briefly. Listings of failPrefix
+// fail.
+type concurrencyRecordingBucket struct {
+	gcs.Bucket
+	failPrefix string
+
+	mu       sync.Mutex
+	inFlight int
+	max      int
+	calls    int
+}
+
+func (b *concurrencyRecordingBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	b.mu.Lock()
+	b.calls++
+	b.inFlight++
+	if b.inFlight > b.max {
+		b.max = b.inFlight
+	}
+	b.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	b.mu.Lock()
+	b.inFlight--
+	b.mu.Unlock()
+
+	if req.Prefix == b.failPrefix {
+		err = errors.New("taco")
+		return
+	}
+
+	listing, err = b.Bucket.ListObjects(ctx, req)
+	return
+}
+
+func newConcurrencyRecordingBucket(
+	t *testing.T,
+	names ...string) (b *concurrencyRecordingBucket) {
+	b = &concurrencyRecordingBucket{
+		Bucket: gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"),
+	}
+
+	for _, name := range names {
+		_, err := b.CreateObject(context.Background(), &gcs.CreateObjectRequest{
+			Name:     name,
+			Contents: strings.NewReader(""),
+		})
+
+		if err != nil {
+			t.Fatalf("CreateObject: %v", err)
+		}
+	}
+
+	return
+}
+
+func TestPrefixesExist(t *testing.T) {
+	bucket := newConcurrencyRecordingBucket(t, "a/", "a/b/c/d", "e/f")
+
+	exists, err := gcsx.PrefixesExist(
+		context.Background(),
+		bucket,
+		[]string{"a/", "a/b/", "a/b/c/", "a/x/", "e/", "g/"})
+
+	if err != nil {
+		t.Fatalf("PrefixesExist: %v", err)
+	}
+
+	expected := map[string]bool{
+		"a/":     true,
+		"a/b/":   true,
+		"a/b/c/": true,
+		"a/x/":   false,
+		"e/":     true,
+		"g/":     false,
+	}
+
+	if !reflect.DeepEqual(exists, expected) {
+		t.Errorf("Got %v, want %v", exists, expected)
+	}
+}
+
+func TestPrefixesExist_BoundsConcurrency(t *testing.T) {
+	bucket := newConcurrencyRecordingBucket(t, "foo")
+
+	var prefixes []string
+	for i := 0; i < 100; i++ {
+		prefixes = append(prefixes, fmt.Sprintf("dir%d/", i))
+	}
+
+	exists, err := gcsx.PrefixesExist(context.Background(), bucket, prefixes)
+	if err != nil {
+		t.Fatalf("PrefixesExist: %v", err)
+	}
+
+	if len(exists) != len(prefixes) {
+		t.Errorf("Got %d results, want %d", len(exists), len(prefixes))
+	}
+
+	if bucket.calls != len(prefixes) {
+		t.Errorf("Got %d calls, want %d", bucket.calls, len(prefixes))
+	}
+
+	// PrefixesExist uses sixteen workers.
+	if bucket.max > 16 {
+		t.Errorf("Got %d concurrent calls, want at most 16", bucket.max)
+	}
+}
+
+func TestPrefixesExist_Error(t *testing.T) {
+	bucket := newConcurrencyRecordingBucket(t, "a/b")
+	bucket.failPrefix = "c/"
+
+	_, err := gcsx.PrefixesExist(
+		context.Background(),
+		bucket,
+		[]string{"a/", "c/"})
+
+	if err == nil || !strings.Contains(err.Error(), "taco") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -112,11 +112,11 @@ func (rr *randomReader) ReadAt(
 		}
 
 		// If we have an existing reader but it's positioned at the wrong place,
-		// clean it up and throw it away.
+		// clean it up and throw it away. If it was streaming ahead for a
+		// sequential reader that has now jumped elsewhere, this cancels the
+		// request rather than leaving it to consume bandwidth.
 		if rr.reader != nil && rr.start != offset {
-			rr.reader.Close()
-			rr.reader = nil
-			rr.cancel = nil
+			rr.discardReader()
 		}
 
 		// If we don't have a reader, start a read operation.
@@ -143,9 +143,7 @@ func (rr *randomReader) ReadAt(
 			err = fmt.Errorf("Reader returned %d too many bytes", rr.start-rr.limit)
 
 			// Don't attempt to reuse the reader when it's behaving wackily.
-			rr.reader.Close()
-			rr.reader = nil
-			rr.cancel = nil
+			rr.discardReader()
 			rr.start = -1
 			rr.limit = -1
 
@@ -154,9 +152,7 @@ func (rr *randomReader) ReadAt(
 
 		// Are we finished with this reader now?
 		if rr.start == rr.limit {
-			rr.reader.Close()
-			rr.reader = nil
-			rr.cancel = nil
+			rr.discardReader()
 		}
 
 		// Handle errors.
@@ -190,12 +186,20 @@ func (rr *randomReader) Object() (o *gcs.Object) {
 func (rr *randomReader) Destroy() {
 	// Close out the reader, if we have one.
 	if rr.reader != nil {
-		rr.reader.Close()
-		rr.reader = nil
-		rr.cancel = nil
+		rr.discardReader()
 	}
 }
 
+// Cancel the in-flight read request and close its reader.
+//
+// REQUIRES: rr.reader != nil
+func (rr *randomReader) discardReader() {
+	rr.cancel()
+	rr.reader.Close()
+	rr.reader = nil
+	rr.cancel = nil
+}
+
 // Like io.ReadFull, but deals with the cancellation issues.
 //
 // REQUIRES: rr.reader != nil
@@ -20,6 +20,7 @@ import (
 	"io"
 	"io/ioutil"
 	"strings"
+	"sync"
 	"testing"
 	"testing/iotest"
 	"time"
@@ -346,9 +347,11 @@ func (t *RandomReaderTest) PropagatesCancellation() {
 	t.rr.wrapped.start = 1
 	t.rr.wrapped.limit = 4
 
-	// Snoop on when cancel is called.
+	// Snoop on when cancel is called. Like a context.CancelFunc, it may be
+	// called more than once.
 	cancelCalled := make(chan struct{})
-	t.rr.wrapped.cancel = func() { close(cancelCalled) }
+	var cancelOnce sync.Once
+	t.rr.wrapped.cancel = func() { cancelOnce.Do(func() { close(cancelCalled) }) }
 
 	// Start a read in the background using a context that we control. It should
 	// not yet return.
@@ -523,3 +526,56 @@ func (t *RandomReaderTest) UpgradesSequentialReads_NoExistingReader() {
 	ExpectEq(1+readSize, t.rr.wrapped.start)
 	ExpectEq(t.object.Size, t.rr.wrapped.limit)
 }
+
+func (t *RandomReaderTest) SequentialThenRandom_CancelsReadAhead() {
+	t.object.Size = 1 << 40
+	const readSize = 10
+
+	// Simulate a previous exhausted reader that ended at the offset from which
+	// we read below, so that the read is upgraded to the rest of the object.
+	t.rr.wrapped.start = 1
+	t.rr.wrapped.limit = 1
+
+	// Capture the context of that request.
+	readAhead := &countingCloser{
+		Reader: strings.NewReader(strings.Repeat("x", 2*readSize)),
+	}
+
+	var readAheadCtx context.Context
+	ExpectCall(t.bucket, "NewReader")(
+		Any(),
+		AllOf(rangeStartIs(1), rangeLimitIs(t.object.Size))).
+		WillOnce(Invoke(func(
+			ctx context.Context,
+			req *gcs.ReadObjectRequest) (io.ReadCloser, error) {
+			readAheadCtx = ctx
+			return readAhead, nil
+		}))
+
+	buf := make([]byte, readSize)
+	_, err := t.rr.ReadAt(buf, 1)
+	AssertEq(nil, err)
+
+	// Watch for cancellation in the background, as the HTTP transport would.
+	cancelled := make(chan struct{})
+	go func() {
+		<-readAheadCtx.Done()
+		close(cancelled)
+	}()
+
+	// Now jump elsewhere. The read-ahead should be cancelled and closed, and
+	// a new request made.
+	ExpectCall(t.bucket, "NewReader")(Any(), rangeStartIs(1<<30)).
+		WillOnce(Return(ioutil.NopCloser(strings.NewReader("yyyy")), nil))
+
+	_, err = t.rr.ReadAt(buf[:4], 1<<30)
+	AssertEq(nil, err)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		AddFailure("Read-ahead was not cancelled.")
+	}
+
+	ExpectEq(1, readAhead.closeCount)
+}
@@ -0,0 +1,172 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+
+	"github.com/jacobsa/gcloud/gcs"
+)
+
+// A set of byte offsets, represented as a sequence of half-open ranges.
+//
+// The zero value is the empty set. Not safe for concurrent access.
+type rangeSet struct {
+	// INVARIANT: For each r, 0 <= r.start < r.limit
+	// INVARIANT: For each i > 0, ranges[i-1].limit < ranges[i].start
+	ranges []offsetRange
+}
+
+type offsetRange struct {
+	start int64
+	limit int64
+}
+
+func (rs *rangeSet) checkInvariants() {
+	for i, r := range rs.ranges {
+		// INVARIANT: For each r, 0 <= r.start < r.limit
+		if !(0 <= r.start && r.start < r.limit) {
+			panic(fmt.Sprintf("Illegal range: [%d, %d)", r.start, r.limit))
+		}
+
+		// INVARIANT: For each i > 0, ranges[i-1].limit < ranges[i].start
+		if i > 0 && !(rs.ranges[i-1].limit < r.start) {
+			panic(fmt.Sprintf(
+				"Ranges out of order: [%d, %d) then [%d, %d)",
+				rs.ranges[i-1].start,
+				rs.ranges[i-1].limit,
+				r.start,
+				r.limit))
+		}
+	}
+}
+
+// Add the offsets [start, limit) to the set.
+func (rs *rangeSet) add(start int64, limit int64) {
+	if start >= limit {
+		return
+	}
+
+	var out []offsetRange
+	inserted := false
+
+	for _, r := range rs.ranges {
+		switch {
+		// Entirely before the new range, and not abutting it.
+		case r.limit < start:
+			out = append(out, r)
+
+		// Entirely after the new range, and not abutting it.
+		case r.start > limit:
+			if !inserted {
+				out = append(out, offsetRange{start, limit})
+				inserted = true
+			}
+
+			out = append(out, r)
+
+		// Overlapping or abutting the new range; absorb it.
+		default:
+			start = minInt64(start, r.start)
+			limit = maxInt64(limit, r.limit)
+		}
+	}
+
+	if !inserted {
+		out = append(out, offsetRange{start, limit})
+	}
+
+	rs.ranges = out
+}
+
+// Remove all offsets at or beyond n from the set.
+func (rs *rangeSet) truncate(n int64) {
+	var out []offsetRange
+	for _, r := range rs.ranges {
+		if r.start >= n {
+			break
+		}
+
+		r.limit = minInt64(r.limit, n)
+		out = append(out, r)
+	}
+
+	rs.ranges = out
+}
+
+// Return the sub-ranges of [start, limit) that are not in the set, in
+// increasing order.
+func (rs *rangeSet) missing(start int64, limit int64) (out []gcs.ByteRange) {
+	for _, r := range rs.ranges {
+		if start >= limit {
+			break
+		}
+
+		if r.limit <= start {
+			continue
+		}
+
+		if r.start >= limit {
+			break
+		}
+
+		if r.start > start {
+			out = append(out, gcs.ByteRange{
+				Start: uint64(start),
+				Limit: uint64(r.start),
+			})
+		}
+
+		start = r.limit
+	}
+
+	if start < limit {
+		out = append(out, gcs.ByteRange{
+			Start: uint64(start),
+			Limit: uint64(limit),
+		})
+	}
+
+	return
+}
+
+// Return the ranges making up the set, in increasing order.
+func (rs *rangeSet) list() (out []gcs.ByteRange) {
+	for _, r := range rs.ranges {
+		out = append(out, gcs.ByteRange{
+			Start: uint64(r.start),
+			Limit: uint64(r.limit),
+		})
+	}
+
+	return
+}
+
+// Return the total number of offsets in the set.
+func (rs *rangeSet) size() (n int64) {
+	for _, r := range rs.ranges {
+		n += r.limit - r.start
+	}
+
+	return
+}
+
+func maxInt64(a int64, b int64) int64 {
+	if a > b {
+		return a
+	}
+
+	return b
+}
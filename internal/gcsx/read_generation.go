@@ -0,0 +1,53 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"io"
+
+	"github.com/jacobsa/gcloud/gcs"
+)
+
+// A reader returned by gcs.Bucket.NewReader that knows which generation of the
+// object it serves, as GCS reports in the x-goog-generation response header.
+// The GCS client doesn't expose this, but other backends may.
+type GenerationReader interface {
+	io.ReadCloser
+
+	// Return the generation whose contents are being served.
+	Generation() int64
+}
+
+// Check that rc, returned by NewReader for req, serves the generation that
+// req asked for, returning *StaleReadError if not. Readers that aren't
+// GenerationReaders, and requests for the live generation, always pass.
+func CheckReadGeneration(req *gcs.ReadObjectRequest, rc io.Reader) (err error) {
+	gr, ok := rc.(GenerationReader)
+	if !ok || req.Generation == 0 {
+		return
+	}
+
+	if g := gr.Generation(); g != req.Generation {
+		err = &StaleReadError{
+			Name:     req.Name,
+			Expected: req.Generation,
+			Actual:   g,
+		}
+
+		return
+	}
+
+	return
+}
@@ -0,0 +1,71 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+)
+
+// A reader that reports the generation it serves.
+type generationReader struct {
+	gcsx.GenerationReader
+	generation int64
+}
+
+func (gr *generationReader) Generation() int64 {
+	return gr.generation
+}
+
+func TestCheckReadGeneration(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		requested int64
+		rc        io.Reader
+		stale     bool
+	}{
+		{"plain reader", 17, strings.NewReader(""), false},
+		{"same generation", 17, &generationReader{generation: 17}, false},
+		{"other generation", 17, &generationReader{generation: 19}, true},
+		{"live generation", 0, &generationReader{generation: 19}, false},
+	}
+
+	for _, tc := range testCases {
+		req := &gcs.ReadObjectRequest{Name: "foo", Generation: tc.requested}
+		err := gcsx.CheckReadGeneration(req, tc.rc)
+
+		if !tc.stale {
+			if err != nil {
+				t.Errorf("%s: got error %v", tc.desc, err)
+			}
+
+			continue
+		}
+
+		sre, ok := err.(*gcsx.StaleReadError)
+		if !ok {
+			t.Errorf("%s: got error %v, want StaleReadError", tc.desc, err)
+			continue
+		}
+
+		if sre.Name != "foo" || sre.Expected != 17 || sre.Actual != 19 {
+			t.Errorf("%s: got %#v", tc.desc, sre)
+		}
+	}
+}
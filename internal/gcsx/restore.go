@@ -0,0 +1,95 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Make a copy of the given non-current generation of the named object its new
+// current generation, returning a record for the result. This happens on the
+// server side, without reading the contents.
+//
+// The copy is made only if the live generation is still the one seen when
+// Restore started, so that a concurrent write isn't silently overwritten; if
+// it has changed, *gcs.PreconditionError is returned and nothing is written.
+// If the source generation doesn't exist, *gcs.NotFoundError is returned.
+//
+// If the bucket is a VersionListingBucket the restored generation carries the
+// source generation's content type and metadata. Otherwise they are lost,
+// since they can't be looked up for a non-current generation.
+//
+// The copy is made with a single-source compose, which is what allows the
+// precondition. Like any composite object, the result has no MD5 hash.
+func Restore(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	name string,
+	sourceGeneration int64) (o *gcs.Object, err error) {
+	// Find the live generation, if any.
+	var current int64
+	live, err := StatObject(ctx, bucket, &gcs.StatObjectRequest{Name: name})
+	switch err.(type) {
+	case nil:
+		current = live.Generation
+
+	case *gcs.NotFoundError, *SoftDeletedError:
+		err = nil
+
+	default:
+		err = fmt.Errorf("StatObject: %v", err)
+		return
+	}
+
+	// Find the source generation's attributes, if we can.
+	versions, err := ListVersions(ctx, bucket, name)
+	if err != nil {
+		err = fmt.Errorf("ListVersions: %v", err)
+		return
+	}
+
+	req := &gcs.ComposeObjectsRequest{
+		DstName:                   name,
+		DstGenerationPrecondition: &current,
+		Sources: []gcs.ComposeSource{
+			{Name: name, Generation: sourceGeneration},
+		},
+	}
+
+	for _, v := range versions {
+		if v.Generation == sourceGeneration {
+			req.ContentType = v.ContentType
+			req.Metadata = v.Metadata
+		}
+	}
+
+	o, err = bucket.ComposeObjects(ctx, req)
+
+	// Don't mangle precondition and not found errors.
+	switch err.(type) {
+	case *gcs.PreconditionError, *gcs.NotFoundError:
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("ComposeObjects: %v", err)
+		return
+	}
+
+	return
+}
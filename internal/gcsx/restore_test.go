@@ -0,0 +1,206 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// A bucket that retains every generation created through it, as a versioned
+// bucket does, supporting listing them and composing from them. Compose
+// supports only a single source. beforeCompose, if set, is called first.
+type retainingBucket struct {
+	gcs.Bucket
+	contents      map[int64]string
+	records       []*gcs.Object
+	beforeCompose func()
+}
+
+func newRetainingBucket() *retainingBucket {
+	return &retainingBucket{
+		Bucket:   gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"),
+		contents: make(map[int64]string),
+	}
+}
+
+func (b *retainingBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	contents, err := ioutil.ReadAll(req.Contents)
+	if err != nil {
+		return
+	}
+
+	reqCopy := *req
+	reqCopy.Contents = strings.NewReader(string(contents))
+
+	o, err = b.Bucket.CreateObject(ctx, &reqCopy)
+	if err == nil {
+		b.contents[o.Generation] = string(contents)
+		b.records = append(b.records, o)
+	}
+
+	return
+}
+
+func (b *retainingBucket) ListObjectVersions(
+	ctx context.Context,
+	name string) (versions []*gcs.Object, err error) {
+	versions = append(versions, b.records...)
+	return
+}
+
+func (b *retainingBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	if b.beforeCompose != nil {
+		b.beforeCompose()
+	}
+
+	if len(req.Sources) != 1 {
+		err = errors.New("Unsupported compose")
+		return
+	}
+
+	contents, ok := b.contents[req.Sources[0].Generation]
+	if !ok {
+		err = &gcs.NotFoundError{Err: errors.New("No such generation")}
+		return
+	}
+
+	o, err = b.CreateObject(
+		ctx,
+		&gcs.CreateObjectRequest{
+			Name:                   req.DstName,
+			GenerationPrecondition: req.DstGenerationPrecondition,
+			ContentType:            req.ContentType,
+			Metadata:               req.Metadata,
+			Contents:               strings.NewReader(contents),
+		})
+
+	return
+}
+
+// Create "taco" and then overwrite it with "burrito", returning the first
+// generation.
+func createTwoGenerations(t *testing.T, bucket gcs.Bucket) (old *gcs.Object) {
+	ctx := context.Background()
+
+	old, err := bucket.CreateObject(
+		ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader("taco"),
+			Metadata: map[string]string{"bar": "baz"},
+		})
+
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	_, err = gcsutil.CreateObject(ctx, bucket, "foo", []byte("burrito"))
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	return
+}
+
+func TestRestore_Success(t *testing.T) {
+	ctx := context.Background()
+	bucket := newRetainingBucket()
+	old := createTwoGenerations(t, bucket)
+
+	o, err := gcsx.Restore(ctx, bucket, "foo", old.Generation)
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	live, err := bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: "foo"})
+	if err != nil {
+		t.Fatalf("StatObject: %v", err)
+	}
+
+	if o.Generation != live.Generation || o.Generation == old.Generation {
+		t.Errorf(
+			"Got generation %d, want new live generation %d",
+			o.Generation,
+			live.Generation)
+	}
+
+	if o.Metadata["bar"] != "baz" {
+		t.Errorf("Metadata not restored: %v", o.Metadata)
+	}
+
+	contents, err := gcsutil.ReadObject(ctx, bucket, "foo")
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+
+	if string(contents) != "taco" {
+		t.Errorf("Got contents %q, want %q", contents, "taco")
+	}
+}
+
+func TestRestore_CurrentGenerationChanged(t *testing.T) {
+	ctx := context.Background()
+	bucket := newRetainingBucket()
+	old := createTwoGenerations(t, bucket)
+
+	// Someone else writes after Restore has looked at the live generation.
+	bucket.beforeCompose = func() {
+		bucket.beforeCompose = nil
+		_, err := gcsutil.CreateObject(ctx, bucket, "foo", []byte("enchilada"))
+		if err != nil {
+			t.Fatalf("CreateObject: %v", err)
+		}
+	}
+
+	_, err := gcsx.Restore(ctx, bucket, "foo", old.Generation)
+	if _, ok := err.(*gcs.PreconditionError); !ok {
+		t.Fatalf("Unexpected error: %#v", err)
+	}
+
+	// Their write should stand.
+	contents, err := gcsutil.ReadObject(ctx, bucket, "foo")
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+
+	if string(contents) != "enchilada" {
+		t.Errorf("Got contents %q, want %q", contents, "enchilada")
+	}
+}
+
+func TestRestore_NoSuchGeneration(t *testing.T) {
+	ctx := context.Background()
+	bucket := newRetainingBucket()
+	createTwoGenerations(t, bucket)
+
+	_, err := gcsx.Restore(ctx, bucket, "foo", 12345)
+	if _, ok := err.(*gcs.NotFoundError); !ok {
+		t.Fatalf("Unexpected error: %#v", err)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Create an objectCreator that accepts a source object and the full contents
+// with which it should be overwritten, first uploading them to a temporary
+// object whose name begins with the supplied prefix and then replacing the
+// source object with it in a single request. Readers of the source object
+// therefore never observe a partially written generation, even if the upload
+// is interrupted.
+//
+// The replacement is done by composing the temporary object alone onto the
+// source object's name, because unlike copies, composes support preconditions
+// on the destination.
+//
+// Create attempts to delete the temporary object whether or not it succeeds,
+// but may fail to do so. Users should arrange for garbage collection.
+//
+// Create guarantees to return *gcs.PreconditionError when the source object
+// has been clobbered.
+func newStagedObjectCreator(
+	prefix string,
+	bucket gcs.Bucket) (oc objectCreator) {
+	oc = &stagedObjectCreator{
+		prefix: prefix,
+		bucket: bucket,
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Implementation
+////////////////////////////////////////////////////////////////////////
+
+type stagedObjectCreator struct {
+	prefix string
+	bucket gcs.Bucket
+}
+
+func (oc *stagedObjectCreator) requests(srcObject *gcs.Object) int {
+	return composeRequests(srcObject)
+}
+
+func (oc *stagedObjectCreator) Create(
+	ctx context.Context,
+	srcObject *gcs.Object,
+	mtime time.Time,
+	r io.Reader) (o *gcs.Object, err error) {
+	// Choose a name for a temporary object.
+	tmpName, err := randomObjectName(oc.prefix)
+	if err != nil {
+		err = fmt.Errorf("randomObjectName: %v", err)
+		return
+	}
+
+	// Upload the contents to the temporary object.
+	var zero int64
+	tmp, err := oc.bucket.CreateObject(
+		ctx,
+		&gcs.CreateObjectRequest{
+			Name:                   tmpName,
+			GenerationPrecondition: &zero,
+			Contents:               r,
+		})
+
+	// A precondition error here means a name collision, not that the source
+	// object was clobbered, so it's not worth distinguishing. Errors worth
+	// retrying are passed through.
+	if err != nil && !IsRetryable(err) {
+		err = fmt.Errorf("CreateObject: %v", err)
+	}
+
+	if err != nil {
+		return
+	}
+
+	// Attempt to delete the temporary object when we're done.
+	defer func() {
+		deleteErr := oc.bucket.DeleteObject(
+			ctx,
+			&gcs.DeleteObjectRequest{
+				Name: tmp.Name,
+			})
+
+		if err == nil && deleteErr != nil {
+			err = fmt.Errorf("DeleteObject: %v", deleteErr)
+		}
+	}()
+
+	// Replace the source object with the temporary object.
+	o, err = oc.bucket.ComposeObjects(
+		ctx,
+		&gcs.ComposeObjectsRequest{
+			DstName:                       srcObject.Name,
+			DstGenerationPrecondition:     &srcObject.Generation,
+			DstMetaGenerationPrecondition: &srcObject.MetaGeneration,
+			Sources: []gcs.ComposeSource{
+				gcs.ComposeSource{
+					Name:       tmp.Name,
+					Generation: tmp.Generation,
+				},
+			},
+			Metadata: syncedMetadata(srcObject, mtime),
+		})
+
+	switch typed := err.(type) {
+	case nil:
+
+	case *gcs.PreconditionError:
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf("ComposeObjects: %v", typed.Err),
+		}
+		return
+
+	default:
+		// Don't mangle errors worth retrying.
+		if IsRetryable(err) {
+			return
+		}
+
+		err = fmt.Errorf("ComposeObjects: %v", err)
+		return
+	}
+
+	o = preserveCacheControl(ctx, oc.bucket, srcObject, o)
+
+	return
+}
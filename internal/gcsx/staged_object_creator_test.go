@@ -0,0 +1,249 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/oglemock"
+	. "github.com/jacobsa/ogletest"
+	"golang.org/x/net/context"
+)
+
+func TestStagedObjectCreator(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type StagedObjectCreatorTest struct {
+	ctx     context.Context
+	bucket  gcs.MockBucket
+	creator objectCreator
+
+	srcObject   gcs.Object
+	srcContents string
+	mtime       time.Time
+}
+
+var _ SetUpInterface = &StagedObjectCreatorTest{}
+
+func init() { RegisterTestSuite(&StagedObjectCreatorTest{}) }
+
+func (t *StagedObjectCreatorTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+
+	// Create the bucket.
+	t.bucket = gcs.NewMockBucket(ti.MockController, "bucket")
+
+	// Create the creator.
+	t.creator = newStagedObjectCreator(prefix, t.bucket)
+}
+
+func (t *StagedObjectCreatorTest) call() (o *gcs.Object, err error) {
+	o, err = t.creator.Create(
+		t.ctx,
+		&t.srcObject,
+		t.mtime,
+		strings.NewReader(t.srcContents))
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *StagedObjectCreatorTest) CallsCreateObject() {
+	t.srcObject.Name = "foo"
+	t.srcContents = "taco"
+
+	// CreateObject
+	var req *gcs.CreateObjectRequest
+	ExpectCall(t.bucket, "CreateObject")(Any(), Any()).
+		WillOnce(DoAll(SaveArg(1, &req), Return(nil, errors.New(""))))
+
+	// Call
+	t.call()
+
+	AssertNe(nil, req)
+	ExpectTrue(strings.HasPrefix(req.Name, prefix), "Name: %s", req.Name)
+	ExpectThat(req.GenerationPrecondition, Pointee(Equals(0)))
+
+	b, err := ioutil.ReadAll(req.Contents)
+	AssertEq(nil, err)
+	ExpectEq(t.srcContents, string(b))
+}
+
+func (t *StagedObjectCreatorTest) CreateObjectFails() {
+	// CreateObject
+	ExpectCall(t.bucket, "CreateObject")(Any(), Any()).
+		WillOnce(Return(nil, errors.New("taco")))
+
+	// Call
+	_, err := t.call()
+
+	ExpectThat(err, Error(HasSubstr("CreateObject")))
+	ExpectThat(err, Error(HasSubstr("taco")))
+}
+
+func (t *StagedObjectCreatorTest) CallsComposeObjects() {
+	t.srcObject.Name = "foo"
+	t.srcObject.Generation = 17
+	t.srcObject.MetaGeneration = 23
+	t.mtime = time.Now().Add(123 * time.Second).UTC()
+
+	// CreateObject
+	tmpObject := &gcs.Object{
+		Name:       "bar",
+		Generation: 19,
+	}
+
+	ExpectCall(t.bucket, "CreateObject")(Any(), Any()).
+		WillOnce(Return(tmpObject, nil))
+
+	// ComposeObjects
+	var req *gcs.ComposeObjectsRequest
+	ExpectCall(t.bucket, "ComposeObjects")(Any(), Any()).
+		WillOnce(DoAll(SaveArg(1, &req), Return(nil, errors.New(""))))
+
+	// DeleteObject
+	ExpectCall(t.bucket, "DeleteObject")(Any(), deleteReqName(tmpObject.Name)).
+		WillOnce(Return(nil))
+
+	// Call
+	t.call()
+
+	AssertNe(nil, req)
+	ExpectEq(t.srcObject.Name, req.DstName)
+	ExpectThat(
+		req.DstGenerationPrecondition,
+		Pointee(Equals(t.srcObject.Generation)))
+	ExpectThat(
+		req.DstMetaGenerationPrecondition,
+		Pointee(Equals(t.srcObject.MetaGeneration)))
+
+	ExpectEq(1, len(req.Metadata))
+	ExpectEq(t.mtime.Format(time.RFC3339Nano), req.Metadata["gcsfuse_mtime"])
+
+	AssertEq(1, len(req.Sources))
+	ExpectEq(tmpObject.Name, req.Sources[0].Name)
+	ExpectEq(tmpObject.Generation, req.Sources[0].Generation)
+}
+
+func (t *StagedObjectCreatorTest) ComposeObjectsFails() {
+	// CreateObject
+	tmpObject := &gcs.Object{
+		Name: "bar",
+	}
+
+	ExpectCall(t.bucket, "CreateObject")(Any(), Any()).
+		WillOnce(Return(tmpObject, nil))
+
+	// ComposeObjects
+	ExpectCall(t.bucket, "ComposeObjects")(Any(), Any()).
+		WillOnce(Return(nil, errors.New("taco")))
+
+	// The temporary object should still be cleaned up.
+	ExpectCall(t.bucket, "DeleteObject")(Any(), deleteReqName(tmpObject.Name)).
+		WillOnce(Return(nil))
+
+	// Call
+	_, err := t.call()
+
+	ExpectThat(err, Error(HasSubstr("ComposeObjects")))
+	ExpectThat(err, Error(HasSubstr("taco")))
+}
+
+func (t *StagedObjectCreatorTest) ComposeObjectsReturnsPreconditionError() {
+	// CreateObject
+	tmpObject := &gcs.Object{
+		Name: "bar",
+	}
+
+	ExpectCall(t.bucket, "CreateObject")(Any(), Any()).
+		WillOnce(Return(tmpObject, nil))
+
+	// ComposeObjects
+	ExpectCall(t.bucket, "ComposeObjects")(Any(), Any()).
+		WillOnce(Return(nil, &gcs.PreconditionError{Err: errors.New("taco")}))
+
+	// The temporary object should still be cleaned up.
+	ExpectCall(t.bucket, "DeleteObject")(Any(), deleteReqName(tmpObject.Name)).
+		WillOnce(Return(nil))
+
+	// Call
+	_, err := t.call()
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+	ExpectThat(err, Error(HasSubstr("ComposeObjects")))
+	ExpectThat(err, Error(HasSubstr("taco")))
+}
+
+func (t *StagedObjectCreatorTest) DeleteObjectFails() {
+	// CreateObject
+	tmpObject := &gcs.Object{
+		Name: "bar",
+	}
+
+	ExpectCall(t.bucket, "CreateObject")(Any(), Any()).
+		WillOnce(Return(tmpObject, nil))
+
+	// ComposeObjects
+	composed := &gcs.Object{}
+	ExpectCall(t.bucket, "ComposeObjects")(Any(), Any()).
+		WillOnce(Return(composed, nil))
+
+	// DeleteObject
+	ExpectCall(t.bucket, "DeleteObject")(Any(), Any()).
+		WillOnce(Return(errors.New("taco")))
+
+	// Call
+	_, err := t.call()
+
+	ExpectThat(err, Error(HasSubstr("DeleteObject")))
+	ExpectThat(err, Error(HasSubstr("taco")))
+}
+
+func (t *StagedObjectCreatorTest) DeleteObjectSucceeds() {
+	// CreateObject
+	tmpObject := &gcs.Object{
+		Name: "bar",
+	}
+
+	ExpectCall(t.bucket, "CreateObject")(Any(), Any()).
+		WillOnce(Return(tmpObject, nil))
+
+	// ComposeObjects
+	composed := &gcs.Object{}
+	ExpectCall(t.bucket, "ComposeObjects")(Any(), Any()).
+		WillOnce(Return(composed, nil))
+
+	// DeleteObject
+	ExpectCall(t.bucket, "DeleteObject")(Any(), Any()).
+		WillOnce(Return(nil))
+
+	// Call
+	o, err := t.call()
+
+	AssertEq(nil, err)
+	ExpectEq(composed, o)
+}
@@ -0,0 +1,60 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Stat the object described by the request, distinguishing objects that are
+// gone for good from ones that GCS still retains in a soft-deleted state:
+//
+// *   If the object doesn't exist at all, returns *gcs.NotFoundError.
+// *   If the record carries a deletion time, returns *SoftDeletedError.
+//
+// Callers that don't care about the distinction should treat both as "not
+// found".
+func StatObject(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	o, err = bucket.StatObject(ctx, req)
+
+	// Don't mangle not found errors.
+	if _, ok := err.(*gcs.NotFoundError); ok {
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("StatObject: %v", err)
+		return
+	}
+
+	if !o.Deleted.IsZero() {
+		err = &SoftDeletedError{
+			Name:       o.Name,
+			Generation: o.Generation,
+			Deleted:    o.Deleted,
+		}
+
+		o = nil
+		return
+	}
+
+	return
+}
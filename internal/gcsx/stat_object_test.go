@@ -0,0 +1,140 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// A bucket that marks the records it returns from StatObject as deleted at
+// the given time, if non-zero, or fails if err is set.
+type softDeletingBucket struct {
+	gcs.Bucket
+	deleted time.Time
+	err     error
+}
+
+func (b *softDeletingBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	if b.err != nil {
+		err = b.err
+		return
+	}
+
+	o, err = b.Bucket.StatObject(ctx, req)
+	if err != nil {
+		return
+	}
+
+	o.Deleted = b.deleted
+	return
+}
+
+func newSoftDeletingBucket(t *testing.T) (b *softDeletingBucket, o *gcs.Object) {
+	b = &softDeletingBucket{
+		Bucket: gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"),
+	}
+
+	o, err := gcsutil.CreateObject(context.Background(), b, "foo", []byte("taco"))
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	return
+}
+
+func TestStatObject_Live(t *testing.T) {
+	bucket, created := newSoftDeletingBucket(t)
+
+	o, err := gcsx.StatObject(
+		context.Background(),
+		bucket,
+		&gcs.StatObjectRequest{Name: "foo"})
+
+	if err != nil {
+		t.Fatalf("StatObject: %v", err)
+	}
+
+	if o.Generation != created.Generation {
+		t.Errorf("Got generation %d, want %d", o.Generation, created.Generation)
+	}
+}
+
+func TestStatObject_NotFound(t *testing.T) {
+	bucket, _ := newSoftDeletingBucket(t)
+
+	o, err := gcsx.StatObject(
+		context.Background(),
+		bucket,
+		&gcs.StatObjectRequest{Name: "bar"})
+
+	if _, ok := err.(*gcs.NotFoundError); !ok {
+		t.Fatalf("Got %v, want *gcs.NotFoundError", err)
+	}
+
+	if o != nil {
+		t.Errorf("Got non-nil record: %#v", o)
+	}
+}
+
+func TestStatObject_SoftDeleted(t *testing.T) {
+	bucket, created := newSoftDeletingBucket(t)
+	bucket.deleted = time.Date(2012, 8, 15, 22, 56, 0, 0, time.UTC)
+
+	o, err := gcsx.StatObject(
+		context.Background(),
+		bucket,
+		&gcs.StatObjectRequest{Name: "foo"})
+
+	sde, ok := err.(*gcsx.SoftDeletedError)
+	if !ok {
+		t.Fatalf("Got %v, want *gcsx.SoftDeletedError", err)
+	}
+
+	if o != nil {
+		t.Errorf("Got non-nil record: %#v", o)
+	}
+
+	if sde.Name != "foo" ||
+		sde.Generation != created.Generation ||
+		!sde.Deleted.Equal(bucket.deleted) {
+		t.Errorf("Unexpected error: %#v", sde)
+	}
+}
+
+func TestStatObject_OtherError(t *testing.T) {
+	bucket, _ := newSoftDeletingBucket(t)
+	bucket.err = errors.New("taco")
+
+	_, err := gcsx.StatObject(
+		context.Background(),
+		bucket,
+		&gcs.StatObjectRequest{Name: "foo"})
+
+	switch err.(type) {
+	case nil, *gcs.NotFoundError, *gcsx.SoftDeletedError:
+		t.Fatalf("Got %v, want an annotated error", err)
+	}
+}
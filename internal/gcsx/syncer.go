@@ -15,8 +15,13 @@
 package gcsx
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"math"
+	"strings"
 	"time"
 
 	"github.com/jacobsa/gcloud/gcs"
@@ -27,6 +32,48 @@ import (
 // key and with a UTC mtime in the format defined by time.RFC3339Nano.
 const MtimeMetadataKey = "gcsfuse_mtime"
 
+// Objects created by Syncer.SyncObject carry forward each of the source
+// object's metadata fields whose keys begin with this prefix, e.g. labels used
+// for cost allocation.
+const LabelMetadataPrefix = "gcsfuse_label_"
+
+// Objects created for new files may record an identity for the mount that
+// created them, e.g. its hostname, under this metadata key. Objects created by
+// Syncer.SyncObject carry forward the source object's value, if any, so that
+// it continues to record the object's creator.
+const CreatorMetadataKey = "gcsfuse_creator"
+
+// Generations written in full by a syncer created with NewIdempotentSyncer
+// carry a random value under this metadata key, unique to the upload that
+// wrote them. It is not carried forward to later generations.
+const SyncTokenMetadataKey = "gcsfuse_sync_token"
+
+// Generations written by a syncer created with NewHashingSyncer carry the
+// SHA-256 hash of their contents under this metadata key, in lower-case hex.
+// It is not carried forward to later generations.
+const SHA256MetadataKey = "gcsfuse_sha256"
+
+// Return the metadata for a new generation of srcObject with the given mtime.
+func syncedMetadata(
+	srcObject *gcs.Object,
+	mtime time.Time) (metadata map[string]string) {
+	metadata = map[string]string{
+		MtimeMetadataKey: mtime.Format(time.RFC3339Nano),
+	}
+
+	if v, ok := srcObject.Metadata[CreatorMetadataKey]; ok {
+		metadata[CreatorMetadataKey] = v
+	}
+
+	for k, v := range srcObject.Metadata {
+		if strings.HasPrefix(k, LabelMetadataPrefix) {
+			metadata[k] = v
+		}
+	}
+
+	return
+}
+
 // Safe for concurrent access.
 type Syncer interface {
 	// Given an object record and content that was originally derived from that
@@ -39,10 +86,57 @@ type Syncer interface {
 	//
 	// In the second case, the TempFile is destroyed. Otherwise, including when
 	// this function fails, it is guaranteed to still be valid.
+	//
+	// Errors for which IsRetryable returns true are returned unmangled, and
+	// since each call reads the content from the start, retrying with the same
+	// arguments uploads all of it again.
+	//
+	// If GCS records fewer bytes for the new generation than were uploaded, the
+	// content is uploaded once more in full, failing with *TruncatedUploadError
+	// if that is short too. Any other disagreement about the size fails with
+	// *SizeMismatchError. In these cases, and if the second upload fails, the
+	// latest generation written is nonetheless live in GCS, so it is returned
+	// along with the error and the TempFile remains valid. The caller should
+	// treat the generation as its new source, to which the content is still
+	// dirty; see MarkTempFileModified. The same goes for *MirrorError, from a
+	// bucket created by NewMirroringBucket.
 	SyncObject(
 		ctx context.Context,
 		srcObject *gcs.Object,
 		content TempFile) (o *gcs.Object, err error)
+
+	// Report what SyncObject would do with the same arguments, without
+	// contacting GCS or modifying the content.
+	PlanSync(
+		srcObject *gcs.Object,
+		content TempFile) (plan SyncPlan, err error)
+
+	// Report what SyncObject would do to replace srcObject with size bytes of
+	// new content written in full, e.g. content that is encoded on its way to
+	// GCS and so can't be appended.
+	PlanRewrite(srcObject *gcs.Object, size int64) (plan SyncPlan)
+}
+
+// A description of the work that Syncer.SyncObject would do.
+type SyncPlan struct {
+	// True if the content is unmodified, in which case there is nothing to do
+	// and the other fields are zero.
+	NoOp bool
+
+	// True if the new generation would be created by composing the source
+	// object with its appended contents, rather than uploading in full.
+	Append bool
+
+	// The generation of the source object on which the write would be
+	// conditioned.
+	GenerationPrecondition int64
+
+	// The number of bytes of content that would be uploaded.
+	Bytes int64
+
+	// The number of requests to GCS that would be made, not counting any
+	// retries.
+	Requests int
 }
 
 // Create a syncer that syncs into the supplied bucket.
@@ -73,12 +167,129 @@ func NewSyncer(
 	return
 }
 
+// Like NewSyncer, but full rewrites are first uploaded to a temporary object
+// and then swapped into place with a single request, so that an interrupted
+// upload never leaves a partially written generation visible. This costs an
+// extra compose and delete per sync.
+func NewStagedSyncer(
+	appendThreshold int64,
+	tmpObjectPrefix string,
+	bucket gcs.Bucket) (os Syncer) {
+	fullCreator := newStagedObjectCreator(
+		tmpObjectPrefix,
+		bucket)
+
+	appendCreator := newAppendObjectCreator(
+		tmpObjectPrefix,
+		bucket)
+
+	os = newSyncer(appendThreshold, fullCreator, appendCreator)
+
+	return
+}
+
+// Like NewSyncer, but each full rewrite is tagged with a token under
+// SyncTokenMetadataKey. If the upload appears to fail, for example because a
+// response was lost after GCS committed the write, the object is statted and
+// a live generation bearing the token is adopted as the result rather than
+// reported as an error. A caller retrying the failed sync therefore never
+// writes a duplicate generation, nor mistakes its own write for a clobber.
+func NewIdempotentSyncer(
+	appendThreshold int64,
+	tmpObjectPrefix string,
+	bucket gcs.Bucket) (os Syncer) {
+	fullCreator := &fullObjectCreator{
+		bucket:     bucket,
+		idempotent: true,
+	}
+
+	appendCreator := newAppendObjectCreator(
+		tmpObjectPrefix,
+		bucket)
+
+	os = newSyncer(appendThreshold, fullCreator, appendCreator)
+
+	return
+}
+
+// Like NewSyncer, but each new generation records the SHA-256 hash of its
+// contents under SHA256MetadataKey, so that readers can verify them end to end
+// independently of GCS's CRC32C checksums. The hash must be known before the
+// upload starts, so the contents are read once to compute it and again to
+// upload them. Since that needs all of the contents, this syncer never
+// appends.
+func NewHashingSyncer(bucket gcs.Bucket) (os Syncer) {
+	fullCreator := &fullObjectCreator{
+		bucket: bucket,
+		hash:   true,
+	}
+
+	// Never used, since nothing reaches the append threshold.
+	appendCreator := newAppendObjectCreator("", bucket)
+
+	os = newSyncer(math.MaxInt64, fullCreator, appendCreator)
+
+	return
+}
+
+// Return the number of requests made by an object creator that uploads to a
+// temporary object, composes it over srcObject, and deletes it again.
+func composeRequests(srcObject *gcs.Object) int {
+	n := 3
+	if srcObject.CacheControl != "" {
+		n++
+	}
+
+	return n
+}
+
+// Compose requests can't carry a Cache-Control header, so after composing a
+// new generation o of srcObject, set the header to match the source with a
+// separate update. The new generation's contents are already committed by
+// then, so this is best-effort: on failure, o is returned as-is.
+func preserveCacheControl(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	srcObject *gcs.Object,
+	o *gcs.Object) (updated *gcs.Object) {
+	updated = o
+	if srcObject.CacheControl == "" || o.CacheControl == srcObject.CacheControl {
+		return
+	}
+
+	cacheControl := srcObject.CacheControl
+	u, err := bucket.UpdateObject(
+		ctx,
+		&gcs.UpdateObjectRequest{
+			Name:                       o.Name,
+			Generation:                 o.Generation,
+			MetaGenerationPrecondition: &o.MetaGeneration,
+			CacheControl:               &cacheControl,
+		})
+
+	if err == nil {
+		updated = u
+	}
+
+	return
+}
+
 ////////////////////////////////////////////////////////////////////////
 // fullObjectCreator
 ////////////////////////////////////////////////////////////////////////
 
 type fullObjectCreator struct {
 	bucket gcs.Bucket
+
+	// Tag uploads with SyncTokenMetadataKey? See NewIdempotentSyncer.
+	idempotent bool
+
+	// Record SHA256MetadataKey? See NewHashingSyncer.
+	hash bool
+}
+
+func (oc *fullObjectCreator) requests(srcObject *gcs.Object) int {
+	return 1
 }
 
 func (oc *fullObjectCreator) Create(
@@ -91,15 +302,48 @@ func (oc *fullObjectCreator) Create(
 		GenerationPrecondition:     &srcObject.Generation,
 		MetaGenerationPrecondition: &srcObject.MetaGeneration,
 		Contents:                   r,
-		Metadata: map[string]string{
-			MtimeMetadataKey: mtime.Format(time.RFC3339Nano),
-		},
+		Metadata:                   syncedMetadata(srcObject, mtime),
+		CacheControl:               srcObject.CacheControl,
+	}
+
+	var token string
+	if oc.idempotent {
+		token, err = randomObjectName("")
+		if err != nil {
+			err = fmt.Errorf("randomObjectName: %v", err)
+			return
+		}
+
+		req.Metadata[SyncTokenMetadataKey] = token
+	}
+
+	if oc.hash {
+		req.Metadata[SHA256MetadataKey], err = hashContents(r)
+		if err != nil {
+			err = fmt.Errorf("hashContents: %v", err)
+			return
+		}
 	}
 
 	o, err = oc.bucket.CreateObject(ctx, req)
+
+	// Did the write happen anyway?
+	if err != nil && o == nil && oc.idempotent {
+		if written := oc.findWritten(ctx, srcObject.Name, token); written != nil {
+			o = written
+			err = nil
+			return
+		}
+	}
+
 	if err != nil {
-		// Don't mangle precondition errors.
-		if _, ok := err.(*gcs.PreconditionError); ok {
+		// Don't mangle precondition errors, errors worth retrying, nor failures
+		// that come with a new generation.
+		if _, ok := err.(*gcs.PreconditionError); ok || IsRetryable(err) {
+			return
+		}
+
+		if _, ok := err.(*MirrorError); ok {
 			return
 		}
 
@@ -110,6 +354,57 @@ func (oc *fullObjectCreator) Create(
 	return
 }
 
+// Return the hex SHA-256 hash of the remaining contents of r, which must be an
+// io.Seeker, such as a reader returned by fullContents. r is left positioned
+// where it started.
+func hashContents(r io.Reader) (hash string, err error) {
+	rs, ok := r.(io.Seeker)
+	if !ok {
+		err = fmt.Errorf("Can't rewind %T", r)
+		return
+	}
+
+	start, err := rs.Seek(0, 1)
+	if err != nil {
+		err = fmt.Errorf("Seek: %v", err)
+		return
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(h, r)
+	if err != nil {
+		err = fmt.Errorf("Copy: %v", err)
+		return
+	}
+
+	_, err = rs.Seek(start, 0)
+	if err != nil {
+		err = fmt.Errorf("Seek: %v", err)
+		return
+	}
+
+	hash = hex.EncodeToString(h.Sum(nil))
+	return
+}
+
+// Return the live generation of the named object if it was written by the
+// upload with the given token, or nil if not or if we can't tell.
+func (oc *fullObjectCreator) findWritten(
+	ctx context.Context,
+	name string,
+	token string) (o *gcs.Object) {
+	live, err := oc.bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: name})
+	if err != nil {
+		return
+	}
+
+	if live.Metadata[SyncTokenMetadataKey] == token {
+		o = live
+	}
+
+	return
+}
+
 ////////////////////////////////////////////////////////////////////////
 // syncer
 ////////////////////////////////////////////////////////////////////////
@@ -121,6 +416,10 @@ type objectCreator interface {
 		srcObject *gcs.Object,
 		mtime time.Time,
 		r io.Reader) (o *gcs.Object, err error)
+
+	// Return the number of requests Create makes for the given source object
+	// when all goes well.
+	requests(srcObject *gcs.Object) int
 }
 
 // Create a syncer that stats the mutable content to see if it's dirty before
@@ -155,17 +454,10 @@ type syncer struct {
 	appendCreator   objectCreator
 }
 
-func (os *syncer) SyncObject(
-	ctx context.Context,
+// Decide how to sync content with the supplied stat result.
+func (os *syncer) plan(
 	srcObject *gcs.Object,
-	content TempFile) (o *gcs.Object, err error) {
-	// Stat the content.
-	sr, err := content.Stat()
-	if err != nil {
-		err = fmt.Errorf("Stat: %v", err)
-		return
-	}
-
+	sr StatResult) (plan SyncPlan, err error) {
 	// Make sure the dirty threshold makes sense.
 	srcSize := int64(srcObject.Size)
 	if sr.DirtyThreshold > srcSize {
@@ -181,6 +473,7 @@ func (os *syncer) SyncObject(
 	// object, and no bytes within the source object have been dirtied), we're
 	// done.
 	if sr.Size == srcSize && sr.DirtyThreshold == srcSize {
+		plan.NoOp = true
 		return
 	}
 
@@ -191,16 +484,171 @@ func (os *syncer) SyncObject(
 		return
 	}
 
-	// Canonicalize to UTC.
-	mtime := sr.Mtime.UTC()
-
 	// Otherwise, we need to create a new generation. If the source object is
 	// long enough, hasn't been dirtied, and has a low enough component count,
 	// then we can make the optimization of not rewriting its contents.
+	plan.GenerationPrecondition = srcObject.Generation
 	if srcSize >= os.appendThreshold &&
 		sr.DirtyThreshold == srcSize &&
 		srcObject.ComponentCount < gcs.MaxComponentCount {
-		_, err = content.Seek(srcSize, 0)
+		plan.Append = true
+		plan.Bytes = sr.Size - srcSize
+		plan.Requests = os.appendCreator.requests(srcObject)
+	} else {
+		plan = os.PlanRewrite(srcObject, sr.Size)
+	}
+
+	return
+}
+
+func (os *syncer) PlanRewrite(
+	srcObject *gcs.Object,
+	size int64) (plan SyncPlan) {
+	plan.GenerationPrecondition = srcObject.Generation
+	plan.Bytes = size
+	plan.Requests = os.fullCreator.requests(srcObject)
+	return
+}
+
+func (os *syncer) PlanSync(
+	srcObject *gcs.Object,
+	content TempFile) (plan SyncPlan, err error) {
+	sr, err := content.Stat()
+	if err != nil {
+		err = fmt.Errorf("Stat: %v", err)
+		return
+	}
+
+	plan, err = os.plan(srcObject, sr)
+	return
+}
+
+// Contents no larger than this many bytes are read into memory with a single
+// call before being uploaded in full, rather than being streamed from the
+// temp file.
+const inlineSyncThreshold = 1 << 16
+
+// Return a reader for all size bytes of content, from memory if the content
+// is small enough. See inlineSyncThreshold.
+func fullContents(content TempFile, size int64) (r io.Reader, err error) {
+	if size > inlineSyncThreshold {
+		_, err = content.Seek(0, 0)
+		if err != nil {
+			err = fmt.Errorf("Seek: %v", err)
+			return
+		}
+
+		r = content
+		return
+	}
+
+	buf := make([]byte, size)
+	n, err := content.ReadAt(buf, 0)
+	if err == io.EOF && int64(n) == size {
+		err = nil
+	}
+
+	if err != nil {
+		err = fmt.Errorf("ReadAt: %v", err)
+		return
+	}
+
+	r = bytes.NewReader(buf)
+	return
+}
+
+// Re-upload the full content over a generation that GCS reports as shorter
+// than expected. Returns *TruncatedUploadError if the new generation is short
+// too, or if the content can't be uploaded in full because some of it was
+// never fetched (as when appending).
+//
+// Unless the short generation has been clobbered, the latest generation
+// written is returned even on failure, since it is live in GCS.
+func (os *syncer) retryTruncated(
+	ctx context.Context,
+	short *gcs.Object,
+	mtime time.Time,
+	expected int64,
+	content TempFile) (o *gcs.Object, err error) {
+	o = short
+
+	// Bytes that are missing locally can't be uploaded again, and the source
+	// generation that held them has been replaced.
+	missing, err := content.Missing(0, expected)
+	if err != nil {
+		err = fmt.Errorf("Missing: %v", err)
+		return
+	}
+
+	if len(missing) > 0 {
+		err = &TruncatedUploadError{
+			Name:     short.Name,
+			Expected: expected,
+			Actual:   int64(short.Size),
+		}
+
+		return
+	}
+
+	r, err := fullContents(content, expected)
+	if err != nil {
+		err = fmt.Errorf("fullContents: %v", err)
+		return
+	}
+
+	retried, err := os.fullCreator.Create(ctx, short, mtime, r)
+	if err != nil {
+		// Don't mess with precondition errors.
+		if _, ok := err.(*gcs.PreconditionError); ok {
+			o = nil
+			return
+		}
+
+		err = fmt.Errorf("Create (retrying truncated upload): %v", err)
+		return
+	}
+
+	o = retried
+	if o.Size < uint64(expected) {
+		err = &TruncatedUploadError{
+			Name:     o.Name,
+			Expected: expected,
+			Actual:   int64(o.Size),
+		}
+
+		return
+	}
+
+	return
+}
+
+func (os *syncer) SyncObject(
+	ctx context.Context,
+	srcObject *gcs.Object,
+	content TempFile) (o *gcs.Object, err error) {
+	// Stat the content.
+	sr, err := content.Stat()
+	if err != nil {
+		err = fmt.Errorf("Stat: %v", err)
+		return
+	}
+
+	// Decide what to do.
+	plan, err := os.plan(srcObject, sr)
+	if err != nil {
+		return
+	}
+
+	if plan.NoOp {
+		return
+	}
+
+	// Canonicalize to UTC.
+	mtime := sr.Mtime.UTC()
+
+	// Create the new generation.
+	if plan.Append {
+		_, err = content.Seek(int64(srcObject.Size), 0)
 		if err != nil {
 			err = fmt.Errorf("Seek: %v", err)
 			return
@@ -208,19 +656,26 @@ func (os *syncer) SyncObject(
 
 		o, err = os.appendCreator.Create(ctx, srcObject, mtime, content)
 	} else {
-		_, err = content.Seek(0, 0)
+		var r io.Reader
+		r, err = fullContents(content, sr.Size)
 		if err != nil {
-			err = fmt.Errorf("Seek: %v", err)
+			err = fmt.Errorf("fullContents: %v", err)
 			return
 		}
 
-		o, err = os.fullCreator.Create(ctx, srcObject, mtime, content)
+		o, err = os.fullCreator.Create(ctx, srcObject, mtime, r)
 	}
 
 	// Deal with errors.
 	if err != nil {
-		// Special case: don't mess with precondition errors.
-		if _, ok := err.(*gcs.PreconditionError); ok {
+		// Special case: don't mess with precondition errors, errors that callers
+		// may want to retry, nor mirroring failures, which come with the
+		// generation written to the primary bucket.
+		if _, ok := err.(*gcs.PreconditionError); ok || IsRetryable(err) {
+			return
+		}
+
+		if _, ok := err.(*MirrorError); ok {
 			return
 		}
 
@@ -228,6 +683,28 @@ func (os *syncer) SyncObject(
 		return
 	}
 
+	// If GCS stored less than we uploaded, the upload was truncated somewhere
+	// along the way. Try once more, replacing the short generation in full.
+	if o.Size < uint64(sr.Size) {
+		o, err = os.retryTruncated(ctx, o, mtime, sr.Size, content)
+		if err != nil {
+			return
+		}
+	}
+
+	// Make sure GCS agrees about how much we uploaded. If it doesn't, keep the
+	// temp file around so the caller still has the contents, and tell the
+	// caller about the generation that we did write.
+	if o.Size != uint64(sr.Size) {
+		err = &SizeMismatchError{
+			Name:     o.Name,
+			Expected: sr.Size,
+			Actual:   int64(o.Size),
+		}
+
+		return
+	}
+
 	// Destroy the temp file.
 	content.Destroy()
 
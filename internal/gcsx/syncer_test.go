@@ -37,11 +37,13 @@ func TestSyncer(t *testing.T) { RunTests(t) }
 ////////////////////////////////////////////////////////////////////////
 
 // An objectCreator that records the arguments it is called with, returning
-// canned results.
+// canned results. It may be called a second time only if retry results are
+// set.
 type fakeObjectCreator struct {
-	called bool
+	called  bool
+	retried bool
 
-	// Supplied arguments
+	// Supplied arguments, from the most recent call
 	srcObject *gcs.Object
 	mtime     time.Time
 	contents  []byte
@@ -49,6 +51,17 @@ type fakeObjectCreator struct {
 	// Canned results
 	o   *gcs.Object
 	err error
+
+	// Canned results for a second call
+	retryO   *gcs.Object
+	retryErr error
+
+	// Canned result for requests
+	requestCount int
+}
+
+func (oc *fakeObjectCreator) requests(srcObject *gcs.Object) int {
+	return oc.requestCount
 }
 
 func (oc *fakeObjectCreator) Create(
@@ -56,8 +69,20 @@ func (oc *fakeObjectCreator) Create(
 	srcObject *gcs.Object,
 	mtime time.Time,
 	r io.Reader) (o *gcs.Object, err error) {
-	// Have we been called more than once?
-	AssertFalse(oc.called)
+	// Have we been called more than expected?
+	if oc.called {
+		AssertTrue(oc.retryO != nil || oc.retryErr != nil)
+		AssertFalse(oc.retried)
+		oc.retried = true
+
+		oc.srcObject = srcObject
+		oc.contents, err = ioutil.ReadAll(r)
+		AssertEq(nil, err)
+
+		o, err = oc.retryO, oc.retryErr
+		return
+	}
+
 	oc.called = true
 
 	// Record args.
@@ -71,6 +96,19 @@ func (oc *fakeObjectCreator) Create(
 	return
 }
 
+// A TempFile that counts the calls made to its Read method, i.e. how often
+// it is used as a stream.
+type readCountingTempFile struct {
+	TempFile
+	reads int
+}
+
+func (tf *readCountingTempFile) Read(p []byte) (n int, err error) {
+	tf.reads++
+	n, err = tf.TempFile.Read(p)
+	return
+}
+
 ////////////////////////////////////////////////////////////////////////
 // Boilerplate
 ////////////////////////////////////////////////////////////////////////
@@ -102,6 +140,8 @@ func (t *SyncerTest) SetUp(ti *TestInfo) {
 
 	// Set up dependencies.
 	t.bucket = gcsfake.NewFakeBucket(&t.clock, "some_bucket")
+	t.fullCreator.requestCount = 1
+	t.appendCreator.requestCount = 3
 	t.syncer = newSyncer(
 		appendThreshold,
 		&t.fullCreator,
@@ -272,6 +312,55 @@ func (t *SyncerTest) CallsFullCreator() {
 	ExpectEq(srcObjectContents[:2], string(t.fullCreator.contents))
 }
 
+func (t *SyncerTest) SmallContentsAreNotStreamed() {
+	var err error
+	t.fullCreator.o = &gcs.Object{Size: 2}
+	t.fullCreator.err = nil
+
+	counting := &readCountingTempFile{TempFile: t.content}
+	t.content = counting
+
+	// Ready the content.
+	err = t.content.Truncate(2)
+	AssertEq(nil, err)
+
+	// Call
+	_, err = t.call()
+	AssertEq(nil, err)
+
+	// The creator should have seen the right contents, without them being read
+	// from the temp file as a stream.
+	AssertTrue(t.fullCreator.called)
+	ExpectEq(srcObjectContents[:2], string(t.fullCreator.contents))
+	ExpectEq(0, counting.reads)
+}
+
+func (t *SyncerTest) LargeContentsAreStreamed() {
+	var err error
+	size := int64(inlineSyncThreshold + 1)
+	t.fullCreator.o = &gcs.Object{Size: uint64(size)}
+	t.fullCreator.err = nil
+
+	counting := &readCountingTempFile{TempFile: t.content}
+	t.content = counting
+
+	// Ready the content. Write at the start so that an append isn't possible.
+	_, err = t.content.WriteAt([]byte("p"), 0)
+	AssertEq(nil, err)
+
+	err = t.content.Truncate(size)
+	AssertEq(nil, err)
+
+	// Call
+	_, err = t.call()
+	AssertEq(nil, err)
+
+	AssertTrue(t.fullCreator.called)
+	AssertEq(size, len(t.fullCreator.contents))
+	ExpectEq("paco", string(t.fullCreator.contents[:4]))
+	ExpectLt(0, counting.reads)
+}
+
 func (t *SyncerTest) FullCreatorFails() {
 	var err error
 	t.fullCreator.err = errors.New("taco")
@@ -303,7 +392,7 @@ func (t *SyncerTest) FullCreatorReturnsPreconditionError() {
 
 func (t *SyncerTest) FullCreatorSucceeds() {
 	var err error
-	t.fullCreator.o = &gcs.Object{}
+	t.fullCreator.o = &gcs.Object{Size: 2}
 	t.fullCreator.err = nil
 
 	// Truncate downward.
@@ -317,6 +406,168 @@ func (t *SyncerTest) FullCreatorSucceeds() {
 	ExpectEq(t.fullCreator.o, o)
 }
 
+func (t *SyncerTest) FullCreatorReturnsWrongSize() {
+	var err error
+	t.fullCreator.o = &gcs.Object{Name: "foo", Size: 3}
+	t.fullCreator.err = nil
+
+	// Truncate downward.
+	err = t.content.Truncate(2)
+	AssertEq(nil, err)
+
+	// Call
+	o, err := t.call()
+
+	ExpectEq(t.fullCreator.o, o)
+	ExpectThat(err, HasSameTypeAs(&SizeMismatchError{}))
+	ExpectThat(err, Error(HasSubstr("expected 2")))
+	ExpectThat(err, Error(HasSubstr("received 3")))
+	ExpectFalse(t.fullCreator.retried)
+
+	// The content should still be usable.
+	sr, err := t.content.Stat()
+	AssertEq(nil, err)
+	ExpectEq(2, sr.Size)
+}
+
+func (t *SyncerTest) TruncatedUpload_RetrySucceeds() {
+	var err error
+
+	short := &gcs.Object{Name: "foo", Generation: 19, Size: 1}
+	t.fullCreator.o = short
+	t.fullCreator.err = nil
+	t.fullCreator.retryO = &gcs.Object{Name: "foo", Generation: 20, Size: 2}
+
+	// Truncate downward.
+	err = t.content.Truncate(2)
+	AssertEq(nil, err)
+
+	// Call
+	o, err := t.call()
+
+	AssertEq(nil, err)
+	ExpectEq(t.fullCreator.retryO, o)
+
+	// The retry should have replaced the short generation with the full
+	// contents.
+	AssertTrue(t.fullCreator.retried)
+	ExpectEq(short, t.fullCreator.srcObject)
+	ExpectEq(srcObjectContents[:2], string(t.fullCreator.contents))
+}
+
+func (t *SyncerTest) TruncatedUpload_AfterAppend() {
+	var err error
+
+	t.appendCreator.o = &gcs.Object{Name: "foo", Generation: 19, Size: 4}
+	t.appendCreator.err = nil
+	t.fullCreator.o = &gcs.Object{Name: "foo", Generation: 20, Size: 5}
+	t.fullCreator.err = nil
+
+	// Append.
+	_, err = t.content.WriteAt([]byte("s"), int64(len(srcObjectContents)))
+	AssertEq(nil, err)
+
+	// Call
+	o, err := t.call()
+
+	AssertEq(nil, err)
+	ExpectEq(t.fullCreator.o, o)
+
+	// The retry rewrites in full.
+	AssertTrue(t.fullCreator.called)
+	ExpectEq("tacos", string(t.fullCreator.contents))
+}
+
+func (t *SyncerTest) TruncatedUpload_RetryStillShort() {
+	var err error
+
+	t.fullCreator.o = &gcs.Object{Name: "foo", Generation: 19, Size: 1}
+	t.fullCreator.err = nil
+	t.fullCreator.retryO = &gcs.Object{Name: "foo", Generation: 20, Size: 0}
+
+	// Truncate downward.
+	err = t.content.Truncate(2)
+	AssertEq(nil, err)
+
+	// Call
+	o, err := t.call()
+
+	ExpectEq(t.fullCreator.retryO, o)
+	ExpectThat(err, HasSameTypeAs(&TruncatedUploadError{}))
+	ExpectThat(err, Error(HasSubstr("uploaded 2")))
+	ExpectThat(err, Error(HasSubstr("only 0")))
+
+	// The content should still be usable.
+	sr, err := t.content.Stat()
+	AssertEq(nil, err)
+	ExpectEq(2, sr.Size)
+}
+
+func (t *SyncerTest) TruncatedUpload_RetryFails() {
+	var err error
+
+	t.fullCreator.o = &gcs.Object{Name: "foo", Generation: 19, Size: 1}
+	t.fullCreator.err = nil
+	t.fullCreator.retryErr = errors.New("taco")
+
+	// Truncate downward.
+	err = t.content.Truncate(2)
+	AssertEq(nil, err)
+
+	// Call
+	o, err := t.call()
+
+	ExpectEq(t.fullCreator.o, o)
+	ExpectThat(err, Error(HasSubstr("retrying truncated upload")))
+	ExpectThat(err, Error(HasSubstr("taco")))
+}
+
+func (t *SyncerTest) TruncatedUpload_RetryClobbered() {
+	var err error
+
+	t.fullCreator.o = &gcs.Object{Name: "foo", Generation: 19, Size: 1}
+	t.fullCreator.err = nil
+	t.fullCreator.retryErr = &gcs.PreconditionError{Err: errors.New("taco")}
+
+	// Truncate downward.
+	err = t.content.Truncate(2)
+	AssertEq(nil, err)
+
+	// Call
+	o, err := t.call()
+
+	ExpectEq(nil, o)
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+}
+
+func (t *SyncerTest) TruncatedUpload_ContentMissing() {
+	var err error
+
+	short := &gcs.Object{Name: "foo", Generation: 19, Size: 4}
+	t.appendCreator.o = short
+	t.appendCreator.err = nil
+
+	// Append to content whose initial bytes were never fetched.
+	t.content, err = NewSparseTempFile(
+		int64(len(srcObjectContents)),
+		"",
+		&t.clock)
+
+	AssertEq(nil, err)
+
+	_, err = t.content.WriteAt([]byte("s"), int64(len(srcObjectContents)))
+	AssertEq(nil, err)
+
+	// Call
+	o, err := t.call()
+
+	ExpectEq(short, o)
+	ExpectThat(err, HasSameTypeAs(&TruncatedUploadError{}))
+
+	// Those bytes can't be uploaded again, so there should be no retry.
+	ExpectFalse(t.fullCreator.called)
+}
+
 func (t *SyncerTest) CallsAppendCreator() {
 	var err error
 
@@ -368,7 +619,7 @@ func (t *SyncerTest) AppendCreatorReturnsPreconditionError() {
 
 func (t *SyncerTest) AppendCreatorSucceeds() {
 	var err error
-	t.appendCreator.o = &gcs.Object{}
+	t.appendCreator.o = &gcs.Object{Size: uint64(len(srcObjectContents + "burrito"))}
 	t.appendCreator.err = nil
 
 	// Append some data.
@@ -381,3 +632,52 @@ func (t *SyncerTest) AppendCreatorSucceeds() {
 	AssertEq(nil, err)
 	ExpectEq(t.appendCreator.o, o)
 }
+
+func (t *SyncerTest) PlanSync_NotDirty() {
+	plan, err := t.syncer.PlanSync(t.srcObject, t.content)
+
+	AssertEq(nil, err)
+	ExpectTrue(plan.NoOp)
+	ExpectFalse(plan.Append)
+	ExpectEq(0, plan.GenerationPrecondition)
+	ExpectEq(0, plan.Bytes)
+	ExpectEq(0, plan.Requests)
+}
+
+func (t *SyncerTest) PlanSync_Full() {
+	// Dirty a byte within the source contents.
+	_, err := t.content.WriteAt([]byte("a"), 1)
+	AssertEq(nil, err)
+
+	plan, err := t.syncer.PlanSync(t.srcObject, t.content)
+
+	AssertEq(nil, err)
+	ExpectFalse(plan.NoOp)
+	ExpectFalse(plan.Append)
+	ExpectEq(t.srcObject.Generation, plan.GenerationPrecondition)
+	ExpectEq(len(srcObjectContents), plan.Bytes)
+	ExpectEq(1, plan.Requests)
+
+	// Nothing should have been created.
+	ExpectFalse(t.fullCreator.called)
+	ExpectFalse(t.appendCreator.called)
+}
+
+func (t *SyncerTest) PlanSync_Append() {
+	// Append some data.
+	_, err := t.content.WriteAt([]byte("burrito"), int64(len(srcObjectContents)))
+	AssertEq(nil, err)
+
+	plan, err := t.syncer.PlanSync(t.srcObject, t.content)
+
+	AssertEq(nil, err)
+	ExpectFalse(plan.NoOp)
+	ExpectTrue(plan.Append)
+	ExpectEq(t.srcObject.Generation, plan.GenerationPrecondition)
+	ExpectEq(len("burrito"), plan.Bytes)
+	ExpectEq(3, plan.Requests)
+
+	// Nothing should have been created.
+	ExpectFalse(t.fullCreator.called)
+	ExpectFalse(t.appendCreator.called)
+}
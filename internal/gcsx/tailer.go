@@ -0,0 +1,209 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// The number of bytes at the end of what has been consumed that a tailer
+// remembers, in order to tell whether a new generation continues the old one.
+const tailWindow = 4096
+
+// Follows a growing object, like tail -f, returning the bytes added since the
+// previous poll.
+//
+// Not safe for concurrent access.
+type Tailer interface {
+	// Stat the object, and read whatever has been added to it since the last
+	// poll, starting from the beginning of the object for the first.
+	//
+	// Appending to an object in GCS creates a new generation, so a change of
+	// generation alone doesn't mean the object was replaced. Instead a new
+	// generation is taken to continue the old one unless it is smaller than
+	// what has been consumed, or the bytes most recently consumed aren't where
+	// they were. In that case the result has Rotated set and holds the new
+	// generation's contents from the beginning.
+	//
+	// If the object doesn't exist, returns *gcs.NotFoundError, and the tailer
+	// carries on from where it was once the object comes back.
+	Poll(ctx context.Context) (r TailResult, err error)
+}
+
+// The result of a call to Tailer.Poll.
+type TailResult struct {
+	// The bytes read, which may be empty.
+	Data []byte
+
+	// Set if the object was found to have been replaced with unrelated
+	// contents, in which case Data starts at the beginning of the new
+	// contents.
+	Rotated bool
+
+	// The generation that Data was read from.
+	Generation int64
+}
+
+// Create a tailer for the named object, which needn't exist yet.
+func NewTailer(bucket gcs.Bucket, name string) Tailer {
+	return &tailer{
+		bucket: bucket,
+		name:   name,
+	}
+}
+
+type tailer struct {
+	/////////////////////////
+	// Dependencies
+	/////////////////////////
+
+	bucket gcs.Bucket
+	name   string
+
+	/////////////////////////
+	// Mutable state
+	/////////////////////////
+
+	// The generation most recently read from, or zero if none.
+	generation int64
+
+	// The number of bytes consumed.
+	offset int64
+
+	// The last bytes consumed, up to tailWindow of them.
+	//
+	// INVARIANT: int64(len(tail)) <= offset
+	tail []byte
+}
+
+func (t *tailer) Poll(ctx context.Context) (r TailResult, err error) {
+	o, err := t.bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: t.name})
+	if _, ok := err.(*gcs.NotFoundError); ok {
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("StatObject: %v", err)
+		return
+	}
+
+	r.Generation = o.Generation
+	size := int64(o.Size)
+
+	switch {
+	// The same generation can't have changed.
+	case o.Generation == t.generation:
+		return
+
+	// A new generation may still continue the contents we've seen, in which
+	// case we needn't read them again. Read from the start of our window, so
+	// we can check that they're where we left them.
+	case size >= t.offset:
+		var data []byte
+		start := t.offset - int64(len(t.tail))
+		data, err = t.read(ctx, o.Generation, start, size)
+		if err != nil {
+			return
+		}
+
+		if bytes.HasPrefix(data, t.tail) {
+			r.Data = data[len(t.tail):]
+			t.consume(o.Generation, r.Data)
+			return
+		}
+	}
+
+	// Start again from the beginning.
+	r.Rotated = t.generation != 0
+	r.Data, err = t.read(ctx, o.Generation, 0, size)
+	if err != nil {
+		return
+	}
+
+	t.offset = 0
+	t.tail = nil
+	t.consume(o.Generation, r.Data)
+
+	return
+}
+
+// Read [start, limit) of the given generation. If the generation has since
+// been clobbered, returns *gcs.NotFoundError.
+func (t *tailer) read(
+	ctx context.Context,
+	generation int64,
+	start int64,
+	limit int64) (data []byte, err error) {
+	if start == limit {
+		return
+	}
+
+	rc, err := t.bucket.NewReader(
+		ctx,
+		&gcs.ReadObjectRequest{
+			Name:       t.name,
+			Generation: generation,
+			Range: &gcs.ByteRange{
+				Start: uint64(start),
+				Limit: uint64(limit),
+			},
+		})
+
+	if _, ok := err.(*gcs.NotFoundError); ok {
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("NewReader: %v", err)
+		return
+	}
+
+	defer rc.Close()
+
+	data, err = ioutil.ReadAll(rc)
+	if err != nil {
+		err = fmt.Errorf("ReadAll: %v", err)
+		return
+	}
+
+	if int64(len(data)) != limit-start {
+		err = &SizeMismatchError{
+			Name:     t.name,
+			Expected: limit - start,
+			Actual:   int64(len(data)),
+		}
+
+		return
+	}
+
+	return
+}
+
+// Record that the supplied bytes of the given generation, following those
+// already consumed, have been returned.
+func (t *tailer) consume(generation int64, data []byte) {
+	t.generation = generation
+	t.offset += int64(len(data))
+
+	t.tail = append(t.tail, data...)
+	if len(t.tail) > tailWindow {
+		t.tail = append([]byte(nil), t.tail[len(t.tail)-tailWindow:]...)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+type tailPoll struct {
+	contents string // If non-empty, written to the object before polling
+	data     string
+	rotated  bool
+}
+
+func runTailPolls(t *testing.T, polls []tailPoll) {
+	ctx := context.Background()
+	bucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+	tailer := gcsx.NewTailer(bucket, "foo")
+
+	for i, p := range polls {
+		var generation int64
+		if p.contents != "" {
+			o, err := gcsutil.CreateObject(ctx, bucket, "foo", []byte(p.contents))
+			if err != nil {
+				t.Fatalf("CreateObject: %v", err)
+			}
+
+			generation = o.Generation
+		}
+
+		r, err := tailer.Poll(ctx)
+		if err != nil {
+			t.Fatalf("Poll %d: %v", i, err)
+		}
+
+		if string(r.Data) != p.data {
+			t.Errorf("Poll %d: got data %q, want %q", i, r.Data, p.data)
+		}
+
+		if r.Rotated != p.rotated {
+			t.Errorf("Poll %d: got Rotated %v, want %v", i, r.Rotated, p.rotated)
+		}
+
+		if generation != 0 && r.Generation != generation {
+			t.Errorf("Poll %d: got generation %d, want %d", i, r.Generation, generation)
+		}
+	}
+}
+
+func TestTailer_Growing(t *testing.T) {
+	runTailPolls(t, []tailPoll{
+		{contents: "taco", data: "taco"},
+		{data: ""},
+		{contents: "tacoburrito", data: "burrito"},
+		{contents: "tacoburrito", data: ""},
+		{contents: "tacoburrito!", data: "!"},
+		{data: ""},
+	})
+}
+
+func TestTailer_Rotated(t *testing.T) {
+	runTailPolls(t, []tailPoll{
+		{contents: "tacoburrito", data: "tacoburrito"},
+
+		// Shrunk.
+		{contents: "enchilada", data: "enchilada", rotated: true},
+
+		// Grown, but with different contents.
+		{contents: "quesadilla!", data: "quesadilla!", rotated: true},
+		{contents: "quesadilla!!", data: "!"},
+	})
+}
+
+func TestTailer_LongContents(t *testing.T) {
+	// More than the tailer remembers, changed only before what it remembers.
+	a := bytes.Repeat([]byte("a"), 10000)
+	b := append([]byte("b"), a[1:]...)
+
+	runTailPolls(t, []tailPoll{
+		{contents: string(a), data: string(a)},
+		{contents: string(a) + "taco", data: "taco"},
+		{contents: string(b) + "taco" + "burrito", data: "burrito"},
+	})
+}
+
+func TestTailer_NotFound(t *testing.T) {
+	ctx := context.Background()
+	bucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+	tailer := gcsx.NewTailer(bucket, "foo")
+
+	_, err := tailer.Poll(ctx)
+	if _, ok := err.(*gcs.NotFoundError); !ok {
+		t.Fatalf("Got error %v, want NotFoundError", err)
+	}
+
+	// Once the object appears, we should get all of it.
+	_, err = gcsutil.CreateObject(ctx, bucket, "foo", []byte("taco"))
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	r, err := tailer.Poll(ctx)
+	if err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+
+	if string(r.Data) != "taco" || r.Rotated {
+		t.Errorf("Unexpected result: %q, %v", r.Data, r.Rotated)
+	}
+}
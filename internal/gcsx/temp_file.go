@@ -15,17 +15,19 @@
 package gcsx
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"syscall"
 	"time"
 
-	"github.com/jacobsa/fuse/fsutil"
+	"github.com/jacobsa/gcloud/gcs"
 	"github.com/jacobsa/timeutil"
 )
 
 // A temporary file that keeps track of the lowest offset at which it has been
-// modified.
+// modified, and of which ranges of its initial content are present locally.
 //
 // Not safe for concurrent access.
 type TempFile interface {
@@ -42,6 +44,27 @@ type TempFile interface {
 	// the seek position.
 	Stat() (sr StatResult, err error)
 
+	// Return the sub-ranges of [start, limit) (clipped to the current size) for
+	// which the initial content is not yet present locally, in increasing
+	// order. Reading from these ranges yields garbage until they have been
+	// supplied with Materialize. Bytes that have been written or that are
+	// beyond the initial size are always present.
+	Missing(start int64, limit int64) (ranges []gcs.ByteRange, err error)
+
+	// Copy from the supplied reader to the range starting at offset, treating
+	// the data as initial content that was previously missing. Unlike WriteAt,
+	// this doesn't count as a modification.
+	//
+	// REQUIRES: The range to be written was returned by Missing.
+	Materialize(r io.Reader, offset int64) (n int64, err error)
+
+	// Return the ranges of the content that have been written or zero-filled
+	// by extension since the file was created, in increasing order and with
+	// adjacent ranges merged. Bytes removed by truncation are not represented;
+	// compare Stat().Size with the initial size for that. Mostly useful for
+	// debugging.
+	DirtyRanges() (ranges []gcs.ByteRange)
+
 	// Explicitly set the mtime that will return in stat results. This will stick
 	// until another method that modifies the file is called.
 	SetMtime(mtime time.Time)
@@ -67,6 +90,10 @@ type StatResult struct {
 	// If neither of those things has ever happened, it is nil. This implies that
 	// DirtyThreshold == Size.
 	Mtime *time.Time
+
+	// The number of bytes of content that are present locally, i.e. that are
+	// not reported by Missing. Always at most Size.
+	MaterializedBytes int64
 }
 
 // Create a temp file whose initial contents are given by the supplied reader.
@@ -76,25 +103,46 @@ func NewTempFile(
 	content io.Reader,
 	dir string,
 	clock timeutil.Clock) (tf TempFile, err error) {
-	// Create an anonymous file to wrap. When we close it, its resources will be
-	// magically cleaned up.
-	f, err := fsutil.AnonymousFile(dir)
+	store, err := NewFileBackingStore(dir)
 	if err != nil {
-		err = fmt.Errorf("AnonymousFile: %v", err)
 		return
 	}
 
-	// Copy into the file.
-	size, err := io.Copy(f, content)
+	tf, err = NewTempFileWithStore(content, store, clock)
+	return
+}
+
+// Create a temp file whose initial contents are size bytes that are not yet
+// known, to be supplied later with Materialize as they become necessary. dir
+// is as with NewTempFile.
+func NewSparseTempFile(
+	size int64,
+	dir string,
+	clock timeutil.Clock) (tf TempFile, err error) {
+	store, err := NewFileBackingStore(dir)
 	if err != nil {
-		err = fmt.Errorf("copy: %v", err)
 		return
 	}
 
-	tf = &tempFile{
-		clock:          clock,
-		f:              f,
-		dirtyThreshold: size,
+	tf, err = NewSparseTempFileWithStore(size, store, clock)
+	return
+}
+
+// Treat all of a temp file's current contents as modified, e.g. because they
+// are to be written over a generation other than the one from which they were
+// derived. Ranges that are missing remain so, and must be materialized from
+// somewhere that agrees with the initial contents.
+func MarkTempFileModified(tf TempFile) (err error) {
+	typed, ok := tf.(*tempFile)
+	if !ok {
+		err = errors.New("Not a temp file created by this package")
+		return
+	}
+
+	typed.dirtyThreshold = 0
+	if typed.mtime == nil {
+		now := typed.clock.Now()
+		typed.mtime = &now
 	}
 
 	return
@@ -113,8 +161,8 @@ type tempFile struct {
 
 	destroyed bool
 
-	// A file containing our current contents.
-	f *os.File
+	// A file containing our current contents, possibly adapting a BackingStore.
+	f backingFile
 
 	// The lowest byte index that has been modified from the initial contents.
 	//
@@ -126,6 +174,24 @@ type tempFile struct {
 	//
 	// INVARIANT: mtime == nil => Stat().DirtyThreshold == Stat().Size
 	mtime *time.Time
+
+	// The ranges of the file whose contents are present locally, i.e. everything
+	// except ranges of the initial content that have not been materialized.
+	//
+	// INVARIANT: present.checkInvariants() doesn't panic
+	// INVARIANT: present lies within [0, Stat().Size)
+	// INVARIANT: Missing(0, Stat().Size) lies within [0, initialSize)
+	present rangeSet
+
+	// The ranges of the file that have been modified from the initial content.
+	//
+	// INVARIANT: dirty.checkInvariants() doesn't panic
+	// INVARIANT: dirty lies within [0, Stat().Size)
+	// INVARIANT: dirty lies within [Stat().DirtyThreshold, Stat().Size)
+	dirty rangeSet
+
+	// The size of the initial content.
+	initialSize int64
 }
 
 ////////////////////////////////////////////////////////////////////////
@@ -160,10 +226,63 @@ func (tf *tempFile) CheckInvariants() {
 		panic(fmt.Sprintf("Mismatch: %d vs. %d", sr.DirtyThreshold, sr.Size))
 	}
 
+	// INVARIANT: The backing store reports a size of Stat().Size
+	size, err := tf.size()
+	if err != nil {
+		panic(err.Error())
+	}
+
+	if size != sr.Size {
+		panic(fmt.Sprintf("Backing store size %d vs. %d", size, sr.Size))
+	}
+
 	// INVARIANT: mtime == nil => Stat().DirtyThreshold == Stat().Size
 	if tf.mtime == nil && sr.DirtyThreshold != sr.Size {
 		panic(fmt.Sprintf("Mismatch: %d vs. %d", sr.DirtyThreshold, sr.Size))
 	}
+
+	// INVARIANT: present.checkInvariants() doesn't panic
+	tf.present.checkInvariants()
+
+	// INVARIANT: present lies within [0, Stat().Size)
+	if n := len(tf.present.ranges); n > 0 && tf.present.ranges[n-1].limit > sr.Size {
+		panic(fmt.Sprintf(
+			"Present range [%d, %d) extends beyond size %d",
+			tf.present.ranges[n-1].start,
+			tf.present.ranges[n-1].limit,
+			sr.Size))
+	}
+
+	// INVARIANT: dirty.checkInvariants() doesn't panic
+	tf.dirty.checkInvariants()
+
+	// INVARIANT: dirty lies within [0, Stat().Size)
+	if n := len(tf.dirty.ranges); n > 0 && tf.dirty.ranges[n-1].limit > sr.Size {
+		panic(fmt.Sprintf(
+			"Dirty range [%d, %d) extends beyond size %d",
+			tf.dirty.ranges[n-1].start,
+			tf.dirty.ranges[n-1].limit,
+			sr.Size))
+	}
+
+	// INVARIANT: dirty lies within [Stat().DirtyThreshold, Stat().Size)
+	if len(tf.dirty.ranges) > 0 && tf.dirty.ranges[0].start < sr.DirtyThreshold {
+		panic(fmt.Sprintf(
+			"Dirty range [%d, %d) starts before threshold %d",
+			tf.dirty.ranges[0].start,
+			tf.dirty.ranges[0].limit,
+			sr.DirtyThreshold))
+	}
+
+	// INVARIANT: Missing(0, Stat().Size) lies within [0, initialSize)
+	for _, r := range tf.present.missing(0, sr.Size) {
+		if int64(r.Limit) > tf.initialSize {
+			panic(fmt.Sprintf(
+				"Missing range %v extends beyond initial size %d",
+				r,
+				tf.initialSize))
+		}
+	}
 }
 
 func (tf *tempFile) Destroy() {
@@ -189,6 +308,7 @@ func (tf *tempFile) ReadAt(p []byte, offset int64) (int, error) {
 func (tf *tempFile) Stat() (sr StatResult, err error) {
 	sr.DirtyThreshold = tf.dirtyThreshold
 	sr.Mtime = tf.mtime
+	sr.MaterializedBytes = tf.present.size()
 
 	// Get the size from the file.
 	sr.Size, err = tf.f.Seek(0, 2)
@@ -200,18 +320,122 @@ func (tf *tempFile) Stat() (sr StatResult, err error) {
 	return
 }
 
-func (tf *tempFile) WriteAt(p []byte, offset int64) (int, error) {
-	// Update our state regarding being dirty.
+func (tf *tempFile) Missing(
+	start int64,
+	limit int64) (ranges []gcs.ByteRange, err error) {
+	// Clip to the current size.
+	size, err := tf.size()
+	if err != nil {
+		return
+	}
+
+	ranges = tf.present.missing(start, minInt64(limit, size))
+	return
+}
+
+func (tf *tempFile) Materialize(r io.Reader, offset int64) (n int64, err error) {
+	w := &offsetWriter{f: tf.f, offset: offset}
+	n, err = io.Copy(w, r)
+	tf.present.add(offset, offset+n)
+
+	// Distinguish running out of space from failing to read.
+	if err != nil && err == w.err {
+		err = classifyBackingError(err)
+	}
+
+	return
+}
+
+func (tf *tempFile) DirtyRanges() (ranges []gcs.ByteRange) {
+	ranges = tf.dirty.list()
+	return
+}
+
+// WriteAt is transactional: if the underlying write fails (e.g. because the
+// disk is full), the bytes it overwrote are restored and the file is
+// truncated back to its previous size, so that the error leaves the contents
+// and our state as they were. Only if that too fails do we fall back to
+// treating the bytes that were written as modified. A full disk is reported
+// as *NoSpaceError.
+//
+// Where the store supports it (see SpaceReserver), space for the write is
+// reserved first, so that a full disk is usually noticed before anything is
+// written at all.
+func (tf *tempFile) WriteAt(p []byte, offset int64) (n int, err error) {
+	// Find the current size. If we're writing beyond it, the gap will be filled
+	// with zeroes that we needn't fetch.
+	size, err := tf.size()
+	if err != nil {
+		return
+	}
+
+	// Make sure there's room before changing anything.
+	err = reserve(tf.f, offset, int64(len(p)))
+	if err != nil {
+		return
+	}
+
+	// Save the existing bytes we're about to overwrite, in case we need to put
+	// them back. Anything beyond the current size is dealt with by truncating.
+	var old []byte
+	if offset < size {
+		old = make([]byte, minInt64(int64(len(p)), size-offset))
+		_, err = tf.f.ReadAt(old, offset)
+		if err != nil {
+			err = fmt.Errorf("ReadAt: %v", err)
+			return
+		}
+	}
+
+	// Call through.
+	n, err = tf.f.WriteAt(p, offset)
+	if err != nil {
+		err = classifyBackingError(err)
+		rollbackErr := tf.rollBack(old, offset, size)
+		if rollbackErr == nil {
+			n = 0
+			return
+		}
+
+		err = fmt.Errorf("%v (rolling back: %v)", err, rollbackErr)
+		if n == 0 {
+			return
+		}
+	}
+
+	// Update our state to reflect the write.
+	tf.present.add(size, offset)
+	tf.dirty.add(size, offset)
+	tf.present.add(offset, offset+int64(n))
+	tf.dirty.add(offset, offset+int64(n))
+
 	tf.dirtyThreshold = minInt64(tf.dirtyThreshold, offset)
 
 	newMtime := tf.clock.Now()
 	tf.mtime = &newMtime
 
-	// Call through.
-	return tf.f.WriteAt(p, offset)
+	return
 }
 
+// Truncate reserves space for any extension where the store supports it, as
+// with WriteAt, so that running out fails the truncation up front. The
+// extension then takes up space even on file systems that support sparse
+// files.
 func (tf *tempFile) Truncate(n int64) error {
+	// Find the current size, so we know what if anything we're extending by.
+	size, err := tf.size()
+	if err != nil {
+		return err
+	}
+
+	// Make sure there's room for the extension before changing anything.
+	if n > size {
+		err = reserve(tf.f, size, n-size)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Update our state regarding being dirty.
 	tf.dirtyThreshold = minInt64(tf.dirtyThreshold, n)
 
@@ -219,7 +443,19 @@ func (tf *tempFile) Truncate(n int64) error {
 	tf.mtime = &newMtime
 
 	// Call through.
-	return tf.f.Truncate(n)
+	err = tf.f.Truncate(n)
+	if err != nil {
+		return classifyBackingError(err)
+	}
+
+	// Anything beyond the new size is gone (and the file system has released
+	// its storage), and any extension consists of zeroes that we needn't fetch.
+	tf.present.truncate(n)
+	tf.present.add(size, n)
+	tf.dirty.truncate(n)
+	tf.dirty.add(size, n)
+
+	return nil
 }
 
 func (tf *tempFile) SetMtime(mtime time.Time) {
@@ -230,6 +466,84 @@ func (tf *tempFile) SetMtime(mtime time.Time) {
 // Helpers
 ////////////////////////////////////////////////////////////////////////
 
+// Undo a failed write at the given offset, given the bytes it may have
+// overwritten and the size of the file before it.
+func (tf *tempFile) rollBack(old []byte, offset int64, size int64) (err error) {
+	_, err = tf.f.WriteAt(old, offset)
+	if err != nil {
+		err = fmt.Errorf("WriteAt: %v", err)
+		return
+	}
+
+	err = tf.f.Truncate(size)
+	if err != nil {
+		err = fmt.Errorf("Truncate: %v", err)
+		return
+	}
+
+	return
+}
+
+// Return the current size of the file, without disturbing the seek position.
+func (tf *tempFile) size() (size int64, err error) {
+	fi, err := tf.f.Stat()
+	if err != nil {
+		err = fmt.Errorf("Stat: %v", err)
+		return
+	}
+
+	size = fi.Size()
+	return
+}
+
+// An io.Writer that writes sequentially to a file starting at a given offset,
+// without disturbing the file's seek position.
+type offsetWriter struct {
+	f      backingFile
+	offset int64
+
+	// The error returned by the most recent write, if any.
+	err error
+}
+
+func (w *offsetWriter) Write(p []byte) (n int, err error) {
+	n, err = w.f.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	w.err = err
+	return
+}
+
+// Map an error from a backing file that indicates that its file system is
+// full, or that the user's quota on it is exhausted, to *NoSpaceError,
+// returning other errors unmodified.
+func classifyBackingError(err error) error {
+	errno := err
+	switch typed := err.(type) {
+	case *os.PathError:
+		errno = typed.Err
+
+	case *os.SyscallError:
+		errno = typed.Err
+	}
+
+	switch errno {
+	case syscall.ENOSPC, syscall.EDQUOT:
+		return &NoSpaceError{Err: err}
+	}
+
+	return err
+}
+
+// The subset of *os.File used by tempFile.
+type backingFile interface {
+	io.ReadSeeker
+	io.ReaderAt
+	io.WriterAt
+	Truncate(size int64) error
+	Stat() (os.FileInfo, error)
+	Close() error
+}
+
 func minInt64(a int64, b int64) int64 {
 	if a < b {
 		return a
@@ -0,0 +1,418 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/jacobsa/timeutil"
+)
+
+// A backing file whose writes apply only the first half of their data before
+// failing, as when the disk fills up part way. After the first failure, all
+// writes fail if failRollback is set.
+type halfWritingFile struct {
+	backingFile
+	failRollback bool
+	failed       bool
+}
+
+func (f *halfWritingFile) WriteAt(p []byte, off int64) (n int, err error) {
+	if f.failed && f.failRollback {
+		err = errors.New("injected rollback failure")
+		return
+	}
+
+	if f.failed {
+		n, err = f.backingFile.WriteAt(p, off)
+		return
+	}
+
+	f.failed = true
+	n, err = f.backingFile.WriteAt(p[:len(p)/2], off)
+	if err == nil {
+		err = syscall.ENOSPC
+	}
+
+	return
+}
+
+func newHalfWritingTempFile(
+	t *testing.T,
+	contents string,
+	failRollback bool) (tf *tempFile, bf *halfWritingFile) {
+	var clock timeutil.SimulatedClock
+	typed, err := NewTempFile(strings.NewReader(contents), "", &clock)
+	if err != nil {
+		t.Fatalf("NewTempFile: %v", err)
+	}
+
+	tf = typed.(*tempFile)
+	bf = &halfWritingFile{backingFile: tf.f, failRollback: failRollback}
+	tf.f = bf
+
+	return
+}
+
+func TestTempFileWriteAtFailureIsRolledBack(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		offset int64
+		data   string
+	}{
+		{"within", 1, "xy"},
+		{"overlapping end", 2, "xyzw"},
+		{"at end", 4, "xyzw"},
+		{"beyond end", 10, "xyzw"},
+	}
+
+	for _, tc := range testCases {
+		tf, _ := newHalfWritingTempFile(t, "taco", false)
+		before, err := tf.Stat()
+		if err != nil {
+			t.Fatalf("%s: Stat: %v", tc.desc, err)
+		}
+
+		n, err := tf.WriteAt([]byte(tc.data), tc.offset)
+		if nse, ok := err.(*NoSpaceError); !ok || nse.Err != syscall.ENOSPC {
+			t.Errorf("%s: got error %v, want NoSpaceError(ENOSPC)", tc.desc, err)
+		}
+
+		if n != 0 {
+			t.Errorf("%s: got n == %d, want 0", tc.desc, n)
+		}
+
+		tf.CheckInvariants()
+
+		after, err := tf.Stat()
+		if err != nil {
+			t.Fatalf("%s: Stat: %v", tc.desc, err)
+		}
+
+		if !reflect.DeepEqual(before, after) {
+			t.Errorf("%s: stat changed: %#v -> %#v", tc.desc, before, after)
+		}
+
+		if r := tf.DirtyRanges(); len(r) != 0 {
+			t.Errorf("%s: unexpected dirty ranges: %v", tc.desc, r)
+		}
+
+		buf := make([]byte, after.Size)
+		_, err = tf.ReadAt(buf, 0)
+		if err != nil {
+			t.Fatalf("%s: ReadAt: %v", tc.desc, err)
+		}
+
+		if string(buf) != "taco" {
+			t.Errorf("%s: got contents %q, want %q", tc.desc, buf, "taco")
+		}
+
+		// The file should still be usable.
+		_, err = tf.WriteAt([]byte(tc.data), tc.offset)
+		if err != nil {
+			t.Errorf("%s: second WriteAt: %v", tc.desc, err)
+		}
+
+		tf.CheckInvariants()
+		tf.Destroy()
+	}
+}
+
+// A backing file on a full file system, for which storage has been allocated
+// only for the bytes before the given offset. Writes that would need more fail
+// as *os.File's do.
+type fullFile struct {
+	backingFile
+	allocated int64
+}
+
+func (f *fullFile) WriteAt(p []byte, off int64) (n int, err error) {
+	if off+int64(len(p)) > f.allocated {
+		err = &os.PathError{Op: "write", Path: "full", Err: syscall.ENOSPC}
+		return
+	}
+
+	n, err = f.backingFile.WriteAt(p, off)
+	return
+}
+
+func TestTempFileMaterializeNoSpace(t *testing.T) {
+	var clock timeutil.SimulatedClock
+	typed, err := NewSparseTempFile(4, "", &clock)
+	if err != nil {
+		t.Fatalf("NewSparseTempFile: %v", err)
+	}
+
+	tf := typed.(*tempFile)
+	defer tf.Destroy()
+	tf.f = &fullFile{backingFile: tf.f}
+
+	_, err = tf.Materialize(strings.NewReader("taco"), 0)
+	if _, ok := err.(*NoSpaceError); !ok {
+		t.Errorf("Got error %v, want NoSpaceError", err)
+	}
+
+	// A failure to read should be passed through.
+	tf.f = tf.f.(*fullFile).backingFile
+	readErr := errors.New("taco")
+	_, err = tf.Materialize(&errReader{readErr}, 0)
+	if err != readErr {
+		t.Errorf("Got error %v, want %v", err, readErr)
+	}
+}
+
+// A reader that always fails with the given error.
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func TestMoveTempFileToMemory(t *testing.T) {
+	var clock timeutil.SimulatedClock
+	typed, err := NewSparseTempFile(8, "", &clock)
+	if err != nil {
+		t.Fatalf("NewSparseTempFile: %v", err)
+	}
+
+	tf := typed.(*tempFile)
+	defer tf.Destroy()
+
+	// Materialize part of the file, then fill up the disk.
+	_, err = tf.Materialize(strings.NewReader("taco"), 0)
+	if err != nil {
+		t.Fatalf("Materialize: %v", err)
+	}
+
+	tf.f = &fullFile{backingFile: tf.f, allocated: 8}
+
+	_, err = tf.WriteAt([]byte("burrito"), 2)
+	if _, ok := err.(*NoSpaceError); !ok {
+		t.Fatalf("Got error %v, want NoSpaceError", err)
+	}
+
+	before, err := tf.Stat()
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+
+	// Once in memory, the file should be just as it was, and writable.
+	err = MoveTempFileToMemory(tf)
+	if err != nil {
+		t.Fatalf("MoveTempFileToMemory: %v", err)
+	}
+
+	tf.CheckInvariants()
+
+	after, err := tf.Stat()
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("Stat changed: %#v -> %#v", before, after)
+	}
+
+	missing, err := tf.Missing(0, 8)
+	if err != nil {
+		t.Fatalf("Missing: %v", err)
+	}
+
+	if len(missing) != 1 || missing[0].Start != 4 || missing[0].Limit != 8 {
+		t.Errorf("Got missing ranges %v, want [4, 8)", missing)
+	}
+
+	_, err = tf.WriteAt([]byte("burrito"), 2)
+	if err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+
+	tf.CheckInvariants()
+
+	buf := make([]byte, 9)
+	_, err = tf.ReadAt(buf, 0)
+	if err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+
+	if string(buf) != "taburrito" {
+		t.Errorf("Got contents %q, want %q", buf, "taburrito")
+	}
+
+	if r := tf.DirtyRanges(); len(r) != 1 || r[0].Start != 2 || r[0].Limit != 9 {
+		t.Errorf("Got dirty ranges %v, want [2, 9)", r)
+	}
+
+	// Moving again should do nothing.
+	mf := tf.f
+	err = MoveTempFileToMemory(tf)
+	if err != nil {
+		t.Fatalf("MoveTempFileToMemory: %v", err)
+	}
+
+	if tf.f != mf {
+		t.Errorf("Contents moved again")
+	}
+}
+
+func TestTempFileWriteAtFailedRollback(t *testing.T) {
+	tf, _ := newHalfWritingTempFile(t, "taco", true)
+
+	// We can't undo the write, so the bytes that made it should be accounted
+	// for as modified.
+	n, err := tf.WriteAt([]byte("xyzw"), 2)
+	if err == nil {
+		t.Fatalf("Expected an error")
+	}
+
+	if n != 2 {
+		t.Errorf("Got n == %d, want 2", n)
+	}
+
+	tf.CheckInvariants()
+
+	sr, err := tf.Stat()
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+
+	if sr.DirtyThreshold != 2 {
+		t.Errorf("Got DirtyThreshold %d, want 2", sr.DirtyThreshold)
+	}
+
+	if sr.Mtime == nil {
+		t.Errorf("Expected an mtime")
+	}
+
+	tf.Destroy()
+}
+
+// A backing store that counts writes and refuses to reserve space beyond a
+// quota, without enforcing the quota otherwise.
+type quotaStore struct {
+	memStore
+	quota  int64
+	writes int
+}
+
+func (s *quotaStore) WriteAt(p []byte, offset int64) (n int, err error) {
+	s.writes++
+	n, err = s.memStore.WriteAt(p, offset)
+	return
+}
+
+func (s *quotaStore) Reserve(offset int64, length int64) (err error) {
+	if offset+length > s.quota {
+		err = &NoSpaceError{Err: syscall.EDQUOT}
+	}
+
+	return
+}
+
+func TestTempFileReservesSpace(t *testing.T) {
+	var clock timeutil.SimulatedClock
+	store := &quotaStore{quota: 8}
+	tf, err := NewTempFileWithStore(strings.NewReader("taco"), store, &clock)
+	if err != nil {
+		t.Fatalf("NewTempFileWithStore: %v", err)
+	}
+
+	defer tf.Destroy()
+
+	// Operations that need more space than is available should be rejected
+	// without touching the store.
+	writes := store.writes
+
+	n, err := tf.WriteAt([]byte("burrito"), 2)
+	if _, ok := err.(*NoSpaceError); !ok {
+		t.Errorf("WriteAt: got error %v, want NoSpaceError", err)
+	}
+
+	if n != 0 {
+		t.Errorf("WriteAt: got n == %d, want 0", n)
+	}
+
+	err = tf.Truncate(9)
+	if _, ok := err.(*NoSpaceError); !ok {
+		t.Errorf("Truncate: got error %v, want NoSpaceError", err)
+	}
+
+	if store.writes != writes {
+		t.Errorf("Got %d writes to the store, want %d", store.writes, writes)
+	}
+
+	tf.CheckInvariants()
+
+	sr, err := tf.Stat()
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+
+	if sr.Size != 4 || sr.DirtyThreshold != 4 || sr.Mtime != nil {
+		t.Errorf("Unexpected stat result: %#v", sr)
+	}
+
+	if string(store.data) != "taco" {
+		t.Errorf("Got contents %q, want %q", store.data, "taco")
+	}
+
+	// Those within the quota should succeed.
+	_, err = tf.WriteAt([]byte("burr"), 2)
+	if err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+
+	err = tf.Truncate(8)
+	if err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+
+	tf.CheckInvariants()
+
+	if got, want := string(store.data), "taburr\x00\x00"; got != want {
+		t.Errorf("Got contents %q, want %q", got, want)
+	}
+}
+
+func TestFileBackingStoreReserve(t *testing.T) {
+	bs, err := NewFileBackingStore("")
+	if err != nil {
+		t.Fatalf("NewFileBackingStore: %v", err)
+	}
+
+	defer bs.Close()
+
+	// Reserving shouldn't change the size.
+	err = bs.(SpaceReserver).Reserve(0, 1<<16)
+	if err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+
+	size, err := bs.Size()
+	if err != nil {
+		t.Fatalf("Size: %v", err)
+	}
+
+	if size != 0 {
+		t.Errorf("Got size %d, want 0", size)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Move the contents of a temp file from its backing store into memory, e.g.
+// because the disk is full. The temp file's state, including which ranges are
+// missing or dirty, is unaffected, and the old backing store is closed,
+// releasing its space. From then on it never returns *NoSpaceError, but can't
+// be mapped with MapTempFile. This is a no-op if the contents are already in
+// memory.
+func MoveTempFileToMemory(tf TempFile) (err error) {
+	typed, ok := tf.(*tempFile)
+	if !ok {
+		err = errors.New("Not a movable temp file")
+		return
+	}
+
+	if sf, ok := typed.f.(*storeFile); ok {
+		if _, ok := sf.store.(*memStore); ok {
+			return
+		}
+	}
+
+	// Preserve the seek position.
+	pos, err := typed.f.Seek(0, 1)
+	if err != nil {
+		err = fmt.Errorf("Seek: %v", err)
+		return
+	}
+
+	size, err := typed.size()
+	if err != nil {
+		return
+	}
+
+	// Missing ranges come along as whatever the file holds for them, which is
+	// fine since they're never read.
+	ms := &memStore{data: make([]byte, size)}
+	_, err = io.ReadFull(io.NewSectionReader(typed.f, 0, size), ms.data)
+	if err != nil {
+		err = fmt.Errorf("ReadFull: %v", err)
+		return
+	}
+
+	typed.f.Close()
+	typed.f = &storeFile{store: ms, pos: pos}
+
+	return
+}
+
+// A BackingStore that keeps its contents in memory.
+type memStore struct {
+	data []byte
+}
+
+func (ms *memStore) ReadAt(p []byte, offset int64) (n int, err error) {
+	if offset < int64(len(ms.data)) {
+		n = copy(p, ms.data[offset:])
+	}
+
+	if n < len(p) {
+		err = io.EOF
+	}
+
+	return
+}
+
+func (ms *memStore) WriteAt(p []byte, offset int64) (n int, err error) {
+	if limit := offset + int64(len(p)); limit > int64(len(ms.data)) {
+		err = ms.Truncate(limit)
+		if err != nil {
+			return
+		}
+	}
+
+	n = copy(ms.data[offset:], p)
+	return
+}
+
+func (ms *memStore) Truncate(size int64) (err error) {
+	if size < 0 {
+		err = fmt.Errorf("Invalid size: %d", size)
+		return
+	}
+
+	if size <= int64(len(ms.data)) {
+		ms.data = ms.data[:size]
+		return
+	}
+
+	extended := make([]byte, size)
+	copy(extended, ms.data)
+	ms.data = extended
+
+	return
+}
+
+func (ms *memStore) Size() (size int64, err error) {
+	size = int64(len(ms.data))
+	return
+}
+
+func (ms *memStore) Close() (err error) {
+	ms.data = nil
+	return
+}
@@ -0,0 +1,83 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// Map the entire contents of a temp file created by NewTempFile or
+// NewSparseTempFile into memory read-only, returning the bytes and a function
+// that unmaps them. This fails if any of the initial content is missing.
+//
+// The mapping shares the temp file's pages, so later writes to the temp file
+// show through it, and accessing bytes removed by a later truncation faults.
+// Callers should unmap before modifying the temp file. The mapping remains
+// valid after Destroy.
+func MapTempFile(tf TempFile) (data []byte, unmap func() error, err error) {
+	typed, ok := tf.(*tempFile)
+	if !ok {
+		err = errors.New("Not a mappable temp file")
+		return
+	}
+
+	fder, ok := typed.f.(interface {
+		Fd() uintptr
+	})
+
+	if !ok {
+		err = errors.New("Temp file has no file descriptor")
+		return
+	}
+
+	sr, err := tf.Stat()
+	if err != nil {
+		err = fmt.Errorf("Stat: %v", err)
+		return
+	}
+
+	if sr.MaterializedBytes != sr.Size {
+		err = fmt.Errorf(
+			"Only %d of %d bytes are present",
+			sr.MaterializedBytes,
+			sr.Size)
+
+		return
+	}
+
+	// mmap refuses empty mappings.
+	if sr.Size == 0 {
+		data = []byte{}
+		unmap = func() error { return nil }
+		return
+	}
+
+	data, err = syscall.Mmap(
+		int(fder.Fd()),
+		0,
+		int(sr.Size),
+		syscall.PROT_READ,
+		syscall.MAP_SHARED)
+
+	if err != nil {
+		err = fmt.Errorf("Mmap: %v", err)
+		return
+	}
+
+	unmap = func() error { return syscall.Munmap(data) }
+	return
+}
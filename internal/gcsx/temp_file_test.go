@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
 	. "github.com/jacobsa/oglematchers"
 	. "github.com/jacobsa/ogletest"
 	"github.com/jacobsa/timeutil"
@@ -91,6 +92,26 @@ func (tf *checkingTempFile) WriteAt(b []byte, o int64) (int, error) {
 	return tf.wrapped.WriteAt(b, o)
 }
 
+func (tf *checkingTempFile) Missing(
+	start int64,
+	limit int64) ([]gcs.ByteRange, error) {
+	tf.wrapped.CheckInvariants()
+	defer tf.wrapped.CheckInvariants()
+	return tf.wrapped.Missing(start, limit)
+}
+
+func (tf *checkingTempFile) Materialize(r io.Reader, o int64) (int64, error) {
+	tf.wrapped.CheckInvariants()
+	defer tf.wrapped.CheckInvariants()
+	return tf.wrapped.Materialize(r, o)
+}
+
+func (tf *checkingTempFile) DirtyRanges() []gcs.ByteRange {
+	tf.wrapped.CheckInvariants()
+	defer tf.wrapped.CheckInvariants()
+	return tf.wrapped.DirtyRanges()
+}
+
 func (tf *checkingTempFile) Truncate(n int64) error {
 	tf.wrapped.CheckInvariants()
 	defer tf.wrapped.CheckInvariants()
@@ -154,6 +175,7 @@ func (t *TempFileTest) Stat_InitialState() {
 	ExpectEq(initialContentSize, sr.Size)
 	ExpectEq(initialContentSize, sr.DirtyThreshold)
 	ExpectEq(nil, sr.Mtime)
+	ExpectEq(initialContentSize, sr.MaterializedBytes)
 }
 
 func (t *TempFileTest) ReadAt() {
@@ -221,6 +243,26 @@ func (t *TempFileTest) Truncate() {
 	ExpectEq(expected, string(actual))
 }
 
+func (t *TempFileTest) MarkTempFileModified() {
+	// Call
+	err := gcsx.MarkTempFileModified(t.tf.wrapped)
+	AssertEq(nil, err)
+
+	// Everything should now count as modified, but the contents and what's
+	// present are unchanged.
+	sr, err := t.tf.Stat()
+
+	AssertEq(nil, err)
+	ExpectEq(initialContentSize, sr.Size)
+	ExpectEq(0, sr.DirtyThreshold)
+	ExpectThat(sr.Mtime, Pointee(timeutil.TimeEq(t.clock.Now())))
+	ExpectEq(initialContentSize, sr.MaterializedBytes)
+
+	actual, err := readAll(&t.tf)
+	AssertEq(nil, err)
+	ExpectEq(initialContent, string(actual))
+}
+
 func (t *TempFileTest) SetMtime() {
 	mtime := time.Date(2015, 4, 5, 2, 15, 0, 0, time.Local)
 	AssertThat(mtime, Not(timeutil.TimeEq(t.clock.Now())))
@@ -234,3 +276,380 @@ func (t *TempFileTest) SetMtime() {
 	AssertEq(nil, err)
 	ExpectThat(sr.Mtime, Pointee(timeutil.TimeEq(mtime)))
 }
+
+func (t *TempFileTest) DirtyRanges() {
+	var err error
+
+	// Initially clean.
+	ExpectThat(t.tf.DirtyRanges(), ElementsAre())
+
+	// Write in the middle, and then abutting that.
+	_, err = t.tf.WriteAt([]byte("xx"), 2)
+	AssertEq(nil, err)
+
+	_, err = t.tf.WriteAt([]byte("yy"), 4)
+	AssertEq(nil, err)
+
+	ExpectThat(t.tf.DirtyRanges(), DeepEquals([]gcs.ByteRange{
+		{Start: 2, Limit: 6},
+	}))
+
+	// Write beyond the end, leaving a zero-filled gap that also counts.
+	_, err = t.tf.WriteAt([]byte("z"), int64(initialContentSize)+2)
+	AssertEq(nil, err)
+
+	ExpectThat(t.tf.DirtyRanges(), DeepEquals([]gcs.ByteRange{
+		{Start: 2, Limit: 6},
+		{Start: uint64(initialContentSize), Limit: uint64(initialContentSize) + 3},
+	}))
+
+	// Truncating discards what lies beyond, and extending is dirty.
+	err = t.tf.Truncate(5)
+	AssertEq(nil, err)
+
+	err = t.tf.Truncate(7)
+	AssertEq(nil, err)
+
+	ExpectThat(t.tf.DirtyRanges(), DeepEquals([]gcs.ByteRange{
+		{Start: 2, Limit: 7},
+	}))
+}
+
+func (t *TempFileTest) Missing_InitialState() {
+	ranges, err := t.tf.Missing(0, int64(initialContentSize))
+
+	AssertEq(nil, err)
+	ExpectThat(ranges, ElementsAre())
+}
+
+////////////////////////////////////////////////////////////////////////
+// Sparse temp files
+////////////////////////////////////////////////////////////////////////
+
+type SparseTempFileTest struct {
+	clock timeutil.SimulatedClock
+	tf    checkingTempFile
+}
+
+func init() { RegisterTestSuite(&SparseTempFileTest{}) }
+
+var _ SetUpInterface = &SparseTempFileTest{}
+
+func (t *SparseTempFileTest) SetUp(ti *TestInfo) {
+	var err error
+
+	// Set up the clock.
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+
+	// And the temp file.
+	t.tf.wrapped, err = gcsx.NewSparseTempFile(
+		int64(initialContentSize),
+		"",
+		&t.clock)
+
+	AssertEq(nil, err)
+}
+
+func (t *SparseTempFileTest) Stat_InitialState() {
+	sr, err := t.tf.Stat()
+
+	AssertEq(nil, err)
+	ExpectEq(initialContentSize, sr.Size)
+	ExpectEq(initialContentSize, sr.DirtyThreshold)
+	ExpectEq(nil, sr.Mtime)
+}
+
+func (t *SparseTempFileTest) MaterializedBytes_InitialState() {
+	sr, err := t.tf.Stat()
+
+	AssertEq(nil, err)
+	ExpectEq(0, sr.MaterializedBytes)
+}
+
+func (t *SparseTempFileTest) Missing_InitialState() {
+	ranges, err := t.tf.Missing(0, int64(initialContentSize))
+
+	AssertEq(nil, err)
+	ExpectThat(ranges, DeepEquals([]gcs.ByteRange{{Start: 0, Limit: 11}}))
+}
+
+func (t *SparseTempFileTest) Missing_ClippedToSize() {
+	ranges, err := t.tf.Missing(3, 100)
+
+	AssertEq(nil, err)
+	ExpectThat(ranges, DeepEquals([]gcs.ByteRange{{Start: 3, Limit: 11}}))
+}
+
+func (t *SparseTempFileTest) Materialize() {
+	// Supply the middle of the content.
+	n, err := t.tf.Materialize(strings.NewReader(initialContent[4:7]), 4)
+
+	AssertEq(nil, err)
+	ExpectEq(3, n)
+
+	// The rest should still be missing.
+	ranges, err := t.tf.Missing(0, int64(initialContentSize))
+
+	AssertEq(nil, err)
+	ExpectThat(
+		ranges,
+		DeepEquals([]gcs.ByteRange{{Start: 0, Limit: 4}, {Start: 7, Limit: 11}}))
+
+	// Materializing doesn't count as a modification.
+	sr, err := t.tf.Stat()
+
+	AssertEq(nil, err)
+	ExpectEq(initialContentSize, sr.Size)
+	ExpectEq(initialContentSize, sr.DirtyThreshold)
+	ExpectEq(nil, sr.Mtime)
+
+	// Read back.
+	var buf [3]byte
+	_, err = t.tf.ReadAt(buf[:], 4)
+
+	AssertEq(nil, err)
+	ExpectEq(initialContent[4:7], string(buf[:]))
+}
+
+func (t *SparseTempFileTest) WriteAt() {
+	// Overwrite a range of the missing content.
+	_, err := t.tf.WriteAt([]byte("foo"), 2)
+	AssertEq(nil, err)
+
+	// That range needn't be fetched.
+	ranges, err := t.tf.Missing(0, int64(initialContentSize))
+
+	AssertEq(nil, err)
+	ExpectThat(
+		ranges,
+		DeepEquals([]gcs.ByteRange{{Start: 0, Limit: 2}, {Start: 5, Limit: 11}}))
+}
+
+func (t *SparseTempFileTest) WriteAt_BeyondEnd() {
+	// Write beyond the end of the initial content.
+	_, err := t.tf.WriteAt([]byte("foo"), 20)
+	AssertEq(nil, err)
+
+	// Only the initial content is missing; the gap is zeroes.
+	ranges, err := t.tf.Missing(0, 100)
+
+	AssertEq(nil, err)
+	ExpectThat(ranges, DeepEquals([]gcs.ByteRange{{Start: 0, Limit: 11}}))
+}
+
+func (t *SparseTempFileTest) Truncate() {
+	// Shrink, then grow again.
+	err := t.tf.Truncate(4)
+	AssertEq(nil, err)
+
+	err = t.tf.Truncate(8)
+	AssertEq(nil, err)
+
+	// Only the part of the initial content we kept is missing.
+	ranges, err := t.tf.Missing(0, 100)
+
+	AssertEq(nil, err)
+	ExpectThat(ranges, DeepEquals([]gcs.ByteRange{{Start: 0, Limit: 4}}))
+
+	// The extension is zeroes.
+	var buf [4]byte
+	_, err = t.tf.ReadAt(buf[:], 4)
+
+	AssertEq(nil, err)
+	ExpectEq("\x00\x00\x00\x00", string(buf[:]))
+}
+
+func (t *SparseTempFileTest) TruncateDown_FreesMaterializedBytes() {
+	var err error
+
+	// Materialize everything.
+	_, err = t.tf.Materialize(strings.NewReader(initialContent), 0)
+	AssertEq(nil, err)
+
+	sr, err := t.tf.Stat()
+	AssertEq(nil, err)
+	ExpectEq(initialContentSize, sr.MaterializedBytes)
+
+	// Shrink.
+	err = t.tf.Truncate(4)
+	AssertEq(nil, err)
+
+	sr, err = t.tf.Stat()
+	AssertEq(nil, err)
+	ExpectEq(4, sr.Size)
+	ExpectEq(4, sr.MaterializedBytes)
+
+	// Growing again leaves only zeroes beyond the old size, which needn't be
+	// fetched.
+	err = t.tf.Truncate(6)
+	AssertEq(nil, err)
+
+	sr, err = t.tf.Stat()
+	AssertEq(nil, err)
+	ExpectEq(6, sr.Size)
+	ExpectEq(6, sr.MaterializedBytes)
+
+	ranges, err := t.tf.Missing(0, 6)
+	AssertEq(nil, err)
+	ExpectThat(ranges, ElementsAre())
+}
+
+func (t *SparseTempFileTest) TruncateDown_PartiallyMaterialized() {
+	var err error
+
+	// Materialize a range straddling the new size.
+	_, err = t.tf.Materialize(strings.NewReader(initialContent[2:8]), 2)
+	AssertEq(nil, err)
+
+	// Shrink.
+	err = t.tf.Truncate(5)
+	AssertEq(nil, err)
+
+	sr, err := t.tf.Stat()
+	AssertEq(nil, err)
+	ExpectEq(5, sr.Size)
+	ExpectEq(3, sr.MaterializedBytes)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Pluggable backing stores
+////////////////////////////////////////////////////////////////////////
+
+// A gcsx.BackingStore that keeps its contents in a slice, standing in for one
+// supplied by a user.
+type sliceStore struct {
+	data   []byte
+	closed bool
+}
+
+func (s *sliceStore) ReadAt(p []byte, offset int64) (n int, err error) {
+	if offset < int64(len(s.data)) {
+		n = copy(p, s.data[offset:])
+	}
+
+	if n < len(p) {
+		err = io.EOF
+	}
+
+	return
+}
+
+func (s *sliceStore) WriteAt(p []byte, offset int64) (n int, err error) {
+	if limit := offset + int64(len(p)); limit > int64(len(s.data)) {
+		err = s.Truncate(limit)
+		if err != nil {
+			return
+		}
+	}
+
+	n = copy(s.data[offset:], p)
+	return
+}
+
+func (s *sliceStore) Truncate(size int64) (err error) {
+	for int64(len(s.data)) < size {
+		s.data = append(s.data, 0)
+	}
+
+	s.data = s.data[:size]
+	return
+}
+
+func (s *sliceStore) Size() (size int64, err error) {
+	size = int64(len(s.data))
+	return
+}
+
+func (s *sliceStore) Close() (err error) {
+	s.closed = true
+	return
+}
+
+// The temp file tests, run against a temp file kept in a sliceStore.
+type StoreTempFileTest struct {
+	TempFileTest
+	store *sliceStore
+}
+
+func init() { RegisterTestSuite(&StoreTempFileTest{}) }
+
+func (t *StoreTempFileTest) SetUp(ti *TestInfo) {
+	var err error
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+
+	t.store = &sliceStore{}
+	t.tf.wrapped, err = gcsx.NewTempFileWithStore(
+		strings.NewReader(initialContent),
+		t.store,
+		&t.clock)
+
+	AssertEq(nil, err)
+}
+
+func (t *StoreTempFileTest) ContentsLiveInStore() {
+	var err error
+	ExpectEq(initialContent, string(t.store.data))
+
+	_, err = t.tf.WriteAt([]byte("enchilada"), int64(initialContentSize))
+	AssertEq(nil, err)
+	ExpectEq(initialContent+"enchilada", string(t.store.data))
+
+	err = t.tf.Truncate(4)
+	AssertEq(nil, err)
+	ExpectEq("taco", string(t.store.data))
+
+	// Destroying the temp file should close the store.
+	t.tf.wrapped.Destroy()
+	ExpectTrue(t.store.closed)
+}
+
+func (t *StoreTempFileTest) CantBeMapped() {
+	_, _, err := gcsx.MapTempFile(t.tf.wrapped)
+	ExpectThat(err, Error(HasSubstr("file descriptor")))
+}
+
+// The sparse temp file tests, run against a temp file kept in a sliceStore.
+type StoreSparseTempFileTest struct {
+	SparseTempFileTest
+	store *sliceStore
+}
+
+func init() { RegisterTestSuite(&StoreSparseTempFileTest{}) }
+
+func (t *StoreSparseTempFileTest) SetUp(ti *TestInfo) {
+	var err error
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+
+	t.store = &sliceStore{}
+	t.tf.wrapped, err = gcsx.NewSparseTempFileWithStore(
+		int64(initialContentSize),
+		t.store,
+		&t.clock)
+
+	AssertEq(nil, err)
+}
+
+func (t *StoreSparseTempFileTest) StoreExtended() {
+	ExpectEq(initialContentSize, len(t.store.data))
+}
+
+// The temp file tests, run against a temp file kept in memory.
+type MemoryTempFileTest struct {
+	TempFileTest
+}
+
+func init() { RegisterTestSuite(&MemoryTempFileTest{}) }
+
+func (t *MemoryTempFileTest) SetUp(ti *TestInfo) {
+	var err error
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+
+	t.tf.wrapped, err = gcsx.NewTempFileWithStore(
+		strings.NewReader(initialContent),
+		gcsx.NewMemoryBackingStore(),
+		&t.clock)
+
+	AssertEq(nil, err)
+}
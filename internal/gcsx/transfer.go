@@ -0,0 +1,109 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Copy the current generation of an object to a new object, possibly in a
+// different bucket, for cases where GCS can't copy it server-side. The
+// contents are streamed from a reader for the source straight into the
+// upload, so only a small buffer is held at any time.
+//
+// The transfer fails with *AlreadyExistsError if the destination exists, and
+// the new generation is checked against the source's size and, if GCS
+// recorded one, its CRC32C checksum. On a mismatch *SizeMismatchError or
+// *ChecksumMismatchError is returned, and the new generation is left in
+// place for the caller to deal with.
+func Transfer(
+	ctx context.Context,
+	srcBucket gcs.Bucket,
+	srcName string,
+	dstBucket gcs.Bucket,
+	dstName string) (o *gcs.Object, err error) {
+	// Find the generation to copy, so we read a consistent snapshot.
+	src, err := srcBucket.StatObject(ctx, &gcs.StatObjectRequest{Name: srcName})
+	if err != nil {
+		err = fmt.Errorf("StatObject(%q): %v", srcName, err)
+		return
+	}
+
+	rc, err := srcBucket.NewReader(
+		ctx,
+		&gcs.ReadObjectRequest{
+			Name:       srcName,
+			Generation: src.Generation,
+		})
+
+	if err != nil {
+		err = fmt.Errorf("NewReader: %v", err)
+		return
+	}
+
+	defer rc.Close()
+
+	// Have GCS check the checksum as it receives the contents, if we know it.
+	req := &gcs.CreateObjectRequest{
+		Name:            dstName,
+		ContentType:     src.ContentType,
+		ContentLanguage: src.ContentLanguage,
+		ContentEncoding: src.ContentEncoding,
+		CacheControl:    src.CacheControl,
+		Metadata:        src.Metadata,
+		Contents:        rc,
+	}
+
+	if src.CRC32C != 0 {
+		crc := src.CRC32C
+		req.CRC32C = &crc
+	}
+
+	o, err = CreateObjectIfAbsent(ctx, dstBucket, req)
+	if _, ok := err.(*AlreadyExistsError); ok {
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("CreateObjectIfAbsent: %v", err)
+		return
+	}
+
+	// Make sure we got what we meant to.
+	if o.Size != src.Size {
+		err = &SizeMismatchError{
+			Name:     dstName,
+			Expected: int64(src.Size),
+			Actual:   int64(o.Size),
+		}
+
+		return
+	}
+
+	if src.CRC32C != 0 && o.CRC32C != 0 && o.CRC32C != src.CRC32C {
+		err = &ChecksumMismatchError{
+			Name:     dstName,
+			Expected: src.CRC32C,
+			Actual:   o.CRC32C,
+		}
+
+		return
+	}
+
+	return
+}
@@ -0,0 +1,171 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// A bucket whose readers count the bytes read from them.
+type countingReadBucket struct {
+	gcs.Bucket
+	read int64
+}
+
+func (b *countingReadBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	rc, err = b.Bucket.NewReader(ctx, req)
+	if err != nil {
+		return
+	}
+
+	rc = &countingReadCloser{ReadCloser: rc, n: &b.read}
+	return
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (rc *countingReadCloser) Read(p []byte) (n int, err error) {
+	n, err = rc.ReadCloser.Read(p)
+	*rc.n += int64(n)
+	return
+}
+
+// A bucket that records how much the supplied counter had reached when
+// CreateObject was called.
+type creationWatchingBucket struct {
+	gcs.Bucket
+	counter      *int64
+	readAtCreate int64
+}
+
+func (b *creationWatchingBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	b.readAtCreate = *b.counter
+	o, err = b.Bucket.CreateObject(ctx, req)
+	return
+}
+
+func TestTransfer(t *testing.T) {
+	ctx := context.Background()
+	contents := bytes.Repeat([]byte("taco"), 1<<18)
+
+	srcBucket := &countingReadBucket{
+		Bucket: gcsfake.NewFakeBucket(timeutil.RealClock(), "src"),
+	}
+
+	_, err := srcBucket.CreateObject(
+		ctx,
+		&gcs.CreateObjectRequest{
+			Name:        "foo",
+			ContentType: "text/plain",
+			Metadata:    map[string]string{"key": "value"},
+			Contents:    bytes.NewReader(contents),
+		})
+
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	dstBucket := &creationWatchingBucket{
+		Bucket:  gcsfake.NewFakeBucket(timeutil.RealClock(), "dst"),
+		counter: &srcBucket.read,
+	}
+
+	o, err := gcsx.Transfer(ctx, srcBucket, "foo", dstBucket, "bar")
+	if err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+
+	// Nothing should have been read before the upload started.
+	if dstBucket.readAtCreate != 0 {
+		t.Errorf("%d bytes read before the upload started", dstBucket.readAtCreate)
+	}
+
+	if srcBucket.read != int64(len(contents)) {
+		t.Errorf("Read %d bytes, want %d", srcBucket.read, len(contents))
+	}
+
+	if o.Name != "bar" || o.Size != uint64(len(contents)) {
+		t.Errorf("Unexpected object: %#v", o)
+	}
+
+	if o.ContentType != "text/plain" || o.Metadata["key"] != "value" {
+		t.Errorf("Attributes not preserved: %#v", o)
+	}
+
+	actual, err := gcsutil.ReadObject(ctx, dstBucket, "bar")
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+
+	if !bytes.Equal(actual, contents) {
+		t.Errorf("Contents differ")
+	}
+}
+
+func TestTransfer_DestinationExists(t *testing.T) {
+	ctx := context.Background()
+	srcBucket := newMoveTestBucket(t)
+
+	dstBucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "dst")
+	existing, err := gcsutil.CreateObject(ctx, dstBucket, "bar", []byte("burrito"))
+	if err != nil {
+		t.Fatalf("CreateObject: %v", err)
+	}
+
+	_, err = gcsx.Transfer(ctx, srcBucket, "foo", dstBucket, "bar")
+	if _, ok := err.(*gcsx.AlreadyExistsError); !ok {
+		t.Fatalf("Got error %v, want AlreadyExistsError", err)
+	}
+
+	o, err := dstBucket.StatObject(ctx, &gcs.StatObjectRequest{Name: "bar"})
+	if err != nil {
+		t.Fatalf("StatObject: %v", err)
+	}
+
+	if o.Generation != existing.Generation {
+		t.Errorf("Destination was overwritten: %#v", o)
+	}
+}
+
+func TestTransfer_SourceMissing(t *testing.T) {
+	ctx := context.Background()
+	srcBucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "src")
+	dstBucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "dst")
+
+	_, err := gcsx.Transfer(ctx, srcBucket, "foo", dstBucket, "bar")
+	if err == nil {
+		t.Fatalf("Expected an error")
+	}
+
+	if _, err := dstBucket.StatObject(ctx, &gcs.StatObjectRequest{Name: "bar"}); err == nil {
+		t.Errorf("Destination was created")
+	}
+}
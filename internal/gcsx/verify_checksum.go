@@ -0,0 +1,120 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Check that the live generation of the named object has the expected CRC32C
+// checksum, returning *ChecksumMismatchError if not.
+//
+// Usually this needs only the checksum GCS records for the object. Where that
+// is missing (which we can't distinguish from a recorded checksum of zero, as
+// for an empty object) the contents are downloaded and checksummed instead.
+// If the object doesn't exist, *gcs.NotFoundError or *SoftDeletedError is
+// returned as for StatObject.
+func VerifyChecksum(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	name string,
+	expected uint32) (err error) {
+	o, err := StatObject(ctx, bucket, &gcs.StatObjectRequest{Name: name})
+
+	// Don't mangle not found errors.
+	switch err.(type) {
+	case *gcs.NotFoundError, *SoftDeletedError:
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("StatObject: %v", err)
+		return
+	}
+
+	actual := o.CRC32C
+	if actual == 0 {
+		actual, err = downloadChecksum(ctx, bucket, o)
+		if err != nil {
+			return
+		}
+	}
+
+	if actual != expected {
+		err = &ChecksumMismatchError{
+			Name:     name,
+			Expected: expected,
+			Actual:   actual,
+		}
+
+		return
+	}
+
+	return
+}
+
+// Compute the CRC32C checksum of the supplied generation's contents by reading
+// them, returning *gcs.NotFoundError unmodified if it has gone away and
+// *SizeMismatchError if GCS returns the wrong amount of data.
+func downloadChecksum(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	o *gcs.Object) (crc uint32, err error) {
+	rc, err := bucket.NewReader(
+		ctx,
+		&gcs.ReadObjectRequest{
+			Name:       o.Name,
+			Generation: o.Generation,
+		})
+
+	// Don't mangle not found errors.
+	if _, ok := err.(*gcs.NotFoundError); ok {
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("NewReader: %v", err)
+		return
+	}
+
+	defer rc.Close()
+
+	h := crc32.New(crc32cTable)
+	n, err := io.Copy(h, rc)
+	if err != nil {
+		err = fmt.Errorf("Copy: %v", err)
+		return
+	}
+
+	if n != int64(o.Size) {
+		err = &SizeMismatchError{
+			Name:     o.Name,
+			Expected: int64(o.Size),
+			Actual:   n,
+		}
+
+		return
+	}
+
+	crc = h.Sum32()
+	return
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsx_test
+
+import (
+	"hash/crc32"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/googlecloudplatform/gcsfuse/internal/gcsx"
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// A bucket that optionally hides the checksums in the records it returns from
+// StatObject, and counts the readers opened on it.
+type checksumHidingBucket struct {
+	gcs.Bucket
+	hide  bool
+	reads int
+}
+
+func (b *checksumHidingBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.Bucket.StatObject(ctx, req)
+	if err == nil && b.hide {
+		copied := *o
+		copied.CRC32C = 0
+		o = &copied
+	}
+
+	return
+}
+
+func (b *checksumHidingBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	b.reads++
+	rc, err = b.Bucket.NewReader(ctx, req)
+	return
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	const contents = "taco"
+	correct := crc32.Checksum(
+		[]byte(contents),
+		crc32.MakeTable(crc32.Castagnoli))
+
+	testCases := []struct {
+		desc     string
+		hide     bool
+		expected uint32
+		match    bool
+		reads    int
+	}{
+		{"metadata match", false, correct, true, 0},
+		{"metadata mismatch", false, correct + 1, false, 0},
+		{"download match", true, correct, true, 1},
+		{"download mismatch", true, correct + 1, false, 1},
+	}
+
+	for _, tc := range testCases {
+		ctx := context.Background()
+		bucket := &checksumHidingBucket{
+			Bucket: gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"),
+			hide:   tc.hide,
+		}
+
+		_, err := bucket.CreateObject(ctx, &gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader(contents),
+		})
+
+		if err != nil {
+			t.Fatalf("CreateObject: %v", err)
+		}
+
+		err = gcsx.VerifyChecksum(ctx, bucket, "foo", tc.expected)
+		if tc.match {
+			if err != nil {
+				t.Errorf("%s: VerifyChecksum: %v", tc.desc, err)
+			}
+		} else {
+			typed, ok := err.(*gcsx.ChecksumMismatchError)
+			if !ok {
+				t.Errorf("%s: unexpected error: %#v", tc.desc, err)
+			} else if typed.Expected != tc.expected || typed.Actual != correct {
+				t.Errorf("%s: unexpected checksums: %v", tc.desc, typed)
+			}
+		}
+
+		if bucket.reads != tc.reads {
+			t.Errorf("%s: got %d reads, want %d", tc.desc, bucket.reads, tc.reads)
+		}
+	}
+}
+
+func TestVerifyChecksum_NotFound(t *testing.T) {
+	bucket := gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	err := gcsx.VerifyChecksum(context.Background(), bucket, "foo", 0)
+	if _, ok := err.(*gcs.NotFoundError); !ok {
+		t.Errorf("Unexpected error: %#v", err)
+	}
+}
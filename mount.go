@@ -29,6 +29,10 @@ import (
 	"github.com/jacobsa/timeutil"
 )
 
+// The prefix of the names of the temporary objects the file system creates in
+// the bucket while writing.
+const tmpObjectPrefix = ".gcsfuse_tmp/"
+
 // Mount the file system based on the supplied arguments, returning a
 // fuse.MountedFileSystem that can be joined to wait for unmounting.
 func mountWithConn(
@@ -108,8 +112,10 @@ be interacting with the file system.
 		FilePerms:              os.FileMode(flags.FileMode),
 		DirPerms:               os.FileMode(flags.DirMode),
 
-		AppendThreshold: 1 << 21, // 2 MiB, a total guess.
-		TmpObjectPrefix: ".gcsfuse_tmp/",
+		AppendThreshold:  1 << 21, // 2 MiB, a total guess.
+		TmpObjectPrefix:  tmpObjectPrefix,
+		StagedWrites:     flags.StagedWrites,
+		IdempotentWrites: flags.IdempotentWrites,
 	}
 
 	server, err := fs.NewServer(serverCfg)